@@ -0,0 +1,55 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/azure-iot-manager/client/iothub"
+)
+
+// AccessLogMiddleware returns a middleware adding the tenant and the IoT
+// Hub requests made on behalf of the request to the access log entry; it
+// must follow accesslog.Middleware, which logs the entry with the logger
+// of the request context once the request is handled.
+func AccessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, stats := iothub.WithCallStats(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		ctx = c.Request.Context()
+		fields := log.Ctx{}
+		if id := identity.FromContext(ctx); id != nil && id.Tenant != "" {
+			fields["tenant_id"] = id.Tenant
+		}
+		if calls, latency := stats.Get(); calls > 0 {
+			fields["azure_calls"] = calls
+			fields["azure_responsetime"] = fmt.Sprintf("%dus",
+				latency.Round(time.Microsecond).Microseconds())
+		}
+		if len(fields) > 0 {
+			ctx = log.WithContext(ctx, log.FromContext(ctx).F(fields))
+			c.Request = c.Request.WithContext(ctx)
+		}
+	}
+}
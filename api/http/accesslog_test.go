@@ -0,0 +1,107 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/go-lib-micro/accesslog"
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/requestid"
+
+	"github.com/mendersoftware/azure-iot-manager/client/iothub"
+)
+
+func TestAccessLogMiddleware(t *testing.T) {
+	testCases := []struct {
+		Name string
+
+		Tenant     string
+		AzureCalls int
+
+		Contains    []string
+		NotContains []string
+	}{{
+		Name: "ok",
+
+		Tenant:     "tenant1",
+		AzureCalls: 2,
+
+		Contains: []string{
+			`"tenant_id":"tenant1"`,
+			`"azure_calls":2`,
+			`"azure_responsetime":"3000us"`,
+		},
+	}, {
+		Name: "ok, no tenant nor Azure calls",
+
+		NotContains: []string{"tenant_id", "azure_calls"},
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := logrus.New()
+			logger.SetOutput(&buf)
+			logger.SetFormatter(&logrus.JSONFormatter{})
+
+			router := gin.New()
+			router.Use(func(c *gin.Context) {
+				ctx := log.WithContext(c.Request.Context(),
+					log.NewFromLogger(logger, log.Ctx{}))
+				c.Request = c.Request.WithContext(ctx)
+			})
+			router.Use(accesslog.Middleware())
+			router.Use(requestid.Middleware())
+			router.Use(AccessLogMiddleware())
+			router.GET("/", func(c *gin.Context) {
+				ctx := c.Request.Context()
+				if tc.Tenant != "" {
+					ctx = identity.WithContext(ctx, &identity.Identity{
+						Tenant: tc.Tenant,
+					})
+					c.Request = c.Request.WithContext(ctx)
+				}
+				stats := iothub.CallStatsFromContext(ctx)
+				for i := 0; i < tc.AzureCalls; i++ {
+					stats.Add(1500 * time.Microsecond)
+				}
+				c.Status(http.StatusNoContent)
+			})
+
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusNoContent, w.Code)
+
+			output := buf.String()
+			assert.Contains(t, output, `"request_id"`)
+			for _, s := range tc.Contains {
+				assert.Contains(t, output, s)
+			}
+			for _, s := range tc.NotContains {
+				assert.NotContains(t, output, s)
+			}
+		})
+	}
+}
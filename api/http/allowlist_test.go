@@ -0,0 +1,125 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	app_mocks "github.com/mendersoftware/azure-iot-manager/app/mocks"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	t.Parallel()
+	networks, err := ParseCIDRs([]string{"10.0.0.0/8", "192.168.1.1", "::1"})
+	if assert.NoError(t, err) && assert.Len(t, networks, 3) {
+		assert.Equal(t, "10.0.0.0/8", networks[0].String())
+		assert.Equal(t, "192.168.1.1/32", networks[1].String())
+		assert.Equal(t, "::1/128", networks[2].String())
+	}
+
+	_, err = ParseCIDRs([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = ParseCIDRs([]string{"localhost"})
+	assert.Error(t, err)
+}
+
+func TestIPAllowlistMiddleware(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		Name string
+
+		Path       string
+		RemoteAddr string
+		Headers    http.Header
+
+		StatusCode int
+	}{{
+		Name: "ok",
+
+		Path:       APIURLInternal + APIURLHealth,
+		RemoteAddr: "10.1.2.3:41234",
+
+		StatusCode: http.StatusNoContent,
+	}, {
+		Name: "ok, single host",
+
+		Path:       APIURLInternal + APIURLHealth,
+		RemoteAddr: "[::1]:41234",
+
+		StatusCode: http.StatusNoContent,
+	}, {
+		Name: "ok, readiness probe",
+
+		Path:       APIURLInternal + APIURLReady,
+		RemoteAddr: "192.168.0.1:41234",
+
+		StatusCode: http.StatusNoContent,
+	}, {
+		Name: "error, source not allowed",
+
+		Path:       APIURLInternal + APIURLHealth,
+		RemoteAddr: "192.168.0.1:41234",
+
+		StatusCode: http.StatusForbidden,
+	}, {
+		Name: "error, forwarded header is ignored",
+
+		Path:       APIURLInternal + APIURLHealth,
+		RemoteAddr: "192.168.0.1:41234",
+		Headers: http.Header{
+			"X-Forwarded-For": []string{"10.1.2.3"},
+		},
+
+		StatusCode: http.StatusForbidden,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			app := &app_mocks.App{}
+			app.On("HealthCheck",
+				mock.MatchedBy(func(_ context.Context) bool {
+					return true
+				})).Return(nil).Maybe()
+
+			router, err := NewRouter(app, NewRouterOptions().
+				SetInternalAPIAllowedCIDRs("10.0.0.0/8", "::1"))
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			req, _ := http.NewRequest("GET", tc.Path, nil)
+			req.RemoteAddr = tc.RemoteAddr
+			for k, v := range tc.Headers {
+				req.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.StatusCode, w.Code)
+		})
+	}
+}
+
+func TestNewRouterInvalidCIDR(t *testing.T) {
+	t.Parallel()
+	_, err := NewRouter(&app_mocks.App{}, NewRouterOptions().
+		SetInternalAPIAllowedCIDRs("10.0.0.0/64"))
+	assert.Error(t, err)
+}
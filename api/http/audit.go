@@ -0,0 +1,92 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/azure-iot-manager/app"
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+var auditActions = map[string]string{
+	http.MethodPost:   model.AuditActionCreate,
+	http.MethodPut:    model.AuditActionUpdate,
+	http.MethodPatch:  model.AuditActionUpdate,
+	http.MethodDelete: model.AuditActionDelete,
+}
+
+// AuditMiddleware returns a middleware recording an audit log for every
+// mutating request handled by the management API. The object type is
+// derived from the route relative to the management API prefix, and the
+// object ID from the "id" path parameter, if any.
+func AuditMiddleware(app app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		action, ok := auditActions[c.Request.Method]
+		if !ok {
+			return
+		}
+		c.Next()
+
+		ctx := c.Request.Context()
+		id := identity.FromContext(ctx)
+		if id == nil {
+			return
+		}
+		outcome := model.AuditOutcomeSuccess
+		if c.Writer.Status() >= http.StatusBadRequest {
+			outcome = model.AuditOutcomeFailure
+		}
+		err := app.AuditLog(ctx, model.AuditLog{
+			ID:       uuid.New(),
+			TenantID: id.Tenant,
+			Actor: model.AuditActor{
+				ID:   id.Subject,
+				Type: model.AuditActorTypeUser,
+			},
+			Action: action,
+			Object: model.AuditObject{
+				ID:   c.Param("id"),
+				Type: auditObjectType(c.FullPath()),
+			},
+			Outcome: outcome,
+		})
+		if err != nil {
+			log.FromContext(ctx).
+				Errorf("failed to record audit log: %s", err)
+		}
+	}
+}
+
+// auditObjectType returns the object type for the route, e.g. "settings"
+// for the settings endpoint.
+func auditObjectType(route string) string {
+	route = strings.TrimPrefix(route, APIURLManagement)
+	var parts []string
+	for _, part := range strings.Split(route, "/") {
+		if part != "" && !strings.HasPrefix(part, ":") &&
+			!strings.HasPrefix(part, "*") {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,169 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	mapp "github.com/mendersoftware/azure-iot-manager/app/mocks"
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+func auditLogMatcher(action, objectType, outcome string) interface{} {
+	return mock.MatchedBy(func(log model.AuditLog) bool {
+		return log.Action == action &&
+			log.Object.Type == objectType &&
+			log.Outcome == outcome
+	})
+}
+
+func TestAuditMiddleware(t *testing.T) {
+	t.Parallel()
+	id := identity.Identity{
+		Subject: "829cbefb-70e7-438f-9ac5-35fd131c2111",
+		Tenant:  "123456789012345678901234",
+		IsUser:  true,
+	}
+	testCases := []struct {
+		Name string
+
+		Method   string
+		Route    string
+		Path     string
+		Identity *identity.Identity
+		Status   int
+		AuditErr error
+
+		Log *model.AuditLog
+	}{{
+		Name: "ok, update",
+
+		Method:   http.MethodPut,
+		Route:    "/settings",
+		Path:     "/settings",
+		Identity: &id,
+		Status:   http.StatusNoContent,
+
+		Log: &model.AuditLog{
+			TenantID: id.Tenant,
+			Actor: model.AuditActor{
+				ID:   id.Subject,
+				Type: model.AuditActorTypeUser,
+			},
+			Action:  model.AuditActionUpdate,
+			Object:  model.AuditObject{Type: "settings"},
+			Outcome: model.AuditOutcomeSuccess,
+		},
+	}, {
+		Name: "ok, delete failed",
+
+		Method:   http.MethodDelete,
+		Route:    "/devices/:id",
+		Path:     "/devices/foo",
+		Identity: &id,
+		Status:   http.StatusNotFound,
+
+		Log: &model.AuditLog{
+			TenantID: id.Tenant,
+			Actor: model.AuditActor{
+				ID:   id.Subject,
+				Type: model.AuditActorTypeUser,
+			},
+			Action: model.AuditActionDelete,
+			Object: model.AuditObject{
+				ID:   "foo",
+				Type: "devices",
+			},
+			Outcome: model.AuditOutcomeFailure,
+		},
+	}, {
+		Name: "ok, error recording the audit log",
+
+		Method:   http.MethodPost,
+		Route:    "/settings",
+		Path:     "/settings",
+		Identity: &id,
+		Status:   http.StatusCreated,
+		AuditErr: errors.New("internal error"),
+
+		Log: &model.AuditLog{
+			TenantID: id.Tenant,
+			Actor: model.AuditActor{
+				ID:   id.Subject,
+				Type: model.AuditActorTypeUser,
+			},
+			Action:  model.AuditActionCreate,
+			Object:  model.AuditObject{Type: "settings"},
+			Outcome: model.AuditOutcomeSuccess,
+		},
+	}, {
+		Name: "ok, not a mutation",
+
+		Method:   http.MethodGet,
+		Route:    "/settings",
+		Path:     "/settings",
+		Identity: &id,
+		Status:   http.StatusOK,
+	}, {
+		Name: "ok, no identity",
+
+		Method: http.MethodPut,
+		Route:  "/settings",
+		Path:   "/settings",
+		Status: http.StatusNoContent,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			app := new(mapp.App)
+			defer app.AssertExpectations(t)
+			if tc.Log != nil {
+				app.On("AuditLog", contextMatcher,
+					mock.MatchedBy(func(log model.AuditLog) bool {
+						expected := *tc.Log
+						expected.ID = log.ID
+						return assert.Equal(t, expected, log)
+					}),
+				).Return(tc.AuditErr)
+			}
+
+			router := gin.New()
+			router.Use(func(c *gin.Context) {
+				if tc.Identity != nil {
+					ctx := identity.WithContext(c.Request.Context(), tc.Identity)
+					c.Request = c.Request.WithContext(ctx)
+				}
+			}, AuditMiddleware(app))
+			router.Handle(tc.Method, APIURLManagement+tc.Route, func(c *gin.Context) {
+				c.Status(tc.Status)
+			})
+
+			req, _ := http.NewRequest(tc.Method, APIURLManagement+tc.Path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.Status, w.Code)
+		})
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/rest.utils"
+
+	"github.com/mendersoftware/azure-iot-manager/app"
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+const (
+	paramFormat = "format"
+	paramFrom   = "from"
+	paramTo     = "to"
+
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+
+	contentTypeCSV    = "text/csv"
+	contentTypeNDJSON = "application/x-ndjson"
+)
+
+// auditLogRecord is the exported representation of an audit log, which
+// includes the tenant for the exports across tenants
+type auditLogRecord struct {
+	TenantID *string `json:"tenant_id,omitempty"`
+	model.AuditLog
+}
+
+var auditLogCSVHeader = []string{
+	"id", "time", "actor_type", "actor_id",
+	"action", "object_type", "object_id", "outcome",
+}
+
+func (r auditLogRecord) csv() []string {
+	record := []string{
+		r.ID.String(),
+		r.Time.UTC().Format(time.RFC3339Nano),
+		r.Actor.Type,
+		r.Actor.ID,
+		r.Action,
+		r.Object.Type,
+		r.Object.ID,
+		r.Outcome,
+	}
+	if r.TenantID != nil {
+		record = append([]string{*r.TenantID}, record...)
+	}
+	return record
+}
+
+// parseAuditLogFilter parses the time range of the export from the query
+func parseAuditLogFilter(c *gin.Context) (model.AuditLogFilter, error) {
+	var filter model.AuditLogFilter
+	params := []struct {
+		name string
+		time *time.Time
+	}{
+		{name: paramFrom, time: &filter.From},
+		{name: paramTo, time: &filter.To},
+	}
+	for _, param := range params {
+		if q := c.Query(param.name); q != "" {
+			var err error
+			*param.time, err = time.Parse(time.RFC3339, q)
+			if err != nil {
+				return filter, errors.Errorf(
+					"invalid %s query: %q is not an RFC3339 time",
+					param.name, q)
+			}
+		}
+	}
+	return filter, nil
+}
+
+// exportAuditLogs streams the audit logs matching the filter in the format
+// requested in the query. The export is rendered as an error response if it
+// fails before the first record; once the records are streamed the status
+// can no longer be changed, so the failure is only logged and the response
+// is cut short.
+func exportAuditLogs(
+	c *gin.Context,
+	a app.App,
+	filter model.AuditLogFilter,
+	withTenant bool,
+) {
+	ctx := c.Request.Context()
+	format := c.DefaultQuery(paramFormat, formatNDJSON)
+	var (
+		contentType string
+		write       func(auditLogRecord) error
+		header      []string
+	)
+	switch format {
+	case formatNDJSON:
+		contentType = contentTypeNDJSON
+		enc := json.NewEncoder(c.Writer)
+		write = func(r auditLogRecord) error { return enc.Encode(r) }
+	case formatCSV:
+		contentType = contentTypeCSV
+		w := csv.NewWriter(c.Writer)
+		header = auditLogCSVHeader
+		if withTenant {
+			header = append([]string{"tenant_id"}, header...)
+		}
+		write = func(r auditLogRecord) error {
+			if err := w.Write(r.csv()); err != nil {
+				return err
+			}
+			w.Flush()
+			return w.Error()
+		}
+	default:
+		rest.RenderError(c, http.StatusBadRequest,
+			errors.Errorf("invalid %s query: %q", paramFormat, format),
+		)
+		return
+	}
+
+	started := false
+	start := func() error {
+		started = true
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition",
+			"attachment; filename=auditlogs."+format)
+		c.Status(http.StatusOK)
+		if header != nil {
+			w := csv.NewWriter(c.Writer)
+			_ = w.Write(header)
+			w.Flush()
+			return w.Error()
+		}
+		return nil
+	}
+	err := a.ExportAuditLogs(ctx, filter, func(auditLog model.AuditLog) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		record := auditLogRecord{AuditLog: auditLog}
+		if withTenant {
+			tenantID := auditLog.TenantID
+			record.TenantID = &tenantID
+		}
+		return write(record)
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if err != nil {
+		if started {
+			log.FromContext(ctx).
+				Errorf("failed to export audit logs: %s", err)
+			c.Abort()
+			return
+		}
+		renderAppError(c, err)
+	}
+}
+
+// GET /auditlogs/export
+func (h *ManagementController) ExportAuditLogs(c *gin.Context) {
+	id := identity.FromContext(c.Request.Context())
+	if id == nil || !id.IsUser {
+		rest.RenderError(c, http.StatusForbidden, ErrMissingUserAuthentication)
+		return
+	}
+	filter, err := parseAuditLogFilter(c)
+	if err != nil {
+		rest.RenderError(c, http.StatusBadRequest, err)
+		return
+	}
+	filter.TenantID = &id.Tenant
+	exportAuditLogs(c, h.app, filter, false)
+}
+
+// GET /auditlogs/export
+func (h *InternalController) ExportAuditLogs(c *gin.Context) {
+	filter, err := parseAuditLogFilter(c)
+	if err != nil {
+		rest.RenderError(c, http.StatusBadRequest, err)
+		return
+	}
+	if tenantID, ok := c.GetQuery(paramTenantID); ok {
+		filter.TenantID = &tenantID
+	}
+	exportAuditLogs(c, h.app, filter, true)
+}
@@ -0,0 +1,207 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/azure-iot-manager/app"
+	app_mocks "github.com/mendersoftware/azure-iot-manager/app/mocks"
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+func TestExportAuditLogs(t *testing.T) {
+	t.Parallel()
+	logs := []model.AuditLog{{
+		ID:       uuid.MustParse("6a0a8b4e-6c34-4b8f-9c07-52f4e4b5b6a1"),
+		TenantID: "tenant",
+		Actor:    model.AuditActor{ID: "user", Type: model.AuditActorTypeUser},
+		Action:   model.AuditActionUpdate,
+		Object:   model.AuditObject{Type: "settings"},
+		Outcome:  model.AuditOutcomeSuccess,
+		Time:     time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC),
+	}, {
+		ID:       uuid.MustParse("4a6c7d0e-2f0c-4d55-a1c7-7f3c3c3f2e11"),
+		TenantID: "tenant",
+		Actor:    model.AuditActor{ID: "user", Type: model.AuditActorTypeUser},
+		Action:   model.AuditActionUpdate,
+		Object:   model.AuditObject{Type: "settings"},
+		Outcome:  model.AuditOutcomeFailure,
+		Time:     time.Date(2021, 10, 2, 12, 0, 0, 0, time.UTC),
+	}}
+	tenantID := "tenant"
+	from := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		Name string
+
+		Internal bool
+		Query    string
+		Identity *identity.Identity
+
+		Filter    *model.AuditLogFilter
+		ExportErr error
+
+		HTTPStatus  int
+		ContentType string
+		Body        string
+	}{{
+		Name: "ok, ndjson",
+
+		Query: "?from=2021-10-01T00:00:00Z",
+		Identity: &identity.Identity{
+			Subject: "user",
+			Tenant:  "tenant",
+			IsUser:  true,
+		},
+
+		Filter: &model.AuditLogFilter{TenantID: &tenantID, From: from},
+
+		HTTPStatus:  http.StatusOK,
+		ContentType: contentTypeNDJSON,
+		Body: `{"id":"6a0a8b4e-6c34-4b8f-9c07-52f4e4b5b6a1",` +
+			`"actor":{"id":"user","type":"user"},"action":"update",` +
+			`"object":{"type":"settings"},"outcome":"success",` +
+			`"time":"2021-10-01T12:00:00Z"}` + "\n" +
+			`{"id":"4a6c7d0e-2f0c-4d55-a1c7-7f3c3c3f2e11",` +
+			`"actor":{"id":"user","type":"user"},"action":"update",` +
+			`"object":{"type":"settings"},"outcome":"failure",` +
+			`"time":"2021-10-02T12:00:00Z"}` + "\n",
+	}, {
+		Name: "ok, internal csv",
+
+		Internal: true,
+		Query:    "?format=csv&tenant_id=tenant",
+
+		Filter: &model.AuditLogFilter{TenantID: &tenantID},
+
+		HTTPStatus:  http.StatusOK,
+		ContentType: contentTypeCSV,
+		Body: "tenant_id,id,time,actor_type,actor_id,action," +
+			"object_type,object_id,outcome\n" +
+			"tenant,6a0a8b4e-6c34-4b8f-9c07-52f4e4b5b6a1," +
+			"2021-10-01T12:00:00Z,user,user,update,settings,,success\n" +
+			"tenant,4a6c7d0e-2f0c-4d55-a1c7-7f3c3c3f2e11," +
+			"2021-10-02T12:00:00Z,user,user,update,settings,,failure\n",
+	}, {
+		Name: "ok, internal all tenants, no logs",
+
+		Internal: true,
+		Query:    "?format=csv",
+
+		Filter: &model.AuditLogFilter{},
+
+		HTTPStatus:  http.StatusOK,
+		ContentType: contentTypeCSV,
+		Body: "tenant_id,id,time,actor_type,actor_id,action," +
+			"object_type,object_id,outcome\n",
+	}, {
+		Name: "error, invalid format",
+
+		Internal: true,
+		Query:    "?format=xml",
+
+		HTTPStatus: http.StatusBadRequest,
+	}, {
+		Name: "error, invalid time range",
+
+		Internal: true,
+		Query:    "?to=yesterday",
+
+		HTTPStatus: http.StatusBadRequest,
+	}, {
+		Name: "error, not a user",
+
+		Identity: &identity.Identity{
+			Subject:  "device",
+			Tenant:   "tenant",
+			IsDevice: true,
+		},
+
+		HTTPStatus: http.StatusForbidden,
+	}, {
+		Name: "error, forbidden",
+
+		Identity: &identity.Identity{
+			Subject: "user",
+			Tenant:  "tenant",
+			IsUser:  true,
+		},
+
+		Filter:    &model.AuditLogFilter{TenantID: &tenantID},
+		ExportErr: app.ErrForbidden,
+
+		HTTPStatus: http.StatusForbidden,
+	}, {
+		Name: "error, internal error",
+
+		Internal: true,
+
+		Filter:    &model.AuditLogFilter{},
+		ExportErr: errors.New("mongo error"),
+
+		HTTPStatus: http.StatusInternalServerError,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			azureIotManagerApp := &app_mocks.App{}
+			defer azureIotManagerApp.AssertExpectations(t)
+			if tc.Filter != nil {
+				azureIotManagerApp.On("ExportAuditLogs",
+					contextMatcher,
+					*tc.Filter,
+					mock.AnythingOfType("func(model.AuditLog) error"),
+				).Run(func(args mock.Arguments) {
+					if tc.ExportErr != nil || tc.Filter.TenantID == nil {
+						return
+					}
+					fn := args.Get(2).(func(model.AuditLog) error)
+					for _, log := range logs {
+						assert.NoError(t, fn(log))
+					}
+				}).Return(tc.ExportErr)
+			}
+
+			router, _ := NewRouter(azureIotManagerApp)
+			url := APIURLManagement + APIURLAuditLogsExport + tc.Query
+			if tc.Internal {
+				url = APIURLInternal + APIURLAuditLogsExport + tc.Query
+			}
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			if tc.Identity != nil {
+				req.Header.Set("Authorization", "Bearer "+GenerateJWT(*tc.Identity))
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.HTTPStatus, w.Code)
+			if tc.HTTPStatus == http.StatusOK {
+				assert.Equal(t, tc.ContentType, w.Header().Get("Content-Type"))
+				assert.Equal(t, tc.Body, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/rest.utils"
+
+	"github.com/mendersoftware/azure-iot-manager/jwt"
+	"github.com/mendersoftware/azure-iot-manager/rbac"
+)
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	ErrInvalidToken  = errors.New("invalid authorization token")
+)
+
+// APIKeyMiddleware returns a middleware authenticating requests carrying
+// one of the given keys as a bearer token. Requests for paths in skipPaths
+// are passed through without authentication.
+func APIKeyMiddleware(keys []string, skipPaths ...string) gin.HandlerFunc {
+	skip := make(map[string]struct{}, len(skipPaths))
+	for _, path := range skipPaths {
+		skip[path] = struct{}{}
+	}
+	return func(c *gin.Context) {
+		if _, ok := skip[c.FullPath()]; ok {
+			return
+		}
+		token, err := identity.ExtractJWTFromHeader(c.Request)
+		if err == nil {
+			for _, key := range keys {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+					return
+				}
+			}
+			err = ErrInvalidAPIKey
+		}
+		c.Header("WWW-Authenticate", `Bearer realm="InternalAPIKey"`)
+		rest.RenderError(c, http.StatusUnauthorized, err)
+		c.Abort()
+	}
+}
+
+// JWTVerificationMiddleware returns a middleware rejecting requests with
+// a token that does not pass the verifier.
+func JWTVerificationMiddleware(verifier jwt.Verifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, err := identity.ExtractJWTFromHeader(c.Request)
+		if err == nil {
+			err = verifier.Verify(token)
+			if err == nil {
+				return
+			}
+			log.FromContext(c.Request.Context()).
+				Warnf("token verification failed: %s", err)
+			err = ErrInvalidToken
+		}
+		c.Header("WWW-Authenticate", `Bearer realm="ManagementJWT"`)
+		rest.RenderError(c, http.StatusUnauthorized, err)
+		c.Abort()
+	}
+}
+
+// ScopesMiddleware adds the RBAC scopes granted by the request token to the
+// request context.
+func ScopesMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, err := identity.ExtractJWTFromHeader(c.Request)
+		if err == nil {
+			var scopes rbac.Scopes
+			scopes, err = rbac.ExtractScopes(token)
+			if err == nil {
+				ctx := rbac.WithContext(c.Request.Context(), scopes)
+				c.Request = c.Request.WithContext(ctx)
+				return
+			}
+		}
+		c.Header("WWW-Authenticate", `Bearer realm="ManagementJWT"`)
+		rest.RenderError(c, http.StatusUnauthorized, err)
+		c.Abort()
+	}
+}
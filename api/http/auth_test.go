@@ -0,0 +1,177 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	app_mocks "github.com/mendersoftware/azure-iot-manager/app/mocks"
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		Name string
+
+		Path          string
+		Authorization string
+
+		StatusCode int
+	}{{
+		Name: "ok",
+
+		Path:          APIURLInternal + APIURLHealth,
+		Authorization: "Bearer key2",
+
+		StatusCode: http.StatusNoContent,
+	}, {
+		Name: "ok, liveness probe",
+
+		Path: APIURLInternal + APIURLAlive,
+
+		StatusCode: http.StatusNoContent,
+	}, {
+		Name: "ok, readiness probe",
+
+		Path: APIURLInternal + APIURLReady,
+
+		StatusCode: http.StatusNoContent,
+	}, {
+		Name: "error, missing key",
+
+		Path: APIURLInternal + APIURLHealth,
+
+		StatusCode: http.StatusUnauthorized,
+	}, {
+		Name: "error, invalid key",
+
+		Path:          APIURLInternal + APIURLHealth,
+		Authorization: "Bearer key3",
+
+		StatusCode: http.StatusUnauthorized,
+	}, {
+		Name: "error, malformed header",
+
+		Path:          APIURLInternal + APIURLHealth,
+		Authorization: "Basic key1",
+
+		StatusCode: http.StatusUnauthorized,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			app := &app_mocks.App{}
+			app.On("HealthCheck",
+				mock.MatchedBy(func(_ context.Context) bool {
+					return true
+				})).Return(nil).Maybe()
+
+			router, _ := NewRouter(app, NewRouterOptions().
+				SetInternalAPIKeys("key1", "key2"))
+			req, _ := http.NewRequest("GET", tc.Path, nil)
+			if tc.Authorization != "" {
+				req.Header.Set("Authorization", tc.Authorization)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.StatusCode, w.Code)
+			if tc.StatusCode == http.StatusUnauthorized {
+				assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+type verifierFunc func(token string) error
+
+func (f verifierFunc) Verify(token string) error {
+	return f(token)
+}
+
+func TestJWTVerificationMiddleware(t *testing.T) {
+	t.Parallel()
+	validToken := GenerateJWT(identity.Identity{
+		IsUser:  true,
+		Subject: "829cbefb-70e7-438f-9ac5-35fd131c2111",
+		Tenant:  "123456789012345678901234",
+	})
+	verifier := verifierFunc(func(token string) error {
+		if token != validToken {
+			return errors.New("invalid signature")
+		}
+		return nil
+	})
+	testCases := []struct {
+		Name string
+
+		Authorization string
+
+		StatusCode int
+	}{{
+		Name: "ok",
+
+		Authorization: "Bearer " + validToken,
+
+		StatusCode: http.StatusOK,
+	}, {
+		Name: "error, verification failed",
+
+		Authorization: "Bearer " + GenerateJWT(identity.Identity{
+			IsUser:  true,
+			Subject: "829cbefb-70e7-438f-9ac5-35fd131c2111",
+			Tenant:  "000000000000000000000000",
+		}),
+
+		StatusCode: http.StatusUnauthorized,
+	}, {
+		Name: "error, missing token",
+
+		StatusCode: http.StatusUnauthorized,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			app := &app_mocks.App{}
+			defer app.AssertExpectations(t)
+			if tc.StatusCode == http.StatusOK {
+				app.On("GetSettings", contextMatcher).
+					Return(model.Settings{}, nil)
+			}
+
+			router, _ := NewRouter(app, NewRouterOptions().
+				SetJWTVerifier(verifier))
+			req, _ := http.NewRequest("GET",
+				APIURLManagement+APIURLSettings, nil)
+			if tc.Authorization != "" {
+				req.Header.Set("Authorization", tc.Authorization)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.StatusCode, w.Code)
+		})
+	}
+}
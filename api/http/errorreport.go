@@ -0,0 +1,103 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/requestid"
+
+	"github.com/mendersoftware/azure-iot-manager/client/sentry"
+	"github.com/mendersoftware/azure-iot-manager/clock"
+	"github.com/mendersoftware/azure-iot-manager/logging"
+)
+
+// ErrorReportingMiddleware returns a middleware reporting the panics and
+// the internal server errors to the error tracker, tagged with the tenant
+// and the request ID, with the secrets redacted. The reports are sent in
+// the background; the panics are propagated to accesslog.Middleware, which
+// logs them. Other 5xx responses (bad gateway, service unavailable)
+// reflect the state of the dependencies and are not reported. The events
+// are timestamped with clk.
+func ErrorReportingMiddleware(reporter sentry.Client, clk clock.Clock) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if r := recover(); r != nil {
+				reportError(c, reporter, clk, sentry.LevelFatal,
+					fmt.Sprintf("panic: %v", r), debug.Stack())
+				panic(r)
+			}
+		}()
+
+		c.Next()
+
+		if c.Writer.Status() == http.StatusInternalServerError {
+			msg := http.StatusText(http.StatusInternalServerError)
+			if len(c.Errors) > 0 {
+				msg = c.Errors[0].Error()
+			}
+			reportError(c, reporter, clk, sentry.LevelError, msg, nil)
+		}
+	}
+}
+
+func reportError(
+	c *gin.Context,
+	reporter sentry.Client,
+	clk clock.Clock,
+	level, msg string,
+	stack []byte,
+) {
+	ctx := c.Request.Context()
+	event := sentry.Event{
+		Level:   level,
+		Message: logging.Redact(msg),
+		Time:    clk.Now(),
+		Tags: map[string]string{
+			"method": c.Request.Method,
+			"route":  c.FullPath(),
+		},
+		Request: &sentry.Request{
+			Method: c.Request.Method,
+			URL:    logging.Redact(c.Request.URL.String()),
+		},
+	}
+	reqID := requestid.FromContext(ctx)
+	if reqID != "" {
+		event.Tags["request_id"] = reqID
+	}
+	if id := identity.FromContext(ctx); id != nil && id.Tenant != "" {
+		event.Tags["tenant_id"] = id.Tenant
+	}
+	if stack != nil {
+		event.Extra = map[string]interface{}{
+			"stacktrace": logging.Redact(string(stack)),
+		}
+	}
+	l := log.FromContext(ctx)
+	go func() {
+		ctx := requestid.WithContext(context.Background(), reqID)
+		if err := reporter.CaptureEvent(ctx, event); err != nil {
+			l.Warnf("failed to report error: %s", err)
+		}
+	}()
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/accesslog"
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/requestid"
+
+	"github.com/mendersoftware/azure-iot-manager/client/sentry"
+	sentry_mocks "github.com/mendersoftware/azure-iot-manager/client/sentry/mocks"
+	"github.com/mendersoftware/azure-iot-manager/clock"
+)
+
+func TestErrorReportingMiddleware(t *testing.T) {
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		Name    string
+		Handler gin.HandlerFunc
+
+		HTTPStatus int
+		Level      string
+		Message    string
+		Tenant     string
+	}{{
+		Name: "ok, not reported",
+		Handler: func(c *gin.Context) {
+			c.Status(http.StatusNoContent)
+		},
+
+		HTTPStatus: http.StatusNoContent,
+	}, {
+		Name: "ok, bad gateway not reported",
+		Handler: func(c *gin.Context) {
+			c.Status(http.StatusBadGateway)
+		},
+
+		HTTPStatus: http.StatusBadGateway,
+	}, {
+		Name: "internal server error",
+		Handler: func(c *gin.Context) {
+			ctx := identity.WithContext(c.Request.Context(),
+				&identity.Identity{Tenant: "tenant1"})
+			c.Request = c.Request.WithContext(ctx)
+			renderAppError(c, errors.New("mongo error"))
+		},
+
+		HTTPStatus: http.StatusInternalServerError,
+		Level:      sentry.LevelError,
+		Message:    "mongo error",
+		Tenant:     "tenant1",
+	}, {
+		Name: "panic",
+		Handler: func(c *gin.Context) {
+			panic("boom")
+		},
+
+		HTTPStatus: http.StatusInternalServerError,
+		Level:      sentry.LevelFatal,
+		Message:    "panic: boom",
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			reporter := &sentry_mocks.Client{}
+			defer reporter.AssertExpectations(t)
+			reported := make(chan sentry.Event, 1)
+			if tc.Level != "" {
+				reporter.On("CaptureEvent",
+					mock.MatchedBy(func(ctx context.Context) bool {
+						return requestid.FromContext(ctx) == "req1"
+					}),
+					mock.AnythingOfType("sentry.Event"),
+				).Run(func(args mock.Arguments) {
+					reported <- args.Get(1).(sentry.Event)
+				}).Return(nil).Once()
+			}
+
+			router := gin.New()
+			router.Use(accesslog.Middleware())
+			router.Use(requestid.Middleware())
+			router.Use(ErrorReportingMiddleware(reporter, clock.NewFake(now)))
+			router.GET("/test", tc.Handler)
+
+			req, _ := http.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set(requestid.RequestIdHeader, "req1")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.HTTPStatus, w.Code)
+			if tc.Level == "" {
+				return
+			}
+
+			select {
+			case event := <-reported:
+				assert.Equal(t, tc.Level, event.Level)
+				assert.Equal(t, tc.Message, event.Message)
+				assert.Equal(t, now, event.Time)
+				assert.Equal(t, "req1", event.Tags["request_id"])
+				assert.Equal(t, "/test", event.Tags["route"])
+				assert.Equal(t, tc.Tenant, event.Tags["tenant_id"])
+				assert.Equal(t, "/test", event.Request.URL)
+				if tc.Level == sentry.LevelFatal {
+					assert.Contains(t, event.Extra["stacktrace"], "panic")
+				}
+			case <-time.After(5 * time.Second):
+				t.Error("timeout waiting for the error report")
+			}
+		})
+	}
+}
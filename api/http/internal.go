@@ -0,0 +1,131 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/rest.utils"
+
+	"github.com/mendersoftware/azure-iot-manager/app"
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+const (
+	paramTenantID = "tenant_id"
+	paramName     = "name"
+	paramSample   = "sample"
+
+	defaultFleetSample = 10
+	maxFleetSample     = 100
+)
+
+// InternalController contains the internal end-points operating on behalf
+// of a tenant
+type InternalController struct {
+	app app.App
+}
+
+// NewInternalController returns a new InternalController
+func NewInternalController(app app.App) *InternalController {
+	return &InternalController{app: app}
+}
+
+// tenantContext adds the identity of the tenant in the path to the request
+// context
+func tenantContext(c *gin.Context) {
+	ctx := identity.WithContext(c.Request.Context(), &identity.Identity{
+		Tenant: c.Param(paramTenantID),
+	})
+	c.Request = c.Request.WithContext(ctx)
+}
+
+// GET /tenants/:tenant_id/features
+func (h *InternalController) GetFeatureFlags(c *gin.Context) {
+	tenantContext(c)
+	flags, err := h.app.GetFeatureFlags(c.Request.Context())
+	if err != nil {
+		renderAppError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, flags)
+}
+
+// PUT /tenants/:tenant_id/features/:name
+func (h *InternalController) SetFeatureFlag(c *gin.Context) {
+	tenantContext(c)
+	var override model.FeatureFlagOverride
+	if err := c.ShouldBindJSON(&override); err != nil {
+		rest.RenderError(c,
+			http.StatusBadRequest,
+			errors.New("malformed request body"),
+		)
+		return
+	}
+	err := h.app.SetFeatureFlag(c.Request.Context(),
+		c.Param(paramName), *override.Enabled)
+	if err != nil {
+		renderAppError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DELETE /tenants/:tenant_id/features/:name
+func (h *InternalController) DeleteFeatureFlag(c *gin.Context) {
+	tenantContext(c)
+	err := h.app.DeleteFeatureFlag(c.Request.Context(), c.Param(paramName))
+	if err != nil {
+		renderAppError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GET /fleet/health
+func (h *InternalController) FleetHealth(c *gin.Context) {
+	sample := defaultFleetSample
+	if q := c.Query(paramSample); q != "" {
+		var err error
+		sample, err = strconv.Atoi(q)
+		if err != nil || sample < 1 || sample > maxFleetSample {
+			rest.RenderError(c, http.StatusBadRequest,
+				errors.Errorf("invalid %s query: %q", paramSample, q),
+			)
+			return
+		}
+	}
+	report, err := h.app.FleetHealth(c.Request.Context(), sample)
+	if err != nil {
+		renderAppError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// GET /migrations
+func (h *InternalController) MigrationStatus(c *gin.Context) {
+	status, err := h.app.MigrationStatus(c.Request.Context())
+	if err != nil {
+		renderAppError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
@@ -0,0 +1,371 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/azure-iot-manager/app"
+	app_mocks "github.com/mendersoftware/azure-iot-manager/app/mocks"
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+func tenantContextMatcher(tenantID string) interface{} {
+	return mock.MatchedBy(func(ctx context.Context) bool {
+		id := identity.FromContext(ctx)
+		return id != nil && id.Tenant == tenantID
+	})
+}
+
+func tenantFeaturesURL(tenantID, name string) string {
+	url := strings.Replace(APIURLTenantFeatures, ":tenant_id", tenantID, 1)
+	if name != "" {
+		url += "/" + name
+	}
+	return APIURLInternal + url
+}
+
+func TestGetFeatureFlags(t *testing.T) {
+	testCases := []struct {
+		Name string
+
+		Flags []model.FeatureFlag
+		Error error
+
+		HTTPStatus int
+	}{
+		{
+			Name: "ok",
+
+			Flags: []model.FeatureFlag{{
+				Name:    "test_feature",
+				Enabled: true,
+				Source:  model.FeatureSourceTenant,
+			}},
+			HTTPStatus: http.StatusOK,
+		},
+		{
+			Name: "error, internal error",
+
+			Error:      errors.New("internal error"),
+			HTTPStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			azureIotManagerApp := &app_mocks.App{}
+			defer azureIotManagerApp.AssertExpectations(t)
+			azureIotManagerApp.On("GetFeatureFlags", tenantContextMatcher("tenant")).
+				Return(tc.Flags, tc.Error)
+
+			router, _ := NewRouter(azureIotManagerApp)
+			req, _ := http.NewRequest("GET",
+				tenantFeaturesURL("tenant", ""), nil)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.HTTPStatus, w.Code)
+			if tc.Error == nil {
+				b, _ := json.Marshal(tc.Flags)
+				assert.JSONEq(t, string(b), w.Body.String())
+			}
+		})
+	}
+}
+
+func TestSetFeatureFlag(t *testing.T) {
+	testCases := []struct {
+		Name string
+
+		Feature string
+		Body    string
+
+		Enabled bool
+		Error   error
+
+		HTTPStatus int
+	}{
+		{
+			Name: "ok",
+
+			Feature: "test_feature",
+			Body:    `{"enabled": false}`,
+
+			HTTPStatus: http.StatusNoContent,
+		},
+		{
+			Name: "error, missing enabled",
+
+			Feature: "test_feature",
+			Body:    `{}`,
+
+			HTTPStatus: http.StatusBadRequest,
+		},
+		{
+			Name: "error, malformed body",
+
+			Feature: "test_feature",
+			Body:    `{"enabled": "yes"}`,
+
+			HTTPStatus: http.StatusBadRequest,
+		},
+		{
+			Name: "error, unknown feature",
+
+			Feature: "dummy",
+			Body:    `{"enabled": true}`,
+			Enabled: true,
+			Error:   app.ErrUnknownFeature,
+
+			HTTPStatus: http.StatusNotFound,
+		},
+		{
+			Name: "error, internal error",
+
+			Feature: "test_feature",
+			Body:    `{"enabled": true}`,
+			Enabled: true,
+			Error:   errors.New("internal error"),
+
+			HTTPStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			azureIotManagerApp := &app_mocks.App{}
+			defer azureIotManagerApp.AssertExpectations(t)
+			if tc.HTTPStatus != http.StatusBadRequest {
+				azureIotManagerApp.On("SetFeatureFlag",
+					tenantContextMatcher("tenant"),
+					tc.Feature,
+					tc.Enabled,
+				).Return(tc.Error)
+			}
+
+			router, _ := NewRouter(azureIotManagerApp)
+			req, _ := http.NewRequest("PUT",
+				tenantFeaturesURL("tenant", tc.Feature),
+				bytes.NewReader([]byte(tc.Body)),
+			)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.HTTPStatus, w.Code)
+		})
+	}
+}
+
+func TestDeleteFeatureFlag(t *testing.T) {
+	testCases := []struct {
+		Name string
+
+		Feature string
+		Error   error
+
+		HTTPStatus int
+	}{
+		{
+			Name: "ok",
+
+			Feature:    "test_feature",
+			HTTPStatus: http.StatusNoContent,
+		},
+		{
+			Name: "error, unknown feature",
+
+			Feature:    "dummy",
+			Error:      app.ErrUnknownFeature,
+			HTTPStatus: http.StatusNotFound,
+		},
+		{
+			Name: "error, internal error",
+
+			Feature:    "test_feature",
+			Error:      errors.New("internal error"),
+			HTTPStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			azureIotManagerApp := &app_mocks.App{}
+			defer azureIotManagerApp.AssertExpectations(t)
+			azureIotManagerApp.On("DeleteFeatureFlag",
+				tenantContextMatcher("tenant"),
+				tc.Feature,
+			).Return(tc.Error)
+
+			router, _ := NewRouter(azureIotManagerApp)
+			req, _ := http.NewRequest("DELETE",
+				tenantFeaturesURL("tenant", tc.Feature), nil)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.HTTPStatus, w.Code)
+		})
+	}
+}
+
+func TestFleetHealth(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Query string
+
+		Sample int
+		Report model.FleetHealthReport
+		Error  error
+
+		HTTPStatus int
+	}{
+		{
+			Name: "ok, default sample",
+
+			Sample: defaultFleetSample,
+			Report: model.FleetHealthReport{
+				Sampled: 1,
+				Failed:  1,
+				Failures: []model.IntegrationFailure{{
+					TenantID: "tenant",
+					Check:    "registry_read",
+					Error:    "no such host",
+				}},
+			},
+			HTTPStatus: http.StatusOK,
+		},
+		{
+			Name:  "ok, sample",
+			Query: "?sample=50",
+
+			Sample:     50,
+			Report:     model.FleetHealthReport{Sampled: 50},
+			HTTPStatus: http.StatusOK,
+		},
+		{
+			Name:  "error, invalid sample",
+			Query: "?sample=1000",
+
+			HTTPStatus: http.StatusBadRequest,
+		},
+		{
+			Name:  "error, internal error",
+			Query: "?sample=1",
+
+			Sample:     1,
+			Error:      errors.New("internal error"),
+			HTTPStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			azureIotManagerApp := &app_mocks.App{}
+			defer azureIotManagerApp.AssertExpectations(t)
+			if tc.Sample > 0 {
+				azureIotManagerApp.On("FleetHealth", contextMatcher, tc.Sample).
+					Return(tc.Report, tc.Error)
+			}
+
+			router, _ := NewRouter(azureIotManagerApp)
+			req, _ := http.NewRequest("GET",
+				APIURLInternal+APIURLFleetHealth+tc.Query, nil)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.HTTPStatus, w.Code)
+			if tc.HTTPStatus == http.StatusOK {
+				b, _ := json.Marshal(tc.Report)
+				assert.JSONEq(t, string(b), w.Body.String())
+			}
+		})
+	}
+}
+
+func TestMigrationStatus(t *testing.T) {
+	testCases := []struct {
+		Name string
+
+		Status model.MigrationStatus
+		Error  error
+
+		HTTPStatus int
+	}{
+		{
+			Name: "ok",
+
+			Status: model.MigrationStatus{
+				Database: "azure_iot_manager",
+				Version:  "1.2.0",
+				Applied: []model.AppliedMigration{{
+					Version: "1.2.0",
+					Time:    time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC),
+				}},
+			},
+			HTTPStatus: http.StatusOK,
+		},
+		{
+			Name: "ok, pending",
+
+			Status: model.MigrationStatus{
+				Database: "azure_iot_manager",
+				Version:  "1.2.0",
+				Pending:  true,
+				Applied:  []model.AppliedMigration{},
+			},
+			HTTPStatus: http.StatusOK,
+		},
+		{
+			Name: "error, internal error",
+
+			Error:      errors.New("internal error"),
+			HTTPStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			azureIotManagerApp := &app_mocks.App{}
+			defer azureIotManagerApp.AssertExpectations(t)
+			azureIotManagerApp.On("MigrationStatus", contextMatcher).
+				Return(tc.Status, tc.Error)
+
+			router, _ := NewRouter(azureIotManagerApp)
+			req, _ := http.NewRequest("GET",
+				APIURLInternal+APIURLMigrations, nil)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.HTTPStatus, w.Code)
+			if tc.HTTPStatus == http.StatusOK {
+				b, _ := json.Marshal(tc.Status)
+				assert.JSONEq(t, string(b), w.Body.String())
+			}
+		})
+	}
+}
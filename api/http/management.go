@@ -16,6 +16,9 @@ package http
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
@@ -24,6 +27,7 @@ import (
 	"github.com/mendersoftware/go-lib-micro/rest.utils"
 
 	"github.com/mendersoftware/azure-iot-manager/app"
+	"github.com/mendersoftware/azure-iot-manager/client/iothub"
 	"github.com/mendersoftware/azure-iot-manager/model"
 )
 
@@ -33,6 +37,39 @@ var (
 	)
 )
 
+const (
+	hdrETag        = "ETag"
+	hdrIfMatch     = "If-Match"
+	hdrIfNoneMatch = "If-None-Match"
+)
+
+// matchETag checks if the etag matches any of the entity tags in the
+// If-None-Match header value. Weak tags are compared by their opaque
+// value.
+func matchETag(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// matchIfMatch checks if the settings match any of the entity tags in the
+// If-Match header value with the strong comparison (RFC 7232, section
+// 3.1): weak tags never match, and "*" only matches existing settings.
+func matchIfMatch(header string, settings model.Settings) bool {
+	etag := settings.ETag()
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == etag || (tag == "*" && settings.ConnectionString != "") {
+			return true
+		}
+	}
+	return false
+}
+
 // ManagementController container for end-points
 type ManagementController struct {
 	app app.App
@@ -45,6 +82,73 @@ func NewManagementController(app app.App) *ManagementController {
 	}
 }
 
+// renderAppError responds with the status code corresponding to the
+// app error; unexpected errors are not disclosed to the client.
+func renderAppError(c *gin.Context, err error) {
+	var (
+		iothubErr *iothub.Error
+		quotaErr  *app.QuotaExceededError
+	)
+	switch {
+	case errors.As(err, &iothubErr):
+		renderIoTHubError(c, iothubErr)
+	case errors.As(err, &quotaErr):
+		if wait := quotaErr.RetryAfter; wait > 0 {
+			// round up to whole seconds
+			c.Header(hdrRetryAfter, strconv.FormatInt(
+				int64((wait+time.Second-1)/time.Second), 10,
+			))
+		}
+		rest.RenderError(c, http.StatusTooManyRequests, err)
+	case errors.Is(err, app.ErrForbidden):
+		rest.RenderError(c, http.StatusForbidden, err)
+	case errors.Is(err, app.ErrNotEntitled):
+		rest.RenderError(c, http.StatusPaymentRequired, err)
+	case errors.Is(err, app.ErrUnknownFeature):
+		rest.RenderError(c, http.StatusNotFound, err)
+	case errors.Is(err, model.ErrConnectionStringMalformed),
+		errors.Is(err, app.ErrDeviceConnectionString),
+		errors.Is(err, app.ErrConnectionStringRejected),
+		errors.Is(err, app.ErrHostNameChanged),
+		errors.Is(err, app.ErrExcessivePermissions):
+		rest.RenderError(c, http.StatusBadRequest, err)
+	case errors.Is(err, app.ErrPreconditionFailed):
+		rest.RenderError(c, http.StatusPreconditionFailed, err)
+	case errors.Is(err, app.ErrIntegrationNotConfigured),
+		errors.Is(err, app.ErrSettingsModified):
+		rest.RenderError(c, http.StatusConflict, err)
+	default:
+		_ = c.Error(err)
+		rest.RenderError(c,
+			http.StatusInternalServerError,
+			errors.New(http.StatusText(http.StatusInternalServerError)),
+		)
+	}
+}
+
+// renderIoTHubError responds with the status code corresponding to the
+// IoT Hub error: missing devices, conflicts and throttling are passed
+// through, any other error is a bad gateway. The message includes the
+// tracking ID of the IoT Hub request, for support escalations.
+func renderIoTHubError(c *gin.Context, err *iothub.Error) {
+	status := http.StatusBadGateway
+	switch {
+	case errors.Is(err, iothub.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, iothub.ErrConflict):
+		status = http.StatusConflict
+	case errors.Is(err, iothub.ErrThrottled):
+		status = http.StatusTooManyRequests
+		if err.RetryAfter > 0 {
+			// round up to whole seconds
+			c.Header(hdrRetryAfter, strconv.FormatInt(
+				int64((err.RetryAfter+time.Second-1)/time.Second), 10,
+			))
+		}
+	}
+	rest.RenderError(c, status, err)
+}
+
 // GET /settings
 func (h *ManagementController) GetSettings(c *gin.Context) {
 	var (
@@ -58,13 +162,17 @@ func (h *ManagementController) GetSettings(c *gin.Context) {
 	}
 	settings, err := h.app.GetSettings(ctx)
 	if err != nil {
-		rest.RenderError(c,
-			http.StatusInternalServerError,
-			errors.New(http.StatusText(http.StatusInternalServerError)),
-		)
+		renderAppError(c, err)
 		return
 	}
 
+	etag := settings.ETag()
+	c.Header(hdrETag, etag)
+	if ifNoneMatch := c.GetHeader(hdrIfNoneMatch); ifNoneMatch != "" &&
+		matchETag(ifNoneMatch, etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
 	c.JSON(http.StatusOK, settings)
 }
 
@@ -89,14 +197,54 @@ func (h *ManagementController) SetSettings(c *gin.Context) {
 		return
 	}
 
-	err := h.app.SetSettings(ctx, settings)
+	var err error
+	if ifMatch := c.GetHeader(hdrIfMatch); ifMatch != "" {
+		err = h.app.SetSettingsIfMatch(ctx, settings, func(current model.Settings) bool {
+			return matchIfMatch(ifMatch, current)
+		})
+	} else {
+		err = h.app.SetSettings(ctx, settings)
+	}
 	if err != nil {
-		_ = c.Error(err)
+		renderAppError(c, err)
+		return
+	}
+	c.Header(hdrETag, settings.ETag())
+	c.Status(http.StatusNoContent)
+}
+
+// PUT /settings/rotate
+func (h *ManagementController) RotateSettings(c *gin.Context) {
+	var (
+		ctx = c.Request.Context()
+		id  = identity.FromContext(ctx)
+	)
+
+	if id == nil || !id.IsUser {
+		rest.RenderError(c, http.StatusForbidden, ErrMissingUserAuthentication)
+		return
+	}
+
+	settings := model.Settings{}
+	if err := c.ShouldBindJSON(&settings); err != nil {
 		rest.RenderError(c,
-			http.StatusInternalServerError,
-			errors.New(http.StatusText(http.StatusInternalServerError)),
+			http.StatusBadRequest,
+			errors.New("malformed request body"),
+		)
+		return
+	} else if settings.ConnectionString == "" {
+		rest.RenderError(c,
+			http.StatusBadRequest,
+			errors.New("connection_string: cannot be blank"),
 		)
 		return
 	}
+
+	err := h.app.RotateConnectionString(ctx, settings.ConnectionString)
+	if err != nil {
+		renderAppError(c, err)
+		return
+	}
+	c.Header(hdrETag, settings.ETag())
 	c.Status(http.StatusNoContent)
 }
@@ -24,12 +24,15 @@ import (
 	"github.com/google/uuid"
 
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/textproto"
 	"testing"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -38,8 +41,14 @@ import (
 	"github.com/mendersoftware/go-lib-micro/requestid"
 	"github.com/mendersoftware/go-lib-micro/rest.utils"
 
+	"github.com/mendersoftware/azure-iot-manager/app"
 	mapp "github.com/mendersoftware/azure-iot-manager/app/mocks"
+	"github.com/mendersoftware/azure-iot-manager/client/iothub"
+	iothubMocks "github.com/mendersoftware/azure-iot-manager/client/iothub/mocks"
+	"github.com/mendersoftware/azure-iot-manager/clock"
 	"github.com/mendersoftware/azure-iot-manager/model"
+	"github.com/mendersoftware/azure-iot-manager/rbac"
+	storeMocks "github.com/mendersoftware/azure-iot-manager/store/mocks"
 )
 
 var contextMatcher = mock.MatchedBy(func(_ context.Context) bool { return true })
@@ -206,6 +215,9 @@ func TestSetSettings(t *testing.T) {
 			a := new(mapp.App)
 			a.On("SetSettings", contextMatcher, mock.AnythingOfType("model.Settings")).
 				Return(nil)
+			a.On("AuditLog", contextMatcher, auditLogMatcher(
+				model.AuditActionUpdate, "settings", model.AuditOutcomeSuccess,
+			)).Return(nil)
 			return a
 		},
 
@@ -228,6 +240,9 @@ func TestSetSettings(t *testing.T) {
 			a := new(mapp.App)
 			a.On("SetSettings", contextMatcher, mock.AnythingOfType("model.Settings")).
 				Return(errors.New("internal error"))
+			a.On("AuditLog", contextMatcher, auditLogMatcher(
+				model.AuditActionUpdate, "settings", model.AuditOutcomeFailure,
+			)).Return(nil)
 			return a
 		},
 
@@ -247,7 +262,13 @@ func TestSetSettings(t *testing.T) {
 			})},
 		},
 
-		App: func(t *testing.T) *mapp.App { return new(mapp.App) },
+		App: func(t *testing.T) *mapp.App {
+			a := new(mapp.App)
+			a.On("AuditLog", contextMatcher, auditLogMatcher(
+				model.AuditActionUpdate, "settings", model.AuditOutcomeFailure,
+			)).Return(nil)
+			return a
+		},
 
 		RspCode: http.StatusBadRequest,
 		Error:   errors.New("malformed request body"),
@@ -290,3 +311,593 @@ func TestSetSettings(t *testing.T) {
 		})
 	}
 }
+
+func TestRotateSettings(t *testing.T) {
+	t.Parallel()
+	userHdrs := http.Header{
+		"Authorization": []string{"Bearer " + GenerateJWT(identity.Identity{
+			Subject: uuid.NewString(),
+			Tenant:  "123456789012345678901234",
+			IsUser:  true,
+		})},
+	}
+	testCases := []struct {
+		Name string
+
+		RequestBody interface{}
+		RequestHdrs http.Header
+
+		App func(t *testing.T) *mapp.App
+
+		RspCode int
+		Error   error
+	}{{
+		Name: "ok",
+
+		RequestBody: map[string]string{
+			"connection_string": "my://connection.string",
+		},
+		RequestHdrs: userHdrs,
+
+		App: func(t *testing.T) *mapp.App {
+			a := new(mapp.App)
+			a.On("RotateConnectionString", contextMatcher, "my://connection.string").
+				Return(nil)
+			a.On("AuditLog", contextMatcher, auditLogMatcher(
+				model.AuditActionUpdate, "settings.rotate", model.AuditOutcomeSuccess,
+			)).Return(nil)
+			return a
+		},
+
+		RspCode: http.StatusNoContent,
+	}, {
+		Name: "error, rejected by the IoT Hub",
+
+		RequestBody: map[string]string{
+			"connection_string": "my://connection.string",
+		},
+		RequestHdrs: userHdrs,
+
+		App: func(t *testing.T) *mapp.App {
+			a := new(mapp.App)
+			a.On("RotateConnectionString", contextMatcher, "my://connection.string").
+				Return(fmt.Errorf("401: %w", app.ErrConnectionStringRejected))
+			a.On("AuditLog", contextMatcher, auditLogMatcher(
+				model.AuditActionUpdate, "settings.rotate", model.AuditOutcomeFailure,
+			)).Return(nil)
+			return a
+		},
+
+		RspCode: http.StatusBadRequest,
+		Error:   app.ErrConnectionStringRejected,
+	}, {
+		Name: "error, concurrent modification",
+
+		RequestBody: map[string]string{
+			"connection_string": "my://connection.string",
+		},
+		RequestHdrs: userHdrs,
+
+		App: func(t *testing.T) *mapp.App {
+			a := new(mapp.App)
+			a.On("RotateConnectionString", contextMatcher, "my://connection.string").
+				Return(app.ErrSettingsModified)
+			a.On("AuditLog", contextMatcher, auditLogMatcher(
+				model.AuditActionUpdate, "settings.rotate", model.AuditOutcomeFailure,
+			)).Return(nil)
+			return a
+		},
+
+		RspCode: http.StatusConflict,
+		Error:   app.ErrSettingsModified,
+	}, {
+		Name: "error, missing connection string",
+
+		RequestBody: map[string]string{},
+		RequestHdrs: userHdrs,
+
+		App: func(t *testing.T) *mapp.App {
+			a := new(mapp.App)
+			a.On("AuditLog", contextMatcher, auditLogMatcher(
+				model.AuditActionUpdate, "settings.rotate", model.AuditOutcomeFailure,
+			)).Return(nil)
+			return a
+		},
+
+		RspCode: http.StatusBadRequest,
+		Error:   errors.New("connection_string: cannot be blank"),
+	}, {
+		Name: "error, not a user",
+
+		RequestBody: map[string]string{
+			"connection_string": "my://connection.string",
+		},
+		RequestHdrs: http.Header{
+			"Authorization": []string{"Bearer " + GenerateJWT(identity.Identity{
+				Subject:  uuid.NewString(),
+				Tenant:   "123456789012345678901234",
+				IsDevice: true,
+			})},
+		},
+
+		App: func(t *testing.T) *mapp.App {
+			a := new(mapp.App)
+			a.On("AuditLog", contextMatcher, auditLogMatcher(
+				model.AuditActionUpdate, "settings.rotate", model.AuditOutcomeFailure,
+			)).Return(nil)
+			return a
+		},
+
+		RspCode: http.StatusForbidden,
+		Error:   ErrMissingUserAuthentication,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			app := tc.App(t)
+			defer app.AssertExpectations(t)
+			b, _ := json.Marshal(tc.RequestBody)
+			req, _ := http.NewRequest("PUT",
+				"http://localhost"+APIURLManagement+APIURLSettingsRotate,
+				bytes.NewReader(b),
+			)
+			for k, v := range tc.RequestHdrs {
+				req.Header[k] = v
+			}
+
+			router, _ := NewRouter(app)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.RspCode, w.Code)
+			if tc.Error != nil {
+				var erro rest.Error
+				err := json.Unmarshal(w.Body.Bytes(), &erro)
+				require.NoError(t, err)
+				assert.Contains(t, erro.Error(), tc.Error.Error())
+			} else {
+				assert.Empty(t, w.Body.Bytes(), string(w.Body.Bytes()))
+			}
+		})
+	}
+}
+
+func TestRotateSettingsQuota(t *testing.T) {
+	t.Parallel()
+	const connStr = "HostName=myhub.azure-devices.net;" +
+		"SharedAccessKeyName=iothubowner;SharedAccessKey=bmV3"
+	now := time.Date(2021, 10, 1, 12, 30, 0, 0, time.UTC)
+	ds := &storeMocks.DataStore{}
+	defer ds.AssertExpectations(t)
+	ds.On("GetSettings", contextMatcher).Return(model.Settings{
+		ConnectionString: "HostName=myhub.azure-devices.net;" +
+			"SharedAccessKeyName=iothubowner;SharedAccessKey=b2xk",
+	}, nil)
+	ds.On("IncrementQuota", contextMatcher, "2021-10-01T12", now.Add(30*time.Minute)).
+		Return(int64(11), nil)
+	ds.On("InsertAuditLog", contextMatcher, mock.MatchedBy(func(log model.AuditLog) bool {
+		return log.Outcome == model.AuditOutcomeFailure
+	})).Return(nil)
+	// the IoT Hub is never reached once the quota is exhausted
+	client := &iothubMocks.Client{}
+	defer client.AssertExpectations(t)
+	a := app.New(app.Config{
+		IoTHub:           client,
+		Clock:            clock.NewFake(now),
+		AzureHourlyQuota: 10,
+	}, ds)
+
+	b, _ := json.Marshal(map[string]string{"connection_string": connStr})
+	req, _ := http.NewRequest("PUT",
+		"http://localhost"+APIURLManagement+APIURLSettingsRotate,
+		bytes.NewReader(b),
+	)
+	req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+		Subject: uuid.NewString(),
+		Tenant:  "123456789012345678901234",
+		IsUser:  true,
+	}))
+	router, _ := NewRouter(a)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1800", w.Header().Get("Retry-After"))
+	var erro rest.Error
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &erro))
+	assert.Contains(t, erro.Error(), "quota of 10 Azure operations per hour exceeded")
+}
+
+func TestSettingsConditionalRequests(t *testing.T) {
+	t.Parallel()
+	settings := model.Settings{ConnectionString: "my://connection.string"}
+	authz := "Bearer " + GenerateJWT(identity.Identity{
+		IsUser:  true,
+		Subject: "829cbefb-70e7-438f-9ac5-35fd131c2111",
+		Tenant:  "123456789012345678901234",
+	})
+	testCases := []struct {
+		Name string
+
+		Method  string
+		Headers http.Header
+		Body    interface{}
+
+		App func(t *testing.T) *mapp.App
+
+		StatusCode int
+		ETag       string
+	}{{
+		Name: "GET not modified",
+
+		Method: http.MethodGet,
+		Headers: http.Header{
+			"Authorization": []string{authz},
+			"If-None-Match": []string{`"foo", ` + settings.ETag()},
+		},
+		App: func(t *testing.T) *mapp.App {
+			app := new(mapp.App)
+			app.On("GetSettings", contextMatcher).Return(settings, nil)
+			return app
+		},
+
+		StatusCode: http.StatusNotModified,
+		ETag:       settings.ETag(),
+	}, {
+		Name: "GET modified",
+
+		Method: http.MethodGet,
+		Headers: http.Header{
+			"Authorization": []string{authz},
+			"If-None-Match": []string{model.Settings{}.ETag()},
+		},
+		App: func(t *testing.T) *mapp.App {
+			app := new(mapp.App)
+			app.On("GetSettings", contextMatcher).Return(settings, nil)
+			return app
+		},
+
+		StatusCode: http.StatusOK,
+		ETag:       settings.ETag(),
+	}, {
+		Name: "PUT precondition ok",
+
+		Method: http.MethodPut,
+		Headers: http.Header{
+			"Authorization": []string{authz},
+			"If-Match":      []string{`"foo", ` + settings.ETag()},
+		},
+		Body: model.Settings{ConnectionString: "my://new.connection.string"},
+		App: func(t *testing.T) *mapp.App {
+			app := new(mapp.App)
+			app.On("SetSettingsIfMatch", contextMatcher,
+				model.Settings{ConnectionString: "my://new.connection.string"},
+				mock.MatchedBy(func(match func(model.Settings) bool) bool {
+					return match(settings)
+				})).
+				Return(nil)
+			app.On("AuditLog", contextMatcher, auditLogMatcher(
+				model.AuditActionUpdate, "settings", model.AuditOutcomeSuccess,
+			)).Return(nil)
+			return app
+		},
+
+		StatusCode: http.StatusNoContent,
+		ETag: model.Settings{
+			ConnectionString: "my://new.connection.string",
+		}.ETag(),
+	}, {
+		Name: "PUT precondition failed",
+
+		Method: http.MethodPut,
+		Headers: http.Header{
+			"Authorization": []string{authz},
+			"If-Match":      []string{model.Settings{}.ETag()},
+		},
+		Body: model.Settings{ConnectionString: "my://new.connection.string"},
+		App: func(t *testing.T) *mapp.App {
+			a := new(mapp.App)
+			a.On("SetSettingsIfMatch", contextMatcher,
+				model.Settings{ConnectionString: "my://new.connection.string"},
+				mock.MatchedBy(func(match func(model.Settings) bool) bool {
+					return !match(settings)
+				})).
+				Return(app.ErrPreconditionFailed)
+			a.On("AuditLog", contextMatcher, auditLogMatcher(
+				model.AuditActionUpdate, "settings", model.AuditOutcomeFailure,
+			)).Return(nil)
+			return a
+		},
+
+		StatusCode: http.StatusPreconditionFailed,
+	}, {
+		Name: "PUT precondition failed, weak entity tag",
+
+		Method: http.MethodPut,
+		Headers: http.Header{
+			"Authorization": []string{authz},
+			"If-Match":      []string{"W/" + settings.ETag()},
+		},
+		Body: model.Settings{ConnectionString: "my://new.connection.string"},
+		App: func(t *testing.T) *mapp.App {
+			a := new(mapp.App)
+			a.On("SetSettingsIfMatch", contextMatcher,
+				model.Settings{ConnectionString: "my://new.connection.string"},
+				mock.MatchedBy(func(match func(model.Settings) bool) bool {
+					return !match(settings)
+				})).
+				Return(app.ErrPreconditionFailed)
+			a.On("AuditLog", contextMatcher, auditLogMatcher(
+				model.AuditActionUpdate, "settings", model.AuditOutcomeFailure,
+			)).Return(nil)
+			return a
+		},
+
+		StatusCode: http.StatusPreconditionFailed,
+	}, {
+		Name: "PUT precondition internal error",
+
+		Method: http.MethodPut,
+		Headers: http.Header{
+			"Authorization": []string{authz},
+			"If-Match":      []string{settings.ETag()},
+		},
+		Body: model.Settings{ConnectionString: "my://new.connection.string"},
+		App: func(t *testing.T) *mapp.App {
+			app := new(mapp.App)
+			app.On("SetSettingsIfMatch", contextMatcher,
+				model.Settings{ConnectionString: "my://new.connection.string"},
+				mock.AnythingOfType("func(model.Settings) bool")).
+				Return(errors.New("internal error"))
+			app.On("AuditLog", contextMatcher, auditLogMatcher(
+				model.AuditActionUpdate, "settings", model.AuditOutcomeFailure,
+			)).Return(nil)
+			return app
+		},
+
+		StatusCode: http.StatusInternalServerError,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			app := tc.App(t)
+			defer app.AssertExpectations(t)
+			var body io.Reader
+			if tc.Body != nil {
+				b, _ := json.Marshal(tc.Body)
+				body = bytes.NewReader(b)
+			}
+			req, _ := http.NewRequest(tc.Method,
+				"http://localhost"+APIURLManagement+APIURLSettings,
+				body,
+			)
+			for k, v := range tc.Headers {
+				req.Header[k] = v
+			}
+
+			router, _ := NewRouter(app)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.StatusCode, w.Code)
+			assert.Equal(t, tc.ETag, w.Header().Get("ETag"))
+		})
+	}
+}
+
+func TestMatchIfMatch(t *testing.T) {
+	t.Parallel()
+	settings := model.Settings{ConnectionString: "my://connection.string"}
+	testCases := []struct {
+		Name string
+
+		Header   string
+		Settings model.Settings
+
+		Match bool
+	}{{
+		Name: "ok",
+
+		Header:   `"foo", ` + settings.ETag(),
+		Settings: settings,
+		Match:    true,
+	}, {
+		Name: "ok, no settings",
+
+		Header: model.Settings{}.ETag(),
+		Match:  true,
+	}, {
+		Name: "ok, wildcard",
+
+		Header:   "*",
+		Settings: settings,
+		Match:    true,
+	}, {
+		Name: "no match",
+
+		Header:   `"foo"`,
+		Settings: settings,
+	}, {
+		Name: "no match, weak entity tag",
+
+		Header:   "W/" + settings.ETag(),
+		Settings: settings,
+	}, {
+		Name: "no match, wildcard without settings",
+
+		Header: "*",
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.Match, matchIfMatch(tc.Header, tc.Settings))
+		})
+	}
+}
+
+func TestSettingsRBAC(t *testing.T) {
+	t.Parallel()
+	makeToken := func(claims string) string {
+		return "Bearer e30." +
+			base64.RawURLEncoding.EncodeToString([]byte(claims)) +
+			".c2ln"
+	}
+	readOnlyToken := makeToken(`{
+		"sub": "829cbefb-70e7-438f-9ac5-35fd131c2111",
+		"mender.user": true,
+		"mender.tenant": "123456789012345678901234",
+		"scp": "` + rbac.ScopeRead + `"
+	}`)
+
+	testApp := new(mapp.App)
+	defer testApp.AssertExpectations(t)
+	testApp.On("GetSettings", mock.MatchedBy(func(ctx context.Context) bool {
+		return assert.Equal(t,
+			rbac.Scopes{rbac.ScopeRead},
+			rbac.FromContext(ctx),
+		)
+	})).Return(model.Settings{}, nil)
+	testApp.On("SetSettings", contextMatcher, model.Settings{}).
+		Return(app.ErrForbidden)
+	testApp.On("AuditLog", contextMatcher, auditLogMatcher(
+		model.AuditActionUpdate, "settings", model.AuditOutcomeFailure,
+	)).Return(nil)
+
+	router, _ := NewRouter(testApp)
+
+	req, _ := http.NewRequest("GET", APIURLManagement+APIURLSettings, nil)
+	req.Header.Set("Authorization", readOnlyToken)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("PUT", APIURLManagement+APIURLSettings,
+		bytes.NewReader([]byte("{}")))
+	req.Header.Set("Authorization", readOnlyToken)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	req, _ = http.NewRequest("GET", APIURLManagement+APIURLSettings, nil)
+	req.Header.Set("Authorization",
+		makeToken(`{"sub":"user","mender.user":true,"scp":1}`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestRenderAppError(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Error error
+
+		Status     int
+		RetryAfter string
+		Message    string
+	}{{
+		Name:  "forbidden",
+		Error: app.ErrForbidden,
+
+		Status:  http.StatusForbidden,
+		Message: app.ErrForbidden.Error(),
+	}, {
+		Name: "iothub, device not found",
+		Error: &iothub.Error{
+			Code:       http.StatusNotFound,
+			ErrorCode:  "DeviceNotFound",
+			TrackingID: "0123abcd",
+		},
+
+		Status:  http.StatusNotFound,
+		Message: "iothub: unexpected HTTP status 404 Not Found (tracking ID: 0123abcd)",
+	}, {
+		Name:  "iothub, precondition failed",
+		Error: &iothub.Error{Code: http.StatusPreconditionFailed},
+
+		Status:  http.StatusConflict,
+		Message: "iothub: unexpected HTTP status 412 Precondition Failed",
+	}, {
+		Name: "iothub, throttled",
+		Error: &iothub.Error{
+			Code:       http.StatusTooManyRequests,
+			RetryAfter: 1500 * time.Millisecond,
+		},
+
+		Status:     http.StatusTooManyRequests,
+		RetryAfter: "2",
+		Message:    "iothub: unexpected HTTP status 429 Too Many Requests",
+	}, {
+		Name:  "iothub, internal error",
+		Error: &iothub.Error{Code: http.StatusInternalServerError},
+
+		Status:  http.StatusBadGateway,
+		Message: "iothub: unexpected HTTP status 500 Internal Server Error",
+	}, {
+		Name:  "malformed connection string",
+		Error: model.ErrConnectionStringMalformed,
+
+		Status:  http.StatusBadRequest,
+		Message: model.ErrConnectionStringMalformed.Error(),
+	}, {
+		Name: "quota exceeded",
+		Error: &app.QuotaExceededError{
+			Window:     app.QuotaWindowHour,
+			Limit:      1000,
+			ResetAt:    time.Date(2021, 10, 1, 13, 0, 0, 0, time.UTC),
+			RetryAfter: 30*time.Minute - 500*time.Millisecond,
+		},
+
+		Status:     http.StatusTooManyRequests,
+		RetryAfter: "1800",
+		Message: "quota of 1000 Azure operations per hour exceeded; " +
+			"resets at 2021-10-01T13:00:00Z",
+	}, {
+		Name:  "not entitled",
+		Error: fmt.Errorf("settings_rotation: %w", app.ErrNotEntitled),
+
+		Status:  http.StatusPaymentRequired,
+		Message: "settings_rotation: " + app.ErrNotEntitled.Error(),
+	}, {
+		Name:  "excessive permissions",
+		Error: app.ErrExcessivePermissions,
+
+		Status:  http.StatusBadRequest,
+		Message: app.ErrExcessivePermissions.Error(),
+	}, {
+		Name:  "host name changed",
+		Error: app.ErrHostNameChanged,
+
+		Status:  http.StatusBadRequest,
+		Message: app.ErrHostNameChanged.Error(),
+	}, {
+		Name:  "integration not configured",
+		Error: app.ErrIntegrationNotConfigured,
+
+		Status:  http.StatusConflict,
+		Message: app.ErrIntegrationNotConfigured.Error(),
+	}, {
+		Name:  "internal error",
+		Error: errors.New("mongo error"),
+
+		Status:  http.StatusInternalServerError,
+		Message: http.StatusText(http.StatusInternalServerError),
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodGet, "/", nil)
+
+			renderAppError(c, tc.Error)
+			assert.Equal(t, tc.Status, w.Code)
+			assert.Equal(t, tc.RetryAfter, w.Header().Get(hdrRetryAfter))
+			var body rest.Error
+			_ = json.Unmarshal(w.Body.Bytes(), &body)
+			assert.Equal(t, tc.Message, body.Err)
+		})
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/rest.utils"
+
+	"github.com/mendersoftware/azure-iot-manager/clock"
+)
+
+const (
+	hdrRateLimitLimit     = "X-RateLimit-Limit"
+	hdrRateLimitRemaining = "X-RateLimit-Remaining"
+	hdrRateLimitReset     = "X-RateLimit-Reset"
+	hdrRetryAfter         = "Retry-After"
+)
+
+var (
+	ErrRateLimitExceeded = errors.New("rate limit exceeded")
+)
+
+// RateLimiter limits the management API requests of each tenant, it
+// counts them in fixed time windows. The limit can be changed at runtime.
+type RateLimiter struct {
+	window time.Duration
+	clock  clock.Clock
+
+	mu     sync.Mutex
+	limit  int
+	start  time.Time
+	counts map[string]int
+}
+
+// NewRateLimiter returns a RateLimiter limiting the requests of each
+// tenant to limit per window; a zero limit disables the rate limiting.
+func NewRateLimiter(limit int, window time.Duration, clk clock.Clock) *RateLimiter {
+	return &RateLimiter{
+		limit:  limit,
+		window: window,
+		clock:  clk,
+	}
+}
+
+// SetLimit changes the limit of requests per window, the requests
+// already counted in the current window are kept.
+func (r *RateLimiter) SetLimit(limit int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limit = limit
+}
+
+// take counts a request for the tenant and returns the limit, the number
+// of requests left in the current window, negative if the limit is
+// exceeded, and the time until the window resets. A zero limit means the
+// rate limiting is disabled.
+func (r *RateLimiter) take(tenantID string) (int, int, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.limit <= 0 {
+		return 0, 0, 0
+	}
+	now := r.clock.Now()
+	if start := now.Truncate(r.window); !start.Equal(r.start) {
+		r.start = start
+		r.counts = make(map[string]int)
+	}
+	r.counts[tenantID]++
+	return r.limit, r.limit - r.counts[tenantID], r.start.Add(r.window).Sub(now)
+}
+
+// Middleware returns the middleware applying the rate limit. The limit
+// state is returned in the X-RateLimit-* headers (the reset is in
+// seconds), requests beyond the limit are rejected with 429 Too Many
+// Requests. The requests are counted per instance of the service.
+func (r *RateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var tenantID string
+		if id := identity.FromContext(c.Request.Context()); id != nil {
+			tenantID = id.Tenant
+		}
+		limit, remaining, reset := r.take(tenantID)
+		if limit <= 0 {
+			return
+		}
+		// round the reset up to whole seconds
+		resetSeconds := strconv.FormatInt(
+			int64((reset+time.Second-1)/time.Second), 10,
+		)
+		c.Header(hdrRateLimitLimit, strconv.Itoa(limit))
+		c.Header(hdrRateLimitReset, resetSeconds)
+		if remaining < 0 {
+			c.Header(hdrRateLimitRemaining, "0")
+			c.Header(hdrRetryAfter, resetSeconds)
+			rest.RenderError(c, http.StatusTooManyRequests, ErrRateLimitExceeded)
+			c.Abort()
+			return
+		}
+		c.Header(hdrRateLimitRemaining, strconv.Itoa(remaining))
+	}
+}
+
+// RateLimitMiddleware returns a middleware limiting the requests of each
+// tenant to limit per window, see RateLimiter.
+func RateLimitMiddleware(
+	limit int,
+	window time.Duration,
+	clk clock.Clock,
+) gin.HandlerFunc {
+	return NewRateLimiter(limit, window, clk).Middleware()
+}
@@ -0,0 +1,129 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	app_mocks "github.com/mendersoftware/azure-iot-manager/app/mocks"
+	"github.com/mendersoftware/azure-iot-manager/clock"
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2021, 10, 1, 12, 0, 10, 0, time.UTC)
+	clk := clock.NewFake(start)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		ctx := identity.WithContext(c.Request.Context(), &identity.Identity{
+			Tenant: c.GetHeader("X-Tenant"),
+		})
+		c.Request = c.Request.WithContext(ctx)
+	}, RateLimitMiddleware(2, time.Minute, clk))
+	router.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	request := func(tenantID string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Tenant", tenantID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	type result struct {
+		Code       int
+		Remaining  string
+		Reset      string
+		RetryAfter string
+	}
+	check := func(expected result, w *httptest.ResponseRecorder) {
+		assert.Equal(t, expected, result{
+			Code:       w.Code,
+			Remaining:  w.Header().Get(hdrRateLimitRemaining),
+			Reset:      w.Header().Get(hdrRateLimitReset),
+			RetryAfter: w.Header().Get(hdrRetryAfter),
+		})
+		assert.Equal(t, "2", w.Header().Get(hdrRateLimitLimit))
+	}
+
+	check(result{http.StatusNoContent, "1", "50", ""}, request("tenant1"))
+	clk.Advance(500 * time.Millisecond)
+	check(result{http.StatusNoContent, "0", "50", ""}, request("tenant1"))
+	check(result{http.StatusTooManyRequests, "0", "50", "50"}, request("tenant1"))
+	// the tenants are limited independently
+	check(result{http.StatusNoContent, "1", "50", ""}, request("tenant2"))
+
+	clk.Advance(50 * time.Second)
+	check(result{http.StatusNoContent, "1", "60", ""}, request("tenant1"))
+}
+
+func TestRateLimiterSetLimit(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewFake(time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC))
+	limiter := NewRateLimiter(0, time.Minute, clk)
+
+	limit, _, _ := limiter.take("tenant")
+	assert.Equal(t, 0, limit)
+
+	limiter.SetLimit(2)
+	limit, remaining, _ := limiter.take("tenant")
+	assert.Equal(t, 2, limit)
+	assert.Equal(t, 1, remaining)
+
+	// the requests counted in the window are kept
+	limiter.SetLimit(1)
+	limit, remaining, _ = limiter.take("tenant")
+	assert.Equal(t, 1, limit)
+	assert.Equal(t, -1, remaining)
+}
+
+func TestNewRouterRateLimit(t *testing.T) {
+	t.Parallel()
+	app := &app_mocks.App{}
+	defer app.AssertExpectations(t)
+	app.On("GetSettings", contextMatcher).
+		Return(model.Settings{}, nil).
+		Once()
+
+	router, err := NewRouter(app, NewRouterOptions().SetRateLimit(1, time.Hour))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	req, _ := http.NewRequest(http.MethodGet, APIURLManagement+APIURLSettings, nil)
+	req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+		Subject: "user",
+		Tenant:  "tenant",
+		IsUser:  true,
+	}))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0", w.Header().Get(hdrRateLimitRemaining))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
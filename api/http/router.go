@@ -15,6 +15,9 @@
 package http
 
 import (
+	"net/http"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 
@@ -23,6 +26,10 @@ import (
 	"github.com/mendersoftware/go-lib-micro/requestid"
 
 	"github.com/mendersoftware/azure-iot-manager/app"
+	"github.com/mendersoftware/azure-iot-manager/client/sentry"
+	"github.com/mendersoftware/azure-iot-manager/clock"
+	"github.com/mendersoftware/azure-iot-manager/docs"
+	"github.com/mendersoftware/azure-iot-manager/jwt"
 )
 
 // API URL used by the HTTP router
@@ -31,34 +38,252 @@ const (
 
 	APIURLAlive  = "/alive"
 	APIURLHealth = "/health"
+	APIURLReady  = "/ready"
+
+	APIURLVersion = "/version"
+
+	APIURLLogLevels = "/log/levels"
+
+	APIURLFleetHealth     = "/fleet/health"
+	APIURLAuditLogsExport = "/auditlogs/export"
+	APIURLMigrations      = "/migrations"
+	APIURLUsage           = "/usage"
+	APIURLTenantFeatures  = "/tenants/:tenant_id/features"
+	APIURLTenantFeature   = "/tenants/:tenant_id/features/:name"
 
 	APIURLManagement = "/api/management/v1/azure-iot-manager"
 
-	APIURLSettings = "/settings"
+	APIURLSettings       = "/settings"
+	APIURLSettingsRotate = "/settings/rotate"
+
+	APIURLOpenAPI = "/openapi.json"
 )
 
+// RouterOptions contains the optional parameters of the HTTP router
+type RouterOptions struct {
+	// InternalAPIKeys enables authentication of the internal API with
+	// the given API keys.
+	InternalAPIKeys []string
+
+	// InternalAPIAllowedCIDRs restricts the source addresses allowed to
+	// access the internal API to the given networks.
+	InternalAPIAllowedCIDRs []string
+
+	// JWTVerifier enables verification of the management API tokens.
+	JWTVerifier jwt.Verifier
+
+	// RateLimit limits the number of management API requests of each
+	// tenant per RateLimitWindow; zero disables the rate limiting.
+	RateLimit       int
+	RateLimitWindow time.Duration
+
+	// RateLimiter limits the management API requests instead of
+	// RateLimit, so that the limit can be changed at runtime.
+	RateLimiter *RateLimiter
+
+	// UsageMetering enables metering of the management API requests of
+	// each tenant.
+	UsageMetering bool
+
+	// ErrorReporter enables reporting of the panics and internal server
+	// errors to an error tracker.
+	ErrorReporter sentry.Client
+
+	// Clock is the clock of the middlewares, defaults to the system clock.
+	Clock clock.Clock
+}
+
+// NewRouterOptions returns a new RouterOptions
+func NewRouterOptions() *RouterOptions {
+	return new(RouterOptions)
+}
+
+// SetInternalAPIKeys sets the API keys accepted by the internal API
+func (o *RouterOptions) SetInternalAPIKeys(keys ...string) *RouterOptions {
+	o.InternalAPIKeys = keys
+	return o
+}
+
+// SetInternalAPIAllowedCIDRs sets the networks allowed to access the
+// internal API
+func (o *RouterOptions) SetInternalAPIAllowedCIDRs(cidrs ...string) *RouterOptions {
+	o.InternalAPIAllowedCIDRs = cidrs
+	return o
+}
+
+// SetJWTVerifier sets the verifier of the management API tokens
+func (o *RouterOptions) SetJWTVerifier(verifier jwt.Verifier) *RouterOptions {
+	o.JWTVerifier = verifier
+	return o
+}
+
+// SetRateLimit limits the number of management API requests of each
+// tenant per window
+func (o *RouterOptions) SetRateLimit(limit int, window time.Duration) *RouterOptions {
+	o.RateLimit = limit
+	o.RateLimitWindow = window
+	return o
+}
+
+// SetRateLimiter sets the limiter of the management API requests
+func (o *RouterOptions) SetRateLimiter(limiter *RateLimiter) *RouterOptions {
+	o.RateLimiter = limiter
+	return o
+}
+
+// SetUsageMetering enables metering of the management API requests
+func (o *RouterOptions) SetUsageMetering(enabled bool) *RouterOptions {
+	o.UsageMetering = enabled
+	return o
+}
+
+// SetErrorReporter sets the client of the error tracker receiving the
+// reports of the panics and internal server errors
+func (o *RouterOptions) SetErrorReporter(reporter sentry.Client) *RouterOptions {
+	o.ErrorReporter = reporter
+	return o
+}
+
+// SetClock sets the clock of the middlewares
+func (o *RouterOptions) SetClock(clk clock.Clock) *RouterOptions {
+	o.Clock = clk
+	return o
+}
+
+func mergeRouterOptions(opts []*RouterOptions) *RouterOptions {
+	opt := NewRouterOptions()
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if o.InternalAPIKeys != nil {
+			opt.InternalAPIKeys = o.InternalAPIKeys
+		}
+		if o.InternalAPIAllowedCIDRs != nil {
+			opt.InternalAPIAllowedCIDRs = o.InternalAPIAllowedCIDRs
+		}
+		if o.JWTVerifier != nil {
+			opt.JWTVerifier = o.JWTVerifier
+		}
+		if o.RateLimit > 0 && o.RateLimitWindow > 0 {
+			opt.RateLimit = o.RateLimit
+			opt.RateLimitWindow = o.RateLimitWindow
+		}
+		if o.RateLimiter != nil {
+			opt.RateLimiter = o.RateLimiter
+		}
+		if o.UsageMetering {
+			opt.UsageMetering = true
+		}
+		if o.ErrorReporter != nil {
+			opt.ErrorReporter = o.ErrorReporter
+		}
+		if o.Clock != nil {
+			opt.Clock = o.Clock
+		}
+	}
+	if opt.Clock == nil {
+		opt.Clock = clock.New()
+	}
+	return opt
+}
+
 // NewRouter returns the gin router
-func NewRouter(app app.App) (*gin.Engine, error) {
+func NewRouter(app app.App, opts ...*RouterOptions) (*gin.Engine, error) {
+	opt := mergeRouterOptions(opts)
 	gin.SetMode(gin.ReleaseMode)
 	gin.DisableConsoleColor()
 
 	router := gin.New()
 	router.Use(accesslog.Middleware())
 	router.Use(requestid.Middleware())
+	router.Use(AccessLogMiddleware())
+	if opt.ErrorReporter != nil {
+		router.Use(ErrorReportingMiddleware(opt.ErrorReporter, opt.Clock))
+	}
+
+	internalSpec, err := docs.InternalAPI()
+	if err != nil {
+		return nil, err
+	}
+	managementSpec, err := docs.ManagementAPI()
+	if err != nil {
+		return nil, err
+	}
 
 	status := NewStatusController(app)
+	// Probes are exempted from the internal API access control
+	probes := []string{
+		APIURLInternal + APIURLAlive,
+		APIURLInternal + APIURLReady,
+	}
 	internalAPI := router.Group(APIURLInternal)
+	if len(opt.InternalAPIAllowedCIDRs) > 0 {
+		networks, err := ParseCIDRs(opt.InternalAPIAllowedCIDRs)
+		if err != nil {
+			return nil, err
+		}
+		internalAPI.Use(IPAllowlistMiddleware(networks, probes...))
+	}
+	if len(opt.InternalAPIKeys) > 0 {
+		internalAPI.Use(APIKeyMiddleware(opt.InternalAPIKeys, probes...))
+	}
 	internalAPI.GET(APIURLAlive, status.Alive)
 	internalAPI.GET(APIURLHealth, status.Health)
+	internalAPI.GET(APIURLReady, status.Ready)
+	internalAPI.GET(APIURLVersion, status.Version)
+	internalAPI.GET(APIURLOpenAPI, serveSpecification(internalSpec))
+	internalAPI.GET(APIURLLogLevels, status.GetLogLevels)
+	internalAPI.PUT(APIURLLogLevels, status.SetLogLevels)
+
+	internal := NewInternalController(app)
+	internalAPI.GET(APIURLFleetHealth, internal.FleetHealth)
+	internalAPI.GET(APIURLAuditLogsExport, internal.ExportAuditLogs)
+	internalAPI.GET(APIURLMigrations, internal.MigrationStatus)
+	internalAPI.GET(APIURLUsage, internal.GetUsage)
+	internalAPI.GET(APIURLTenantFeatures, internal.GetFeatureFlags)
+	internalAPI.PUT(APIURLTenantFeature, internal.SetFeatureFlag)
+	internalAPI.DELETE(APIURLTenantFeature, internal.DeleteFeatureFlag)
+
+	// The specification is public and does not require authentication.
+	router.GET(APIURLManagement+APIURLOpenAPI, serveSpecification(managementSpec))
 
 	management := NewManagementController(app)
-	managementAPI := router.Group(APIURLManagement, identity.Middleware())
+	managementMiddleware := []gin.HandlerFunc{
+		identity.Middleware(),
+		ScopesMiddleware(),
+	}
+	if opt.RateLimiter != nil {
+		managementMiddleware = append(managementMiddleware, opt.RateLimiter.Middleware())
+	} else if opt.RateLimit > 0 {
+		managementMiddleware = append(managementMiddleware,
+			RateLimitMiddleware(opt.RateLimit, opt.RateLimitWindow, opt.Clock),
+		)
+	}
+	if opt.UsageMetering {
+		managementMiddleware = append(managementMiddleware, UsageMiddleware(app))
+	}
+	managementMiddleware = append(managementMiddleware, AuditMiddleware(app))
+	if opt.JWTVerifier != nil {
+		managementMiddleware = append([]gin.HandlerFunc{
+			JWTVerificationMiddleware(opt.JWTVerifier),
+		}, managementMiddleware...)
+	}
+	managementAPI := router.Group(APIURLManagement, managementMiddleware...)
 	managementAPI.GET(APIURLSettings, management.GetSettings)
 	managementAPI.PUT(APIURLSettings, management.SetSettings)
+	managementAPI.PUT(APIURLSettingsRotate, management.RotateSettings)
+	managementAPI.GET(APIURLAuditLogsExport, management.ExportAuditLogs)
 
 	return router, nil
 }
 
+func serveSpecification(spec []byte) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, gin.MIMEJSON, spec)
+	}
+}
+
 // Make gin-gonic use validatable structs instead of relying on go-playground
 // validator interface.
 type validateValidatableValidator struct{}
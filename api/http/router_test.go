@@ -0,0 +1,99 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	app_mocks "github.com/mendersoftware/azure-iot-manager/app/mocks"
+)
+
+type openAPISpec struct {
+	OpenAPI string                                `json:"openapi"`
+	Paths   map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+var ginParamRegex = regexp.MustCompile(`[:*]([^/]+)`)
+
+func TestOpenAPIRouteCoverage(t *testing.T) {
+	router, err := NewRouter(&app_mocks.App{})
+	require.NoError(t, err)
+
+	specs := map[string]*openAPISpec{
+		APIURLInternal:   nil,
+		APIURLManagement: nil,
+	}
+	for prefix := range specs {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", prefix+APIURLOpenAPI, nil)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		spec := new(openAPISpec)
+		err := json.Unmarshal(w.Body.Bytes(), spec)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(spec.OpenAPI, "3."),
+			"unexpected OpenAPI version %q", spec.OpenAPI)
+		specs[prefix] = spec
+	}
+
+	routes := make(map[string]bool)
+	for _, route := range router.Routes() {
+		var (
+			spec         *openAPISpec
+			path, prefix string
+		)
+		for p, s := range specs {
+			if strings.HasPrefix(route.Path, p+"/") {
+				spec, prefix = s, p
+				path = strings.TrimPrefix(route.Path, prefix)
+				break
+			}
+		}
+		if !assert.NotNil(t, spec, "route %s does not belong to any API", route.Path) {
+			continue
+		}
+		path = ginParamRegex.ReplaceAllString(path, "{$1}")
+		method := strings.ToLower(route.Method)
+		routes[prefix+path+" "+method] = true
+		if assert.Contains(t, spec.Paths, path,
+			"path %s is missing in the specification", route.Path) {
+			assert.Contains(t, spec.Paths[path], method,
+				"%s %s is missing in the specification",
+				route.Method, route.Path)
+		}
+	}
+	for prefix, spec := range specs {
+		for path, methods := range spec.Paths {
+			for method := range methods {
+				if method == "parameters" {
+					continue
+				}
+				assert.True(t, routes[prefix+path+" "+method],
+					"%s %s%s is documented but not routed",
+					strings.ToUpper(method), prefix, path)
+			}
+		}
+	}
+}
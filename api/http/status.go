@@ -17,13 +17,17 @@ package http
 import (
 	"context"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/rest.utils"
 	"github.com/pkg/errors"
 
 	"github.com/mendersoftware/azure-iot-manager/app"
+	"github.com/mendersoftware/azure-iot-manager/logging"
+	"github.com/mendersoftware/azure-iot-manager/version"
 )
 
 const (
@@ -45,13 +49,41 @@ func (h StatusController) Alive(c *gin.Context) {
 	c.Writer.WriteHeader(http.StatusNoContent)
 }
 
+const (
+	paramDetail = "detail"
+)
+
 // Health responds to GET /health
+// If the "detail" query parameter is set, the response contains a report
+// with the status of each dependency.
 func (h StatusController) Health(c *gin.Context) {
 	ctx := c.Request.Context()
 	l := log.FromContext(ctx)
 	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
 	defer cancel()
 
+	var detail bool
+	if q := c.Query(paramDetail); q != "" {
+		var err error
+		detail, err = strconv.ParseBool(q)
+		if err != nil {
+			rest.RenderError(c, http.StatusBadRequest,
+				errors.Errorf("invalid %s query: %q", paramDetail, q),
+			)
+			return
+		}
+	}
+	if detail {
+		report := h.app.HealthReport(ctx)
+		status := http.StatusOK
+		if !report.Healthy() {
+			l.Errorf("health check failed: %v", report)
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, report)
+		return
+	}
+
 	err := h.app.HealthCheck(ctx)
 	if err != nil {
 		l.Error(errors.Wrap(err, "health check failed"))
@@ -63,3 +95,53 @@ func (h StatusController) Health(c *gin.Context) {
 
 	c.Writer.WriteHeader(http.StatusNoContent)
 }
+
+// Ready responds to GET /ready; unlike Alive, it fails when the service
+// dependencies are unreachable and the service cannot serve requests.
+func (h StatusController) Ready(c *gin.Context) {
+	ctx := c.Request.Context()
+	l := log.FromContext(ctx)
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	err := h.app.HealthCheck(ctx)
+	if err != nil {
+		l.Warn(errors.Wrap(err, "readiness check failed"))
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.Writer.WriteHeader(http.StatusNoContent)
+}
+
+// Version responds to GET /version with the build information
+func (h StatusController) Version(c *gin.Context) {
+	c.JSON(http.StatusOK, version.Get())
+}
+
+// GetLogLevels responds to GET /log/levels with the current log levels
+func (h StatusController) GetLogLevels(c *gin.Context) {
+	c.JSON(http.StatusOK, logging.GetLevels())
+}
+
+// SetLogLevels responds to PUT /log/levels; the levels apply to this
+// instance only, until the configuration is reloaded.
+func (h StatusController) SetLogLevels(c *gin.Context) {
+	var levels logging.Levels
+	if err := c.ShouldBindJSON(&levels); err != nil {
+		rest.RenderError(c,
+			http.StatusBadRequest,
+			errors.New("malformed request body"),
+		)
+		return
+	}
+	if err := logging.SetLevels(levels); err != nil {
+		rest.RenderError(c, http.StatusBadRequest, err)
+		return
+	}
+	log.FromContext(c.Request.Context()).
+		Infof("log levels changed: %+v", logging.GetLevels())
+	c.Status(http.StatusNoContent)
+}
@@ -16,15 +16,20 @@ package http
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
 	app_mocks "github.com/mendersoftware/azure-iot-manager/app/mocks"
+	"github.com/mendersoftware/azure-iot-manager/logging"
+	"github.com/mendersoftware/azure-iot-manager/model"
+	"github.com/mendersoftware/azure-iot-manager/version"
 )
 
 func TestAlive(t *testing.T) {
@@ -85,3 +90,209 @@ func TestHealth(t *testing.T) {
 		})
 	}
 }
+
+func TestReady(t *testing.T) {
+	testCases := []struct {
+		Name           string
+		HealthCheckErr error
+
+		HTTPStatus int
+	}{
+		{
+			Name:       "ok",
+			HTTPStatus: http.StatusNoContent,
+		},
+		{
+			Name:           "ko",
+			HealthCheckErr: errors.New("error"),
+			HTTPStatus:     http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			azureIotManagerApp := &app_mocks.App{}
+			azureIotManagerApp.On("HealthCheck",
+				mock.MatchedBy(func(_ context.Context) bool {
+					return true
+				})).Return(tc.HealthCheckErr)
+
+			router, _ := NewRouter(azureIotManagerApp)
+			req, err := http.NewRequest("GET", APIURLInternal+APIURLReady, nil)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.HTTPStatus, w.Code)
+			if tc.HTTPStatus == http.StatusNoContent {
+				assert.Nil(t, w.Body.Bytes())
+			}
+
+			azureIotManagerApp.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHealthDetail(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Query  string
+		Report *model.HealthReport
+
+		HTTPStatus int
+	}{
+		{
+			Name:  "ok",
+			Query: "?detail=true",
+			Report: &model.HealthReport{
+				Status: model.HealthStatusOK,
+				Dependencies: []model.DependencyHealth{{
+					Name:    "mongo",
+					Status:  model.HealthStatusOK,
+					Latency: 1.5,
+				}},
+			},
+			HTTPStatus: http.StatusOK,
+		},
+		{
+			Name:  "ko",
+			Query: "?detail=1",
+			Report: &model.HealthReport{
+				Status: model.HealthStatusError,
+				Dependencies: []model.DependencyHealth{{
+					Name:    "mongo",
+					Status:  model.HealthStatusError,
+					Latency: 10000,
+					Error:   "context deadline exceeded",
+				}},
+			},
+			HTTPStatus: http.StatusServiceUnavailable,
+		},
+		{
+			Name:       "error, invalid detail parameter",
+			Query:      "?detail=maybe",
+			HTTPStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			azureIotManagerApp := &app_mocks.App{}
+			defer azureIotManagerApp.AssertExpectations(t)
+			if tc.Report != nil {
+				azureIotManagerApp.On("HealthReport",
+					mock.MatchedBy(func(_ context.Context) bool {
+						return true
+					})).Return(*tc.Report)
+			}
+
+			router, _ := NewRouter(azureIotManagerApp)
+			req, err := http.NewRequest("GET",
+				APIURLInternal+APIURLHealth+tc.Query, nil)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.HTTPStatus, w.Code)
+			if tc.Report != nil {
+				b, _ := json.Marshal(tc.Report)
+				assert.JSONEq(t, string(b), w.Body.String())
+			}
+		})
+	}
+}
+
+func TestVersion(t *testing.T) {
+	azureIotManagerApp := &app_mocks.App{}
+	defer azureIotManagerApp.AssertExpectations(t)
+
+	router, _ := NewRouter(azureIotManagerApp)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", APIURLInternal+APIURLVersion, nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	b, _ := json.Marshal(version.Get())
+	assert.JSONEq(t, string(b), w.Body.String())
+}
+
+func TestLogLevels(t *testing.T) {
+	levels := logging.GetLevels()
+	defer func() { _ = logging.SetLevels(levels) }()
+
+	testCases := []struct {
+		Name string
+		Body string
+
+		HTTPStatus int
+		Levels     logging.Levels
+	}{
+		{
+			Name: "ok",
+			Body: `{"default": "info", "components": {"store": "debug"}}`,
+
+			HTTPStatus: http.StatusNoContent,
+			Levels: logging.Levels{
+				Default:    "info",
+				Components: map[string]string{"store": "debug"},
+			},
+		},
+		{
+			Name: "ok, default only",
+			Body: `{"default": "warning"}`,
+
+			HTTPStatus: http.StatusNoContent,
+			Levels: logging.Levels{
+				Default:    "warning",
+				Components: map[string]string{},
+			},
+		},
+		{
+			Name: "error, unknown component",
+			Body: `{"default": "info", "components": {"workers": "debug"}}`,
+
+			HTTPStatus: http.StatusBadRequest,
+		},
+		{
+			Name: "error, invalid level",
+			Body: `{"default": "verbose"}`,
+
+			HTTPStatus: http.StatusBadRequest,
+		},
+		{
+			Name: "error, malformed body",
+			Body: `levels`,
+
+			HTTPStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			azureIotManagerApp := &app_mocks.App{}
+			defer azureIotManagerApp.AssertExpectations(t)
+			router, _ := NewRouter(azureIotManagerApp)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("PUT", APIURLInternal+APIURLLogLevels,
+				strings.NewReader(tc.Body))
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.HTTPStatus, w.Code)
+			if tc.HTTPStatus != http.StatusNoContent {
+				return
+			}
+
+			w = httptest.NewRecorder()
+			req, _ = http.NewRequest("GET", APIURLInternal+APIURLLogLevels, nil)
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+			b, _ := json.Marshal(tc.Levels)
+			assert.JSONEq(t, string(b), w.Body.String())
+		})
+	}
+}
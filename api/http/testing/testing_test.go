@@ -0,0 +1,125 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package testing
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	api "github.com/mendersoftware/azure-iot-manager/api/http"
+	"github.com/mendersoftware/azure-iot-manager/jwt"
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+func TestManagementRequest(t *testing.T) {
+	router, app, err := NewRouter()
+	require.NoError(t, err)
+	defer app.AssertExpectations(t)
+
+	settings := model.Settings{ConnectionString: "HostName=localhost;" +
+		"SharedAccessKeyName=iothubowner;SharedAccessKey=c2VjcmV0"}
+	app.On("GetSettings", mock.MatchedBy(func(ctx context.Context) bool {
+		id := identity.FromContext(ctx)
+		return id != nil && id.Tenant == "tenant"
+	})).Return(settings, nil)
+
+	req, err := NewManagementRequest(http.MethodGet, api.APIURLSettings).
+		WithIdentity(identity.Identity{
+			Subject: "user",
+			Tenant:  "tenant",
+			IsUser:  true,
+		}).
+		Build()
+	require.NoError(t, err)
+	w := Do(router, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, err = NewManagementRequest(http.MethodGet, api.APIURLSettings).Build()
+	require.NoError(t, err)
+	w = Do(router, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestInternalRequest(t *testing.T) {
+	router, app, err := NewRouter(
+		api.NewRouterOptions().SetInternalAPIKeys("key"),
+	)
+	require.NoError(t, err)
+	defer app.AssertExpectations(t)
+
+	app.On("SetFeatureFlag",
+		mock.Anything, "test_feature", false,
+	).Return(nil)
+
+	enabled := false
+	req, err := NewInternalRequest(http.MethodPut,
+		"/tenants/tenant/features/test_feature").
+		WithToken("key").
+		WithJSON(model.FeatureFlagOverride{Enabled: &enabled}).
+		Build()
+	require.NoError(t, err)
+	w := Do(router, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	_, err = NewInternalRequest(http.MethodPut, "/").
+		WithJSON(func() {}).
+		Build()
+	assert.Error(t, err)
+}
+
+func TestSignJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecKey384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	id := identity.Identity{Subject: "user", Tenant: "tenant", IsUser: true}
+	for _, key := range []crypto.Signer{rsaKey, ecKey, edKey} {
+		token, err := SignJWT(id, key, "kid")
+		if assert.NoError(t, err) {
+			verifier := jwt.NewKeyVerifier(key.Public())
+			assert.NoError(t, verifier.Verify(token))
+		}
+	}
+	_, err = SignJWT(id, ecKey384, "")
+	assert.Error(t, err)
+}
+
+func TestGenerateJWT(t *testing.T) {
+	id := identity.Identity{Subject: "user", Tenant: "tenant", IsUser: true}
+	parsed, err := identity.ExtractIdentity(GenerateJWT(id))
+	require.NoError(t, err)
+	b, _ := json.Marshal(id)
+	p, _ := json.Marshal(parsed)
+	assert.JSONEq(t, string(b), string(p))
+}
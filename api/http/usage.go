@@ -0,0 +1,73 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/rest.utils"
+
+	"github.com/mendersoftware/azure-iot-manager/app"
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+const (
+	paramPeriod = "period"
+)
+
+// UsageMiddleware returns a middleware metering the management API
+// requests of each tenant. Metering is best effort: failures are logged
+// and never fail the request.
+func UsageMiddleware(app app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		id := identity.FromContext(ctx)
+		if id == nil || id.Tenant == "" {
+			return
+		}
+		err := app.RecordUsage(ctx, model.UsageAPICalls)
+		if err != nil {
+			log.FromContext(ctx).
+				Warnf("failed to record API call usage: %s", err)
+		}
+	}
+}
+
+// GET /usage
+func (h *InternalController) GetUsage(c *gin.Context) {
+	// the app defaults to the current period
+	var period string
+	if q := c.Query(paramPeriod); q != "" {
+		var err error
+		period, err = model.ParseUsagePeriod(q)
+		if err != nil {
+			rest.RenderError(c, http.StatusBadRequest,
+				errors.Errorf("invalid %s query: %q", paramPeriod, q),
+			)
+			return
+		}
+	}
+	usage, err := h.app.GetUsage(c.Request.Context(), period)
+	if err != nil {
+		renderAppError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, usage)
+}
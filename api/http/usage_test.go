@@ -0,0 +1,145 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	app_mocks "github.com/mendersoftware/azure-iot-manager/app/mocks"
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+func TestUsageMiddleware(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		Name string
+
+		RecordUsageErr error
+	}{{
+		Name: "ok",
+	}, {
+		Name: "ok, metering error",
+
+		RecordUsageErr: errors.New("mongo error"),
+	}}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			app := &app_mocks.App{}
+			defer app.AssertExpectations(t)
+			app.On("RecordUsage", contextMatcher, model.UsageAPICalls).
+				Return(tc.RecordUsageErr).
+				Once()
+			app.On("GetSettings", contextMatcher).
+				Return(model.Settings{}, nil).
+				Once()
+
+			router, err := NewRouter(app, NewRouterOptions().SetUsageMetering(true))
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			req, _ := http.NewRequest(http.MethodGet,
+				APIURLManagement+APIURLSettings, nil)
+			req.Header.Set("Authorization", "Bearer "+GenerateJWT(identity.Identity{
+				Subject: "user",
+				Tenant:  "tenant",
+				IsUser:  true,
+			}))
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+		})
+	}
+}
+
+func TestGetUsage(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Query string
+
+		Period string
+		Usage  []model.Usage
+		Error  error
+
+		HTTPStatus int
+	}{
+		{
+			Name: "ok, current period",
+
+			Usage:      []model.Usage{},
+			HTTPStatus: http.StatusOK,
+		},
+		{
+			Name:  "ok, period",
+			Query: "?period=2021-10",
+
+			Period: "2021-10",
+			Usage: []model.Usage{{
+				TenantID:        "tenant",
+				Period:          "2021-10",
+				APICalls:        10,
+				AzureOperations: 2,
+			}},
+			HTTPStatus: http.StatusOK,
+		},
+		{
+			Name:  "error, invalid period",
+			Query: "?period=october",
+
+			HTTPStatus: http.StatusBadRequest,
+		},
+		{
+			Name:  "error, internal error",
+			Query: "?period=2021-10",
+
+			Period:     "2021-10",
+			Error:      errors.New("internal error"),
+			HTTPStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			azureIotManagerApp := &app_mocks.App{}
+			defer azureIotManagerApp.AssertExpectations(t)
+			if tc.HTTPStatus != http.StatusBadRequest {
+				azureIotManagerApp.On("GetUsage", contextMatcher, tc.Period).
+					Return(tc.Usage, tc.Error)
+			}
+
+			router, _ := NewRouter(azureIotManagerApp)
+			req, _ := http.NewRequest("GET",
+				APIURLInternal+APIURLUsage+tc.Query, nil)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.HTTPStatus, w.Code)
+			if tc.HTTPStatus == http.StatusOK {
+				b, _ := json.Marshal(tc.Usage)
+				assert.JSONEq(t, string(b), w.Body.String())
+			}
+		})
+	}
+}
@@ -16,40 +16,310 @@ package app
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"time"
 
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/azure-iot-manager/client/auditlogs"
+	"github.com/mendersoftware/azure-iot-manager/client/iothub"
+	"github.com/mendersoftware/azure-iot-manager/clock"
 	"github.com/mendersoftware/azure-iot-manager/model"
 	"github.com/mendersoftware/azure-iot-manager/store"
 )
 
+const (
+	// healthHubSample is the number of integrations checked for the IoT
+	// Hubs of the health report
+	healthHubSample = 3
+	// auditLogsMaxLag is the age of the oldest audit log not forwarded
+	// beyond which the forwarding is reported as failing
+	auditLogsMaxLag = time.Hour
+	// healthCheckTimeout bounds the duration of the dependency checks
+	healthCheckTimeout = 10 * time.Second
+)
+
+var (
+	ErrPreconditionFailed = errors.New(
+		"the settings have been modified by another request",
+	)
+)
+
 // App interface describes app objects
 //nolint:lll
 //go:generate ../utils/mockgen.sh
 type App interface {
 	HealthCheck(ctx context.Context) error
+	HealthReport(ctx context.Context) model.HealthReport
+	MigrationStatus(ctx context.Context) (model.MigrationStatus, error)
 	GetSettings(ctx context.Context) (model.Settings, error)
 	SetSettings(ctx context.Context, settings model.Settings) error
+	SetSettingsIfMatch(ctx context.Context, settings model.Settings, match func(current model.Settings) bool) error
+	RotateConnectionString(ctx context.Context, connStr string) error
+	AuditLog(ctx context.Context, log model.AuditLog) error
+	ForwardAuditLogs(ctx context.Context) (int, error)
+	ExportAuditLogs(ctx context.Context, filter model.AuditLogFilter, fn func(model.AuditLog) error) error
+	PurgeAuditLogs(ctx context.Context, before time.Time) (int, error)
+	CheckIntegration(ctx context.Context) model.IntegrationReport
+	FleetHealth(ctx context.Context, sample int) (model.FleetHealthReport, error)
+	GetFeatureFlags(ctx context.Context) ([]model.FeatureFlag, error)
+	FeatureEnabled(ctx context.Context, name string) bool
+	SetFeatureFlag(ctx context.Context, name string, enabled bool) error
+	DeleteFeatureFlag(ctx context.Context, name string) error
+	RecordUsage(ctx context.Context, counter string) error
+	GetUsage(ctx context.Context, period string) ([]model.Usage, error)
 }
 
 // app is an app object
 type app struct {
 	Config
 	store store.DataStore
+
+	fleetMu  sync.Mutex
+	fleetRun *fleetHealthRun
+
+	azureLimiter *limiter
+
+	healthMu   sync.Mutex
+	healthRuns map[string]*dependencyRun
 }
 
+// Config contains the optional dependencies of the app
 type Config struct {
+	// AuditLogs is the client used for forwarding the audit logs to the
+	// auditlogs service; if nil, the audit logs are only stored locally.
+	AuditLogs auditlogs.Client
+	// IoTHub is the client used for accessing the Azure IoT Hub; if nil,
+	// a client using the default HTTP client is used.
+	IoTHub iothub.Client
+	// Features are the states of the feature flags set in the service
+	// configuration
+	Features map[string]bool
+	// Clock is the time source of the app; if nil, the system clock is
+	// used.
+	Clock clock.Clock
+	// AzureConcurrency and AzureTenantConcurrency limit the number of
+	// concurrent requests to the Azure IoT Hub, respectively in total and
+	// for each tenant; zero disables the limit.
+	AzureConcurrency       int
+	AzureTenantConcurrency int
+	// AzureHourlyQuota and AzureDailyQuota limit the number of requests
+	// to the Azure IoT Hub of each tenant per hour and per day (UTC);
+	// zero disables the quota.
+	AzureHourlyQuota int64
+	AzureDailyQuota  int64
+	// HealthCacheTTL is the duration the results of the dependency checks
+	// are reused by the health checks; zero disables the caching.
+	HealthCacheTTL time.Duration
+	// RotationGracePeriod is the duration the connection string replaced
+	// by a rotation is kept as a fallback; zero disables the fallback.
+	RotationGracePeriod time.Duration
+	// PolicyEnforcement is the enforcement mode of the least privilege of
+	// the shared access policies; empty disables the enforcement.
+	PolicyEnforcement string
 }
 
 // NewApp initialize a new azure-iot-manager App
 func New(config Config, ds store.DataStore) App {
+	if config.IoTHub == nil {
+		config.IoTHub = iothub.NewClient(nil)
+	}
+	if config.Clock == nil {
+		config.Clock = clock.New()
+	}
 	return &app{
 		Config: config,
 		store:  ds,
+
+		azureLimiter: newLimiter(
+			config.AzureConcurrency,
+			config.AzureTenantConcurrency,
+		),
+		healthRuns: map[string]*dependencyRun{},
 	}
 }
 
 // HealthCheck performs a health check and returns an error if it fails
 func (a *app) HealthCheck(ctx context.Context) error {
-	return a.store.Ping(ctx)
+	for _, dep := range a.dependencies() {
+		if _, err := a.checkDependency(ctx, dep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type dependencyCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// dependencyRun is a check of a dependency, shared by the callers while it
+// runs; done is closed when the result is available.
+type dependencyRun struct {
+	done   chan struct{}
+	health model.DependencyHealth
+	err    error
+	time   time.Time
+}
+
+func (a *app) dependencies() []dependencyCheck {
+	return []dependencyCheck{
+		{name: "mongo", check: a.store.Ping},
+	}
+}
+
+// HealthReport checks all the dependencies and reports the status and
+// latency of each one of them. The report also contains the state of the
+// audit logs forwarding and of a sample of the IoT Hubs, which do not
+// affect the status of the service.
+func (a *app) HealthReport(ctx context.Context) model.HealthReport {
+	report := model.HealthReport{
+		Status: model.HealthStatusOK,
+	}
+	for _, dep := range a.dependencies() {
+		health, err := a.checkDependency(ctx, dep)
+		if err != nil {
+			report.Status = model.HealthStatusError
+		}
+		report.Dependencies = append(report.Dependencies, health)
+	}
+	if a.AuditLogs != nil {
+		report.Dependencies = append(report.Dependencies,
+			a.auditLogsForwardingHealth(ctx),
+		)
+	}
+	report.Dependencies = append(report.Dependencies, a.hubsHealth(ctx)...)
+	return report
+}
+
+// auditLogsForwardingHealth reports the age of the oldest audit log not
+// forwarded to the auditlogs service yet; the forwarding fails if it is
+// older than auditLogsMaxLag.
+func (a *app) auditLogsForwardingHealth(ctx context.Context) model.DependencyHealth {
+	health := model.DependencyHealth{
+		Name:   "auditlogs_forwarding",
+		Status: model.HealthStatusOK,
+	}
+	start := a.Clock.Now()
+	oldest, err := a.store.GetOldestUnforwardedAuditLog(ctx)
+	now := a.Clock.Now()
+	health.Latency = latency(now.Sub(start))
+	if err != nil {
+		health.Status = model.HealthStatusError
+		health.Error = err.Error()
+	} else if oldest != nil {
+		lag := now.Sub(oldest.Time)
+		health.Lag = lag.Seconds()
+		if lag > auditLogsMaxLag {
+			health.Status = model.HealthStatusError
+			health.Error = fmt.Sprintf(
+				"the oldest audit log not forwarded is %s old",
+				lag.Truncate(time.Second),
+			)
+		}
+	}
+	return health
+}
+
+// hubsHealth reports the IoT Hubs of the integrations checked by the fleet
+// health checks, which run at most once per fleetHealthInterval
+func (a *app) hubsHealth(ctx context.Context) []model.DependencyHealth {
+	report, err := a.FleetHealth(ctx, healthHubSample)
+	if err != nil {
+		return []model.DependencyHealth{{
+			Name:   "iothub",
+			Status: model.HealthStatusError,
+			Error:  err.Error(),
+		}}
+	}
+	deps := make([]model.DependencyHealth, len(report.Hubs))
+	for i, hub := range report.Hubs {
+		deps[i] = model.DependencyHealth{
+			Name:    "iothub " + hub.HostName,
+			Status:  model.HealthStatusOK,
+			Latency: hub.Latency,
+		}
+		if hub.Failed > 0 {
+			deps[i].Status = model.HealthStatusError
+			deps[i].Error = fmt.Sprintf(
+				"%d of %d integrations failed", hub.Failed, hub.Sampled,
+			)
+		}
+	}
+	return deps
+}
+
+func latency(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// checkDependency checks the dependency, or returns the result of the
+// previous check if it is more recent than HealthCacheTTL, so that
+// frequent health probes do not load the dependencies. The concurrent
+// callers share the running check, which runs in the background within
+// healthCheckTimeout: the callers give up on their context without
+// waiting for it.
+func (a *app) checkDependency(
+	ctx context.Context,
+	dep dependencyCheck,
+) (model.DependencyHealth, error) {
+	a.healthMu.Lock()
+	run := a.healthRuns[dep.name]
+	if run != nil {
+		select {
+		case <-run.done:
+			if a.Clock.Now().Sub(run.time) >= a.HealthCacheTTL {
+				run = nil
+			}
+		default:
+		}
+	}
+	if run == nil {
+		run = &dependencyRun{done: make(chan struct{})}
+		a.healthRuns[dep.name] = run
+		l := log.FromContext(ctx)
+		go func() {
+			ctx, cancel := context.WithTimeout(
+				log.WithContext(context.Background(), l),
+				healthCheckTimeout,
+			)
+			defer cancel()
+			start := a.Clock.Now()
+			run.err = dep.check(ctx)
+			run.time = a.Clock.Now()
+			run.health = model.DependencyHealth{
+				Name:    dep.name,
+				Status:  model.HealthStatusOK,
+				Latency: latency(run.time.Sub(start)),
+			}
+			if run.err != nil {
+				run.health.Status = model.HealthStatusError
+				run.health.Error = run.err.Error()
+			}
+			close(run.done)
+		}()
+	}
+	a.healthMu.Unlock()
+
+	select {
+	case <-run.done:
+		return run.health, run.err
+	case <-ctx.Done():
+		return model.DependencyHealth{
+			Name:   dep.name,
+			Status: model.HealthStatusError,
+			Error:  ctx.Err().Error(),
+		}, ctx.Err()
+	}
+}
+
+// MigrationStatus reports the migrations applied to the database
+func (a *app) MigrationStatus(ctx context.Context) (model.MigrationStatus, error) {
+	return a.store.GetMigrationStatus(ctx)
 }
 
 func (a *app) GetSettings(ctx context.Context) (model.Settings, error) {
@@ -57,5 +327,35 @@ func (a *app) GetSettings(ctx context.Context) (model.Settings, error) {
 }
 
 func (a *app) SetSettings(ctx context.Context, settings model.Settings) error {
+	if err := a.checkSettings(ctx, settings); err != nil {
+		return err
+	}
 	return a.store.SetSettings(ctx, settings)
 }
+
+// SetSettingsIfMatch replaces the settings only if match accepts the
+// stored settings at the time of the write; ErrPreconditionFailed is
+// returned otherwise
+func (a *app) SetSettingsIfMatch(
+	ctx context.Context,
+	settings model.Settings,
+	match func(current model.Settings) bool,
+) error {
+	if err := a.checkSettings(ctx, settings); err != nil {
+		return err
+	}
+	err := a.store.SetSettingsIfMatch(ctx, settings, match)
+	if errors.Is(err, store.ErrObjectModified) {
+		return ErrPreconditionFailed
+	}
+	return err
+}
+
+func (a *app) checkSettings(ctx context.Context, settings model.Settings) error {
+	// malformed connection strings are reported by CheckIntegration
+	cs, err := model.ParseConnectionString(settings.ConnectionString)
+	if err == nil {
+		return a.checkPolicy(ctx, cs)
+	}
+	return nil
+}
@@ -18,11 +18,19 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	alMocks "github.com/mendersoftware/azure-iot-manager/client/auditlogs/mocks"
+	"github.com/mendersoftware/azure-iot-manager/client/iothub"
+	iothubMocks "github.com/mendersoftware/azure-iot-manager/client/iothub/mocks"
+	"github.com/mendersoftware/azure-iot-manager/clock"
 	"github.com/mendersoftware/azure-iot-manager/model"
+	"github.com/mendersoftware/azure-iot-manager/store"
 	storeMocks "github.com/mendersoftware/azure-iot-manager/store/mocks"
 )
 
@@ -150,3 +158,317 @@ func TestSetSettings(t *testing.T) {
 		})
 	}
 }
+
+func TestSetSettingsIfMatch(t *testing.T) {
+	testCases := []struct {
+		Name string
+
+		StoreError error
+
+		Error error
+	}{{
+		Name: "settings saved",
+	}, {
+		Name: "precondition failed",
+
+		StoreError: store.ErrObjectModified,
+
+		Error: ErrPreconditionFailed,
+	}, {
+		Name: "settings saving error",
+
+		StoreError: errors.New("error setting the settings"),
+
+		Error: errors.New("error setting the settings"),
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			settings := model.Settings{ConnectionString: "my://connection.string"}
+			ds := &storeMocks.DataStore{}
+			defer ds.AssertExpectations(t)
+			ds.On("SetSettingsIfMatch",
+				mock.MatchedBy(func(ctx context.Context) bool {
+					return true
+				}),
+				settings,
+				mock.AnythingOfType("func(model.Settings) bool"),
+			).Return(tc.StoreError)
+			app := New(Config{}, ds)
+
+			err := app.SetSettingsIfMatch(context.Background(), settings,
+				func(model.Settings) bool { return true })
+			if tc.Error != nil {
+				assert.EqualError(t, err, tc.Error.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestHealthReport(t *testing.T) {
+	testCases := []struct {
+		Name string
+
+		PingReturn error
+
+		Status    string
+		DepStatus string
+		DepError  string
+	}{
+		{
+			Name: "db Ping successful",
+
+			Status:    model.HealthStatusOK,
+			DepStatus: model.HealthStatusOK,
+		},
+		{
+			Name:       "db Ping failed",
+			PingReturn: errors.New("failed to connect to db"),
+
+			Status:    model.HealthStatusError,
+			DepStatus: model.HealthStatusError,
+			DepError:  "failed to connect to db",
+		},
+	}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			store := &storeMocks.DataStore{}
+			defer store.AssertExpectations(t)
+			store.On("Ping",
+				mock.MatchedBy(func(ctx context.Context) bool {
+					return true
+				}),
+			).Return(tc.PingReturn)
+			store.On("ListSettings", mock.Anything).Return(nil, nil)
+			app := New(Config{}, store)
+
+			report := app.HealthReport(context.Background())
+			assert.Equal(t, tc.Status, report.Status)
+			if assert.Len(t, report.Dependencies, 1) {
+				dep := report.Dependencies[0]
+				assert.Equal(t, "mongo", dep.Name)
+				assert.Equal(t, tc.DepStatus, dep.Status)
+				assert.Equal(t, tc.DepError, dep.Error)
+				assert.GreaterOrEqual(t, dep.Latency, float64(0))
+			}
+		})
+	}
+}
+
+func TestHealthReportDetail(t *testing.T) {
+	const hub = "HostName=hub.azure-devices.net;" +
+		"SharedAccessKeyName=iothubowner;SharedAccessKey=c2VjcmV0"
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		Name string
+
+		Oldest    *model.AuditLog
+		OldestErr error
+		Tenants   []model.TenantSettings
+		ListErr   error
+
+		Dependencies []model.DependencyHealth
+	}{
+		{
+			Name: "ok",
+
+			Oldest: &model.AuditLog{Time: now.Add(-time.Minute)},
+			Tenants: []model.TenantSettings{{
+				TenantID: "tenant1",
+				Settings: model.Settings{ConnectionString: hub},
+			}},
+
+			Dependencies: []model.DependencyHealth{{
+				Name:   "auditlogs_forwarding",
+				Status: model.HealthStatusOK,
+				Lag:    60,
+			}, {
+				Name:   "iothub hub.azure-devices.net",
+				Status: model.HealthStatusOK,
+			}},
+		},
+		{
+			Name: "forwarding lagging behind, hub failing",
+
+			Oldest: &model.AuditLog{Time: now.Add(-2 * time.Hour)},
+			Tenants: []model.TenantSettings{{
+				TenantID: "tenant2",
+				Settings: model.Settings{ConnectionString: hub},
+			}},
+
+			Dependencies: []model.DependencyHealth{{
+				Name:   "auditlogs_forwarding",
+				Status: model.HealthStatusError,
+				Lag:    7200,
+				Error:  "the oldest audit log not forwarded is 2h0m0s old",
+			}, {
+				Name:   "iothub hub.azure-devices.net",
+				Status: model.HealthStatusError,
+				Error:  "1 of 1 integrations failed",
+			}},
+		},
+		{
+			Name: "store errors",
+
+			OldestErr: errors.New("mongo error"),
+			ListErr:   errors.New("mongo error"),
+
+			Dependencies: []model.DependencyHealth{{
+				Name:   "auditlogs_forwarding",
+				Status: model.HealthStatusError,
+				Error:  "mongo error",
+			}, {
+				Name:   "iothub",
+				Status: model.HealthStatusError,
+				Error:  "mongo error",
+			}},
+		},
+	}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			ds := &storeMocks.DataStore{}
+			defer ds.AssertExpectations(t)
+			ds.On("Ping", mock.Anything).Return(nil)
+			ds.On("GetOldestUnforwardedAuditLog", mock.Anything).
+				Return(tc.Oldest, tc.OldestErr)
+			ds.On("ListSettings", mock.Anything).Return(tc.Tenants, tc.ListErr)
+			for _, tenant := range tc.Tenants {
+				ds.On("GetSettings", mock.MatchedBy(func(ctx context.Context) bool {
+					id := identity.FromContext(ctx)
+					return id != nil && id.Tenant == tenant.TenantID
+				})).Return(tenant.Settings, nil)
+			}
+			client := &iothubMocks.Client{}
+			defer client.AssertExpectations(t)
+			if len(tc.Tenants) > 0 && tc.Tenants[0].TenantID == "tenant1" {
+				client.On("GetDeviceStatistics", mock.Anything, mock.Anything).
+					Return(&iothub.RegistryStatistics{}, nil)
+				client.On("GetServiceStatistics", mock.Anything, mock.Anything).
+					Return(&iothub.ServiceStatistics{}, nil)
+			} else if len(tc.Tenants) > 0 {
+				client.On("GetDeviceStatistics", mock.Anything, mock.Anything).
+					Return(nil, &iothub.Error{Code: 401})
+			}
+			a := New(Config{
+				IoTHub:    client,
+				Clock:     clock.NewFake(now),
+				AuditLogs: &alMocks.Client{},
+			}, ds)
+
+			report := a.HealthReport(context.Background())
+			// the audit logs forwarding and the IoT Hubs do not affect
+			// the status of the service
+			assert.Equal(t, model.HealthStatusOK, report.Status)
+			if assert.Len(t, report.Dependencies, 1+len(tc.Dependencies)) {
+				assert.Equal(t, "mongo", report.Dependencies[0].Name)
+				assert.Equal(t, tc.Dependencies, report.Dependencies[1:])
+			}
+		})
+	}
+}
+
+func TestHealthCheckCache(t *testing.T) {
+	store := &storeMocks.DataStore{}
+	defer store.AssertExpectations(t)
+	clk := clock.NewFake(time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC))
+	app := New(Config{Clock: clk, HealthCacheTTL: 5 * time.Second}, store)
+	ctx := context.Background()
+	store.On("ListSettings", mock.Anything).Return(nil, nil)
+
+	store.On("Ping",
+		mock.MatchedBy(func(ctx context.Context) bool {
+			return true
+		}),
+	).Return(errors.New("failed to connect to db")).Once()
+	assert.EqualError(t, app.HealthCheck(ctx), "failed to connect to db")
+	// the failure is cached too
+	clk.Advance(4 * time.Second)
+	assert.EqualError(t, app.HealthCheck(ctx), "failed to connect to db")
+	report := app.HealthReport(ctx)
+	assert.Equal(t, model.HealthStatusError, report.Status)
+
+	store.On("Ping",
+		mock.MatchedBy(func(ctx context.Context) bool {
+			return true
+		}),
+	).Return(nil).Once()
+	clk.Advance(time.Second)
+	assert.NoError(t, app.HealthCheck(ctx))
+	report = app.HealthReport(ctx)
+	assert.Equal(t, model.HealthStatusOK, report.Status)
+}
+
+func TestHealthCheckConcurrent(t *testing.T) {
+	store := &storeMocks.DataStore{}
+	defer store.AssertExpectations(t)
+	// the check blocks until released
+	started := make(chan context.Context, 1)
+	release := make(chan struct{})
+	store.On("Ping", mock.Anything).
+		Run(func(args mock.Arguments) {
+			started <- args.Get(0).(context.Context)
+			<-release
+		}).
+		Return(nil).
+		Once()
+	clk := clock.NewFake(time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC))
+	app := New(Config{Clock: clk, HealthCacheTTL: 5 * time.Second}, store)
+
+	// the first caller gives up: the check goes on for the others
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- app.HealthCheck(ctx)
+	}()
+	checkCtx := <-started
+	cancel()
+	select {
+	case err := <-errs:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the canceled health check did not return")
+	}
+	assert.NoError(t, checkCtx.Err())
+	_, ok := checkCtx.Deadline()
+	assert.True(t, ok, "the check must have a timeout")
+
+	// the callers share the running check, or its result
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- app.HealthCheck(context.Background())
+		}()
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for the health check")
+		}
+	}
+}
+
+func TestMigrationStatus(t *testing.T) {
+	store := &storeMocks.DataStore{}
+	defer store.AssertExpectations(t)
+	status := model.MigrationStatus{
+		Database: "azure_iot_manager",
+		Version:  "1.2.0",
+		Pending:  true,
+	}
+	store.On("GetMigrationStatus",
+		mock.MatchedBy(func(ctx context.Context) bool {
+			return true
+		}),
+	).Return(status, nil)
+	app := New(Config{}, store)
+
+	res, err := app.MigrationStatus(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, status, res)
+}
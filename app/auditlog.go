@@ -0,0 +1,92 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+const (
+	auditLogsBatchSize = 100
+	auditLogsLease     = time.Minute
+)
+
+// AuditLog stores the audit log locally; the log is forwarded to the
+// auditlogs service by ForwardAuditLogs. The time of the log defaults to the
+// current time.
+func (a *app) AuditLog(ctx context.Context, log model.AuditLog) error {
+	if log.Time.IsZero() {
+		log.Time = a.Clock.Now()
+	}
+	return a.store.InsertAuditLog(ctx, log)
+}
+
+// ExportAuditLogs calls fn with each stored audit log matching the filter,
+// in chronological order
+func (a *app) ExportAuditLogs(
+	ctx context.Context,
+	filter model.AuditLogFilter,
+	fn func(model.AuditLog) error,
+) error {
+	return a.store.IterateAuditLogs(ctx, filter, fn)
+}
+
+// PurgeAuditLogs deletes the stored audit logs older than before and
+// returns the number of logs deleted. When the logs are forwarded to the
+// auditlogs service, the logs not yet forwarded are kept.
+func (a *app) PurgeAuditLogs(ctx context.Context, before time.Time) (int, error) {
+	n, err := a.store.DeleteAuditLogs(ctx, before, a.AuditLogs != nil)
+	return int(n), err
+}
+
+// ForwardAuditLogs forwards the pending audit logs to the auditlogs service
+// and returns the number of logs forwarded. The logs which cannot be
+// forwarded (e.g. because the auditlogs service is unavailable) are retried
+// on the next invocation.
+func (a *app) ForwardAuditLogs(ctx context.Context) (int, error) {
+	if a.AuditLogs == nil {
+		return 0, nil
+	}
+	l := log.FromContext(ctx)
+	forwarded := 0
+	for {
+		logs, err := a.store.ClaimAuditLogs(ctx, auditLogsBatchSize, auditLogsLease)
+		if err != nil {
+			return forwarded, err
+		}
+		for _, auditLog := range logs {
+			err = a.AuditLogs.SubmitAuditLog(ctx, auditLog)
+			if err != nil {
+				// stop here, the remaining logs will be claimed
+				// again once the lease expires
+				return forwarded, err
+			}
+			err = a.store.SetAuditLogForwarded(ctx, auditLog.ID)
+			if err != nil {
+				l.Warnf("failed to mark audit log %s as forwarded: %s",
+					auditLog.ID, err)
+			}
+			forwarded++
+		}
+		if len(logs) < auditLogsBatchSize {
+			return forwarded, nil
+		}
+	}
+}
@@ -0,0 +1,334 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	alMocks "github.com/mendersoftware/azure-iot-manager/client/auditlogs/mocks"
+	"github.com/mendersoftware/azure-iot-manager/clock"
+	"github.com/mendersoftware/azure-iot-manager/model"
+	storeMocks "github.com/mendersoftware/azure-iot-manager/store/mocks"
+)
+
+func TestAuditLog(t *testing.T) {
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		Name string
+
+		Log       model.AuditLog
+		InsertErr error
+
+		Stored model.AuditLog
+		Error  error
+	}{
+		{
+			Name: "ok, current time",
+
+			Log: model.AuditLog{Action: model.AuditActionUpdate},
+
+			Stored: model.AuditLog{Action: model.AuditActionUpdate, Time: now},
+		},
+		{
+			Name: "ok, time of the log",
+
+			Log: model.AuditLog{
+				Action: model.AuditActionUpdate,
+				Time:   now.Add(-time.Minute),
+			},
+
+			Stored: model.AuditLog{
+				Action: model.AuditActionUpdate,
+				Time:   now.Add(-time.Minute),
+			},
+		},
+		{
+			Name: "error, store",
+
+			Log:       model.AuditLog{Action: model.AuditActionUpdate},
+			InsertErr: errors.New("internal error"),
+
+			Stored: model.AuditLog{Action: model.AuditActionUpdate, Time: now},
+			Error:  errors.New("internal error"),
+		},
+	}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			// the audit logs are recorded whatever the feature flags
+			store := &storeMocks.DataStore{}
+			defer store.AssertExpectations(t)
+			store.On("InsertAuditLog",
+				mock.MatchedBy(func(ctx context.Context) bool {
+					return true
+				}),
+				tc.Stored,
+			).Return(tc.InsertErr)
+			app := New(Config{
+				Features: map[string]bool{"audit_logs": false},
+				Clock:    clock.NewFake(now),
+			}, store)
+
+			err := app.AuditLog(context.Background(), tc.Log)
+			if tc.Error != nil {
+				assert.EqualError(t, err, tc.Error.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestForwardAuditLogs(t *testing.T) {
+	logs := []model.AuditLog{{ID: uuid.New()}, {ID: uuid.New()}}
+	fullBatch := make([]model.AuditLog, auditLogsBatchSize)
+	for i := range fullBatch {
+		fullBatch[i].ID = uuid.New()
+	}
+	testCases := []struct {
+		Name string
+
+		NoClient bool
+		Store    func(t *testing.T) *storeMocks.DataStore
+		Client   func(t *testing.T) *alMocks.Client
+
+		Forwarded int
+		Error     error
+	}{
+		{
+			Name: "ok",
+
+			Store: func(t *testing.T) *storeMocks.DataStore {
+				store := &storeMocks.DataStore{}
+				store.On("ClaimAuditLogs",
+					mock.MatchedBy(func(ctx context.Context) bool {
+						return true
+					}),
+					auditLogsBatchSize,
+					auditLogsLease,
+				).Return(logs, nil)
+				for _, log := range logs {
+					store.On("SetAuditLogForwarded",
+						mock.MatchedBy(func(ctx context.Context) bool {
+							return true
+						}),
+						log.ID,
+					).Return(nil)
+				}
+				return store
+			},
+			Client: func(t *testing.T) *alMocks.Client {
+				client := &alMocks.Client{}
+				for _, log := range logs {
+					client.On("SubmitAuditLog",
+						mock.MatchedBy(func(ctx context.Context) bool {
+							return true
+						}),
+						log,
+					).Return(nil)
+				}
+				return client
+			},
+
+			Forwarded: 2,
+		},
+		{
+			Name: "ok, multiple batches",
+
+			Store: func(t *testing.T) *storeMocks.DataStore {
+				store := &storeMocks.DataStore{}
+				store.On("ClaimAuditLogs",
+					mock.MatchedBy(func(ctx context.Context) bool {
+						return true
+					}),
+					auditLogsBatchSize,
+					auditLogsLease,
+				).Return(fullBatch, nil).Once()
+				store.On("ClaimAuditLogs",
+					mock.MatchedBy(func(ctx context.Context) bool {
+						return true
+					}),
+					auditLogsBatchSize,
+					auditLogsLease,
+				).Return([]model.AuditLog{}, nil).Once()
+				store.On("SetAuditLogForwarded",
+					mock.MatchedBy(func(ctx context.Context) bool {
+						return true
+					}),
+					mock.AnythingOfType("uuid.UUID"),
+				).Return(errors.New("internal error"))
+				return store
+			},
+			Client: func(t *testing.T) *alMocks.Client {
+				client := &alMocks.Client{}
+				client.On("SubmitAuditLog",
+					mock.MatchedBy(func(ctx context.Context) bool {
+						return true
+					}),
+					mock.AnythingOfType("model.AuditLog"),
+				).Return(nil)
+				return client
+			},
+
+			Forwarded: auditLogsBatchSize,
+		},
+		{
+			Name: "ok, no client",
+
+			NoClient: true,
+			Store: func(t *testing.T) *storeMocks.DataStore {
+				return &storeMocks.DataStore{}
+			},
+		},
+		{
+			Name: "error, auditlogs service unavailable",
+
+			Store: func(t *testing.T) *storeMocks.DataStore {
+				store := &storeMocks.DataStore{}
+				store.On("ClaimAuditLogs",
+					mock.MatchedBy(func(ctx context.Context) bool {
+						return true
+					}),
+					auditLogsBatchSize,
+					auditLogsLease,
+				).Return(logs, nil)
+				store.On("SetAuditLogForwarded",
+					mock.MatchedBy(func(ctx context.Context) bool {
+						return true
+					}),
+					logs[0].ID,
+				).Return(nil)
+				return store
+			},
+			Client: func(t *testing.T) *alMocks.Client {
+				client := &alMocks.Client{}
+				client.On("SubmitAuditLog",
+					mock.MatchedBy(func(ctx context.Context) bool {
+						return true
+					}),
+					logs[0],
+				).Return(nil)
+				client.On("SubmitAuditLog",
+					mock.MatchedBy(func(ctx context.Context) bool {
+						return true
+					}),
+					logs[1],
+				).Return(errors.New("service unavailable"))
+				return client
+			},
+
+			Forwarded: 1,
+			Error:     errors.New("service unavailable"),
+		},
+		{
+			Name: "error, store",
+
+			Store: func(t *testing.T) *storeMocks.DataStore {
+				store := &storeMocks.DataStore{}
+				store.On("ClaimAuditLogs",
+					mock.MatchedBy(func(ctx context.Context) bool {
+						return true
+					}),
+					auditLogsBatchSize,
+					auditLogsLease,
+				).Return(nil, errors.New("internal error"))
+				return store
+			},
+			Client: func(t *testing.T) *alMocks.Client {
+				return &alMocks.Client{}
+			},
+
+			Error: errors.New("internal error"),
+		},
+	}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			store := tc.Store(t)
+			defer store.AssertExpectations(t)
+			config := Config{}
+			if !tc.NoClient {
+				client := tc.Client(t)
+				defer client.AssertExpectations(t)
+				config.AuditLogs = client
+			}
+			app := New(config, store)
+
+			forwarded, err := app.ForwardAuditLogs(context.Background())
+			assert.Equal(t, tc.Forwarded, forwarded)
+			if tc.Error != nil {
+				assert.EqualError(t, err, tc.Error.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPurgeAuditLogs(t *testing.T) {
+	before := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		Name string
+
+		Forwarding bool
+		Deleted    int64
+		Error      error
+	}{{
+		Name: "ok",
+
+		Deleted: 10,
+	}, {
+		Name: "ok, forwarded only",
+
+		Forwarding: true,
+		Deleted:    5,
+	}, {
+		Name: "error",
+
+		Error: errors.New("mongo error"),
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			store := &storeMocks.DataStore{}
+			defer store.AssertExpectations(t)
+			store.On("DeleteAuditLogs",
+				mock.MatchedBy(func(ctx context.Context) bool {
+					return true
+				}),
+				before,
+				tc.Forwarding,
+			).Return(tc.Deleted, tc.Error)
+			config := Config{}
+			if tc.Forwarding {
+				config.AuditLogs = &alMocks.Client{}
+			}
+
+			n, err := New(config, store).PurgeAuditLogs(context.Background(), before)
+			if tc.Error != nil {
+				assert.EqualError(t, err, tc.Error.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, int(tc.Deleted), n)
+			}
+		})
+	}
+}
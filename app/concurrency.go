@@ -0,0 +1,150 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+)
+
+// limiter bounds the number of concurrent operations, globally and for
+// each tenant; a zero limit disables the corresponding bound.
+type limiter struct {
+	global    chan struct{}
+	perTenant int
+
+	mu      sync.Mutex
+	tenants map[string]*tenantSlots
+}
+
+// tenantSlots are the slots of a tenant with operations in flight or
+// waiting; refs counts them so that idle tenants are forgotten.
+type tenantSlots struct {
+	slots chan struct{}
+	refs  int
+}
+
+func newLimiter(global, perTenant int) *limiter {
+	l := &limiter{
+		perTenant: perTenant,
+		tenants:   make(map[string]*tenantSlots),
+	}
+	if global > 0 {
+		l.global = make(chan struct{}, global)
+	}
+	return l
+}
+
+// acquire blocks until a slot is available for the tenant in the context,
+// and returns the function releasing it. The tenant slot is acquired
+// first, so that a tenant waiting on its own limit does not hold any of
+// the global slots.
+func (l *limiter) acquire(ctx context.Context) (func(), error) {
+	var tenant string
+	if id := identity.FromContext(ctx); id != nil {
+		tenant = id.Tenant
+	}
+	var slots chan struct{}
+	if l.perTenant > 0 {
+		slots = l.ref(tenant)
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			l.unref(tenant)
+			return nil, ctx.Err()
+		}
+	}
+	if l.global != nil {
+		select {
+		case l.global <- struct{}{}:
+		case <-ctx.Done():
+			if slots != nil {
+				<-slots
+				l.unref(tenant)
+			}
+			return nil, ctx.Err()
+		}
+	}
+	return func() {
+		if l.global != nil {
+			<-l.global
+		}
+		if slots != nil {
+			<-slots
+			l.unref(tenant)
+		}
+	}, nil
+}
+
+func (l *limiter) ref(tenant string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t, ok := l.tenants[tenant]
+	if !ok {
+		t = &tenantSlots{slots: make(chan struct{}, l.perTenant)}
+		l.tenants[tenant] = t
+	}
+	t.refs++
+	return t.slots
+}
+
+func (l *limiter) unref(tenant string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t := l.tenants[tenant]
+	t.refs--
+	if t.refs == 0 {
+		delete(l.tenants, tenant)
+	}
+}
+
+type internalKey struct{}
+
+// WithInternal marks the context of the checks run by the service itself
+// or by its operators, e.g. the fleet health and the diagnostics: their
+// requests to the Azure IoT Hub neither consume the tenant quotas nor are
+// metered.
+func WithInternal(ctx context.Context) context.Context {
+	return context.WithValue(ctx, internalKey{}, true)
+}
+
+func isInternal(ctx context.Context) bool {
+	internal, _ := ctx.Value(internalKey{}).(bool)
+	return internal
+}
+
+// azureOperation runs a request to the Azure IoT Hub on behalf of the
+// tenant in the context, within its quotas and the concurrency limits,
+// and meters it; internal operations are only subject to the concurrency
+// limits.
+func (a *app) azureOperation(ctx context.Context, op func(ctx context.Context) error) error {
+	internal := isInternal(ctx)
+	if !internal {
+		if err := a.consumeQuota(ctx); err != nil {
+			return err
+		}
+	}
+	release, err := a.azureLimiter.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if !internal {
+		defer a.meterAzureOperation(ctx)
+	}
+	return op(ctx)
+}
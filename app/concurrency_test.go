@@ -0,0 +1,109 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+)
+
+func TestLimiter(t *testing.T) {
+	tenantCtx := func(tenant string) context.Context {
+		return identity.WithContext(context.Background(), &identity.Identity{
+			Tenant: tenant,
+		})
+	}
+	// blocked returns true if acquiring a slot for the tenant blocks
+	blocked := func(l *limiter, tenant string) bool {
+		ctx, cancel := context.WithTimeout(tenantCtx(tenant), 10*time.Millisecond)
+		defer cancel()
+		release, err := l.acquire(ctx)
+		if err != nil {
+			assert.Equal(t, context.DeadlineExceeded, err)
+			return true
+		}
+		release()
+		return false
+	}
+
+	t.Run("per tenant", func(t *testing.T) {
+		l := newLimiter(0, 2)
+		release1, err := l.acquire(tenantCtx("tenant1"))
+		require.NoError(t, err)
+		release2, err := l.acquire(tenantCtx("tenant1"))
+		require.NoError(t, err)
+
+		assert.True(t, blocked(l, "tenant1"))
+		assert.False(t, blocked(l, "tenant2"))
+
+		release1()
+		assert.False(t, blocked(l, "tenant1"))
+		release2()
+		assert.Empty(t, l.tenants)
+	})
+
+	t.Run("global", func(t *testing.T) {
+		l := newLimiter(2, 1)
+		release1, err := l.acquire(tenantCtx("tenant1"))
+		require.NoError(t, err)
+		release2, err := l.acquire(tenantCtx("tenant2"))
+		require.NoError(t, err)
+
+		assert.True(t, blocked(l, "tenant3"))
+		// a tenant waiting on its own limit holds no global slot
+		assert.True(t, blocked(l, "tenant1"))
+		release2()
+		assert.False(t, blocked(l, "tenant3"))
+
+		release1()
+		assert.Empty(t, l.tenants)
+		assert.Len(t, l.global, 0)
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		l := newLimiter(0, 0)
+		for i := 0; i < 10; i++ {
+			_, err := l.acquire(tenantCtx("tenant1"))
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("waiting for a slot", func(t *testing.T) {
+		l := newLimiter(1, 0)
+		release, err := l.acquire(tenantCtx("tenant1"))
+		require.NoError(t, err)
+		acquired := make(chan struct{})
+		go func() {
+			release, err := l.acquire(tenantCtx("tenant2"))
+			if assert.NoError(t, err) {
+				release()
+			}
+			close(acquired)
+		}()
+		select {
+		case <-acquired:
+			t.Fatal("slot acquired beyond the limit")
+		case <-time.After(10 * time.Millisecond):
+		}
+		release()
+		<-acquired
+	})
+}
@@ -0,0 +1,115 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+var (
+	ErrUnknownFeature = errors.New("unknown feature flag")
+)
+
+// Feature is the definition of a feature flag
+type Feature struct {
+	Name        string
+	Description string
+	Default     bool
+}
+
+// Features are the feature flags gating the experimental subsystems of the
+// service. The state of a flag is, in order of precedence: the tenant
+// override, the service configuration, the default. No flag is registered
+// at the moment; the flags must not gate the compliance features, such as
+// the audit logs, which tenants cannot opt out of.
+var Features = []Feature{}
+
+func lookupFeature(name string) (Feature, bool) {
+	for _, feature := range Features {
+		if feature.Name == name {
+			return feature, true
+		}
+	}
+	return Feature{}, false
+}
+
+func (a *app) featureFlag(feature Feature, overrides map[string]bool) model.FeatureFlag {
+	flag := model.FeatureFlag{
+		Name:        feature.Name,
+		Description: feature.Description,
+		Enabled:     feature.Default,
+		Source:      model.FeatureSourceDefault,
+	}
+	if enabled, ok := a.Features[feature.Name]; ok {
+		flag.Enabled = enabled
+		flag.Source = model.FeatureSourceConfig
+	}
+	if enabled, ok := overrides[feature.Name]; ok {
+		flag.Enabled = enabled
+		flag.Source = model.FeatureSourceTenant
+	}
+	return flag
+}
+
+// GetFeatureFlags returns the state of all the feature flags for the tenant
+func (a *app) GetFeatureFlags(ctx context.Context) ([]model.FeatureFlag, error) {
+	overrides, err := a.store.GetFeatureFlags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	flags := make([]model.FeatureFlag, len(Features))
+	for i, feature := range Features {
+		flags[i] = a.featureFlag(feature, overrides)
+	}
+	return flags, nil
+}
+
+// FeatureEnabled returns true if the feature is enabled for the tenant. If
+// the tenant overrides cannot be loaded, the state from the configuration
+// (or the default) applies.
+func (a *app) FeatureEnabled(ctx context.Context, name string) bool {
+	feature, ok := lookupFeature(name)
+	if !ok {
+		return false
+	}
+	overrides, err := a.store.GetFeatureFlags(ctx)
+	if err != nil {
+		log.FromContext(ctx).
+			Warnf("failed to load the feature flags overrides: %s", err)
+	}
+	return a.featureFlag(feature, overrides).Enabled
+}
+
+// SetFeatureFlag overrides the state of the feature flag for the tenant
+func (a *app) SetFeatureFlag(ctx context.Context, name string, enabled bool) error {
+	if _, ok := lookupFeature(name); !ok {
+		return ErrUnknownFeature
+	}
+	return a.store.SetFeatureFlag(ctx, name, enabled)
+}
+
+// DeleteFeatureFlag removes the tenant override of the feature flag
+func (a *app) DeleteFeatureFlag(ctx context.Context, name string) error {
+	if _, ok := lookupFeature(name); !ok {
+		return ErrUnknownFeature
+	}
+	return a.store.DeleteFeatureFlag(ctx, name)
+}
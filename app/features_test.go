@@ -0,0 +1,171 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/azure-iot-manager/model"
+	storeMocks "github.com/mendersoftware/azure-iot-manager/store/mocks"
+)
+
+var testFeature = Feature{
+	Name:        "test_feature",
+	Description: "Feature registered by the tests",
+	Default:     true,
+}
+
+// registerFeatures replaces the registered feature flags for the duration
+// of the test
+func registerFeatures(t *testing.T, features ...Feature) {
+	registered := Features
+	Features = features
+	t.Cleanup(func() {
+		Features = registered
+	})
+}
+
+func TestGetFeatureFlags(t *testing.T) {
+	registerFeatures(t, testFeature)
+	testCases := []struct {
+		Name string
+
+		Features     map[string]bool
+		Overrides    map[string]bool
+		OverridesErr error
+
+		Flags []model.FeatureFlag
+		Error error
+	}{
+		{
+			Name: "ok, default",
+
+			Overrides: map[string]bool{},
+
+			Flags: []model.FeatureFlag{{
+				Name:        testFeature.Name,
+				Description: testFeature.Description,
+				Enabled:     true,
+				Source:      model.FeatureSourceDefault,
+			}},
+		},
+		{
+			Name: "ok, config",
+
+			Features:  map[string]bool{testFeature.Name: false},
+			Overrides: map[string]bool{},
+
+			Flags: []model.FeatureFlag{{
+				Name:        testFeature.Name,
+				Description: testFeature.Description,
+				Enabled:     false,
+				Source:      model.FeatureSourceConfig,
+			}},
+		},
+		{
+			Name: "ok, tenant override",
+
+			Features:  map[string]bool{testFeature.Name: false},
+			Overrides: map[string]bool{testFeature.Name: true, "removed": true},
+
+			Flags: []model.FeatureFlag{{
+				Name:        testFeature.Name,
+				Description: testFeature.Description,
+				Enabled:     true,
+				Source:      model.FeatureSourceTenant,
+			}},
+		},
+		{
+			Name: "error, store",
+
+			OverridesErr: errors.New("internal error"),
+
+			Error: errors.New("internal error"),
+		},
+	}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			store := &storeMocks.DataStore{}
+			defer store.AssertExpectations(t)
+			store.On("GetFeatureFlags",
+				mock.MatchedBy(func(ctx context.Context) bool {
+					return true
+				}),
+			).Return(tc.Overrides, tc.OverridesErr)
+			app := New(Config{Features: tc.Features}, store)
+
+			flags, err := app.GetFeatureFlags(context.Background())
+			if tc.Error != nil {
+				assert.EqualError(t, err, tc.Error.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.Flags, flags)
+			}
+		})
+	}
+}
+
+func TestFeatureEnabled(t *testing.T) {
+	registerFeatures(t, testFeature)
+	store := &storeMocks.DataStore{}
+	defer store.AssertExpectations(t)
+	store.On("GetFeatureFlags",
+		mock.MatchedBy(func(ctx context.Context) bool {
+			return true
+		}),
+	).Return(nil, errors.New("internal error"))
+	app := New(Config{}, store)
+
+	// the default applies if the overrides cannot be loaded
+	assert.True(t, app.FeatureEnabled(context.Background(), testFeature.Name))
+	assert.False(t, app.FeatureEnabled(context.Background(), "unknown"))
+}
+
+func TestSetFeatureFlag(t *testing.T) {
+	registerFeatures(t, testFeature)
+	store := &storeMocks.DataStore{}
+	defer store.AssertExpectations(t)
+	store.On("SetFeatureFlag",
+		mock.MatchedBy(func(ctx context.Context) bool {
+			return true
+		}),
+		testFeature.Name,
+		false,
+	).Return(nil)
+	store.On("DeleteFeatureFlag",
+		mock.MatchedBy(func(ctx context.Context) bool {
+			return true
+		}),
+		testFeature.Name,
+	).Return(errors.New("internal error"))
+	app := New(Config{}, store)
+	ctx := context.Background()
+
+	err := app.SetFeatureFlag(ctx, testFeature.Name, false)
+	assert.NoError(t, err)
+	err = app.SetFeatureFlag(ctx, "unknown", false)
+	assert.EqualError(t, err, ErrUnknownFeature.Error())
+
+	err = app.DeleteFeatureFlag(ctx, testFeature.Name)
+	assert.EqualError(t, err, "internal error")
+	err = app.DeleteFeatureFlag(ctx, "unknown")
+	assert.EqualError(t, err, ErrUnknownFeature.Error())
+}
@@ -0,0 +1,174 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+const (
+	// fleetHealthInterval is the minimum interval between two fleet
+	// health checks, whatever the sample size; the last report is
+	// returned in between.
+	fleetHealthInterval = time.Minute
+	// fleetHealthTimeout bounds the duration of the fleet health checks
+	fleetHealthTimeout = 5 * time.Minute
+)
+
+// fleetHealthRun is a fleet health check, shared by the callers while it
+// runs; done is closed when the report is available.
+type fleetHealthRun struct {
+	done   chan struct{}
+	report model.FleetHealthReport
+	err    error
+}
+
+// FleetHealth runs the integration checks of up to sample randomly chosen
+// tenants with a connection string, and reports the failed ones. The checks
+// run sequentially and at most once per minute, the previous report is
+// returned when called more often, whatever the sample size, and the
+// concurrent callers share the running checks, so that monitoring does not
+// load the tenants' hubs. The checks run in the background, within
+// fleetHealthTimeout, and are not canceled with the context of the caller.
+// They neither consume the tenant quotas nor are metered.
+func (a *app) FleetHealth(
+	ctx context.Context,
+	sample int,
+) (model.FleetHealthReport, error) {
+	a.fleetMu.Lock()
+	now := a.Clock.Now()
+	run := a.fleetRun
+	if run != nil {
+		select {
+		case <-run.done:
+			if run.err != nil || now.Sub(run.report.Time) >= fleetHealthInterval {
+				run = nil
+			}
+		default:
+		}
+	}
+	if run == nil {
+		run = &fleetHealthRun{done: make(chan struct{})}
+		a.fleetRun = run
+		l := log.FromContext(ctx)
+		go func() {
+			ctx, cancel := context.WithTimeout(
+				log.WithContext(context.Background(), l),
+				fleetHealthTimeout,
+			)
+			defer cancel()
+			run.report, run.err = a.fleetHealth(ctx, sample, now)
+			close(run.done)
+		}()
+	}
+	a.fleetMu.Unlock()
+
+	select {
+	case <-run.done:
+		return run.report, run.err
+	case <-ctx.Done():
+		return model.FleetHealthReport{}, ctx.Err()
+	}
+}
+
+func (a *app) fleetHealth(
+	ctx context.Context,
+	sample int,
+	now time.Time,
+) (model.FleetHealthReport, error) {
+	settings, err := a.store.ListSettings(ctx)
+	if err != nil {
+		return model.FleetHealthReport{}, err
+	}
+	tenants := make([]model.TenantSettings, 0, len(settings))
+	for _, s := range settings {
+		if s.ConnectionString != "" {
+			tenants = append(tenants, s)
+		}
+	}
+	rand.Shuffle(len(tenants), func(i, j int) {
+		tenants[i], tenants[j] = tenants[j], tenants[i]
+	})
+	if len(tenants) > sample {
+		tenants = tenants[:sample]
+	}
+
+	report := model.FleetHealthReport{
+		Time:     now,
+		Sampled:  len(tenants),
+		Failures: []model.IntegrationFailure{},
+		Hubs:     []model.HubHealth{},
+	}
+	hubs := map[string]*model.HubHealth{}
+	for _, tenant := range tenants {
+		var hub *model.HubHealth
+		cs, err := model.ParseConnectionString(tenant.ConnectionString)
+		if err == nil {
+			if hub = hubs[cs.HostName]; hub == nil {
+				hub = &model.HubHealth{HostName: cs.HostName}
+				hubs[cs.HostName] = hub
+			}
+			hub.Sampled++
+		}
+		start := a.Clock.Now()
+		checks := a.CheckIntegration(identity.WithContext(
+			WithInternal(ctx),
+			&identity.Identity{Tenant: tenant.TenantID},
+		)).Checks
+		// the checks failed on the timeout are not reported
+		if err := ctx.Err(); err != nil {
+			return model.FleetHealthReport{}, err
+		}
+		if hub != nil {
+			hub.Latency += latency(a.Clock.Now().Sub(start))
+		}
+		for _, check := range checks {
+			if check.Status != model.IntegrationCheckFailed {
+				continue
+			}
+			failure := model.IntegrationFailure{
+				TenantID: tenant.TenantID,
+				Check:    check.Name,
+				Error:    check.Error,
+			}
+			if hub != nil {
+				failure.HostName = hub.HostName
+				hub.Failed++
+			}
+			report.Failures = append(report.Failures, failure)
+			report.Failed++
+			break
+		}
+	}
+	sort.Slice(report.Failures, func(i, j int) bool {
+		return report.Failures[i].TenantID < report.Failures[j].TenantID
+	})
+	for _, hub := range hubs {
+		hub.Latency /= float64(hub.Sampled)
+		report.Hubs = append(report.Hubs, *hub)
+	}
+	sort.Slice(report.Hubs, func(i, j int) bool {
+		return report.Hubs[i].HostName < report.Hubs[j].HostName
+	})
+	return report, nil
+}
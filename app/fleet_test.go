@@ -0,0 +1,220 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/azure-iot-manager/client/iothub"
+	iothubMocks "github.com/mendersoftware/azure-iot-manager/client/iothub/mocks"
+	"github.com/mendersoftware/azure-iot-manager/clock"
+	"github.com/mendersoftware/azure-iot-manager/model"
+	storeMocks "github.com/mendersoftware/azure-iot-manager/store/mocks"
+)
+
+func TestFleetHealth(t *testing.T) {
+	const (
+		goodHub = "HostName=good.azure-devices.net;" +
+			"SharedAccessKeyName=iothubowner;SharedAccessKey=c2VjcmV0"
+		badHub = "HostName=bad.azure-devices.net;" +
+			"SharedAccessKeyName=iothubowner;SharedAccessKey=c2VjcmV0"
+	)
+	tenants := []model.TenantSettings{
+		{TenantID: "tenant1", Settings: model.Settings{ConnectionString: goodHub}},
+		{TenantID: "tenant2", Settings: model.Settings{ConnectionString: badHub}},
+		{TenantID: "tenant3"},
+	}
+	tenantMatcher := func(tenantID string) interface{} {
+		return mock.MatchedBy(func(ctx context.Context) bool {
+			id := identity.FromContext(ctx)
+			return id != nil && id.Tenant == tenantID
+		})
+	}
+	hubMatcher := func(hostName string) interface{} {
+		return mock.MatchedBy(func(cs *model.ConnectionString) bool {
+			return cs.HostName == hostName
+		})
+	}
+
+	ds := &storeMocks.DataStore{}
+	defer ds.AssertExpectations(t)
+	ds.On("ListSettings", mock.Anything).Return(tenants, nil).Once()
+	for _, tenant := range tenants[:2] {
+		ds.On("GetSettings", tenantMatcher(tenant.TenantID)).
+			Return(tenant.Settings, nil).
+			Once()
+	}
+	client := &iothubMocks.Client{}
+	defer client.AssertExpectations(t)
+	client.On("GetDeviceStatistics", mock.Anything, hubMatcher("good.azure-devices.net")).
+		Return(&iothub.RegistryStatistics{}, nil)
+	client.On("GetServiceStatistics", mock.Anything, hubMatcher("good.azure-devices.net")).
+		Return(&iothub.ServiceStatistics{}, nil)
+	client.On("GetDeviceStatistics", mock.Anything, hubMatcher("bad.azure-devices.net")).
+		Return(nil, &iothub.Error{Code: 401})
+
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(now)
+	// the checks neither consume the quotas nor are metered: the store
+	// mock fails on IncrementQuota and IncrementUsage
+	app := New(Config{
+		IoTHub:           client,
+		Clock:            clk,
+		AzureHourlyQuota: 1,
+		AzureDailyQuota:  1,
+	}, ds)
+
+	expected := model.FleetHealthReport{
+		Time:    now,
+		Sampled: 2,
+		Failed:  1,
+		Failures: []model.IntegrationFailure{{
+			TenantID: "tenant2",
+			HostName: "bad.azure-devices.net",
+			Check:    "registry_read",
+			Error:    "iothub: unexpected HTTP status 401 Unauthorized",
+		}},
+		Hubs: []model.HubHealth{
+			{HostName: "bad.azure-devices.net", Sampled: 1, Failed: 1},
+			{HostName: "good.azure-devices.net", Sampled: 1},
+		},
+	}
+	report, err := app.FleetHealth(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, report)
+
+	// the report is reused within the interval, whatever the sample size
+	clk.Advance(fleetHealthInterval / 2)
+	report, err = app.FleetHealth(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, report)
+	report, err = app.FleetHealth(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, report)
+
+	clk.Advance(fleetHealthInterval)
+	ds.On("ListSettings", mock.Anything).Return(nil, errors.New("mongo error"))
+	_, err = app.FleetHealth(context.Background(), 10)
+	assert.EqualError(t, err, "mongo error")
+}
+
+func TestFleetHealthSample(t *testing.T) {
+	tenants := make([]model.TenantSettings, 5)
+	for i := range tenants {
+		tenants[i] = model.TenantSettings{
+			TenantID: string(rune('a' + i)),
+			Settings: model.Settings{ConnectionString: "invalid"},
+		}
+	}
+	ds := &storeMocks.DataStore{}
+	defer ds.AssertExpectations(t)
+	ds.On("ListSettings", mock.Anything).Return(tenants, nil)
+	ds.On("GetSettings", mock.Anything).
+		Return(model.Settings{ConnectionString: "invalid"}, nil).
+		Times(2)
+
+	report, err := New(Config{}, ds).FleetHealth(context.Background(), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Sampled)
+	assert.Equal(t, 2, report.Failed)
+	for _, failure := range report.Failures {
+		assert.Equal(t, "connection_string", failure.Check)
+		assert.Empty(t, failure.HostName)
+	}
+	assert.Empty(t, report.Hubs)
+}
+
+func TestFleetHealthConcurrent(t *testing.T) {
+	ds := &storeMocks.DataStore{}
+	defer ds.AssertExpectations(t)
+	// the check blocks until released
+	started := make(chan context.Context, 1)
+	release := make(chan struct{})
+	ds.On("ListSettings", mock.Anything).
+		Run(func(args mock.Arguments) {
+			started <- args.Get(0).(context.Context)
+			<-release
+		}).
+		Return([]model.TenantSettings{}, nil).
+		Once()
+
+	clk := clock.NewFake(time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC))
+	app := New(Config{Clock: clk}, ds)
+
+	// the first caller gives up: the check goes on for the others
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := app.FleetHealth(ctx, 10)
+		errs <- err
+	}()
+	checkCtx := <-started
+	cancel()
+	assert.Equal(t, context.Canceled, <-errs)
+	assert.NoError(t, checkCtx.Err())
+	_, ok := checkCtx.Deadline()
+	assert.True(t, ok, "the check must have a timeout")
+
+	// the callers share the running check, whatever the sample size
+	reports := make(chan model.FleetHealthReport, 2)
+	for _, sample := range []int{10, 1} {
+		go func(sample int) {
+			report, err := app.FleetHealth(context.Background(), sample)
+			assert.NoError(t, err)
+			reports <- report
+		}(sample)
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		select {
+		case report := <-reports:
+			assert.Equal(t, clk.Now(), report.Time)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for the fleet health report")
+		}
+	}
+}
+
+func TestFleetHealthCanceled(t *testing.T) {
+	ds := &storeMocks.DataStore{}
+	defer ds.AssertExpectations(t)
+	ds.On("ListSettings", mock.Anything).
+		Return([]model.TenantSettings{{
+			TenantID: "tenant1",
+			Settings: model.Settings{ConnectionString: "invalid"},
+		}}, nil)
+	// the checks of the tenant fail on the timeout: they are not
+	// reported as an integration failure
+	ds.On("GetSettings", mock.Anything).
+		Run(func(args mock.Arguments) {
+			<-args.Get(0).(context.Context).Done()
+		}).
+		Return(model.Settings{}, context.DeadlineExceeded).
+		Once()
+
+	app := New(Config{}, ds).(*app)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := app.fleetHealth(ctx, 10, time.Now())
+	assert.Equal(t, context.Canceled, err)
+}
@@ -0,0 +1,115 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+var (
+	ErrIntegrationNotConfigured = errors.New("the connection string is not configured")
+	ErrDeviceConnectionString   = errors.New(
+		"the connection string is a device connection string; " +
+			"a shared access policy of the IoT Hub is required",
+	)
+)
+
+type integrationCheck struct {
+	name        string
+	description string
+	check       func(ctx context.Context, cs *model.ConnectionString) error
+}
+
+func (a *app) integrationChecks() []integrationCheck {
+	return []integrationCheck{{
+		name:        "registry_read",
+		description: "IoT Hub is reachable and the policy grants RegistryRead",
+		check: func(ctx context.Context, cs *model.ConnectionString) error {
+			return a.azureOperation(ctx, func(ctx context.Context) error {
+				_, err := a.IoTHub.GetDeviceStatistics(ctx, cs)
+				return err
+			})
+		},
+	}, {
+		name:        "service_connect",
+		description: "the policy grants ServiceConnect",
+		check: func(ctx context.Context, cs *model.ConnectionString) error {
+			return a.azureOperation(ctx, func(ctx context.Context) error {
+				_, err := a.IoTHub.GetServiceStatistics(ctx, cs)
+				return err
+			})
+		},
+	}}
+}
+
+// CheckIntegration runs the diagnostic checks of the integration with the
+// Azure IoT Hub configured in the settings of the tenant: the connection
+// string is loaded and parsed, and the IoT Hub is queried for verifying
+// connectivity and the permissions of the shared access policy. The checks
+// depending on a failed check are skipped.
+func (a *app) CheckIntegration(ctx context.Context) model.IntegrationReport {
+	var (
+		report model.IntegrationReport
+		cs     *model.ConnectionString
+		failed bool
+	)
+	add := func(name, description string, err error) {
+		check := model.IntegrationCheck{
+			Name:        name,
+			Description: description,
+			Status:      model.IntegrationCheckOK,
+		}
+		if failed {
+			check.Status = model.IntegrationCheckSkipped
+		} else if err != nil {
+			check.Status = model.IntegrationCheckFailed
+			check.Error = err.Error()
+			failed = true
+		}
+		report.Checks = append(report.Checks, check)
+	}
+
+	settings, err := a.store.GetSettings(ctx)
+	if err == nil && settings.ConnectionString == "" {
+		err = ErrIntegrationNotConfigured
+	}
+	add("settings", "the settings contain a connection string", err)
+
+	if !failed {
+		cs, err = model.ParseConnectionString(settings.ConnectionString)
+		if err == nil && cs.Name == "" {
+			err = ErrDeviceConnectionString
+		}
+	}
+	add("connection_string", "the connection string is valid", err)
+
+	for _, check := range a.integrationChecks() {
+		err = nil
+		if !failed {
+			check := check
+			err = a.withFallback(settings, cs,
+				func(cs *model.ConnectionString) error {
+					return check.check(ctx, cs)
+				},
+			)
+		}
+		add(check.name, check.description, err)
+	}
+	return report
+}
@@ -0,0 +1,200 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/azure-iot-manager/client/iothub"
+	iothubMocks "github.com/mendersoftware/azure-iot-manager/client/iothub/mocks"
+	"github.com/mendersoftware/azure-iot-manager/model"
+	storeMocks "github.com/mendersoftware/azure-iot-manager/store/mocks"
+)
+
+func TestCheckIntegration(t *testing.T) {
+	const connectionString = "HostName=myhub.azure-devices.net;" +
+		"SharedAccessKeyName=iothubowner;SharedAccessKey=c2VjcmV0"
+	contextMatcher := mock.MatchedBy(func(ctx context.Context) bool {
+		return true
+	})
+	csMatcher := mock.MatchedBy(func(cs *model.ConnectionString) bool {
+		return cs.HostName == "myhub.azure-devices.net"
+	})
+	testCases := []struct {
+		Name string
+
+		Settings    model.Settings
+		SettingsErr error
+		IoTHub      func(t *testing.T) *iothubMocks.Client
+
+		// AzureOperations is the number of metered IoT Hub requests
+		AzureOperations int
+
+		Statuses []string
+		Errors   map[string]string
+	}{
+		{
+			Name: "ok",
+
+			Settings: model.Settings{ConnectionString: connectionString},
+			IoTHub: func(t *testing.T) *iothubMocks.Client {
+				client := &iothubMocks.Client{}
+				client.On("GetDeviceStatistics", contextMatcher, csMatcher).
+					Return(&iothub.RegistryStatistics{}, nil)
+				client.On("GetServiceStatistics", contextMatcher, csMatcher).
+					Return(&iothub.ServiceStatistics{}, nil)
+				return client
+			},
+			AzureOperations: 2,
+
+			Statuses: []string{
+				model.IntegrationCheckOK,
+				model.IntegrationCheckOK,
+				model.IntegrationCheckOK,
+				model.IntegrationCheckOK,
+			},
+		},
+		{
+			Name: "missing ServiceConnect permission",
+
+			Settings: model.Settings{ConnectionString: connectionString},
+			IoTHub: func(t *testing.T) *iothubMocks.Client {
+				client := &iothubMocks.Client{}
+				client.On("GetDeviceStatistics", contextMatcher, csMatcher).
+					Return(&iothub.RegistryStatistics{}, nil)
+				client.On("GetServiceStatistics", contextMatcher, csMatcher).
+					Return(nil, &iothub.Error{Code: 401})
+				return client
+			},
+			AzureOperations: 2,
+
+			Statuses: []string{
+				model.IntegrationCheckOK,
+				model.IntegrationCheckOK,
+				model.IntegrationCheckOK,
+				model.IntegrationCheckFailed,
+			},
+			Errors: map[string]string{
+				"service_connect": "iothub: unexpected HTTP status 401 Unauthorized",
+			},
+		},
+		{
+			Name: "hub unreachable",
+
+			Settings: model.Settings{ConnectionString: connectionString},
+			IoTHub: func(t *testing.T) *iothubMocks.Client {
+				client := &iothubMocks.Client{}
+				client.On("GetDeviceStatistics", contextMatcher, csMatcher).
+					Return(nil, errors.New("no such host"))
+				return client
+			},
+			AzureOperations: 1,
+
+			Statuses: []string{
+				model.IntegrationCheckOK,
+				model.IntegrationCheckOK,
+				model.IntegrationCheckFailed,
+				model.IntegrationCheckSkipped,
+			},
+			Errors: map[string]string{
+				"registry_read": "no such host",
+			},
+		},
+		{
+			Name: "device connection string",
+
+			Settings: model.Settings{
+				ConnectionString: "HostName=myhub.azure-devices.net;" +
+					"DeviceId=dev1;SharedAccessKey=c2VjcmV0",
+			},
+
+			Statuses: []string{
+				model.IntegrationCheckOK,
+				model.IntegrationCheckFailed,
+				model.IntegrationCheckSkipped,
+				model.IntegrationCheckSkipped,
+			},
+			Errors: map[string]string{
+				"connection_string": ErrDeviceConnectionString.Error(),
+			},
+		},
+		{
+			Name: "not configured",
+
+			Statuses: []string{
+				model.IntegrationCheckFailed,
+				model.IntegrationCheckSkipped,
+				model.IntegrationCheckSkipped,
+				model.IntegrationCheckSkipped,
+			},
+			Errors: map[string]string{
+				"settings": ErrIntegrationNotConfigured.Error(),
+			},
+		},
+		{
+			Name: "store error",
+
+			SettingsErr: errors.New("internal error"),
+
+			Statuses: []string{
+				model.IntegrationCheckFailed,
+				model.IntegrationCheckSkipped,
+				model.IntegrationCheckSkipped,
+				model.IntegrationCheckSkipped,
+			},
+			Errors: map[string]string{
+				"settings": "internal error",
+			},
+		},
+	}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			store := &storeMocks.DataStore{}
+			defer store.AssertExpectations(t)
+			store.On("GetSettings", contextMatcher).
+				Return(tc.Settings, tc.SettingsErr)
+			if tc.AzureOperations > 0 {
+				store.On("IncrementUsage",
+					contextMatcher,
+					mock.AnythingOfType("string"),
+					model.UsageAzureOperations,
+					int64(1),
+				).Return(nil).Times(tc.AzureOperations)
+			}
+			client := &iothubMocks.Client{}
+			if tc.IoTHub != nil {
+				client = tc.IoTHub(t)
+			}
+			defer client.AssertExpectations(t)
+			app := New(Config{IoTHub: client}, store)
+
+			report := app.CheckIntegration(context.Background())
+			statuses := make([]string, len(report.Checks))
+			for i, check := range report.Checks {
+				statuses[i] = check.Status
+				assert.Equal(t, tc.Errors[check.Name], check.Error, check.Name)
+				assert.NotEmpty(t, check.Description)
+			}
+			assert.Equal(t, tc.Statuses, statuses)
+			assert.Equal(t, len(tc.Errors) == 0, report.OK())
+		})
+	}
+}
@@ -18,6 +18,7 @@ package mocks
 
 import (
 	context "context"
+	time "time"
 
 	model "github.com/mendersoftware/azure-iot-manager/model"
 	mock "github.com/stretchr/testify/mock"
@@ -28,6 +29,141 @@ type App struct {
 	mock.Mock
 }
 
+// AuditLog provides a mock function with given fields: ctx, log
+func (_m *App) AuditLog(ctx context.Context, log model.AuditLog) error {
+	ret := _m.Called(ctx, log)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuditLog) error); ok {
+		r0 = rf(ctx, log)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CheckIntegration provides a mock function with given fields: ctx
+func (_m *App) CheckIntegration(ctx context.Context) model.IntegrationReport {
+	ret := _m.Called(ctx)
+
+	var r0 model.IntegrationReport
+	if rf, ok := ret.Get(0).(func(context.Context) model.IntegrationReport); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(model.IntegrationReport)
+	}
+
+	return r0
+}
+
+// DeleteFeatureFlag provides a mock function with given fields: ctx, name
+func (_m *App) DeleteFeatureFlag(ctx context.Context, name string) error {
+	ret := _m.Called(ctx, name)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, name)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ExportAuditLogs provides a mock function with given fields: ctx, filter, fn
+func (_m *App) ExportAuditLogs(ctx context.Context, filter model.AuditLogFilter, fn func(model.AuditLog) error) error {
+	ret := _m.Called(ctx, filter, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuditLogFilter, func(model.AuditLog) error) error); ok {
+		r0 = rf(ctx, filter, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// FeatureEnabled provides a mock function with given fields: ctx, name
+func (_m *App) FeatureEnabled(ctx context.Context, name string) bool {
+	ret := _m.Called(ctx, name)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(context.Context, string) bool); ok {
+		r0 = rf(ctx, name)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
+// FleetHealth provides a mock function with given fields: ctx, sample
+func (_m *App) FleetHealth(ctx context.Context, sample int) (model.FleetHealthReport, error) {
+	ret := _m.Called(ctx, sample)
+
+	var r0 model.FleetHealthReport
+	if rf, ok := ret.Get(0).(func(context.Context, int) model.FleetHealthReport); ok {
+		r0 = rf(ctx, sample)
+	} else {
+		r0 = ret.Get(0).(model.FleetHealthReport)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, sample)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ForwardAuditLogs provides a mock function with given fields: ctx
+func (_m *App) ForwardAuditLogs(ctx context.Context) (int, error) {
+	ret := _m.Called(ctx)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context) int); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetFeatureFlags provides a mock function with given fields: ctx
+func (_m *App) GetFeatureFlags(ctx context.Context) ([]model.FeatureFlag, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.FeatureFlag
+	if rf, ok := ret.Get(0).(func(context.Context) []model.FeatureFlag); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.FeatureFlag)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetSettings provides a mock function with given fields: ctx
 func (_m *App) GetSettings(ctx context.Context) (model.Settings, error) {
 	ret := _m.Called(ctx)
@@ -49,6 +185,29 @@ func (_m *App) GetSettings(ctx context.Context) (model.Settings, error) {
 	return r0, r1
 }
 
+// GetUsage provides a mock function with given fields: ctx, period
+func (_m *App) GetUsage(ctx context.Context, period string) ([]model.Usage, error) {
+	ret := _m.Called(ctx, period)
+
+	var r0 []model.Usage
+	if rf, ok := ret.Get(0).(func(context.Context, string) []model.Usage); ok {
+		r0 = rf(ctx, period)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Usage)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, period)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // HealthCheck provides a mock function with given fields: ctx
 func (_m *App) HealthCheck(ctx context.Context) error {
 	ret := _m.Called(ctx)
@@ -63,6 +222,104 @@ func (_m *App) HealthCheck(ctx context.Context) error {
 	return r0
 }
 
+// HealthReport provides a mock function with given fields: ctx
+func (_m *App) HealthReport(ctx context.Context) model.HealthReport {
+	ret := _m.Called(ctx)
+
+	var r0 model.HealthReport
+	if rf, ok := ret.Get(0).(func(context.Context) model.HealthReport); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(model.HealthReport)
+	}
+
+	return r0
+}
+
+// MigrationStatus provides a mock function with given fields: ctx
+func (_m *App) MigrationStatus(ctx context.Context) (model.MigrationStatus, error) {
+	ret := _m.Called(ctx)
+
+	var r0 model.MigrationStatus
+	if rf, ok := ret.Get(0).(func(context.Context) model.MigrationStatus); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(model.MigrationStatus)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PurgeAuditLogs provides a mock function with given fields: ctx, before
+func (_m *App) PurgeAuditLogs(ctx context.Context, before time.Time) (int, error) {
+	ret := _m.Called(ctx, before)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) int); ok {
+		r0 = rf(ctx, before)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(ctx, before)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RecordUsage provides a mock function with given fields: ctx, counter
+func (_m *App) RecordUsage(ctx context.Context, counter string) error {
+	ret := _m.Called(ctx, counter)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, counter)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RotateConnectionString provides a mock function with given fields: ctx, connStr
+func (_m *App) RotateConnectionString(ctx context.Context, connStr string) error {
+	ret := _m.Called(ctx, connStr)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, connStr)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetFeatureFlag provides a mock function with given fields: ctx, name, enabled
+func (_m *App) SetFeatureFlag(ctx context.Context, name string, enabled bool) error {
+	ret := _m.Called(ctx, name, enabled)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, bool) error); ok {
+		r0 = rf(ctx, name, enabled)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // SetSettings provides a mock function with given fields: ctx, settings
 func (_m *App) SetSettings(ctx context.Context, settings model.Settings) error {
 	ret := _m.Called(ctx, settings)
@@ -76,3 +333,17 @@ func (_m *App) SetSettings(ctx context.Context, settings model.Settings) error {
 
 	return r0
 }
+
+// SetSettingsIfMatch provides a mock function with given fields: ctx, settings, match
+func (_m *App) SetSettingsIfMatch(ctx context.Context, settings model.Settings, match func(current model.Settings) bool) error {
+	ret := _m.Called(ctx, settings, match)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.Settings, func(current model.Settings) bool) error); ok {
+		r0 = rf(ctx, settings, match)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
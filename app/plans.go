@@ -0,0 +1,101 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+// Capabilities which can be restricted to the tenant plans
+const (
+	// CapabilitySettingsRotation is the zero-downtime rotation of the
+	// connection string
+	CapabilitySettingsRotation = "settings_rotation"
+	// CapabilityAuditLogsExport is the export of the audit logs through
+	// the management API
+	CapabilityAuditLogsExport = "audit_logs_export"
+)
+
+// Capabilities are the capabilities which can be restricted to the tenant
+// plans
+var Capabilities = []string{
+	CapabilitySettingsRotation,
+	CapabilityAuditLogsExport,
+}
+
+var (
+	ErrNotEntitled = errors.New("the tenant plan does not include the capability")
+)
+
+// planApp restricts the premium capabilities to the plans entitled to
+// them before delegating to App
+type planApp struct {
+	App
+	plans map[string][]string
+}
+
+// NewWithPlans wraps the app with a layer restricting the capabilities to
+// the tenant plans, from the plan claim of the caller's token. plans maps
+// the plan names to the capabilities they include; the capabilities not
+// included in any plan are available to all the tenants.
+func NewWithPlans(app App, plans map[string][]string) App {
+	return &planApp{App: app, plans: plans}
+}
+
+// entitled checks that the plan of the caller includes the capability;
+// the requests without an identity (internal API) are not restricted.
+func (a *planApp) entitled(ctx context.Context, capability string) error {
+	id := identity.FromContext(ctx)
+	if id == nil {
+		return nil
+	}
+	var restricted bool
+	for plan, capabilities := range a.plans {
+		if !containsString(capabilities, capability) {
+			continue
+		} else if plan == id.Plan {
+			return nil
+		}
+		restricted = true
+	}
+	if restricted {
+		return errors.Wrapf(ErrNotEntitled, "%s", capability)
+	}
+	return nil
+}
+
+func (a *planApp) RotateConnectionString(ctx context.Context, connStr string) error {
+	if err := a.entitled(ctx, CapabilitySettingsRotation); err != nil {
+		return err
+	}
+	return a.App.RotateConnectionString(ctx, connStr)
+}
+
+func (a *planApp) ExportAuditLogs(
+	ctx context.Context,
+	filter model.AuditLogFilter,
+	fn func(model.AuditLog) error,
+) error {
+	if err := a.entitled(ctx, CapabilityAuditLogsExport); err != nil {
+		return err
+	}
+	return a.App.ExportAuditLogs(ctx, filter, fn)
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/azure-iot-manager/model"
+	storeMocks "github.com/mendersoftware/azure-iot-manager/store/mocks"
+)
+
+func TestPlans(t *testing.T) {
+	plans := map[string][]string{
+		"professional": {CapabilityAuditLogsExport},
+		"enterprise":   {CapabilityAuditLogsExport, CapabilitySettingsRotation},
+	}
+	testCases := []struct {
+		Name string
+
+		Identity *identity.Identity
+
+		ExportError error
+		RotateError error
+	}{{
+		Name: "internal request",
+	}, {
+		Name:     "enterprise plan",
+		Identity: &identity.Identity{Tenant: "tenant", Plan: "enterprise"},
+	}, {
+		Name:     "professional plan",
+		Identity: &identity.Identity{Tenant: "tenant", Plan: "professional"},
+
+		RotateError: ErrNotEntitled,
+	}, {
+		Name:     "os plan",
+		Identity: &identity.Identity{Tenant: "tenant", Plan: "os"},
+
+		ExportError: ErrNotEntitled,
+		RotateError: ErrNotEntitled,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			store := &storeMocks.DataStore{}
+			defer store.AssertExpectations(t)
+			ctxMatcher := mock.MatchedBy(func(ctx context.Context) bool {
+				return true
+			})
+			if tc.ExportError == nil {
+				store.On("IterateAuditLogs", ctxMatcher,
+					model.AuditLogFilter{},
+					mock.AnythingOfType("func(model.AuditLog) error"),
+				).Return(nil)
+			}
+			app := NewWithPlans(New(Config{}, store), plans)
+
+			ctx := context.Background()
+			if tc.Identity != nil {
+				ctx = identity.WithContext(ctx, tc.Identity)
+			}
+			err := app.ExportAuditLogs(ctx, model.AuditLogFilter{},
+				func(model.AuditLog) error { return nil })
+			assert.Equal(t, tc.ExportError, errors.Cause(err))
+			// the malformed connection string fails after the plan check
+			err = app.RotateConnectionString(ctx, "")
+			if tc.RotateError != nil {
+				assert.EqualError(t, err,
+					"settings_rotation: "+tc.RotateError.Error())
+			} else {
+				assert.Error(t, err)
+				assert.False(t, errors.Is(err, ErrNotEntitled))
+			}
+		})
+	}
+}
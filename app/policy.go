@@ -0,0 +1,108 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+// Enforcement modes of the least privilege of the shared access policies
+const (
+	// PolicyEnforcementOff accepts any shared access policy
+	PolicyEnforcementOff = "off"
+	// PolicyEnforcementWarn logs a warning for the shared access
+	// policies granting unneeded permissions
+	PolicyEnforcementWarn = "warn"
+	// PolicyEnforcementRefuse rejects the shared access policies
+	// granting unneeded permissions
+	PolicyEnforcementRefuse = "refuse"
+)
+
+var (
+	ErrExcessivePermissions = errors.New(
+		"the shared access policy grants more permissions than required",
+	)
+	ErrUnknownPolicyEnforcement = errors.New("unknown policy enforcement mode")
+)
+
+// requiredPermissions are the permissions of the shared access policy
+// required by the integration: reading and updating the device registry,
+// and the service endpoints of the IoT Hub.
+var requiredPermissions = []string{
+	model.PermissionRegistryRead,
+	model.PermissionRegistryWrite,
+	model.PermissionServiceConnect,
+}
+
+// ValidatePolicyEnforcement checks that mode is one of the enforcement
+// modes of the least privilege of the shared access policies
+func ValidatePolicyEnforcement(mode string) error {
+	switch mode {
+	case PolicyEnforcementOff, PolicyEnforcementWarn, PolicyEnforcementRefuse:
+		return nil
+	}
+	return errors.Wrapf(ErrUnknownPolicyEnforcement, "%q", mode)
+}
+
+// checkPolicy verifies that the shared access policy of the connection
+// string does not grant more permissions than the integration requires.
+// Depending on PolicyEnforcement, a policy granting unneeded permissions
+// is logged or rejected; policies other than the built-in policies of
+// the IoT Hub are not checked, as their permissions are unknown.
+func (a *app) checkPolicy(ctx context.Context, cs *model.ConnectionString) error {
+	if a.PolicyEnforcement == "" || a.PolicyEnforcement == PolicyEnforcementOff {
+		return nil
+	}
+	granted, ok := cs.Permissions()
+	if !ok {
+		return nil
+	}
+	var excess []string
+	for _, permission := range granted {
+		if !containsString(requiredPermissions, permission) {
+			excess = append(excess, permission)
+		}
+	}
+	if len(excess) == 0 {
+		return nil
+	}
+	err := errors.Wrapf(ErrExcessivePermissions,
+		"policy %q grants %s; the integration requires %s",
+		cs.Name,
+		strings.Join(excess, ", "),
+		strings.Join(requiredPermissions, ", "),
+	)
+	if a.PolicyEnforcement == PolicyEnforcementRefuse {
+		return err
+	}
+	log.FromContext(ctx).Warn(err.Error())
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
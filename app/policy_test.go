@@ -0,0 +1,102 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/azure-iot-manager/model"
+	storeMocks "github.com/mendersoftware/azure-iot-manager/store/mocks"
+)
+
+func TestSetSettingsPolicyEnforcement(t *testing.T) {
+	const (
+		ownerConnectionString = "HostName=myhub.azure-devices.net;" +
+			"SharedAccessKeyName=iothubowner;SharedAccessKey=c2VjcmV0"
+		customConnectionString = "HostName=myhub.azure-devices.net;" +
+			"SharedAccessKeyName=mender;SharedAccessKey=c2VjcmV0"
+	)
+	testCases := []struct {
+		Name string
+
+		Enforcement      string
+		ConnectionString string
+
+		Error error
+	}{{
+		Name: "ok, enforcement off",
+
+		Enforcement:      PolicyEnforcementOff,
+		ConnectionString: ownerConnectionString,
+	}, {
+		Name: "ok, warning only",
+
+		Enforcement:      PolicyEnforcementWarn,
+		ConnectionString: ownerConnectionString,
+	}, {
+		Name: "ok, custom policy",
+
+		Enforcement:      PolicyEnforcementRefuse,
+		ConnectionString: customConnectionString,
+	}, {
+		Name: "ok, malformed connection string",
+
+		Enforcement:      PolicyEnforcementRefuse,
+		ConnectionString: "my://connection.string",
+	}, {
+		Name: "error, excessive permissions",
+
+		Enforcement:      PolicyEnforcementRefuse,
+		ConnectionString: ownerConnectionString,
+
+		Error: ErrExcessivePermissions,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			store := &storeMocks.DataStore{}
+			defer store.AssertExpectations(t)
+			settings := model.Settings{ConnectionString: tc.ConnectionString}
+			if tc.Error == nil {
+				store.On("SetSettings",
+					mock.MatchedBy(func(ctx context.Context) bool {
+						return true
+					}),
+					settings,
+				).Return(nil)
+			}
+			app := New(Config{PolicyEnforcement: tc.Enforcement}, store)
+
+			err := app.SetSettings(context.Background(), settings)
+			if tc.Error != nil {
+				assert.EqualError(t, err, `policy "iothubowner" grants DeviceConnect; `+
+					"the integration requires RegistryRead, RegistryWrite, "+
+					"ServiceConnect: "+tc.Error.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidatePolicyEnforcement(t *testing.T) {
+	assert.NoError(t, ValidatePolicyEnforcement(PolicyEnforcementWarn))
+	assert.EqualError(t, ValidatePolicyEnforcement("strict"),
+		`"strict": `+ErrUnknownPolicyEnforcement.Error())
+}
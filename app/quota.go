@@ -0,0 +1,105 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+)
+
+var (
+	ErrQuotaExceeded = errors.New("quota of Azure operations exceeded")
+)
+
+// Quota windows of the Azure operations
+const (
+	QuotaWindowHour = "hour"
+	QuotaWindowDay  = "day"
+)
+
+// QuotaExceededError is returned when the tenant has exhausted its quota
+// of Azure operations for the window; it matches ErrQuotaExceeded.
+type QuotaExceededError struct {
+	Window  string
+	Limit   int64
+	ResetAt time.Time
+	// RetryAfter is the time left until the reset when the quota was
+	// exceeded
+	RetryAfter time.Duration
+}
+
+func (err *QuotaExceededError) Error() string {
+	return fmt.Sprintf(
+		"quota of %d Azure operations per %s exceeded; resets at %s",
+		err.Limit, err.Window, err.ResetAt.Format(time.RFC3339),
+	)
+}
+
+// Is matches ErrQuotaExceeded
+func (err *QuotaExceededError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// quotaWindow returns the identifier and the end of the window, in UTC,
+// containing t, e.g. "2021-10-01T12" for the hour starting at 12:00.
+func quotaWindow(window string, t time.Time) (string, time.Time) {
+	t = t.UTC()
+	if window == QuotaWindowHour {
+		start := t.Truncate(time.Hour)
+		return start.Format("2006-01-02T15"), start.Add(time.Hour)
+	}
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return start.Format("2006-01-02"), start.AddDate(0, 0, 1)
+}
+
+// consumeQuota counts an Azure operation against the quotas of the tenant
+// in the context, and fails with a *QuotaExceededError if any is
+// exhausted; the rejected operations count too. Failures of the counters
+// do not fail the operation.
+func (a *app) consumeQuota(ctx context.Context) error {
+	quotas := []struct {
+		window string
+		limit  int64
+	}{
+		{QuotaWindowDay, a.AzureDailyQuota},
+		{QuotaWindowHour, a.AzureHourlyQuota},
+	}
+	now := a.Clock.Now()
+	for _, quota := range quotas {
+		if quota.limit <= 0 {
+			continue
+		}
+		window, end := quotaWindow(quota.window, now)
+		count, err := a.store.IncrementQuota(ctx, window, end)
+		if err != nil {
+			log.FromContext(ctx).
+				Warnf("failed to count Azure operation quota: %s", err)
+			continue
+		} else if count > quota.limit {
+			return &QuotaExceededError{
+				Window:     quota.window,
+				Limit:      quota.limit,
+				ResetAt:    end,
+				RetryAfter: end.Sub(now),
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/azure-iot-manager/clock"
+	"github.com/mendersoftware/azure-iot-manager/model"
+	storeMocks "github.com/mendersoftware/azure-iot-manager/store/mocks"
+)
+
+func TestAzureOperationQuota(t *testing.T) {
+	now := time.Date(2021, 10, 1, 12, 30, 0, 0, time.UTC)
+	contextMatcher := mock.MatchedBy(func(ctx context.Context) bool {
+		return true
+	})
+	testCases := []struct {
+		Name string
+
+		DailyCount  int64
+		HourlyCount int64
+		CountErr    error
+
+		Error error
+	}{{
+		Name: "ok",
+
+		DailyCount:  100,
+		HourlyCount: 10,
+	}, {
+		Name: "ok, counter error",
+
+		CountErr: errors.New("connection refused"),
+	}, {
+		Name: "error, hourly quota exceeded",
+
+		DailyCount:  100,
+		HourlyCount: 11,
+
+		Error: &QuotaExceededError{
+			Window:     QuotaWindowHour,
+			Limit:      10,
+			ResetAt:    time.Date(2021, 10, 1, 13, 0, 0, 0, time.UTC),
+			RetryAfter: 30 * time.Minute,
+		},
+	}, {
+		Name: "error, daily quota exceeded",
+
+		DailyCount: 101,
+
+		Error: &QuotaExceededError{
+			Window:     QuotaWindowDay,
+			Limit:      100,
+			ResetAt:    time.Date(2021, 10, 2, 0, 0, 0, 0, time.UTC),
+			RetryAfter: 11*time.Hour + 30*time.Minute,
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			store := &storeMocks.DataStore{}
+			defer store.AssertExpectations(t)
+			store.On("IncrementQuota",
+				contextMatcher,
+				"2021-10-01",
+				time.Date(2021, 10, 2, 0, 0, 0, 0, time.UTC),
+			).Return(tc.DailyCount, tc.CountErr)
+			if tc.DailyCount <= 100 {
+				store.On("IncrementQuota",
+					contextMatcher,
+					"2021-10-01T12",
+					time.Date(2021, 10, 1, 13, 0, 0, 0, time.UTC),
+				).Return(tc.HourlyCount, tc.CountErr)
+			}
+			if tc.Error == nil {
+				store.On("IncrementUsage",
+					contextMatcher,
+					"2021-10",
+					model.UsageAzureOperations,
+					int64(1),
+				).Return(nil)
+			}
+			app := New(Config{
+				Clock:            clock.NewFake(now),
+				AzureHourlyQuota: 10,
+				AzureDailyQuota:  100,
+			}, store).(*app)
+
+			var called bool
+			err := app.azureOperation(context.Background(),
+				func(ctx context.Context) error {
+					called = true
+					return nil
+				},
+			)
+			if tc.Error != nil {
+				assert.Equal(t, tc.Error, err)
+				assert.True(t, errors.Is(err, ErrQuotaExceeded))
+				assert.False(t, called)
+			} else {
+				assert.NoError(t, err)
+				assert.True(t, called)
+			}
+		})
+	}
+}
+
+func TestAzureOperationInternal(t *testing.T) {
+	store := &storeMocks.DataStore{}
+	defer store.AssertExpectations(t)
+	app := New(Config{
+		Clock:            clock.NewFake(time.Now()),
+		AzureHourlyQuota: 1,
+		AzureDailyQuota:  1,
+	}, store).(*app)
+
+	// internal operations neither consume the quotas nor are metered:
+	// the store mock fails on IncrementQuota and IncrementUsage
+	var called bool
+	err := app.azureOperation(WithInternal(context.Background()),
+		func(ctx context.Context) error {
+			called = true
+			return nil
+		},
+	)
+	assert.NoError(t, err)
+	assert.True(t, called)
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/azure-iot-manager/model"
+	"github.com/mendersoftware/azure-iot-manager/rbac"
+)
+
+var (
+	ErrForbidden = errors.New("insufficient permissions for the operation")
+)
+
+// rbacApp enforces the RBAC scopes of the caller before delegating to App
+type rbacApp struct {
+	App
+}
+
+// NewWithRBAC wraps the app with a layer enforcing the scopes granted to
+// the caller
+func NewWithRBAC(app App) App {
+	return &rbacApp{App: app}
+}
+
+func (a *rbacApp) GetSettings(ctx context.Context) (model.Settings, error) {
+	if !rbac.FromContext(ctx).CanRead() {
+		return model.Settings{}, ErrForbidden
+	}
+	return a.App.GetSettings(ctx)
+}
+
+func (a *rbacApp) SetSettings(ctx context.Context, settings model.Settings) error {
+	if !rbac.FromContext(ctx).CanWrite() {
+		return ErrForbidden
+	}
+	return a.App.SetSettings(ctx, settings)
+}
+
+func (a *rbacApp) SetSettingsIfMatch(
+	ctx context.Context,
+	settings model.Settings,
+	match func(current model.Settings) bool,
+) error {
+	if !rbac.FromContext(ctx).CanWrite() {
+		return ErrForbidden
+	}
+	return a.App.SetSettingsIfMatch(ctx, settings, match)
+}
+
+func (a *rbacApp) RotateConnectionString(ctx context.Context, connStr string) error {
+	if !rbac.FromContext(ctx).CanWrite() {
+		return ErrForbidden
+	}
+	return a.App.RotateConnectionString(ctx, connStr)
+}
+
+func (a *rbacApp) ExportAuditLogs(
+	ctx context.Context,
+	filter model.AuditLogFilter,
+	fn func(model.AuditLog) error,
+) error {
+	if !rbac.FromContext(ctx).CanRead() {
+		return ErrForbidden
+	}
+	return a.App.ExportAuditLogs(ctx, filter, fn)
+}
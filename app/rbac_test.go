@@ -0,0 +1,103 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/azure-iot-manager/model"
+	"github.com/mendersoftware/azure-iot-manager/rbac"
+	storeMocks "github.com/mendersoftware/azure-iot-manager/store/mocks"
+)
+
+func TestRBAC(t *testing.T) {
+	testCases := []struct {
+		Name string
+
+		Scopes rbac.Scopes
+
+		ReadError  error
+		WriteError error
+	}{{
+		Name: "unrestricted",
+	}, {
+		Name:   "read-write",
+		Scopes: rbac.Scopes{rbac.ScopeWrite},
+	}, {
+		Name:   "read-only",
+		Scopes: rbac.Scopes{rbac.ScopeRead},
+
+		WriteError: ErrForbidden,
+	}, {
+		Name:   "no access",
+		Scopes: rbac.Scopes{},
+
+		ReadError:  ErrForbidden,
+		WriteError: ErrForbidden,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			store := &storeMocks.DataStore{}
+			defer store.AssertExpectations(t)
+			ctxMatcher := mock.MatchedBy(func(ctx context.Context) bool {
+				return true
+			})
+			if tc.ReadError == nil {
+				store.On("GetSettings", ctxMatcher).
+					Return(model.Settings{}, nil)
+				store.On("IterateAuditLogs", ctxMatcher,
+					model.AuditLogFilter{},
+					mock.AnythingOfType("func(model.AuditLog) error"),
+				).Return(nil)
+			}
+			if tc.WriteError == nil {
+				store.On("SetSettings", ctxMatcher, model.Settings{}).
+					Return(nil)
+				store.On("SetSettingsIfMatch", ctxMatcher, model.Settings{},
+					mock.AnythingOfType("func(model.Settings) bool"),
+				).Return(nil)
+			}
+			app := NewWithRBAC(New(Config{}, store))
+
+			ctx := context.Background()
+			if tc.Scopes != nil {
+				ctx = rbac.WithContext(ctx, tc.Scopes)
+			}
+			_, err := app.GetSettings(ctx)
+			assert.Equal(t, tc.ReadError, err)
+			err = app.ExportAuditLogs(ctx, model.AuditLogFilter{},
+				func(model.AuditLog) error { return nil })
+			assert.Equal(t, tc.ReadError, err)
+			err = app.SetSettings(ctx, model.Settings{})
+			assert.Equal(t, tc.WriteError, err)
+			err = app.SetSettingsIfMatch(ctx, model.Settings{},
+				func(model.Settings) bool { return true })
+			assert.Equal(t, tc.WriteError, err)
+			// the malformed connection string fails after the RBAC check
+			err = app.RotateConnectionString(ctx, "")
+			if tc.WriteError != nil {
+				assert.Equal(t, tc.WriteError, err)
+			} else {
+				assert.Error(t, err)
+				assert.NotEqual(t, ErrForbidden, err)
+			}
+		})
+	}
+}
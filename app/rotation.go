@@ -0,0 +1,110 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/azure-iot-manager/client/iothub"
+	"github.com/mendersoftware/azure-iot-manager/model"
+	"github.com/mendersoftware/azure-iot-manager/store"
+)
+
+var (
+	ErrConnectionStringRejected = errors.New(
+		"the connection string was rejected by the IoT Hub",
+	)
+	ErrSettingsModified = errors.New(
+		"the settings were modified concurrently; please retry",
+	)
+	ErrHostNameChanged = errors.New(
+		"the connection string is for another IoT Hub; " +
+			"use PUT /settings to change the IoT Hub",
+	)
+)
+
+// RotateConnectionString replaces the connection string of the tenant
+// with connStr after verifying it against the IoT Hub. The replaced
+// connection string is kept as a fallback for RotationGracePeriod, so
+// that the Azure operations keep working while the old shared access
+// key is being phased out. The IoT Hub cannot be changed by a rotation.
+func (a *app) RotateConnectionString(ctx context.Context, connStr string) error {
+	cs, err := model.ParseConnectionString(connStr)
+	if err != nil {
+		return err
+	} else if cs.Name == "" {
+		return ErrDeviceConnectionString
+	} else if err = a.checkPolicy(ctx, cs); err != nil {
+		return err
+	}
+	settings, err := a.store.GetSettings(ctx)
+	if err != nil {
+		return err
+	} else if settings.ConnectionString == "" {
+		return ErrIntegrationNotConfigured
+	}
+	current, err := model.ParseConnectionString(settings.ConnectionString)
+	if err == nil && !strings.EqualFold(current.HostName, cs.HostName) {
+		return ErrHostNameChanged
+	}
+	// the checks are requested by the tenant: they consume its quotas and
+	// are metered
+	for _, check := range a.integrationChecks() {
+		err = check.check(ctx, cs)
+		if errors.Is(err, iothub.ErrUnauthorized) {
+			return errors.Wrap(ErrConnectionStringRejected, err.Error())
+		} else if err != nil {
+			return err
+		}
+	}
+
+	rotated := model.Settings{ConnectionString: connStr}
+	if connStr != settings.ConnectionString && a.RotationGracePeriod > 0 {
+		expiresAt := a.Clock.Now().Add(a.RotationGracePeriod)
+		rotated.PreviousConnectionString = settings.ConnectionString
+		rotated.PreviousExpiresAt = &expiresAt
+	}
+	err = a.store.RotateSettings(ctx, settings.ConnectionString, rotated)
+	if err == store.ErrObjectModified {
+		return ErrSettingsModified
+	}
+	return err
+}
+
+// withFallback calls fn with the connection string cs and, if the IoT Hub
+// rejects it, retries with the connection string replaced by the last
+// rotation while its grace period lasts.
+func (a *app) withFallback(
+	settings model.Settings,
+	cs *model.ConnectionString,
+	fn func(cs *model.ConnectionString) error,
+) error {
+	err := fn(cs)
+	if !errors.Is(err, iothub.ErrUnauthorized) {
+		return err
+	}
+	previous, ok := settings.FallbackConnectionString(a.Clock.Now())
+	if !ok {
+		return err
+	}
+	fallback, perr := model.ParseConnectionString(previous)
+	if perr != nil {
+		return err
+	}
+	return fn(fallback)
+}
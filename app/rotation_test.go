@@ -0,0 +1,286 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/azure-iot-manager/client/iothub"
+	iothubMocks "github.com/mendersoftware/azure-iot-manager/client/iothub/mocks"
+	"github.com/mendersoftware/azure-iot-manager/clock"
+	"github.com/mendersoftware/azure-iot-manager/model"
+	"github.com/mendersoftware/azure-iot-manager/store"
+	storeMocks "github.com/mendersoftware/azure-iot-manager/store/mocks"
+)
+
+const (
+	oldConnectionString = "HostName=myhub.azure-devices.net;" +
+		"SharedAccessKeyName=iothubowner;SharedAccessKey=b2xk"
+	newConnectionString = "HostName=myhub.azure-devices.net;" +
+		"SharedAccessKeyName=iothubowner;SharedAccessKey=bmV3"
+)
+
+func keyMatcher(key string) interface{} {
+	return mock.MatchedBy(func(cs *model.ConnectionString) bool {
+		return string(cs.Key) == key
+	})
+}
+
+func TestRotateConnectionString(t *testing.T) {
+	contextMatcher := mock.MatchedBy(func(ctx context.Context) bool {
+		return true
+	})
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(time.Hour)
+	testCases := []struct {
+		Name string
+
+		ConnectionString string
+		Settings         model.Settings
+		IoTHub           func(t *testing.T) *iothubMocks.Client
+		RotateErr        error
+
+		Rotated *model.Settings
+		Error   error
+	}{{
+		Name: "ok",
+
+		ConnectionString: newConnectionString,
+		Settings:         model.Settings{ConnectionString: oldConnectionString},
+		IoTHub: func(t *testing.T) *iothubMocks.Client {
+			client := &iothubMocks.Client{}
+			client.On("GetDeviceStatistics", contextMatcher, keyMatcher("new")).
+				Return(&iothub.RegistryStatistics{}, nil)
+			client.On("GetServiceStatistics", contextMatcher, keyMatcher("new")).
+				Return(&iothub.ServiceStatistics{}, nil)
+			return client
+		},
+
+		Rotated: &model.Settings{
+			ConnectionString:         newConnectionString,
+			PreviousConnectionString: oldConnectionString,
+			PreviousExpiresAt:        &expiresAt,
+		},
+	}, {
+		Name: "error, rejected by the IoT Hub",
+
+		ConnectionString: newConnectionString,
+		Settings:         model.Settings{ConnectionString: oldConnectionString},
+		IoTHub: func(t *testing.T) *iothubMocks.Client {
+			client := &iothubMocks.Client{}
+			client.On("GetDeviceStatistics", contextMatcher, keyMatcher("new")).
+				Return(&iothub.RegistryStatistics{}, nil)
+			client.On("GetServiceStatistics", contextMatcher, keyMatcher("new")).
+				Return(nil, &iothub.Error{Code: 401})
+			return client
+		},
+
+		Error: ErrConnectionStringRejected,
+	}, {
+		Name: "error, concurrent modification",
+
+		ConnectionString: newConnectionString,
+		Settings:         model.Settings{ConnectionString: oldConnectionString},
+		IoTHub: func(t *testing.T) *iothubMocks.Client {
+			client := &iothubMocks.Client{}
+			client.On("GetDeviceStatistics", contextMatcher, keyMatcher("new")).
+				Return(&iothub.RegistryStatistics{}, nil)
+			client.On("GetServiceStatistics", contextMatcher, keyMatcher("new")).
+				Return(&iothub.ServiceStatistics{}, nil)
+			return client
+		},
+		RotateErr: store.ErrObjectModified,
+
+		Rotated: &model.Settings{
+			ConnectionString:         newConnectionString,
+			PreviousConnectionString: oldConnectionString,
+			PreviousExpiresAt:        &expiresAt,
+		},
+		Error: ErrSettingsModified,
+	}, {
+		Name: "error, host name changed",
+
+		ConnectionString: "HostName=otherhub.azure-devices.net;" +
+			"SharedAccessKeyName=iothubowner;SharedAccessKey=bmV3",
+		Settings: model.Settings{ConnectionString: oldConnectionString},
+
+		Error: ErrHostNameChanged,
+	}, {
+		Name: "error, not configured",
+
+		ConnectionString: newConnectionString,
+
+		Error: ErrIntegrationNotConfigured,
+	}, {
+		Name: "error, device connection string",
+
+		ConnectionString: "HostName=myhub.azure-devices.net;" +
+			"DeviceId=dev1;SharedAccessKey=bmV3",
+
+		Error: ErrDeviceConnectionString,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			ds := &storeMocks.DataStore{}
+			defer ds.AssertExpectations(t)
+			if tc.Error != ErrDeviceConnectionString {
+				ds.On("GetSettings", contextMatcher).
+					Return(tc.Settings, nil)
+			}
+			if tc.Rotated != nil {
+				ds.On("RotateSettings",
+					contextMatcher,
+					oldConnectionString,
+					*tc.Rotated,
+				).Return(tc.RotateErr)
+			}
+			client := &iothubMocks.Client{}
+			if tc.IoTHub != nil {
+				client = tc.IoTHub(t)
+				// each request to the IoT Hub is metered for the tenant
+				ds.On("IncrementUsage",
+					contextMatcher,
+					"2021-10",
+					model.UsageAzureOperations,
+					int64(1),
+				).Return(nil).Times(len(client.ExpectedCalls))
+			}
+			defer client.AssertExpectations(t)
+			app := New(Config{
+				IoTHub:              client,
+				Clock:               clock.NewFake(now),
+				RotationGracePeriod: time.Hour,
+			}, ds)
+
+			err := app.RotateConnectionString(context.Background(), tc.ConnectionString)
+			if tc.Error != nil {
+				assert.True(t, errors.Is(err, tc.Error), err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRotateConnectionStringQuota(t *testing.T) {
+	contextMatcher := mock.MatchedBy(func(ctx context.Context) bool {
+		return true
+	})
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	ds := &storeMocks.DataStore{}
+	defer ds.AssertExpectations(t)
+	ds.On("GetSettings", contextMatcher).
+		Return(model.Settings{ConnectionString: oldConnectionString}, nil)
+	ds.On("IncrementQuota", contextMatcher, "2021-10-01T12", now.Add(time.Hour)).
+		Return(int64(11), nil)
+	// the IoT Hub mock fails on any request
+	client := &iothubMocks.Client{}
+	app := New(Config{
+		IoTHub:           client,
+		Clock:            clock.NewFake(now),
+		AzureHourlyQuota: 10,
+	}, ds)
+
+	err := app.RotateConnectionString(context.Background(), newConnectionString)
+	var quotaErr *QuotaExceededError
+	if assert.True(t, errors.As(err, &quotaErr), err) {
+		assert.Equal(t, QuotaWindowHour, quotaErr.Window)
+		assert.Equal(t, time.Hour, quotaErr.RetryAfter)
+	}
+}
+
+func TestCheckIntegrationFallback(t *testing.T) {
+	contextMatcher := mock.MatchedBy(func(ctx context.Context) bool {
+		return true
+	})
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		Name string
+
+		ExpiresAt time.Time
+
+		Statuses []string
+	}{{
+		Name: "ok, fallback to the previous connection string",
+
+		ExpiresAt: now.Add(time.Minute),
+
+		Statuses: []string{
+			model.IntegrationCheckOK,
+			model.IntegrationCheckOK,
+			model.IntegrationCheckOK,
+			model.IntegrationCheckOK,
+		},
+	}, {
+		Name: "error, grace period expired",
+
+		ExpiresAt: now,
+
+		Statuses: []string{
+			model.IntegrationCheckOK,
+			model.IntegrationCheckOK,
+			model.IntegrationCheckFailed,
+			model.IntegrationCheckSkipped,
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			ds := &storeMocks.DataStore{}
+			defer ds.AssertExpectations(t)
+			ds.On("GetSettings", contextMatcher).
+				Return(model.Settings{
+					ConnectionString:         newConnectionString,
+					PreviousConnectionString: oldConnectionString,
+					PreviousExpiresAt:        &tc.ExpiresAt,
+				}, nil)
+			ds.On("IncrementUsage",
+				contextMatcher,
+				mock.AnythingOfType("string"),
+				model.UsageAzureOperations,
+				int64(1),
+			).Return(nil)
+
+			client := &iothubMocks.Client{}
+			defer client.AssertExpectations(t)
+			client.On("GetDeviceStatistics", contextMatcher, keyMatcher("new")).
+				Return(nil, &iothub.Error{Code: 401})
+			client.On("GetDeviceStatistics", contextMatcher, keyMatcher("old")).
+				Return(&iothub.RegistryStatistics{}, nil).
+				Maybe()
+			client.On("GetServiceStatistics", contextMatcher, keyMatcher("new")).
+				Return(nil, &iothub.Error{Code: 401}).
+				Maybe()
+			client.On("GetServiceStatistics", contextMatcher, keyMatcher("old")).
+				Return(&iothub.ServiceStatistics{}, nil).
+				Maybe()
+			app := New(Config{IoTHub: client, Clock: clock.NewFake(now)}, ds)
+
+			report := app.CheckIntegration(context.Background())
+			statuses := make([]string, len(report.Checks))
+			for i, check := range report.Checks {
+				statuses[i] = check.Status
+			}
+			assert.Equal(t, tc.Statuses, statuses)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+// RecordUsage increments the usage counter of the tenant in the context
+// for the current billing period
+func (a *app) RecordUsage(ctx context.Context, counter string) error {
+	period := model.UsagePeriod(a.Clock.Now())
+	return a.store.IncrementUsage(ctx, period, counter, 1)
+}
+
+// GetUsage returns the usage of all the tenants during the billing period,
+// the current one if period is empty
+func (a *app) GetUsage(ctx context.Context, period string) ([]model.Usage, error) {
+	if period == "" {
+		period = model.UsagePeriod(a.Clock.Now())
+	}
+	return a.store.ListUsage(ctx, period)
+}
+
+// meterAzureOperation records a request to the Azure IoT Hub on behalf of
+// the tenant; metering is best effort and never fails the operation.
+func (a *app) meterAzureOperation(ctx context.Context) {
+	err := a.RecordUsage(ctx, model.UsageAzureOperations)
+	if err != nil {
+		log.FromContext(ctx).
+			Warnf("failed to record Azure operation usage: %s", err)
+	}
+}
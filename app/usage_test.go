@@ -0,0 +1,78 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/azure-iot-manager/clock"
+	"github.com/mendersoftware/azure-iot-manager/model"
+	storeMocks "github.com/mendersoftware/azure-iot-manager/store/mocks"
+)
+
+func TestRecordUsage(t *testing.T) {
+	ds := &storeMocks.DataStore{}
+	defer ds.AssertExpectations(t)
+	ds.On("IncrementUsage",
+		mock.Anything,
+		"2021-10",
+		model.UsageAPICalls,
+		int64(1),
+	).Return(nil).Once()
+	ds.On("IncrementUsage",
+		mock.Anything,
+		"2021-11",
+		model.UsageAPICalls,
+		int64(1),
+	).Return(errors.New("mongo error")).Once()
+
+	clk := clock.NewFake(time.Date(2021, 10, 31, 23, 0, 0, 0, time.UTC))
+	app := New(Config{Clock: clk}, ds)
+
+	err := app.RecordUsage(context.Background(), model.UsageAPICalls)
+	assert.NoError(t, err)
+
+	clk.Advance(time.Hour)
+	err = app.RecordUsage(context.Background(), model.UsageAPICalls)
+	assert.EqualError(t, err, "mongo error")
+}
+
+func TestGetUsage(t *testing.T) {
+	usage := []model.Usage{{
+		TenantID: "tenant",
+		Period:   "2021-10",
+		APICalls: 10,
+	}}
+	ds := &storeMocks.DataStore{}
+	defer ds.AssertExpectations(t)
+	ds.On("ListUsage", mock.Anything, "2021-10").Return(usage, nil)
+
+	res, err := New(Config{}, ds).GetUsage(context.Background(), "2021-10")
+	assert.NoError(t, err)
+	assert.Equal(t, usage, res)
+
+	// the current period by default
+	clk := clock.NewFake(time.Date(2021, 11, 30, 23, 0, 0, 0, time.UTC))
+	ds.On("ListUsage", mock.Anything, "2021-11").Return(usage, nil).Once()
+	res, err = New(Config{Clock: clk}, ds).GetUsage(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, usage, res)
+}
@@ -0,0 +1,245 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package bundle exports and imports the data of a tenant as a portable
+// JSON bundle, for migrating tenants between environments and for
+// disaster-recovery drills.
+package bundle
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/azure-iot-manager/app"
+	"github.com/mendersoftware/azure-iot-manager/clock"
+	"github.com/mendersoftware/azure-iot-manager/model"
+	"github.com/mendersoftware/azure-iot-manager/store"
+)
+
+const (
+	// Version is the version of the bundle format
+	Version = 1
+
+	keyIterations = 100000
+	keyLength     = 32
+	saltLength    = 16
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("bundle: unsupported version")
+	ErrEmptyPassphrase    = errors.New("bundle: empty passphrase")
+	ErrDecrypt            = errors.New("bundle: failed to decrypt secret, " +
+		"wrong passphrase or corrupted bundle")
+)
+
+// Bundle is the exported data of a tenant
+type Bundle struct {
+	Version    int       `json:"version"`
+	TenantID   string    `json:"tenant_id"`
+	ExportedAt time.Time `json:"exported_at"`
+
+	// Integration is the integration with the Azure IoT Hub, nil if the
+	// tenant has none
+	Integration *Integration `json:"integration,omitempty"`
+	// FeatureFlags are the feature flag overrides of the tenant
+	FeatureFlags map[string]bool `json:"feature_flags,omitempty"`
+}
+
+// Integration is the exported integration with the Azure IoT Hub
+type Integration struct {
+	// ConnectionString is encrypted with the passphrase of the bundle
+	ConnectionString Secret `json:"connection_string"`
+}
+
+// Secret is a value encrypted with AES-256-GCM, using a key derived from
+// the passphrase of the bundle with PBKDF2-SHA256
+type Secret struct {
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func newGCM(passphrase, salt []byte) (cipher.AEAD, error) {
+	key := pbkdf2.Key(passphrase, salt, keyIterations, keyLength, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt encrypts the value with the passphrase
+func Encrypt(value string, passphrase []byte) (Secret, error) {
+	if len(passphrase) == 0 {
+		return Secret{}, ErrEmptyPassphrase
+	}
+	salt := make([]byte, saltLength)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return Secret{}, err
+	}
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return Secret{}, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return Secret{}, err
+	}
+	return Secret{
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, []byte(value), nil),
+	}, nil
+}
+
+// Decrypt decrypts the secret with the passphrase
+func (s Secret) Decrypt(passphrase []byte) (string, error) {
+	if len(passphrase) == 0 {
+		return "", ErrEmptyPassphrase
+	}
+	gcm, err := newGCM(passphrase, s.Salt)
+	if err != nil {
+		return "", err
+	}
+	if len(s.Nonce) != gcm.NonceSize() {
+		return "", ErrDecrypt
+	}
+	value, err := gcm.Open(nil, s.Nonce, s.Ciphertext, nil)
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(value), nil
+}
+
+// Export returns the bundle of the data of the tenant, encrypting the
+// secrets with the passphrase and timestamped with clk. The connection
+// string replaced by the last rotation and the overrides of the feature
+// flags no longer registered are not exported.
+func Export(
+	ctx context.Context,
+	ds store.DataStore,
+	clk clock.Clock,
+	tenantID string,
+	passphrase []byte,
+) (*Bundle, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrEmptyPassphrase
+	}
+	ctx = identity.WithContext(ctx, &identity.Identity{Tenant: tenantID})
+	bundle := &Bundle{
+		Version:    Version,
+		TenantID:   tenantID,
+		ExportedAt: clk.Now().UTC(),
+	}
+	settings, err := ds.GetSettings(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to export the settings")
+	}
+	if settings.ConnectionString != "" {
+		secret, err := Encrypt(settings.ConnectionString, passphrase)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to encrypt the connection string")
+		}
+		bundle.Integration = &Integration{ConnectionString: secret}
+	}
+	flags, err := ds.GetFeatureFlags(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to export the feature flags")
+	}
+	for name, enabled := range flags {
+		if !knownFeature(name) {
+			continue
+		}
+		if bundle.FeatureFlags == nil {
+			bundle.FeatureFlags = make(map[string]bool)
+		}
+		bundle.FeatureFlags[name] = enabled
+	}
+	return bundle, nil
+}
+
+// Import restores the data of the bundle into the tenant, or into the
+// tenant of the bundle if tenantID is empty, decrypting the secrets with
+// the passphrase. The integration of the tenant is replaced, or removed if
+// the bundle has none, and the feature flag overrides which are not part
+// of the bundle are removed. Bundles with unknown feature flags are
+// rejected before any data is written.
+func Import(
+	ctx context.Context,
+	ds store.DataStore,
+	bundle *Bundle,
+	tenantID string,
+	passphrase []byte,
+) error {
+	if bundle.Version != Version {
+		return errors.Wrapf(ErrUnsupportedVersion, "%d", bundle.Version)
+	}
+	for name := range bundle.FeatureFlags {
+		if !knownFeature(name) {
+			return errors.Wrap(app.ErrUnknownFeature, name)
+		}
+	}
+	if tenantID == "" {
+		tenantID = bundle.TenantID
+	}
+	ctx = identity.WithContext(ctx, &identity.Identity{Tenant: tenantID})
+	var settings model.Settings
+	if bundle.Integration != nil {
+		connStr, err := bundle.Integration.ConnectionString.Decrypt(passphrase)
+		if err != nil {
+			return err
+		}
+		settings.ConnectionString = connStr
+	}
+	if err := ds.SetSettings(ctx, settings); err != nil {
+		return errors.Wrap(err, "failed to import the settings")
+	}
+	current, err := ds.GetFeatureFlags(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to import the feature flags")
+	}
+	for name := range current {
+		if _, ok := bundle.FeatureFlags[name]; ok {
+			continue
+		}
+		if err := ds.DeleteFeatureFlag(ctx, name); err != nil {
+			return errors.Wrap(err, "failed to import the feature flags")
+		}
+	}
+	for name, enabled := range bundle.FeatureFlags {
+		if err := ds.SetFeatureFlag(ctx, name, enabled); err != nil {
+			return errors.Wrap(err, "failed to import the feature flags")
+		}
+	}
+	return nil
+}
+
+func knownFeature(name string) bool {
+	for _, feature := range app.Features {
+		if feature.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package bundle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/azure-iot-manager/app"
+	"github.com/mendersoftware/azure-iot-manager/clock"
+	"github.com/mendersoftware/azure-iot-manager/model"
+	storeMocks "github.com/mendersoftware/azure-iot-manager/store/mocks"
+)
+
+var errInternal = errors.New("internal error")
+
+const connStr = "HostName=hub.azure-devices.net;" +
+	"SharedAccessKeyName=registryReadWrite;SharedAccessKey=c2VjcmV0"
+
+func tenantMatcher(tenantID string) interface{} {
+	return mock.MatchedBy(func(ctx context.Context) bool {
+		id := identity.FromContext(ctx)
+		return id != nil && id.Tenant == tenantID
+	})
+}
+
+func TestSecret(t *testing.T) {
+	passphrase := []byte("correct horse battery staple")
+	secret, err := Encrypt(connStr, passphrase)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.NotContains(t, string(secret.Ciphertext), "SharedAccessKey")
+
+	other, err := Encrypt(connStr, passphrase)
+	assert.NoError(t, err)
+	assert.NotEqual(t, secret, other, "the salt and nonce are not random")
+
+	value, err := secret.Decrypt(passphrase)
+	assert.NoError(t, err)
+	assert.Equal(t, connStr, value)
+
+	_, err = secret.Decrypt([]byte("wrong"))
+	assert.ErrorIs(t, err, ErrDecrypt)
+
+	_, err = secret.Decrypt(nil)
+	assert.ErrorIs(t, err, ErrEmptyPassphrase)
+
+	_, err = Encrypt(connStr, nil)
+	assert.ErrorIs(t, err, ErrEmptyPassphrase)
+}
+
+// registerFeatures registers the feature flags for the duration of the
+// test
+func registerFeatures(t *testing.T, features ...app.Feature) {
+	registered := app.Features
+	app.Features = features
+	t.Cleanup(func() {
+		app.Features = registered
+	})
+}
+
+func TestExportImport(t *testing.T) {
+	registerFeatures(t, app.Feature{Name: "test_feature"})
+	passphrase := []byte("passphrase")
+	flags := map[string]bool{"test_feature": false}
+
+	ds := &storeMocks.DataStore{}
+	defer ds.AssertExpectations(t)
+	ds.On("GetSettings", tenantMatcher("tenant1")).
+		Return(model.Settings{ConnectionString: connStr}, nil).Once()
+	// the overrides of the flags no longer registered are not exported
+	ds.On("GetFeatureFlags", tenantMatcher("tenant1")).
+		Return(map[string]bool{"test_feature": false, "removed_feature": true}, nil).
+		Once()
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	bundle, err := Export(context.Background(), ds, clock.NewFake(now), "tenant1", passphrase)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, Version, bundle.Version)
+	assert.Equal(t, "tenant1", bundle.TenantID)
+	assert.Equal(t, now, bundle.ExportedAt)
+	assert.Equal(t, flags, bundle.FeatureFlags)
+	if assert.NotNil(t, bundle.Integration) {
+		assert.NotEmpty(t, bundle.Integration.ConnectionString.Ciphertext)
+	}
+
+	// import into another tenant, dropping the flags missing in the bundle
+	ds.On("SetSettings", tenantMatcher("tenant2"),
+		model.Settings{ConnectionString: connStr}).
+		Return(nil).Once()
+	ds.On("GetFeatureFlags", tenantMatcher("tenant2")).
+		Return(map[string]bool{"removed_feature": false}, nil).Once()
+	ds.On("DeleteFeatureFlag", tenantMatcher("tenant2"), "removed_feature").
+		Return(nil).Once()
+	ds.On("SetFeatureFlag", tenantMatcher("tenant2"), "test_feature", false).
+		Return(nil).Once()
+	err = Import(context.Background(), ds, bundle, "tenant2", passphrase)
+	assert.NoError(t, err)
+}
+
+func TestImportNoIntegration(t *testing.T) {
+	ds := &storeMocks.DataStore{}
+	defer ds.AssertExpectations(t)
+	// the integration of the tenant is removed
+	ds.On("SetSettings", tenantMatcher("tenant1"), model.Settings{}).
+		Return(nil).Once()
+	ds.On("GetFeatureFlags", tenantMatcher("tenant1")).
+		Return(map[string]bool{}, nil).Once()
+	err := Import(context.Background(), ds, &Bundle{
+		Version:  Version,
+		TenantID: "tenant1",
+	}, "", []byte("passphrase"))
+	assert.NoError(t, err)
+}
+
+func TestExportError(t *testing.T) {
+	ds := &storeMocks.DataStore{}
+	defer ds.AssertExpectations(t)
+	_, err := Export(context.Background(), ds, clock.New(), "tenant1", nil)
+	assert.ErrorIs(t, err, ErrEmptyPassphrase)
+
+	ds.On("GetSettings", mock.Anything).
+		Return(model.Settings{}, errors.New("internal error"))
+	_, err = Export(context.Background(), ds, clock.New(), "tenant1", []byte("passphrase"))
+	assert.EqualError(t, err, "failed to export the settings: internal error")
+}
+
+func TestImportError(t *testing.T) {
+	passphrase := []byte("passphrase")
+	secret, err := Encrypt(connStr, passphrase)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	testCases := []struct {
+		Name string
+
+		Bundle     Bundle
+		Passphrase []byte
+		Store      func(ds *storeMocks.DataStore)
+
+		Error error
+	}{{
+		Name: "error, unsupported version",
+
+		Bundle:     Bundle{Version: 2, TenantID: "tenant1"},
+		Passphrase: passphrase,
+
+		Error: ErrUnsupportedVersion,
+	}, {
+		Name: "error, wrong passphrase",
+
+		Bundle: Bundle{
+			Version:     Version,
+			TenantID:    "tenant1",
+			Integration: &Integration{ConnectionString: secret},
+		},
+		Passphrase: []byte("wrong"),
+
+		Error: ErrDecrypt,
+	}, {
+		Name: "error, unknown feature flag",
+
+		Bundle: Bundle{
+			Version:      Version,
+			TenantID:     "tenant1",
+			Integration:  &Integration{ConnectionString: secret},
+			FeatureFlags: map[string]bool{"$where": true},
+		},
+		Passphrase: passphrase,
+
+		Error: app.ErrUnknownFeature,
+	}, {
+		Name: "error, store",
+
+		Bundle: Bundle{
+			Version:     Version,
+			TenantID:    "tenant1",
+			Integration: &Integration{ConnectionString: secret},
+		},
+		Passphrase: passphrase,
+		Store: func(ds *storeMocks.DataStore) {
+			ds.On("SetSettings", tenantMatcher("tenant1"), mock.Anything).
+				Return(errInternal)
+		},
+
+		Error: errInternal,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			ds := &storeMocks.DataStore{}
+			defer ds.AssertExpectations(t)
+			if tc.Store != nil {
+				tc.Store(ds)
+			}
+			err := Import(context.Background(), ds, &tc.Bundle, "", tc.Passphrase)
+			assert.ErrorIs(t, err, tc.Error)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package auditlogs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/requestid"
+
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+const (
+	URILogs = "/api/internal/v1/auditlogs/tenants/:tenant_id/logs"
+
+	defaultTimeout = 10 * time.Second
+)
+
+// Client is the auditlogs service client
+//nolint:lll
+//go:generate ../../utils/mockgen.sh
+type Client interface {
+	SubmitAuditLog(ctx context.Context, log model.AuditLog) error
+}
+
+type client struct {
+	client *http.Client
+	uri    string
+}
+
+// NewClient returns a new auditlogs client for the service at url; if
+// httpClient is nil, a default client is used
+func NewClient(url string, httpClient *http.Client) Client {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	return &client{
+		client: httpClient,
+		uri:    strings.TrimRight(url, "/"),
+	}
+}
+
+type auditLog struct {
+	Actor  model.AuditActor  `json:"actor"`
+	Action string            `json:"action"`
+	Object model.AuditObject `json:"object"`
+	Change string            `json:"change,omitempty"`
+	Time   time.Time         `json:"time"`
+}
+
+// SubmitAuditLog submits the audit log to the auditlogs service
+func (c *client) SubmitAuditLog(ctx context.Context, log model.AuditLog) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+	b, _ := json.Marshal(auditLog{
+		Actor:  log.Actor,
+		Action: log.Action,
+		Object: log.Object,
+		Change: log.Outcome,
+		Time:   log.Time,
+	})
+	url := c.uri + strings.Replace(URILogs, ":tenant_id", log.TenantID, 1)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "auditlogs: failed to prepare request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if reqID := requestid.FromContext(ctx); reqID != "" {
+		req.Header.Set(requestid.RequestIdHeader, reqID)
+	}
+
+	rsp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "auditlogs: failed to submit audit log")
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode >= 300 {
+		return errors.Errorf(
+			"auditlogs: unexpected HTTP status from auditlogs service: %s",
+			rsp.Status,
+		)
+	}
+	return nil
+}
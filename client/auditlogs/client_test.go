@@ -0,0 +1,111 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package auditlogs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/go-lib-micro/requestid"
+
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+func TestSubmitAuditLog(t *testing.T) {
+	log := model.AuditLog{
+		ID:       uuid.New(),
+		TenantID: "123456789012345678901234",
+		Actor: model.AuditActor{
+			ID:   "829cbefb-70e7-438f-9ac5-35fd131c2111",
+			Type: model.AuditActorTypeUser,
+		},
+		Action:  model.AuditActionUpdate,
+		Object:  model.AuditObject{Type: "settings"},
+		Outcome: model.AuditOutcomeSuccess,
+		Time:    time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC),
+	}
+	testCases := []struct {
+		Name string
+
+		Status int
+		Error  string
+	}{
+		{
+			Name:   "ok",
+			Status: http.StatusNoContent,
+		},
+		{
+			Name:   "error, unexpected status",
+			Status: http.StatusInternalServerError,
+			Error: "auditlogs: unexpected HTTP status from auditlogs " +
+				"service: 500 Internal Server Error",
+		},
+	}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t, http.MethodPost, r.Method)
+					assert.Equal(t,
+						"/api/internal/v1/auditlogs/tenants/"+
+							log.TenantID+"/logs",
+						r.URL.Path,
+					)
+					assert.Equal(t, "test", r.Header.Get(requestid.RequestIdHeader))
+					var body map[string]interface{}
+					err := json.NewDecoder(r.Body).Decode(&body)
+					assert.NoError(t, err)
+					assert.Equal(t, map[string]interface{}{
+						"actor": map[string]interface{}{
+							"id":   log.Actor.ID,
+							"type": log.Actor.Type,
+						},
+						"action": log.Action,
+						"object": map[string]interface{}{
+							"type": "settings",
+						},
+						"change": log.Outcome,
+						"time":   "2021-10-01T12:00:00Z",
+					}, body)
+					w.WriteHeader(tc.Status)
+				},
+			))
+			defer srv.Close()
+
+			ctx := requestid.WithContext(context.Background(), "test")
+			err := NewClient(srv.URL+"/", srv.Client()).SubmitAuditLog(ctx, log)
+			if tc.Error != "" {
+				assert.EqualError(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSubmitAuditLogUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	err := NewClient(srv.URL, nil).SubmitAuditLog(context.Background(), model.AuditLog{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,211 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package iothub
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/requestid"
+
+	"github.com/mendersoftware/azure-iot-manager/clock"
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+const (
+	URIDeviceStatistics  = "/statistics/devices"
+	URIServiceStatistics = "/statistics/service"
+
+	APIVersion = "2021-04-12"
+
+	// OperationRegistry is the class of the operations on the device
+	// identity registry
+	OperationRegistry = "registry"
+	// OperationService is the class of the operations on the IoT Hub
+	// service
+	OperationService = "service"
+
+	// hdrClientRequestID is the Azure header correlating the requests
+	// with the logs of the IoT Hub
+	hdrClientRequestID = "x-ms-client-request-id"
+
+	defaultTimeout = 10 * time.Second
+	tokenLifetime  = time.Hour
+)
+
+// RegistryStatistics contains the device count of the identity registry
+type RegistryStatistics struct {
+	TotalDeviceCount    int `json:"totalDeviceCount"`
+	EnabledDeviceCount  int `json:"enabledDeviceCount"`
+	DisabledDeviceCount int `json:"disabledDeviceCount"`
+}
+
+// ServiceStatistics contains the IoT Hub service statistics
+type ServiceStatistics struct {
+	ConnectedDeviceCount int `json:"connectedDeviceCount"`
+}
+
+// Client is the Azure IoT Hub service API client
+//nolint:lll
+//go:generate ../../utils/mockgen.sh
+type Client interface {
+	GetDeviceStatistics(ctx context.Context, cs *model.ConnectionString) (*RegistryStatistics, error)
+	GetServiceStatistics(ctx context.Context, cs *model.ConnectionString) (*ServiceStatistics, error)
+}
+
+// Options contains the optional parameters of the client
+type Options struct {
+	// Timeouts are the deadlines of the requests by operation class; the
+	// classes without a timeout use a 10 seconds deadline. The deadline
+	// of the request context, if any, takes precedence.
+	Timeouts map[string]time.Duration
+}
+
+// NewOptions returns a new Options
+func NewOptions() *Options {
+	return &Options{Timeouts: map[string]time.Duration{}}
+}
+
+// SetTimeout sets the deadline of the requests of the operation class
+func (o *Options) SetTimeout(operation string, timeout time.Duration) *Options {
+	if o.Timeouts == nil {
+		o.Timeouts = map[string]time.Duration{}
+	}
+	o.Timeouts[operation] = timeout
+	return o
+}
+
+func mergeOptions(opts []*Options) *Options {
+	opt := NewOptions()
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		for operation, timeout := range o.Timeouts {
+			if timeout > 0 {
+				opt.SetTimeout(operation, timeout)
+			}
+		}
+	}
+	return opt
+}
+
+type client struct {
+	client   *http.Client
+	clock    clock.Clock
+	timeouts map[string]time.Duration
+}
+
+// NewClient returns a new IoT Hub client; if httpClient is nil, the
+// default HTTP client is used.
+func NewClient(httpClient *http.Client, opts ...*Options) Client {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	return &client{
+		client:   httpClient,
+		clock:    clock.New(),
+		timeouts: mergeOptions(opts).Timeouts,
+	}
+}
+
+// timeout returns the deadline of the requests of the operation class
+func (c *client) timeout(operation string) time.Duration {
+	if timeout, ok := c.timeouts[operation]; ok {
+		return timeout
+	}
+	return defaultTimeout
+}
+
+func (c *client) do(
+	ctx context.Context,
+	cs *model.ConnectionString,
+	operation string,
+	method, path string,
+	v interface{},
+) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout(operation))
+		defer cancel()
+	}
+	url := "https://" + cs.HostName + path + "?api-version=" + APIVersion
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return errors.Wrap(err, "iothub: failed to prepare request")
+	}
+	req.Header.Set("Authorization", cs.Authorization(c.clock.Now().Add(tokenLifetime)))
+	if reqID := requestid.FromContext(ctx); reqID != "" {
+		req.Header.Set(requestid.RequestIdHeader, reqID)
+		req.Header.Set(hdrClientRequestID, reqID)
+	}
+
+	start := c.clock.Now()
+	rsp, err := c.client.Do(req)
+	CallStatsFromContext(ctx).Add(c.clock.Now().Sub(start))
+	if err != nil {
+		return errors.Wrap(err, "iothub: failed to execute request")
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode >= 300 {
+		err := newError(rsp, c.clock.Now())
+		log.FromContext(ctx).F(log.Ctx{
+			"iothub_errorcode":   err.ErrorCode,
+			"iothub_tracking_id": err.TrackingID,
+		}).Warnf("iothub: %s %s: %d", method, path, err.Code)
+		return err
+	}
+	if v != nil {
+		if err := json.NewDecoder(rsp.Body).Decode(v); err != nil {
+			return errors.Wrap(err, "iothub: failed to decode response")
+		}
+	}
+	return nil
+}
+
+// GetDeviceStatistics returns the statistics of the device identity
+// registry; it requires the RegistryRead permission.
+func (c *client) GetDeviceStatistics(
+	ctx context.Context,
+	cs *model.ConnectionString,
+) (*RegistryStatistics, error) {
+	stats := new(RegistryStatistics)
+	err := c.do(ctx, cs, OperationRegistry,
+		http.MethodGet, URIDeviceStatistics, stats)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// GetServiceStatistics returns the service statistics of the IoT Hub; it
+// requires the ServiceConnect permission.
+func (c *client) GetServiceStatistics(
+	ctx context.Context,
+	cs *model.ConnectionString,
+) (*ServiceStatistics, error) {
+	stats := new(ServiceStatistics)
+	err := c.do(ctx, cs, OperationService,
+		http.MethodGet, URIServiceStatistics, stats)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
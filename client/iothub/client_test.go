@@ -0,0 +1,264 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package iothub
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/requestid"
+
+	"github.com/mendersoftware/azure-iot-manager/clock"
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+func newTestServer(
+	t *testing.T,
+	path string,
+	status int,
+	body string,
+) (*httptest.Server, *model.ConnectionString) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodGet, r.Method)
+			assert.Equal(t, path, r.URL.Path)
+			assert.Equal(t, APIVersion, r.URL.Query().Get("api-version"))
+			assert.True(t, strings.HasPrefix(
+				r.Header.Get("Authorization"), "SharedAccessSignature ",
+			))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(body))
+		},
+	))
+	cs := &model.ConnectionString{
+		HostName: srv.Listener.Addr().String(),
+		Name:     "iothubowner",
+		Key:      []byte("secret"),
+	}
+	return srv, cs
+}
+
+func TestGetDeviceStatistics(t *testing.T) {
+	testCases := []struct {
+		Name string
+
+		Status int
+		Body   string
+
+		Result *RegistryStatistics
+		Error  string
+	}{
+		{
+			Name:   "ok",
+			Status: http.StatusOK,
+			Body: `{"totalDeviceCount": 3, "enabledDeviceCount": 2, ` +
+				`"disabledDeviceCount": 1}`,
+
+			Result: &RegistryStatistics{
+				TotalDeviceCount:    3,
+				EnabledDeviceCount:  2,
+				DisabledDeviceCount: 1,
+			},
+		},
+		{
+			Name:   "error, unauthorized",
+			Status: http.StatusUnauthorized,
+			Body:   `{"Message": "ErrorCode:IotHubUnauthorizedAccess;Unauthorized"}`,
+
+			Error: "iothub: unexpected HTTP status 401 Unauthorized: " +
+				"ErrorCode:IotHubUnauthorizedAccess;Unauthorized",
+		},
+		{
+			Name:   "error, malformed response",
+			Status: http.StatusOK,
+			Body:   `[]`,
+
+			Error: "iothub: failed to decode response: .*",
+		},
+	}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			srv, cs := newTestServer(t, URIDeviceStatistics, tc.Status, tc.Body)
+			defer srv.Close()
+
+			stats, err := NewClient(srv.Client()).
+				GetDeviceStatistics(context.Background(), cs)
+			if tc.Error != "" {
+				if assert.Error(t, err) {
+					assert.Regexp(t, tc.Error, err.Error())
+				}
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.Result, stats)
+			}
+		})
+	}
+}
+
+func TestGetServiceStatistics(t *testing.T) {
+	srv, cs := newTestServer(t, URIServiceStatistics,
+		http.StatusOK, `{"connectedDeviceCount": 5}`)
+	defer srv.Close()
+
+	stats, err := NewClient(srv.Client()).
+		GetServiceStatistics(context.Background(), cs)
+	assert.NoError(t, err)
+	assert.Equal(t, &ServiceStatistics{ConnectedDeviceCount: 5}, stats)
+
+	srv, cs = newTestServer(t, URIServiceStatistics, http.StatusForbidden, "")
+	defer srv.Close()
+
+	_, err = NewClient(srv.Client()).
+		GetServiceStatistics(context.Background(), cs)
+	var iotErr *Error
+	if assert.ErrorAs(t, err, &iotErr) {
+		assert.Equal(t, http.StatusForbidden, iotErr.Code)
+	}
+}
+
+func TestClientUnreachable(t *testing.T) {
+	srv, cs := newTestServer(t, URIDeviceStatistics, http.StatusOK, "{}")
+	srv.Close()
+
+	_, err := NewClient(nil).GetDeviceStatistics(context.Background(), cs)
+	assert.Error(t, err)
+}
+
+func TestClientTokenExpiry(t *testing.T) {
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	var authorization string
+	srv := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			authorization = r.Header.Get("Authorization")
+			_, _ = w.Write([]byte(`{"connectedDeviceCount": 0}`))
+		},
+	))
+	defer srv.Close()
+	cs := &model.ConnectionString{
+		HostName: srv.Listener.Addr().String(),
+		Name:     "iothubowner",
+		Key:      []byte("secret"),
+	}
+
+	c := NewClient(srv.Client())
+	c.(*client).clock = clock.NewFake(now)
+	_, err := c.GetServiceStatistics(context.Background(), cs)
+	assert.NoError(t, err)
+	assert.Equal(t, cs.Authorization(now.Add(tokenLifetime)), authorization)
+}
+
+func TestClientTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	srv := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-unblock:
+			case <-r.Context().Done():
+			}
+		},
+	))
+	defer srv.Close()
+	defer close(unblock)
+	cs := &model.ConnectionString{
+		HostName: srv.Listener.Addr().String(),
+		Name:     "iothubowner",
+		Key:      []byte("secret"),
+	}
+
+	c := NewClient(srv.Client(),
+		NewOptions().SetTimeout(OperationRegistry, 10*time.Millisecond),
+		NewOptions().SetTimeout(OperationService, 0),
+	)
+	assert.Equal(t, 10*time.Millisecond, c.(*client).timeout(OperationRegistry))
+	assert.Equal(t, defaultTimeout, c.(*client).timeout(OperationService))
+
+	start := time.Now()
+	_, err := c.GetDeviceStatistics(context.Background(), cs)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, int64(time.Since(start)), int64(defaultTimeout))
+
+	// the deadline of the request context takes precedence
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = c.GetServiceStatistics(ctx, cs)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestClientRequestID(t *testing.T) {
+	const reqID = "eed14d55-d996-42cd-8248-e806663810a8"
+	var header http.Header
+	srv := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+			w.Header().Set(hdrErrorCode, "IotHubUnauthorizedAccess")
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"Message": "ErrorCode:IotHubUnauthorizedAccess;` +
+				`Unauthorized","ExceptionMessage":"Tracking ID:abc123"}`))
+		},
+	))
+	defer srv.Close()
+	cs := &model.ConnectionString{
+		HostName: srv.Listener.Addr().String(),
+		Name:     "iothubowner",
+		Key:      []byte("secret"),
+	}
+
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buf)
+	ctx := requestid.WithContext(context.Background(), reqID)
+	ctx = log.WithContext(ctx, log.NewFromLogger(logger, log.Ctx{"request_id": reqID}))
+
+	_, err := NewClient(srv.Client()).GetDeviceStatistics(ctx, cs)
+	assert.Error(t, err)
+	assert.Equal(t, reqID, header.Get(requestid.RequestIdHeader))
+	assert.Equal(t, reqID, header.Get(hdrClientRequestID))
+
+	output := buf.String()
+	assert.Contains(t, output, "request_id="+reqID)
+	assert.Contains(t, output, "iothub_errorcode=IotHubUnauthorizedAccess")
+	assert.Contains(t, output, "iothub_tracking_id=abc123")
+}
+
+func TestClientCallStats(t *testing.T) {
+	srv, cs := newTestServer(t, URIServiceStatistics,
+		http.StatusOK, `{"connectedDeviceCount": 1}`)
+	defer srv.Close()
+
+	c := NewClient(srv.Client())
+	_, err := c.GetServiceStatistics(context.Background(), cs)
+	assert.NoError(t, err)
+
+	ctx, stats := WithCallStats(context.Background())
+	_, err = c.GetServiceStatistics(ctx, cs)
+	assert.NoError(t, err)
+	_, err = c.GetServiceStatistics(ctx, cs)
+	assert.NoError(t, err)
+	calls, latency := stats.Get()
+	assert.Equal(t, 2, calls)
+	assert.Greater(t, int64(latency), int64(0))
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package iothub
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+// EmulatedDevice is a device in the identity registry of the emulator
+type EmulatedDevice struct {
+	DeviceID  string
+	Disabled  bool
+	Connected bool
+}
+
+// Emulator is an in-memory IoT Hub implementing the Client interface, for
+// running integration tests and demos without an Azure subscription. Each
+// host name in the connection strings has its own identity registry,
+// created on first use.
+type Emulator struct {
+	mu   sync.Mutex
+	hubs map[string]map[string]EmulatedDevice
+}
+
+// NewEmulator returns a new IoT Hub emulator with empty registries
+func NewEmulator() *Emulator {
+	return &Emulator{
+		hubs: make(map[string]map[string]EmulatedDevice),
+	}
+}
+
+func (e *Emulator) registry(hostName string) map[string]EmulatedDevice {
+	registry, ok := e.hubs[hostName]
+	if !ok {
+		registry = make(map[string]EmulatedDevice)
+		e.hubs[hostName] = registry
+	}
+	return registry
+}
+
+// SetDevice adds or replaces a device in the registry of the hub
+func (e *Emulator) SetDevice(hostName string, device EmulatedDevice) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.registry(hostName)[device.DeviceID] = device
+}
+
+// DeleteDevice removes a device from the registry of the hub
+func (e *Emulator) DeleteDevice(hostName, deviceID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.registry(hostName), deviceID)
+}
+
+// authorize rejects device connection strings, which are not allowed to
+// use the service APIs, like the IoT Hub does.
+func (e *Emulator) authorize(cs *model.ConnectionString) error {
+	if cs.DeviceID != "" {
+		return &Error{
+			Code:    http.StatusUnauthorized,
+			Message: "device credentials are not allowed on service APIs",
+		}
+	}
+	return nil
+}
+
+// GetDeviceStatistics returns the statistics of the emulated registry
+func (e *Emulator) GetDeviceStatistics(
+	ctx context.Context,
+	cs *model.ConnectionString,
+) (*RegistryStatistics, error) {
+	if err := e.authorize(cs); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	stats := new(RegistryStatistics)
+	for _, device := range e.registry(cs.HostName) {
+		stats.TotalDeviceCount++
+		if device.Disabled {
+			stats.DisabledDeviceCount++
+		} else {
+			stats.EnabledDeviceCount++
+		}
+	}
+	return stats, nil
+}
+
+// GetServiceStatistics returns the service statistics of the emulated hub
+func (e *Emulator) GetServiceStatistics(
+	ctx context.Context,
+	cs *model.ConnectionString,
+) (*ServiceStatistics, error) {
+	if err := e.authorize(cs); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	stats := new(ServiceStatistics)
+	for _, device := range e.registry(cs.HostName) {
+		if device.Connected {
+			stats.ConnectedDeviceCount++
+		}
+	}
+	return stats, nil
+}
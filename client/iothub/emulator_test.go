@@ -0,0 +1,78 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package iothub
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+func TestEmulator(t *testing.T) {
+	var _ Client = NewEmulator()
+
+	ctx := context.Background()
+	hub := &model.ConnectionString{
+		HostName: "hub.azure-devices.net",
+		Name:     "iothubowner",
+		Key:      []byte("secret"),
+	}
+	other := &model.ConnectionString{
+		HostName: "other.azure-devices.net",
+		Name:     "iothubowner",
+		Key:      []byte("secret"),
+	}
+
+	emulator := NewEmulator()
+	emulator.SetDevice(hub.HostName, EmulatedDevice{
+		DeviceID:  "dev1",
+		Connected: true,
+	})
+	emulator.SetDevice(hub.HostName, EmulatedDevice{DeviceID: "dev2"})
+	emulator.SetDevice(hub.HostName, EmulatedDevice{
+		DeviceID: "dev3",
+		Disabled: true,
+	})
+	emulator.SetDevice(other.HostName, EmulatedDevice{DeviceID: "dev1"})
+	emulator.DeleteDevice(other.HostName, "dev1")
+
+	registry, err := emulator.GetDeviceStatistics(ctx, hub)
+	assert.NoError(t, err)
+	assert.Equal(t, &RegistryStatistics{
+		TotalDeviceCount:    3,
+		EnabledDeviceCount:  2,
+		DisabledDeviceCount: 1,
+	}, registry)
+	service, err := emulator.GetServiceStatistics(ctx, hub)
+	assert.NoError(t, err)
+	assert.Equal(t, &ServiceStatistics{ConnectedDeviceCount: 1}, service)
+
+	registry, err = emulator.GetDeviceStatistics(ctx, other)
+	assert.NoError(t, err)
+	assert.Equal(t, &RegistryStatistics{}, registry)
+
+	device := *hub
+	device.DeviceID = "dev1"
+	_, err = emulator.GetDeviceStatistics(ctx, &device)
+	if assert.Error(t, err) {
+		assert.Equal(t, http.StatusUnauthorized, err.(*Error).Code)
+	}
+	_, err = emulator.GetServiceStatistics(ctx, &device)
+	assert.Error(t, err)
+}
@@ -0,0 +1,143 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package iothub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	hdrErrorCode  = "iothub-errorcode"
+	hdrRetryAfter = "Retry-After"
+)
+
+var (
+	// ErrNotFound matches the errors of the requests on a device or
+	// module which does not exist
+	ErrNotFound = &Error{Code: http.StatusNotFound}
+	// ErrConflict matches the errors of the requests conflicting with the
+	// current state of the resource (e.g. ETag mismatch)
+	ErrConflict = &Error{Code: http.StatusConflict}
+	// ErrThrottled matches the errors of the requests throttled by the
+	// IoT Hub
+	ErrThrottled = &Error{Code: http.StatusTooManyRequests}
+	// ErrUnauthorized matches the errors of the requests whose shared
+	// access signature is rejected or lacks the required permissions
+	ErrUnauthorized = &Error{Code: http.StatusUnauthorized}
+
+	reErrorCode  = regexp.MustCompile(`ErrorCode:(\w+)`)
+	reTrackingID = regexp.MustCompile(`Tracking ID:(\S+?)(?:-TimeStamp:|$|\s)`)
+)
+
+// Error is returned when the IoT Hub responds with an unexpected status
+type Error struct {
+	Code    int
+	Message string
+	// ErrorCode is the IoT Hub error code, e.g. "DeviceNotFound"
+	ErrorCode string
+	// TrackingID identifies the failed request for Azure support
+	TrackingID string
+	// RetryAfter is the delay requested by the IoT Hub before retrying
+	RetryAfter time.Duration
+}
+
+func (err *Error) Error() string {
+	msg := fmt.Sprintf("iothub: unexpected HTTP status %d %s",
+		err.Code, http.StatusText(err.Code))
+	if err.Message != "" {
+		msg += ": " + err.Message
+	}
+	if err.TrackingID != "" {
+		msg += " (tracking ID: " + err.TrackingID + ")"
+	}
+	return msg
+}
+
+// Is matches ErrNotFound, ErrConflict, ErrThrottled and ErrUnauthorized by
+// status code; precondition failures (ETag mismatch) are reported as
+// conflicts and missing permissions as unauthorized.
+func (err *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok || t.Message != "" || t.ErrorCode != "" {
+		return false
+	}
+	code := err.Code
+	switch code {
+	case http.StatusPreconditionFailed:
+		code = http.StatusConflict
+	case http.StatusForbidden:
+		code = http.StatusUnauthorized
+	}
+	return code == t.Code
+}
+
+// newError decodes the error response of the IoT Hub. The error code and
+// tracking ID are either in the plain text message, or in the JSON
+// document embedded in the message by the most recent API versions.
+func newError(rsp *http.Response, now time.Time) *Error {
+	var body struct {
+		Message          string `json:"Message"`
+		ExceptionMessage string `json:"ExceptionMessage"`
+	}
+	_ = json.NewDecoder(rsp.Body).Decode(&body)
+	err := &Error{
+		Code:      rsp.StatusCode,
+		Message:   body.Message,
+		ErrorCode: rsp.Header.Get(hdrErrorCode),
+	}
+	var embedded struct {
+		ErrorCode  json.Number `json:"errorCode"`
+		TrackingID string      `json:"trackingId"`
+		Message    string      `json:"message"`
+	}
+	if strings.HasPrefix(body.Message, "{") &&
+		json.Unmarshal([]byte(body.Message), &embedded) == nil {
+		err.Message = embedded.Message
+		err.TrackingID = embedded.TrackingID
+		if err.ErrorCode == "" {
+			err.ErrorCode = embedded.ErrorCode.String()
+		}
+	}
+	if m := reErrorCode.FindStringSubmatch(body.Message); err.ErrorCode == "" && m != nil {
+		err.ErrorCode = m[1]
+	}
+	m := reTrackingID.FindStringSubmatch(body.ExceptionMessage)
+	if err.TrackingID == "" && m != nil {
+		err.TrackingID = m[1]
+	}
+	err.RetryAfter = parseRetryAfter(rsp.Header.Get(hdrRetryAfter), now)
+	return err
+}
+
+// parseRetryAfter parses the Retry-After header, either in seconds or as
+// an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,154 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package iothub
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewError(t *testing.T) {
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		Name string
+
+		Status int
+		Header http.Header
+		Body   string
+
+		Error  *Error
+		Target error
+		String string
+	}{{
+		Name: "device not found",
+
+		Status: http.StatusNotFound,
+		Header: http.Header{hdrErrorCode: []string{"DeviceNotFound"}},
+		Body: `{"Message":"ErrorCode:DeviceNotFound;E2E_test_device",` +
+			`"ExceptionMessage":"Tracking ID:0123abcd-G:5-TimeStamp:10/01/2021 12:00:00"}`,
+
+		Error: &Error{
+			Code:       http.StatusNotFound,
+			Message:    "ErrorCode:DeviceNotFound;E2E_test_device",
+			ErrorCode:  "DeviceNotFound",
+			TrackingID: "0123abcd-G:5",
+		},
+		Target: ErrNotFound,
+		String: "iothub: unexpected HTTP status 404 Not Found: " +
+			"ErrorCode:DeviceNotFound;E2E_test_device (tracking ID: 0123abcd-G:5)",
+	}, {
+		Name: "precondition failed, embedded JSON",
+
+		Status: http.StatusPreconditionFailed,
+		Body: `{"Message":"{\"errorCode\":412002,\"trackingId\":\"4567ef\",` +
+			`\"message\":\"ETag mismatch\",\"timestampUtc\":\"2021-10-01T12:00:00Z\"}"}`,
+
+		Error: &Error{
+			Code:       http.StatusPreconditionFailed,
+			Message:    "ETag mismatch",
+			ErrorCode:  "412002",
+			TrackingID: "4567ef",
+		},
+		Target: ErrConflict,
+		String: "iothub: unexpected HTTP status 412 Precondition Failed: " +
+			"ETag mismatch (tracking ID: 4567ef)",
+	}, {
+		Name: "throttled",
+
+		Status: http.StatusTooManyRequests,
+		Header: http.Header{hdrRetryAfter: []string{"30"}},
+		Body:   `{"Message":"ErrorCode:ThrottlingException;Throttled"}`,
+
+		Error: &Error{
+			Code:       http.StatusTooManyRequests,
+			Message:    "ErrorCode:ThrottlingException;Throttled",
+			ErrorCode:  "ThrottlingException",
+			RetryAfter: 30 * time.Second,
+		},
+		Target: ErrThrottled,
+		String: "iothub: unexpected HTTP status 429 Too Many Requests: " +
+			"ErrorCode:ThrottlingException;Throttled",
+	}, {
+		Name: "throttled, retry after date",
+
+		Status: http.StatusTooManyRequests,
+		Header: http.Header{hdrRetryAfter: []string{
+			now.Add(time.Minute).Format(http.TimeFormat),
+		}},
+
+		Error: &Error{
+			Code:       http.StatusTooManyRequests,
+			RetryAfter: time.Minute,
+		},
+		Target: ErrThrottled,
+		String: "iothub: unexpected HTTP status 429 Too Many Requests",
+	}, {
+		Name: "unauthorized",
+
+		Status: http.StatusUnauthorized,
+		Body:   `{"Message":"ErrorCode:IotHubUnauthorizedAccess;Unauthorized"}`,
+
+		Error: &Error{
+			Code:      http.StatusUnauthorized,
+			Message:   "ErrorCode:IotHubUnauthorizedAccess;Unauthorized",
+			ErrorCode: "IotHubUnauthorizedAccess",
+		},
+		Target: ErrUnauthorized,
+		String: "iothub: unexpected HTTP status 401 Unauthorized: " +
+			"ErrorCode:IotHubUnauthorizedAccess;Unauthorized",
+	}, {
+		Name: "forbidden",
+
+		Status: http.StatusForbidden,
+
+		Error:  &Error{Code: http.StatusForbidden},
+		Target: ErrUnauthorized,
+		String: "iothub: unexpected HTTP status 403 Forbidden",
+	}, {
+		Name: "no body",
+
+		Status: http.StatusInternalServerError,
+		Body:   "internal error",
+
+		Error:  &Error{Code: http.StatusInternalServerError},
+		String: "iothub: unexpected HTTP status 500 Internal Server Error",
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			header := tc.Header
+			if header == nil {
+				header = http.Header{}
+			}
+			err := newError(&http.Response{
+				StatusCode: tc.Status,
+				Header:     header,
+				Body:       ioutil.NopCloser(strings.NewReader(tc.Body)),
+			}, now)
+			assert.Equal(t, tc.Error, err)
+			assert.EqualError(t, err, tc.String)
+			for _, target := range []error{
+				ErrNotFound, ErrConflict, ErrThrottled, ErrUnauthorized,
+			} {
+				assert.Equal(t, target == tc.Target, errors.Is(err, target))
+			}
+		})
+	}
+}
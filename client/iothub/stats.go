@@ -0,0 +1,62 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package iothub
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type callStatsContextKey struct{}
+
+// CallStats counts the IoT Hub requests made on behalf of a request and
+// their total latency
+type CallStats struct {
+	mu      sync.Mutex
+	calls   int
+	latency time.Duration
+}
+
+// WithCallStats returns a context recording the IoT Hub requests made with
+// it in the returned CallStats
+func WithCallStats(ctx context.Context) (context.Context, *CallStats) {
+	stats := new(CallStats)
+	return context.WithValue(ctx, callStatsContextKey{}, stats), stats
+}
+
+// CallStatsFromContext returns the CallStats of the context, or nil
+func CallStatsFromContext(ctx context.Context) *CallStats {
+	stats, _ := ctx.Value(callStatsContextKey{}).(*CallStats)
+	return stats
+}
+
+// Add records a request which took latency; it is a no-op on nil
+func (s *CallStats) Add(latency time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	s.latency += latency
+}
+
+// Get returns the number of requests and their total latency
+func (s *CallStats) Get() (int, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls, s.latency
+}
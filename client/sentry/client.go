@@ -0,0 +1,249 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package sentry implements a client of the Sentry event ingestion API,
+// also implemented by Sentry-compatible error trackers.
+package sentry
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/requestid"
+)
+
+const (
+	LevelError = "error"
+	LevelFatal = "fatal"
+
+	clientName     = "azure-iot-manager"
+	defaultTimeout = 10 * time.Second
+)
+
+var (
+	ErrInvalidDSN = errors.New("sentry: invalid DSN")
+)
+
+// Event is an error report
+type Event struct {
+	// Level is the severity of the event: LevelError or LevelFatal
+	Level   string
+	Message string
+	Time    time.Time
+	// Tags are the indexed attributes of the event, e.g. the tenant ID
+	Tags map[string]string
+	// Extra are the attributes of the event which are not indexed,
+	// e.g. the stack trace of a panic
+	Extra map[string]interface{}
+
+	Request *Request
+}
+
+// Request is the HTTP request causing the event
+type Request struct {
+	Method string
+	URL    string
+}
+
+// Client is the Sentry client
+//nolint:lll
+//go:generate ../../utils/mockgen.sh
+type Client interface {
+	CaptureEvent(ctx context.Context, event Event) error
+}
+
+// Options contains the optional parameters of the client
+type Options struct {
+	// Release is the version of the service reporting the events
+	Release string
+	// ServerName identifies the instance reporting the events
+	ServerName string
+	// HTTPClient is the client sending the events
+	HTTPClient *http.Client
+}
+
+// NewOptions returns a new Options
+func NewOptions() *Options {
+	return &Options{}
+}
+
+// SetRelease sets the version of the service reporting the events
+func (o *Options) SetRelease(release string) *Options {
+	o.Release = release
+	return o
+}
+
+// SetServerName sets the name of the instance reporting the events
+func (o *Options) SetServerName(name string) *Options {
+	o.ServerName = name
+	return o
+}
+
+// SetHTTPClient sets the client sending the events
+func (o *Options) SetHTTPClient(client *http.Client) *Options {
+	o.HTTPClient = client
+	return o
+}
+
+func mergeOptions(opts []*Options) *Options {
+	opt := NewOptions()
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if o.Release != "" {
+			opt.Release = o.Release
+		}
+		if o.ServerName != "" {
+			opt.ServerName = o.ServerName
+		}
+		if o.HTTPClient != nil {
+			opt.HTTPClient = o.HTTPClient
+		}
+	}
+	return opt
+}
+
+type client struct {
+	client   *http.Client
+	storeURL string
+	auth     string
+	options  *Options
+}
+
+// NewClient returns a new client reporting the events to the project
+// identified by the DSN, e.g. https://<key>@sentry.example.com/<project>
+func NewClient(dsn string, opts ...*Options) (Client, error) {
+	storeURL, key, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	opt := mergeOptions(opts)
+	httpClient := opt.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	return &client{
+		client:   httpClient,
+		storeURL: storeURL,
+		auth: fmt.Sprintf("Sentry sentry_version=7, sentry_client=%s, sentry_key=%s",
+			clientName, key),
+		options: opt,
+	}, nil
+}
+
+// parseDSN returns the URL of the store endpoint of the project and the
+// public key of the DSN
+func parseDSN(dsn string) (string, string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", errors.Wrap(ErrInvalidDSN, err.Error())
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", "", errors.Wrapf(ErrInvalidDSN, "unsupported scheme %q", u.Scheme)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return "", "", errors.Wrap(ErrInvalidDSN, "missing public key")
+	}
+	path := strings.TrimRight(u.Path, "/")
+	slash := strings.LastIndex(path, "/")
+	project := path[slash+1:]
+	if project == "" {
+		return "", "", errors.Wrap(ErrInvalidDSN, "missing project ID")
+	}
+	storeURL := fmt.Sprintf("%s://%s%s/api/%s/store/",
+		u.Scheme, u.Host, path[:slash], project)
+	return storeURL, u.User.Username(), nil
+}
+
+type event struct {
+	EventID    string                 `json:"event_id"`
+	Timestamp  string                 `json:"timestamp"`
+	Level      string                 `json:"level"`
+	Platform   string                 `json:"platform"`
+	Logger     string                 `json:"logger"`
+	Message    string                 `json:"message"`
+	Release    string                 `json:"release,omitempty"`
+	ServerName string                 `json:"server_name,omitempty"`
+	Tags       map[string]string      `json:"tags,omitempty"`
+	Extra      map[string]interface{} `json:"extra,omitempty"`
+	Request    *request               `json:"request,omitempty"`
+}
+
+type request struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+// CaptureEvent reports the event
+func (c *client) CaptureEvent(ctx context.Context, e Event) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+	id := uuid.New()
+	body := event{
+		EventID:    hex.EncodeToString(id[:]),
+		Timestamp:  e.Time.UTC().Format(time.RFC3339),
+		Level:      e.Level,
+		Platform:   "go",
+		Logger:     clientName,
+		Message:    e.Message,
+		Release:    c.options.Release,
+		ServerName: c.options.ServerName,
+		Tags:       e.Tags,
+		Extra:      e.Extra,
+	}
+	if e.Request != nil {
+		body.Request = &request{Method: e.Request.Method, URL: e.Request.URL}
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return errors.Wrap(err, "sentry: failed to encode event")
+	}
+	req, err := http.NewRequestWithContext(ctx,
+		http.MethodPost, c.storeURL, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "sentry: failed to prepare request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", c.auth)
+	if reqID := requestid.FromContext(ctx); reqID != "" {
+		req.Header.Set(requestid.RequestIdHeader, reqID)
+	}
+
+	rsp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "sentry: failed to submit event")
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode >= 300 {
+		return errors.Errorf(
+			"sentry: unexpected HTTP status from Sentry: %s",
+			rsp.Status,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRealClock(t *testing.T) {
+	clock := New()
+	before := time.Now()
+	assert.False(t, clock.Now().Before(before))
+	select {
+	case <-clock.After(time.Millisecond):
+	case <-time.After(time.Second):
+		t.Error("timed out waiting for the clock")
+	}
+}
+
+func TestFake(t *testing.T) {
+	start := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFake(start)
+	assert.Equal(t, start, clock.Now())
+
+	immediate := clock.After(0)
+	later := clock.After(time.Minute)
+	sooner := clock.After(time.Second)
+	assert.Equal(t, 2, clock.Waiters())
+	assert.Equal(t, start, <-immediate)
+
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, start.Add(30*time.Second), clock.Now())
+	assert.Equal(t, start.Add(30*time.Second), <-sooner)
+	select {
+	case <-later:
+		t.Error("the clock fired too early")
+	default:
+	}
+	assert.Equal(t, 1, clock.Waiters())
+
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, start.Add(time.Minute), <-later)
+	assert.Equal(t, 0, clock.Waiters())
+}
@@ -24,6 +24,20 @@ const (
 	// SettingListenDefault is the default value for the listen address
 	SettingListenDefault = ":8080"
 
+	// SettingTLSCertFile and SettingTLSKeyFile are the config keys for
+	// the paths of the PEM encoded certificate and private key of the
+	// listener; the listener serves HTTPS if both are set
+	SettingTLSCertFile = "tls.cert_file"
+	SettingTLSKeyFile  = "tls.key_file"
+	// SettingTLSMinVersion is the config key for the minimum version of
+	// the TLS connections: "1.2" or "1.3"
+	SettingTLSMinVersion = "tls.min_version"
+	// SettingTLSMinVersionDefault is the default minimum TLS version
+	SettingTLSMinVersionDefault = "1.2"
+	// SettingTLSCipherSuites is the config key for the cipher suites of
+	// the TLS 1.2 connections; empty selects the secure defaults
+	SettingTLSCipherSuites = "tls.cipher_suites"
+
 	// SettingMongo is the config key for the mongo URL
 	SettingMongo = "mongo_url"
 	// SettingMongoDefault is the default value for the mongo URL
@@ -50,6 +64,183 @@ const (
 	// SettingDbPassword is the config key for the mongo password
 	SettingDbPassword = "mongo_password"
 
+	// SettingDbSlowQueryThreshold is the config key for the duration, in
+	// milliseconds, above which the database operations are logged
+	SettingDbSlowQueryThreshold = "mongo_slow_query_threshold"
+	// SettingDbSlowQueryThresholdDefault is the default slow query
+	// threshold
+	SettingDbSlowQueryThresholdDefault = 500
+
+	// SettingInternalAPIKeys is the config key for the list of API keys
+	// accepted by the internal API
+	SettingInternalAPIKeys = "internal_api_keys"
+
+	// SettingInternalAPIKeysFile is the config key for the path to a file
+	// containing the API keys accepted by the internal API, one per line
+	SettingInternalAPIKeysFile = "internal_api_keys_file"
+
+	// SettingWorkersEmbedded is the config key for running the background
+	// workers in the server process; if false, the server only serves the
+	// API and the workers run with the worker command
+	SettingWorkersEmbedded = "workers.embedded"
+	// SettingWorkersEmbeddedDefault is the default of embedded workers
+	SettingWorkersEmbeddedDefault = true
+
+	// SettingEncryptionKeyID is the config key for the ID of the key
+	// encrypting the secrets stored in the database; empty stores them in
+	// plaintext
+	SettingEncryptionKeyID = "encryption.key_id"
+	// SettingEncryptionKeys is the config key for the list of the keys of
+	// the stored secrets, in the "<id>:<base64 encoded 32 bytes key>" form
+	SettingEncryptionKeys = "encryption.keys"
+	// SettingEncryptionKeysFile is the config key for the path to a file
+	// containing the keys of the stored secrets, one per line
+	SettingEncryptionKeysFile = "encryption.keys_file"
+
+	// SettingInternalAPIAllowedCIDRs is the config key for the list of
+	// networks (CIDR notation) allowed to access the internal API
+	SettingInternalAPIAllowedCIDRs = "internal_api_allowed_cidrs"
+
+	// SettingJWTPublicKeyFile is the config key for the path to the PEM
+	// encoded public key(s) used for verifying the management API tokens
+	SettingJWTPublicKeyFile = "jwt_public_key_file"
+
+	// SettingJWKSURL is the config key for the URL of the JSON Web Key Set
+	// used for verifying the management API tokens
+	SettingJWKSURL = "jwks_url"
+
+	// SettingErrorReportingDSN is the config key for the DSN of the
+	// Sentry project receiving the error reports
+	SettingErrorReportingDSN = "error_reporting.dsn"
+
+	// SettingAuditLogsAddr is the config key for the address of the
+	// auditlogs service
+	SettingAuditLogsAddr = "auditlogs_addr"
+
+	// SettingAuditLogsForwardInterval is the config key for the interval
+	// in seconds between attempts to forward the audit logs
+	SettingAuditLogsForwardInterval = "auditlogs_forward_interval"
+	// SettingAuditLogsForwardIntervalDefault is the default value for the
+	// audit logs forward interval
+	SettingAuditLogsForwardIntervalDefault = 10
+
+	// SettingAuditLogsRetention is the config key for the number of days
+	// the audit logs are stored locally; 0 keeps them forever
+	SettingAuditLogsRetention = "auditlogs_retention"
+	// SettingAuditLogsRetentionDefault is the default value for the audit
+	// logs retention (keep forever)
+	SettingAuditLogsRetentionDefault = 0
+
+	// SettingSecretsBackend is the config key for the backend used for
+	// resolving the settings referencing a secret; the only supported
+	// backend is "vault"
+	SettingSecretsBackend = "secrets.backend"
+
+	// SettingVaultAddress is the config key for the URL of the Vault server
+	SettingVaultAddress = "secrets.vault.address"
+
+	// SettingVaultToken is the config key for the Vault token
+	SettingVaultToken = "secrets.vault.token"
+
+	// SettingVaultTokenFile is the config key for the path to a file
+	// containing the Vault token
+	SettingVaultTokenFile = "secrets.vault.token_file"
+
+	// SettingVaultNamespace is the config key for the Vault namespace
+	SettingVaultNamespace = "secrets.vault.namespace"
+
+	// SettingManagementRateLimit is the config key for the maximum number
+	// of management API requests of each tenant per minute
+	SettingManagementRateLimit = "management_rate_limit"
+	// SettingManagementRateLimitDefault is the default value for the
+	// management API rate limit (disabled)
+	SettingManagementRateLimitDefault = 0
+
+	// SettingHealthCacheTTL is the config key for the duration, in
+	// seconds, the results of the dependency checks are reused by the
+	// health checks
+	SettingHealthCacheTTL = "health_cache_ttl"
+	// SettingHealthCacheTTLDefault is the default health check cache TTL
+	SettingHealthCacheTTLDefault = 5
+
+	// SettingConnectionStringGracePeriod is the config key for the
+	// duration, in seconds, the connection string replaced by a rotation
+	// is kept as a fallback
+	SettingConnectionStringGracePeriod = "connection_string_grace_period"
+	// SettingConnectionStringGracePeriodDefault is the default grace
+	// period of the rotated connection strings (1 hour)
+	SettingConnectionStringGracePeriodDefault = 3600
+
+	// SettingSASPolicyEnforcement is the config key for the enforcement
+	// mode of the least privilege of the shared access policies: "off",
+	// "warn" or "refuse"
+	SettingSASPolicyEnforcement = "sas_policy_enforcement"
+	// SettingSASPolicyEnforcementDefault is the default enforcement mode
+	SettingSASPolicyEnforcementDefault = "warn"
+
+	// SettingAzureConcurrency is the config key for the maximum number of
+	// concurrent requests to the Azure IoT Hub
+	SettingAzureConcurrency = "azure_concurrency"
+	// SettingAzureConcurrencyDefault is the default value for the Azure
+	// concurrency limit (disabled)
+	SettingAzureConcurrencyDefault = 0
+
+	// SettingAzureTenantConcurrency is the config key for the maximum
+	// number of concurrent requests to the Azure IoT Hub of each tenant
+	SettingAzureTenantConcurrency = "azure_tenant_concurrency"
+	// SettingAzureTenantConcurrencyDefault is the default value for the
+	// Azure concurrency limit of each tenant (disabled)
+	SettingAzureTenantConcurrencyDefault = 0
+
+	// SettingAzureTimeoutRegistry is the config key for the deadline in
+	// seconds of the requests to the IoT Hub device identity registry
+	SettingAzureTimeoutRegistry = "azure_timeouts.registry"
+	// SettingAzureTimeoutRegistryDefault is the default value for the
+	// deadline of the registry requests
+	SettingAzureTimeoutRegistryDefault = 10
+
+	// SettingAzureTimeoutService is the config key for the deadline in
+	// seconds of the requests to the IoT Hub service API
+	SettingAzureTimeoutService = "azure_timeouts.service"
+	// SettingAzureTimeoutServiceDefault is the default value for the
+	// deadline of the service requests
+	SettingAzureTimeoutServiceDefault = 10
+
+	// SettingAzureQuotaHourly is the config key for the maximum number of
+	// requests to the Azure IoT Hub of each tenant per hour
+	SettingAzureQuotaHourly = "azure_quota.hourly"
+	// SettingAzureQuotaHourlyDefault is the default hourly quota
+	// (disabled)
+	SettingAzureQuotaHourlyDefault = 0
+	// SettingAzureQuotaDaily is the config key for the maximum number of
+	// requests to the Azure IoT Hub of each tenant per day
+	SettingAzureQuotaDaily = "azure_quota.daily"
+	// SettingAzureQuotaDailyDefault is the default daily quota (disabled)
+	SettingAzureQuotaDailyDefault = 0
+
+	// SettingFeatures is the config key for the map of feature flags
+	// (feature name to boolean) overriding the built-in defaults
+	SettingFeatures = "features"
+
+	// SettingPlans is the config key for the map of tenant plans to the
+	// premium capabilities they include
+	SettingPlans = "plans"
+
+	// SettingLogFormat is the config key for the format of the log
+	// entries: "text" or "json"
+	SettingLogFormat        = "log_format"
+	SettingLogFormatDefault = "text"
+
+	// SettingLogLevels is the config key for the map of log levels (e.g.
+	// "debug") by component, overriding the global log level
+	SettingLogLevels = "log_levels"
+
+	// SettingFIPSMode is the config key for restricting the cryptographic
+	// operations to the FIPS 140 approved algorithms
+	SettingFIPSMode = "fips_mode"
+	// SettingFIPSModeDefault is the default value for the FIPS mode
+	SettingFIPSModeDefault = false
+
 	// SettingDebugLog is the config key for the turning on the debug log
 	SettingDebugLog = "debug_log"
 	// SettingDebugLogDefault is the default value for the debug log enabling
@@ -60,10 +251,39 @@ var (
 	// Defaults are the default configuration settings
 	Defaults = []config.Default{
 		{Key: SettingListen, Value: SettingListenDefault},
+		{Key: SettingTLSMinVersion, Value: SettingTLSMinVersionDefault},
+		{Key: SettingWorkersEmbedded, Value: SettingWorkersEmbeddedDefault},
 		{Key: SettingMongo, Value: SettingMongoDefault},
 		{Key: SettingDbName, Value: SettingDbNameDefault},
 		{Key: SettingDbSSL, Value: SettingDbSSLDefault},
 		{Key: SettingDbSSLSkipVerify, Value: SettingDbSSLSkipVerifyDefault},
+		{Key: SettingDbSlowQueryThreshold, Value: SettingDbSlowQueryThresholdDefault},
+		{
+			Key:   SettingAuditLogsForwardInterval,
+			Value: SettingAuditLogsForwardIntervalDefault,
+		},
+		{Key: SettingAuditLogsRetention, Value: SettingAuditLogsRetentionDefault},
+		{Key: SettingManagementRateLimit, Value: SettingManagementRateLimitDefault},
+		{Key: SettingHealthCacheTTL, Value: SettingHealthCacheTTLDefault},
+		{
+			Key:   SettingConnectionStringGracePeriod,
+			Value: SettingConnectionStringGracePeriodDefault,
+		},
+		{
+			Key:   SettingSASPolicyEnforcement,
+			Value: SettingSASPolicyEnforcementDefault,
+		},
+		{Key: SettingAzureConcurrency, Value: SettingAzureConcurrencyDefault},
+		{
+			Key:   SettingAzureTenantConcurrency,
+			Value: SettingAzureTenantConcurrencyDefault,
+		},
+		{Key: SettingAzureTimeoutRegistry, Value: SettingAzureTimeoutRegistryDefault},
+		{Key: SettingAzureTimeoutService, Value: SettingAzureTimeoutServiceDefault},
+		{Key: SettingAzureQuotaHourly, Value: SettingAzureQuotaHourlyDefault},
+		{Key: SettingAzureQuotaDaily, Value: SettingAzureQuotaDailyDefault},
+		{Key: SettingFIPSMode, Value: SettingFIPSModeDefault},
 		{Key: SettingDebugLog, Value: SettingDebugLogDefault},
+		{Key: SettingLogFormat, Value: SettingLogFormatDefault},
 	}
 )
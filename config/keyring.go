@@ -0,0 +1,50 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/config"
+
+	"github.com/mendersoftware/azure-iot-manager/keyring"
+)
+
+// Keyring returns the keyring of the secrets stored in the database, from
+// the keys of the configuration and of the keys file if configured.
+func Keyring(c config.Reader) (*keyring.Keyring, error) {
+	keys := c.GetStringSlice(SettingEncryptionKeys)
+	if path := c.GetString(SettingEncryptionKeysFile); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: failed to read the keys",
+				SettingEncryptionKeysFile)
+		}
+		for _, key := range strings.Split(string(b), "\n") {
+			key = strings.TrimSpace(key)
+			if key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+	k, err := keyring.Parse(c.GetString(SettingEncryptionKeyID), keys)
+	if err != nil {
+		return nil, errors.Wrap(err, "encryption")
+	}
+	return k, nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package config
+
+import (
+	"crypto/tls"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/config"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSConfig returns the TLS configuration enforcing the TLS policy of the
+// configuration: the minimum protocol version and the cipher suites. Only
+// the secure cipher suites are accepted; the TLS 1.3 cipher suites are not
+// configurable. The listener certificate is not loaded; an unset minimum
+// version selects the default.
+func TLSConfig(c config.Reader) (*tls.Config, error) {
+	minVersion := c.GetString(SettingTLSMinVersion)
+	if minVersion == "" {
+		minVersion = SettingTLSMinVersionDefault
+	}
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, errors.Errorf(
+			"%s: unsupported TLS version %q; expected \"1.2\" or \"1.3\"",
+			SettingTLSMinVersion, minVersion,
+		)
+	}
+	tlsConfig := &tls.Config{MinVersion: version}
+	names := c.GetStringSlice(SettingTLSCipherSuites)
+	if len(names) == 0 {
+		return tlsConfig, nil
+	}
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	for _, name := range names {
+		id, ok := suites[name]
+		if !ok {
+			return nil, errors.Errorf(
+				"%s: unknown or insecure cipher suite %q",
+				SettingTLSCipherSuites, name,
+			)
+		}
+		tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+	}
+	return tlsConfig, nil
+}
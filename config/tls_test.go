@@ -0,0 +1,98 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package config
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLSConfig(t *testing.T) {
+	testCases := []struct {
+		Name string
+
+		MinVersion   string
+		CipherSuites []string
+
+		Config *tls.Config
+		Error  string
+	}{{
+		Name: "ok, defaults",
+
+		MinVersion: SettingTLSMinVersionDefault,
+
+		Config: &tls.Config{MinVersion: tls.VersionTLS12},
+	}, {
+		Name: "ok, unset",
+
+		Config: &tls.Config{MinVersion: tls.VersionTLS12},
+	}, {
+		Name: "ok, cipher suites",
+
+		MinVersion: "1.2",
+		CipherSuites: []string{
+			"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+			"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+		},
+
+		Config: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			CipherSuites: []uint16{
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			},
+		},
+	}, {
+		Name: "ok, TLS 1.3",
+
+		MinVersion: "1.3",
+
+		Config: &tls.Config{MinVersion: tls.VersionTLS13},
+	}, {
+		Name: "error, unsupported version",
+
+		MinVersion: "1.1",
+
+		Error: `tls.min_version: unsupported TLS version "1.1"; ` +
+			`expected "1.2" or "1.3"`,
+	}, {
+		Name: "error, insecure cipher suite",
+
+		MinVersion:   "1.2",
+		CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"},
+
+		Error: `tls.cipher_suites: unknown or insecure cipher suite ` +
+			`"TLS_RSA_WITH_RC4_128_SHA"`,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			conf := viper.New()
+			conf.Set(SettingTLSMinVersion, tc.MinVersion)
+			conf.Set(SettingTLSCipherSuites, tc.CipherSuites)
+
+			tlsConfig, err := TLSConfig(conf)
+			if tc.Error != "" {
+				assert.EqualError(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.Config, tlsConfig)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/config"
+
+	"github.com/mendersoftware/azure-iot-manager/logging"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding the
+// configuration settings
+const EnvPrefix = "AZURE_IOT_MANAGER"
+
+type settingType int
+
+const (
+	typeString settingType = iota
+	typeBool
+	typeInt
+	typeStringSlice
+)
+
+var settingTypes = map[string]settingType{
+	SettingListen:                      typeString,
+	SettingTLSCertFile:                 typeString,
+	SettingTLSKeyFile:                  typeString,
+	SettingTLSMinVersion:               typeString,
+	SettingTLSCipherSuites:             typeStringSlice,
+	SettingWorkersEmbedded:             typeBool,
+	SettingEncryptionKeyID:             typeString,
+	SettingEncryptionKeys:              typeStringSlice,
+	SettingEncryptionKeysFile:          typeString,
+	SettingMongo:                       typeString,
+	SettingDbName:                      typeString,
+	SettingDbSSL:                       typeBool,
+	SettingDbSSLSkipVerify:             typeBool,
+	SettingDbSlowQueryThreshold:        typeInt,
+	SettingDbUsername:                  typeString,
+	SettingDbPassword:                  typeString,
+	SettingInternalAPIKeys:             typeStringSlice,
+	SettingInternalAPIKeysFile:         typeString,
+	SettingInternalAPIAllowedCIDRs:     typeStringSlice,
+	SettingJWTPublicKeyFile:            typeString,
+	SettingJWKSURL:                     typeString,
+	SettingErrorReportingDSN:           typeString,
+	SettingAuditLogsAddr:               typeString,
+	SettingAuditLogsForwardInterval:    typeInt,
+	SettingAuditLogsRetention:          typeInt,
+	SettingAzureConcurrency:            typeInt,
+	SettingAzureTenantConcurrency:      typeInt,
+	SettingHealthCacheTTL:              typeInt,
+	SettingConnectionStringGracePeriod: typeInt,
+	SettingSASPolicyEnforcement:        typeString,
+	SettingAzureTimeoutRegistry:        typeInt,
+	SettingAzureTimeoutService:         typeInt,
+	SettingAzureQuotaHourly:            typeInt,
+	SettingAzureQuotaDaily:             typeInt,
+	SettingSecretsBackend:              typeString,
+	SettingVaultAddress:                typeString,
+	SettingVaultToken:                  typeString,
+	SettingVaultTokenFile:              typeString,
+	SettingVaultNamespace:              typeString,
+	SettingManagementRateLimit:         typeInt,
+	SettingFIPSMode:                    typeBool,
+	SettingDebugLog:                    typeBool,
+	SettingLogFormat:                   typeString,
+}
+
+func init() {
+	for _, component := range logging.Components {
+		settingTypes[LogLevelKey(component)] = typeString
+	}
+}
+
+// LogLevelKey returns the config key of the log level of the component
+func LogLevelKey(component string) string {
+	return SettingLogLevels + "." + component
+}
+
+// featureKey reports whether key is a feature flag setting
+// ("features.<name>"); feature flags are booleans.
+func featureKey(key string) bool {
+	return strings.HasPrefix(key, SettingFeatures+".")
+}
+
+// planKey reports whether key is a tenant plan setting ("plans.<name>");
+// plans are lists of capabilities.
+func planKey(key string) bool {
+	return strings.HasPrefix(key, SettingPlans+".")
+}
+
+// Keys returns the keys of all the known settings, sorted
+func Keys() []string {
+	keys := make([]string, 0, len(settingTypes))
+	for key := range settingTypes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// RequiredSettings are the settings which must have a non-empty value
+var RequiredSettings = []string{
+	SettingListen,
+	SettingMongo,
+	SettingDbName,
+}
+
+// KeyReader is a configuration reader able to list the configured keys
+type KeyReader interface {
+	config.Reader
+	AllKeys() []string
+}
+
+// Validate checks the configuration and the environment variables
+// (in the "KEY=value" form) for unknown settings, values of the wrong type
+// and missing required settings. It returns the list of problems found.
+func Validate(c KeyReader, environ []string) []error {
+	var errs []error
+
+	keys := c.AllKeys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, ok := settingTypes[key]; ok {
+			continue
+		} else if featureKey(key) {
+			if err := checkType(c.Get(key), typeBool); err != nil {
+				errs = append(errs, errors.Wrap(err, key))
+			}
+			continue
+		} else if planKey(key) {
+			if err := checkType(c.Get(key), typeStringSlice); err != nil {
+				errs = append(errs, errors.Wrap(err, key))
+			}
+			continue
+		}
+		errs = append(errs, errors.Errorf("%s: unknown setting", key))
+	}
+	envPrefix := EnvPrefix + "_"
+	envKeys := make(map[string]struct{}, len(settingTypes))
+	for key := range settingTypes {
+		envKeys[strings.ReplaceAll(key, ".", "_")] = struct{}{}
+	}
+	for _, env := range environ {
+		kv := strings.SplitN(env, "=", 2)
+		name := kv[0]
+		if !strings.HasPrefix(name, envPrefix) {
+			continue
+		}
+		key := strings.ToLower(strings.TrimPrefix(name, envPrefix))
+		if strings.HasPrefix(key, SettingFeatures+"_") && len(kv) == 2 {
+			if err := checkType(kv[1], typeBool); err != nil {
+				errs = append(errs, errors.Wrap(err, name))
+			}
+			continue
+		} else if strings.HasPrefix(key, SettingPlans+"_") {
+			continue
+		}
+		if _, ok := envKeys[key]; !ok {
+			errs = append(errs, errors.Errorf(
+				"%s: environment variable does not match any setting", name,
+			))
+		}
+	}
+
+	for _, key := range Keys() {
+		value := c.Get(key)
+		if value == nil {
+			continue
+		}
+		if err := checkType(value, settingTypes[key]); err != nil {
+			errs = append(errs, errors.Wrap(err, key))
+		}
+	}
+
+	for _, key := range RequiredSettings {
+		if c.GetString(key) == "" {
+			errs = append(errs, errors.Errorf("%s: missing required setting", key))
+		}
+	}
+	if _, err := TLSConfig(c); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := Keyring(c); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// checkType checks that the value, as read from the configuration file or
+// from an environment variable (string), is of the expected type.
+func checkType(value interface{}, typ settingType) error {
+	var err error
+	switch typ {
+	case typeString:
+		switch value.(type) {
+		case string, int, int64, float64, bool:
+		default:
+			err = fmt.Errorf("expected a string, got %T", value)
+		}
+	case typeBool:
+		switch v := value.(type) {
+		case bool:
+		case string:
+			if _, e := strconv.ParseBool(v); e != nil {
+				err = fmt.Errorf("expected a boolean, got %q", v)
+			}
+		default:
+			err = fmt.Errorf("expected a boolean, got %T", value)
+		}
+	case typeInt:
+		switch v := value.(type) {
+		case int, int64:
+		case string:
+			if _, e := strconv.Atoi(v); e != nil {
+				err = fmt.Errorf("expected an integer, got %q", v)
+			}
+		default:
+			err = fmt.Errorf("expected an integer, got %T", value)
+		}
+	case typeStringSlice:
+		switch v := value.(type) {
+		case string, []string:
+		case []interface{}:
+			for _, item := range v {
+				if e := checkType(item, typeString); e != nil {
+					err = fmt.Errorf(
+						"expected a list of strings, got %T item", item,
+					)
+					break
+				}
+			}
+		default:
+			err = fmt.Errorf("expected a list of strings, got %T", value)
+		}
+	}
+	return err
+}
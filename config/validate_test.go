@@ -0,0 +1,225 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mapReader map[string]interface{}
+
+func (m mapReader) Get(key string) interface{}    { return m[key] }
+func (m mapReader) GetBool(key string) bool       { return m[key] == true }
+func (m mapReader) GetFloat64(key string) float64 { return 0 }
+func (m mapReader) GetInt(key string) int         { return 0 }
+func (m mapReader) GetString(key string) string {
+	if m[key] == nil {
+		return ""
+	}
+	return fmt.Sprint(m[key])
+}
+func (m mapReader) GetStringMap(key string) map[string]interface{}  { return nil }
+func (m mapReader) GetStringMapString(key string) map[string]string { return nil }
+func (m mapReader) GetStringSlice(key string) []string              { return nil }
+func (m mapReader) GetTime(key string) time.Time                    { return time.Time{} }
+func (m mapReader) GetDuration(key string) time.Duration            { return 0 }
+func (m mapReader) IsSet(key string) bool {
+	_, ok := m[key]
+	return ok
+}
+func (m mapReader) AllKeys() []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestValidate(t *testing.T) {
+	defaults := mapReader{}
+	for _, d := range Defaults {
+		defaults[d.Key] = d.Value
+	}
+	testCases := []struct {
+		Name string
+
+		Config  map[string]interface{}
+		Environ []string
+
+		Errors []string
+	}{
+		{
+			Name: "ok, defaults",
+		},
+		{
+			Name: "ok",
+
+			Config: map[string]interface{}{
+				SettingDebugLog:                 "true",
+				SettingAuditLogsForwardInterval: "30",
+				SettingInternalAPIKeys:          []interface{}{"key1", "key2"},
+				SettingInternalAPIAllowedCIDRs:  "10.0.0.0/8 127.0.0.1/32",
+			},
+			Environ: []string{
+				"PATH=/usr/bin",
+				"AZURE_IOT_MANAGER_DEBUG_LOG=true",
+				"AZURE_IOT_MANAGER_SECRETS_VAULT_ADDRESS=https://vault:8200",
+			},
+		},
+		{
+			Name: "error, unknown settings",
+
+			Config: map[string]interface{}{
+				"mongo_pasword": "secret",
+			},
+			Environ: []string{
+				"AZURE_IOT_MANAGER_MONGO_PASWORD=secret",
+			},
+
+			Errors: []string{
+				"mongo_pasword: unknown setting",
+				"AZURE_IOT_MANAGER_MONGO_PASWORD: environment variable " +
+					"does not match any setting",
+			},
+		},
+		{
+			Name: "error, wrong types",
+
+			Config: map[string]interface{}{
+				SettingDbSSL:                    "yes please",
+				SettingAuditLogsForwardInterval: 1.5,
+				SettingInternalAPIKeys: []interface{}{
+					map[string]interface{}{"key": "value"},
+				},
+				SettingJWKSURL: []interface{}{"https://example.com"},
+			},
+
+			Errors: []string{
+				"auditlogs_forward_interval: expected an integer, got float64",
+				"internal_api_keys: expected a list of strings, " +
+					"got map[string]interface {} item",
+				"jwks_url: expected a string, got []interface {}",
+				`mongo_ssl: expected a boolean, got "yes please"`,
+			},
+		},
+		{
+			Name: "feature flags",
+
+			Config: map[string]interface{}{
+				"features.test_feature": false,
+				"features.other":        "maybe",
+			},
+			Environ: []string{
+				"AZURE_IOT_MANAGER_FEATURES_TEST_FEATURE=true",
+				"AZURE_IOT_MANAGER_FEATURES_OTHER=1.5",
+			},
+
+			Errors: []string{
+				`features.other: expected a boolean, got "maybe"`,
+				`AZURE_IOT_MANAGER_FEATURES_OTHER: expected a boolean, got "1.5"`,
+			},
+		},
+		{
+			Name: "plans",
+
+			Config: map[string]interface{}{
+				"plans.enterprise": []interface{}{"settings_rotation"},
+				"plans.other": []interface{}{
+					map[string]interface{}{"key": "value"},
+				},
+			},
+			Environ: []string{
+				"AZURE_IOT_MANAGER_PLANS_PROFESSIONAL=audit_logs_export",
+			},
+
+			Errors: []string{
+				"plans.other: expected a list of strings, " +
+					"got map[string]interface {} item",
+			},
+		},
+		{
+			Name: "error, TLS policy",
+
+			Config: map[string]interface{}{
+				SettingTLSMinVersion: "1.0",
+			},
+
+			Errors: []string{
+				`tls.min_version: unsupported TLS version "1.0"; ` +
+					`expected "1.2" or "1.3"`,
+			},
+		},
+		{
+			Name: "error, unknown encryption key",
+
+			Config: map[string]interface{}{
+				SettingEncryptionKeyID: "v2",
+				SettingEncryptionKeys: []string{
+					"v1:MTExMTExMTExMTExMTExMTExMTExMTExMTExMTExMTE=",
+				},
+			},
+
+			Errors: []string{
+				`encryption: "v2": keyring: unknown key`,
+			},
+		},
+		{
+			Name: "error, missing required settings",
+
+			Config: map[string]interface{}{
+				SettingMongo: "",
+			},
+
+			Errors: []string{
+				"mongo_url: missing required setting",
+			},
+		},
+	}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			conf := mapReader{}
+			for key, value := range defaults {
+				conf[key] = value
+			}
+			for key, value := range tc.Config {
+				conf[key] = value
+			}
+			errs := Validate(conf, tc.Environ)
+			var msgs []string
+			for _, err := range errs {
+				msgs = append(msgs, err.Error())
+			}
+			assert.Equal(t, tc.Errors, msgs)
+		})
+	}
+}
+
+func TestSettingTypes(t *testing.T) {
+	for _, d := range Defaults {
+		_, ok := settingTypes[d.Key]
+		assert.True(t, ok, "missing type of setting %s", d.Key)
+	}
+	for _, key := range RequiredSettings {
+		_, ok := settingTypes[key]
+		assert.True(t, ok, "missing type of setting %s", key)
+	}
+}
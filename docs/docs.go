@@ -0,0 +1,79 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package docs contains the OpenAPI specifications of the service APIs.
+package docs
+
+import (
+	// Required for embedding the specifications
+	_ "embed"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	//go:embed management_api.yml
+	managementAPI []byte
+
+	//go:embed internal_api.yml
+	internalAPI []byte
+)
+
+// ManagementAPI returns the JSON encoded OpenAPI specification of the
+// management API
+func ManagementAPI() ([]byte, error) {
+	return yamlToJSON(managementAPI)
+}
+
+// InternalAPI returns the JSON encoded OpenAPI specification of the
+// internal API
+func InternalAPI() ([]byte, error) {
+	return yamlToJSON(internalAPI)
+}
+
+func yamlToJSON(spec []byte) ([]byte, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(spec, &doc); err != nil {
+		return nil, errors.Wrap(err, "docs: failed to parse specification")
+	}
+	return json.Marshal(normalize(doc))
+}
+
+// normalize converts the YAML mappings with non-string keys (e.g. the
+// response status codes) to string-keyed maps that can be JSON encoded.
+func normalize(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, elem := range value {
+			value[k] = normalize(elem)
+		}
+		return value
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, elem := range value {
+			m[fmt.Sprint(k)] = normalize(elem)
+		}
+		return m
+	case []interface{}:
+		for i, elem := range value {
+			value[i] = normalize(elem)
+		}
+		return value
+	default:
+		return value
+	}
+}
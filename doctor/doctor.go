@@ -0,0 +1,227 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package doctor diagnoses the deployment of the service: the
+// configuration, the database and the integrations with the Azure IoT Hub.
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/azure-iot-manager/app"
+	"github.com/mendersoftware/azure-iot-manager/clock"
+	dconfig "github.com/mendersoftware/azure-iot-manager/config"
+	"github.com/mendersoftware/azure-iot-manager/store"
+)
+
+const (
+	StatusOK      = "ok"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+
+	// MaxClockSkew is the maximum tolerated difference between the local
+	// clock and the clock of the database server; the shared access
+	// signatures and the JWT expiration depend on an accurate clock.
+	MaxClockSkew = 30 * time.Second
+
+	// maxFailures is the maximum number of failed integrations reported
+	maxFailures = 5
+)
+
+var (
+	errMigrationsPending = errors.New("the database schema is behind the service")
+)
+
+// Check is the result of a single diagnostic check
+type Check struct {
+	Name        string
+	Description string
+	Status      string
+	Error       string
+	// Hint is the suggested remediation of a failed check
+	Hint string
+}
+
+// Report is the result of the diagnostic checks
+type Report struct {
+	Checks []Check
+}
+
+// OK returns true if none of the checks failed
+func (r Report) OK() bool {
+	for _, check := range r.Checks {
+		if check.Status == StatusFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// Options are the dependencies of the diagnostic checks
+type Options struct {
+	// Config is the configuration of the service
+	Config dconfig.KeyReader
+	// Environ are the environment variables, as returned by os.Environ
+	Environ []string
+	// Store is the data store, nil if the connection failed
+	Store store.DataStore
+	// StoreError is the error connecting to the data store
+	StoreError error
+	// App checks the integrations of the tenants
+	App app.App
+	// AppError is the error setting up the App, e.g. loading the
+	// encryption keys; it fails the check of the integrations
+	AppError error
+	// Sample is the number of integrations to check
+	Sample int
+	// Clock is the local clock, defaults to the system clock
+	Clock clock.Clock
+}
+
+// Run runs the diagnostic checks; the checks depending on the database
+// are skipped if it is unreachable.
+func Run(ctx context.Context, opts Options) Report {
+	if opts.Clock == nil {
+		opts.Clock = clock.New()
+	}
+	var report Report
+	add := func(name, description, hint string, err error) {
+		check := Check{
+			Name:        name,
+			Description: description,
+			Status:      StatusOK,
+		}
+		if err != nil {
+			check.Status = StatusFailed
+			check.Error = err.Error()
+			check.Hint = hint
+		}
+		report.Checks = append(report.Checks, check)
+	}
+	skip := func(name, description string) {
+		report.Checks = append(report.Checks, Check{
+			Name:        name,
+			Description: description,
+			Status:      StatusSkipped,
+		})
+	}
+
+	add("config", "the configuration is valid",
+		"run `azure-iot-manager config validate` and fix the reported settings",
+		checkConfig(opts))
+
+	err := opts.StoreError
+	if err == nil && opts.Store == nil {
+		err = errors.New("no data store")
+	} else if err == nil {
+		err = opts.Store.Ping(ctx)
+	}
+	add("mongo", "the database is reachable",
+		"verify the mongo, mongo_username and mongo_password settings "+
+			"and that the database server is up",
+		err)
+	if err != nil {
+		skip("migrations", "the database schema is up to date")
+		skip("indexes", "the database indexes exist")
+		skip("clock_skew", "the local clock is in sync with the database")
+		skip("azure", "a sample of the integrations is healthy")
+		return report
+	}
+
+	status, err := opts.Store.GetMigrationStatus(ctx)
+	if err == nil && status.Pending {
+		err = errors.Wrapf(errMigrationsPending, "version %s", status.Version)
+	}
+	add("migrations", "the database schema is up to date",
+		"run `azure-iot-manager migrate`", err)
+
+	missing, err := opts.Store.GetMissingIndexes(ctx)
+	if err == nil && len(missing) > 0 {
+		err = errors.Errorf("missing indexes: %s", strings.Join(missing, ", "))
+	}
+	add("indexes", "the database indexes exist",
+		"run `azure-iot-manager migrate`", err)
+
+	add("clock_skew", "the local clock is in sync with the database",
+		"synchronize the clock of the hosts with NTP",
+		checkClockSkew(ctx, opts))
+
+	if opts.AppError != nil {
+		add("azure", "a sample of the integrations is healthy",
+			"fix the settings reported by the config check", opts.AppError)
+		return report
+	} else if opts.App == nil {
+		skip("azure", "a sample of the integrations is healthy")
+		return report
+	}
+	fleet, err := opts.App.FleetHealth(ctx, opts.Sample)
+	if err == nil && fleet.Sampled == 0 {
+		skip("azure", "a sample of the integrations is healthy")
+		return report
+	}
+	if err == nil && fleet.Failed > 0 {
+		failures := make([]string, 0, maxFailures)
+		for i, f := range fleet.Failures {
+			if i == maxFailures {
+				failures = append(failures, "...")
+				break
+			}
+			failures = append(failures,
+				fmt.Sprintf("tenant %q: %s: %s", f.TenantID, f.Check, f.Error))
+		}
+		err = errors.Errorf("%d of %d integrations failed: %s",
+			fleet.Failed, fleet.Sampled, strings.Join(failures, "; "))
+	}
+	add("azure", "a sample of the integrations is healthy",
+		"run `azure-iot-manager check-integration --tenant <id>` "+
+			"for the details of a failed integration",
+		err)
+	return report
+}
+
+func checkConfig(opts Options) error {
+	if opts.Config == nil {
+		return nil
+	}
+	errs := dconfig.Validate(opts.Config, opts.Environ)
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+func checkClockSkew(ctx context.Context, opts Options) error {
+	serverTime, err := opts.Store.GetServerTime(ctx)
+	if err != nil {
+		return err
+	}
+	skew := opts.Clock.Now().Sub(serverTime)
+	if skew > MaxClockSkew {
+		return errors.Errorf("the local clock is %s ahead of the database server",
+			skew.Round(time.Second))
+	} else if skew < -MaxClockSkew {
+		return errors.Errorf("the local clock is %s behind the database server",
+			(-skew).Round(time.Second))
+	}
+	return nil
+}
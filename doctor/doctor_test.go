@@ -0,0 +1,235 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package doctor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	app_mocks "github.com/mendersoftware/azure-iot-manager/app/mocks"
+	"github.com/mendersoftware/azure-iot-manager/clock"
+	dconfig "github.com/mendersoftware/azure-iot-manager/config"
+	"github.com/mendersoftware/azure-iot-manager/model"
+	storeMocks "github.com/mendersoftware/azure-iot-manager/store/mocks"
+)
+
+func TestRun(t *testing.T) {
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	contextMatcher := mock.MatchedBy(func(context.Context) bool { return true })
+
+	testCases := []struct {
+		Name string
+
+		Config     map[string]interface{}
+		StoreError error
+		Store      func(ds *storeMocks.DataStore)
+		App        func(a *app_mocks.App)
+		AppError   error
+
+		Statuses map[string]string
+		Errors   map[string]string
+	}{{
+		Name: "ok",
+
+		Store: func(ds *storeMocks.DataStore) {
+			ds.On("Ping", contextMatcher).Return(nil)
+			ds.On("GetMigrationStatus", contextMatcher).
+				Return(model.MigrationStatus{Version: "1.4.0"}, nil)
+			ds.On("GetMissingIndexes", contextMatcher).Return(nil, nil)
+			ds.On("GetServerTime", contextMatcher).
+				Return(now.Add(-2*time.Second), nil)
+		},
+		App: func(a *app_mocks.App) {
+			a.On("FleetHealth", contextMatcher, 10).
+				Return(model.FleetHealthReport{Sampled: 3}, nil)
+		},
+
+		Statuses: map[string]string{
+			"config":     StatusOK,
+			"mongo":      StatusOK,
+			"migrations": StatusOK,
+			"indexes":    StatusOK,
+			"clock_skew": StatusOK,
+			"azure":      StatusOK,
+		},
+	}, {
+		Name: "error, database unreachable",
+
+		Config: map[string]interface{}{
+			dconfig.SettingTLSMinVersion: "1.0",
+		},
+		StoreError: errors.New("Error reaching mongo server"),
+
+		Statuses: map[string]string{
+			"config":     StatusFailed,
+			"mongo":      StatusFailed,
+			"migrations": StatusSkipped,
+			"indexes":    StatusSkipped,
+			"clock_skew": StatusSkipped,
+			"azure":      StatusSkipped,
+		},
+		Errors: map[string]string{
+			"config": `tls.min_version: unsupported TLS version "1.0"; ` +
+				`expected "1.2" or "1.3"`,
+			"mongo": "Error reaching mongo server",
+		},
+	}, {
+		Name: "error, database behind and clock skew",
+
+		Store: func(ds *storeMocks.DataStore) {
+			ds.On("Ping", contextMatcher).Return(nil)
+			ds.On("GetMigrationStatus", contextMatcher).
+				Return(model.MigrationStatus{Version: "1.4.0", Pending: true}, nil)
+			ds.On("GetMissingIndexes", contextMatcher).
+				Return([]string{"quotas: quotas expires_at"}, nil)
+			ds.On("GetServerTime", contextMatcher).
+				Return(now.Add(time.Minute), nil)
+		},
+		App: func(a *app_mocks.App) {
+			a.On("FleetHealth", contextMatcher, 10).
+				Return(model.FleetHealthReport{}, nil)
+		},
+
+		Statuses: map[string]string{
+			"config":     StatusOK,
+			"mongo":      StatusOK,
+			"migrations": StatusFailed,
+			"indexes":    StatusFailed,
+			"clock_skew": StatusFailed,
+			"azure":      StatusSkipped,
+		},
+		Errors: map[string]string{
+			"migrations": "version 1.4.0: the database schema is behind the service",
+			"indexes":    "missing indexes: quotas: quotas expires_at",
+			"clock_skew": "the local clock is 1m0s behind the database server",
+		},
+	}, {
+		Name: "error, integrations failed",
+
+		Store: func(ds *storeMocks.DataStore) {
+			ds.On("Ping", contextMatcher).Return(nil)
+			ds.On("GetMigrationStatus", contextMatcher).
+				Return(model.MigrationStatus{Version: "1.4.0"}, nil)
+			ds.On("GetMissingIndexes", contextMatcher).Return(nil, nil)
+			ds.On("GetServerTime", contextMatcher).Return(now, nil)
+		},
+		App: func(a *app_mocks.App) {
+			a.On("FleetHealth", contextMatcher, 10).
+				Return(model.FleetHealthReport{
+					Sampled: 3,
+					Failed:  1,
+					Failures: []model.IntegrationFailure{{
+						TenantID: "tenant1",
+						Check:    "registry",
+						Error:    "unauthorized",
+					}},
+				}, nil)
+		},
+
+		Statuses: map[string]string{
+			"config":     StatusOK,
+			"mongo":      StatusOK,
+			"migrations": StatusOK,
+			"indexes":    StatusOK,
+			"clock_skew": StatusOK,
+			"azure":      StatusFailed,
+		},
+		Errors: map[string]string{
+			"azure": `1 of 3 integrations failed: tenant "tenant1": ` +
+				`registry: unauthorized`,
+		},
+	}, {
+		Name: "error, app setup",
+
+		Config: map[string]interface{}{
+			dconfig.SettingEncryptionKeyID: "v1",
+		},
+		Store: func(ds *storeMocks.DataStore) {
+			ds.On("Ping", contextMatcher).Return(nil)
+			ds.On("GetMigrationStatus", contextMatcher).
+				Return(model.MigrationStatus{Version: "1.4.0"}, nil)
+			ds.On("GetMissingIndexes", contextMatcher).Return(nil, nil)
+			ds.On("GetServerTime", contextMatcher).Return(now, nil)
+		},
+		AppError: errors.New(`encryption: "v1": keyring: unknown key`),
+
+		Statuses: map[string]string{
+			"config":     StatusFailed,
+			"mongo":      StatusOK,
+			"migrations": StatusOK,
+			"indexes":    StatusOK,
+			"clock_skew": StatusOK,
+			"azure":      StatusFailed,
+		},
+		Errors: map[string]string{
+			"config": `encryption: "v1": keyring: unknown key`,
+			"azure":  `encryption: "v1": keyring: unknown key`,
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			conf := viper.New()
+			for _, d := range dconfig.Defaults {
+				conf.SetDefault(d.Key, d.Value)
+			}
+			for key, value := range tc.Config {
+				conf.Set(key, value)
+			}
+			opts := Options{
+				Config:     conf,
+				StoreError: tc.StoreError,
+				AppError:   tc.AppError,
+				Sample:     10,
+				Clock:      clock.NewFake(now),
+			}
+			if tc.Store != nil {
+				ds := &storeMocks.DataStore{}
+				defer ds.AssertExpectations(t)
+				tc.Store(ds)
+				opts.Store = ds
+			}
+			if tc.App != nil {
+				a := &app_mocks.App{}
+				defer a.AssertExpectations(t)
+				tc.App(a)
+				opts.App = a
+			}
+
+			report := Run(context.Background(), opts)
+			statuses := make(map[string]string)
+			errs := make(map[string]string)
+			for _, check := range report.Checks {
+				statuses[check.Name] = check.Status
+				if check.Error != "" {
+					errs[check.Name] = check.Error
+					assert.NotEmpty(t, check.Hint)
+				}
+			}
+			assert.Equal(t, tc.Statuses, statuses)
+			if tc.Errors == nil {
+				tc.Errors = map[string]string{}
+			}
+			assert.Equal(t, tc.Errors, errs)
+			assert.Equal(t, len(tc.Errors) == 0, report.OK())
+		})
+	}
+}
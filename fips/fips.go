@@ -0,0 +1,82 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package fips restricts the cryptographic operations of the service to
+// the algorithms approved by FIPS 140. The restriction of the TLS
+// connections and the validated cryptographic module require a binary
+// built with the boringcrypto build tag (make build-fips); the mode set
+// in the configuration additionally restricts the algorithms of the
+// service itself, e.g. the token signatures.
+package fips
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// MinRSAKeySize is the smallest approved RSA modulus, in bits
+const MinRSAKeySize = 2048
+
+var (
+	ErrNotValidatedModule = errors.New(
+		"fips: the binary is not built with a FIPS validated " +
+			"cryptographic module",
+	)
+	ErrNotApproved = errors.New("fips: algorithm not approved")
+)
+
+var enabled int32
+
+// Enable restricts the cryptographic operations to the approved
+// algorithms; it fails if the binary is not built with a validated
+// cryptographic module.
+func Enable() error {
+	if !ValidatedModule {
+		return ErrNotValidatedModule
+	}
+	atomic.StoreInt32(&enabled, 1)
+	return nil
+}
+
+// Enabled reports whether the cryptographic operations are restricted to
+// the approved algorithms
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+// ValidatePublicKey checks that the public key is of an approved
+// algorithm and size: RSA keys of at least MinRSAKeySize bits, or ECDSA
+// keys on the P-256, P-384 or P-521 curves.
+func ValidatePublicKey(key crypto.PublicKey) error {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < MinRSAKeySize {
+			return errors.Wrapf(ErrNotApproved,
+				"RSA key of %d bits", key.N.BitLen())
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		switch name := key.Curve.Params().Name; name {
+		case "P-256", "P-384", "P-521":
+			return nil
+		default:
+			return errors.Wrapf(ErrNotApproved, "ECDSA curve %s", name)
+		}
+	}
+	return errors.Wrapf(ErrNotApproved, "%T key", key)
+}
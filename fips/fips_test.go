@@ -0,0 +1,87 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package fips
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePublicKey(t *testing.T) {
+	rsa1024, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		Name string
+		Key  crypto.PublicKey
+
+		Error string
+	}{{
+		Name: "ok, RSA 2048",
+		Key:  &rsa2048.PublicKey,
+	}, {
+		Name: "ok, ECDSA P-256",
+		Key:  &p256.PublicKey,
+	}, {
+		Name: "error, RSA 1024",
+		Key:  &rsa1024.PublicKey,
+
+		Error: "RSA key of 1024 bits: " + ErrNotApproved.Error(),
+	}, {
+		Name: "error, Ed25519",
+		Key:  edPub,
+
+		Error: "ed25519.PublicKey key: " + ErrNotApproved.Error(),
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			err := ValidatePublicKey(tc.Key)
+			if tc.Error != "" {
+				assert.EqualError(t, err, tc.Error)
+				assert.True(t, errors.Is(err, ErrNotApproved))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestEnable(t *testing.T) {
+	defer func() { enabled = 0 }()
+	err := Enable()
+	if ValidatedModule {
+		assert.NoError(t, err)
+		assert.True(t, Enabled())
+	} else {
+		assert.Equal(t, ErrNotValidatedModule, err)
+		assert.False(t, Enabled())
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/mendersoftware/azure-iot-manager
 
-go 1.14
+go 1.16
 
 require (
 	github.com/gin-gonic/gin v1.7.4
@@ -9,8 +9,11 @@ require (
 	github.com/mendersoftware/go-lib-micro v0.0.0-20210709141452-a75f1eb981b4
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.8.1
+	github.com/spf13/viper v1.8.1
 	github.com/stretchr/testify v1.7.0
 	github.com/urfave/cli v1.22.5
 	go.mongodb.org/mongo-driver v1.7.3
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/sys v0.0.0-20210510120138-977fb7262007
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
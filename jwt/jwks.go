@@ -0,0 +1,263 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+)
+
+const (
+	// jwksRefreshInterval is the minimum interval between fetching the
+	// key set when a token is signed with an unknown key.
+	jwksRefreshInterval = time.Minute
+	// jwksMaxAge is the interval the key set is refreshed at, so that
+	// the keys removed from the key set are no longer trusted.
+	jwksMaxAge       = 15 * time.Minute
+	jwksFetchTimeout = 10 * time.Second
+)
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use,omitempty"`
+
+	// RSA parameters
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP parameters
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, errors.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, errors.Errorf("unsupported key type %q", k.KeyType)
+}
+
+type jwksVerifier struct {
+	url    string
+	client *http.Client
+	now    func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	err       error
+	fetchedAt time.Time
+	// refreshing is closed when the running refresh completes, nil when
+	// no refresh is running
+	refreshing chan struct{}
+}
+
+// NewJWKSVerifier returns a Verifier accepting tokens signed by the keys
+// published in the JSON Web Key Set at url. The key set is fetched lazily,
+// refreshed every jwksMaxAge and when a token is signed with an unknown
+// key; the refresh runs in the background while the current key set is in
+// use.
+func NewJWKSVerifier(url string, client *http.Client) Verifier {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &jwksVerifier{
+		url:    url,
+		client: client,
+		now:    time.Now,
+	}
+}
+
+// fetch fetches the key set; the keys which are not supported are skipped.
+func (v *jwksVerifier) fetch() (map[string]crypto.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "jwt: failed to prepare JWKS request")
+	}
+	rsp, err := v.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "jwt: failed to fetch JWKS")
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf(
+			"jwt: failed to fetch JWKS: unexpected status code %d",
+			rsp.StatusCode,
+		)
+	}
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(rsp.Body).Decode(&jwks); err != nil {
+		return nil, errors.Wrap(err, "jwt: failed to decode JWKS")
+	}
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.NewEmpty().Warnf("skipping the key %q of the JWKS: %s", jwk.KeyID, err)
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+	return keys, nil
+}
+
+// refresh fetches the key set and replaces the current one, which is kept
+// if the key set cannot be fetched; done is closed on completion.
+func (v *jwksVerifier) refresh(done chan struct{}) {
+	keys, err := v.fetch()
+	if err != nil {
+		log.NewEmpty().Warnf("failed to refresh the JWKS: %s", err)
+	}
+	v.mu.Lock()
+	if err == nil {
+		v.keys = keys
+	}
+	v.err = err
+	v.refreshing = nil
+	v.mu.Unlock()
+	close(done)
+}
+
+// lookup returns the keys to verify a token signed with the key kid. The
+// key set is refreshed in the background when it is stale, or when the key
+// is unknown at most once per jwksRefreshInterval; only the callers without
+// a key to use wait for the refresh.
+func (v *jwksVerifier) lookup(kid string) ([]crypto.PublicKey, error) {
+	v.mu.Lock()
+	now := v.now()
+	_, found := v.keys[kid]
+	age := now.Sub(v.fetchedAt)
+	if v.refreshing == nil &&
+		(age >= jwksMaxAge || (!found && age >= jwksRefreshInterval)) {
+		v.fetchedAt = now
+		v.refreshing = make(chan struct{})
+		go v.refresh(v.refreshing)
+	}
+	if done := v.refreshing; done != nil && (v.keys == nil || !found) {
+		v.mu.Unlock()
+		<-done
+		v.mu.Lock()
+	}
+	keys, err := v.keys, v.err
+	v.mu.Unlock()
+
+	if keys == nil {
+		if err == nil {
+			err = ErrNoKeys
+		}
+		return nil, err
+	}
+	if key, ok := keys[kid]; ok {
+		return []crypto.PublicKey{key}, nil
+	} else if kid != "" {
+		return nil, ErrTokenSignature
+	}
+	list := make([]crypto.PublicKey, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, key)
+	}
+	return list, nil
+}
+
+func (v *jwksVerifier) Verify(raw string) error {
+	tkn, err := parse(raw)
+	if err != nil {
+		return err
+	}
+	keys, err := v.lookup(tkn.header.KeyID)
+	if err != nil {
+		return err
+	}
+	err = ErrNoKeys
+	for _, key := range keys {
+		err = tkn.verifySignature(key)
+		if err == nil {
+			return tkn.validate(v.now())
+		}
+	}
+	return err
+}
@@ -0,0 +1,246 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package jwt implements verification of JSON Web Token signatures.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"time"
+
+	// Register hash functions
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/azure-iot-manager/fips"
+)
+
+var (
+	ErrTokenFormat      = errors.New("jwt: incorrect token format")
+	ErrTokenAlgorithm   = errors.New("jwt: unsupported signing algorithm")
+	ErrTokenSignature   = errors.New("jwt: invalid token signature")
+	ErrTokenExpired     = errors.New("jwt: token has expired")
+	ErrTokenNotValidYet = errors.New("jwt: token is not valid yet")
+	ErrNoKeys           = errors.New("jwt: no verification keys available")
+)
+
+// Verifier verifies the signature and validity of a token
+type Verifier interface {
+	Verify(token string) error
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+type claims struct {
+	ExpiresAt *int64 `json:"exp,omitempty"`
+	NotBefore *int64 `json:"nbf,omitempty"`
+}
+
+// token is a decoded JSON Web Token
+type token struct {
+	header    header
+	claims    claims
+	signed    []byte
+	signature []byte
+}
+
+func parse(raw string) (*token, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenFormat
+	}
+	tkn := &token{
+		signed: []byte(parts[0] + "." + parts[1]),
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "jwt: failed to decode header")
+	}
+	if err = json.Unmarshal(b, &tkn.header); err != nil {
+		return nil, errors.Wrap(err, "jwt: failed to decode header")
+	}
+	b, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.Wrap(err, "jwt: failed to decode claims")
+	}
+	if err = json.Unmarshal(b, &tkn.claims); err != nil {
+		return nil, errors.Wrap(err, "jwt: failed to decode claims")
+	}
+	tkn.signature, err = base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.Wrap(err, "jwt: failed to decode signature")
+	}
+	return tkn, nil
+}
+
+func (t *token) validate(now time.Time) error {
+	if t.claims.ExpiresAt != nil && now.Unix() >= *t.claims.ExpiresAt {
+		return ErrTokenExpired
+	}
+	if t.claims.NotBefore != nil && now.Unix() < *t.claims.NotBefore {
+		return ErrTokenNotValidYet
+	}
+	return nil
+}
+
+var hashes = map[string]crypto.Hash{
+	"256": crypto.SHA256,
+	"384": crypto.SHA384,
+	"512": crypto.SHA512,
+}
+
+// curves maps the ECDSA algorithms to the curve of their keys (RFC 7518)
+var curves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+// verifySignature verifies the token signature with the given key
+func (t *token) verifySignature(key crypto.PublicKey) error {
+	if fips.Enabled() && fips.ValidatePublicKey(key) != nil {
+		return ErrTokenAlgorithm
+	}
+	alg := t.header.Algorithm
+	if alg == "EdDSA" {
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(pub, t.signed, t.signature) {
+			return ErrTokenSignature
+		}
+		return nil
+	}
+	if len(alg) != 5 {
+		return ErrTokenAlgorithm
+	}
+	hash, ok := hashes[alg[2:]]
+	if !ok {
+		return ErrTokenAlgorithm
+	}
+	h := hash.New()
+	_, _ = h.Write(t.signed)
+	digest := h.Sum(nil)
+	switch alg[:2] {
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(pub, hash, digest, t.signature) != nil {
+			return ErrTokenSignature
+		}
+	case "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPSS(pub, hash, digest, t.signature, nil) != nil {
+			return ErrTokenSignature
+		}
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != curves[alg] {
+			return ErrTokenSignature
+		}
+		// The signature is the concatenation of the R and S values
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(t.signature) != 2*size {
+			return ErrTokenSignature
+		}
+		r := new(big.Int).SetBytes(t.signature[:size])
+		s := new(big.Int).SetBytes(t.signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return ErrTokenSignature
+		}
+	default:
+		return ErrTokenAlgorithm
+	}
+	return nil
+}
+
+// ParsePublicKeys parses all PEM encoded public keys (PKIX or PKCS#1 RSA)
+// and certificates in data.
+func ParsePublicKeys(data []byte) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		var (
+			key crypto.PublicKey
+			err error
+		)
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "jwt: failed to parse public key")
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	return keys, nil
+}
+
+type keyVerifier struct {
+	keys []crypto.PublicKey
+	now  func() time.Time
+}
+
+// NewKeyVerifier returns a Verifier accepting tokens signed by any of the
+// given public keys.
+func NewKeyVerifier(keys ...crypto.PublicKey) Verifier {
+	return &keyVerifier{
+		keys: keys,
+		now:  time.Now,
+	}
+}
+
+func (v *keyVerifier) Verify(raw string) error {
+	tkn, err := parse(raw)
+	if err != nil {
+		return err
+	}
+	err = ErrNoKeys
+	for _, key := range v.keys {
+		err = tkn.verifySignature(key)
+		if err == nil {
+			return tkn.validate(v.now())
+		}
+	}
+	return err
+}
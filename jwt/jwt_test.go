@@ -0,0 +1,336 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sign(t *testing.T, alg, kid string, claims map[string]interface{},
+	key crypto.Signer) string {
+	hdr := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		hdr["kid"] = kid
+	}
+	b, _ := json.Marshal(hdr)
+	token := base64.RawURLEncoding.EncodeToString(b)
+	b, _ = json.Marshal(claims)
+	token += "." + base64.RawURLEncoding.EncodeToString(b)
+
+	var (
+		sig []byte
+		err error
+	)
+	digest := sha256.Sum256([]byte(token))
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(token))
+	case *ecdsa.PrivateKey:
+		h := hashes[alg[2:]].New()
+		_, _ = h.Write([]byte(token))
+		r, s, err := ecdsa.Sign(rand.Reader, k, h.Sum(nil))
+		require.NoError(t, err)
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	case *rsa.PrivateKey:
+		if alg == "PS256" {
+			sig, err = rsa.SignPSS(rand.Reader, k, crypto.SHA256, digest[:], nil)
+		} else {
+			sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		}
+		require.NoError(t, err)
+	}
+	return token + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestKeyVerifier(t *testing.T) {
+	t.Parallel()
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ec384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	ec521Key, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	now := time.Now().Unix()
+	valid := map[string]interface{}{"sub": "user", "exp": now + 60}
+
+	testCases := []struct {
+		Name string
+
+		Token string
+		Error error
+	}{{
+		Name:  "ok, RS256",
+		Token: sign(t, "RS256", "", valid, rsaKey),
+	}, {
+		Name:  "ok, PS256",
+		Token: sign(t, "PS256", "", valid, rsaKey),
+	}, {
+		Name:  "ok, ES256",
+		Token: sign(t, "ES256", "", valid, ecKey),
+	}, {
+		Name:  "ok, ES384",
+		Token: sign(t, "ES384", "", valid, ec384Key),
+	}, {
+		Name:  "ok, ES512",
+		Token: sign(t, "ES512", "", valid, ec521Key),
+	}, {
+		Name:  "ok, EdDSA",
+		Token: sign(t, "EdDSA", "", valid, edKey),
+	}, {
+		Name:  "error, unknown key",
+		Token: sign(t, "RS256", "", valid, otherKey),
+		Error: ErrTokenSignature,
+	}, {
+		Name:  "error, algorithm mismatch",
+		Token: sign(t, "ES256", "", valid, rsaKey),
+		Error: ErrTokenSignature,
+	}, {
+		Name:  "error, curve mismatch",
+		Token: sign(t, "ES384", "", valid, ecKey),
+		Error: ErrTokenSignature,
+	}, {
+		Name:  "error, curve mismatch, ES512",
+		Token: sign(t, "ES512", "", valid, ec384Key),
+		Error: ErrTokenSignature,
+	}, {
+		Name: "error, unsigned token",
+		Token: func() string {
+			tkn := sign(t, "RS256", "", valid, rsaKey)
+			hdr := base64.RawURLEncoding.EncodeToString(
+				[]byte(`{"alg":"none"}`),
+			)
+			return hdr + tkn[strings.Index(tkn, "."):]
+		}(),
+		Error: ErrTokenAlgorithm,
+	}, {
+		Name: "error, expired",
+		Token: sign(t, "RS256", "", map[string]interface{}{
+			"sub": "user", "exp": now - 1,
+		}, rsaKey),
+		Error: ErrTokenExpired,
+	}, {
+		Name: "error, not valid yet",
+		Token: sign(t, "RS256", "", map[string]interface{}{
+			"sub": "user", "nbf": now + 60,
+		}, rsaKey),
+		Error: ErrTokenNotValidYet,
+	}, {
+		Name:  "error, malformed token",
+		Token: "foo.bar",
+		Error: ErrTokenFormat,
+	}}
+	verifier := NewKeyVerifier(
+		rsaKey.Public(), ecKey.Public(), ec384Key.Public(),
+		ec521Key.Public(), edKey.Public(),
+	)
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			err := verifier.Verify(tc.Token)
+			if tc.Error != nil {
+				assert.EqualError(t, err, tc.Error.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestParsePublicKeys(t *testing.T) {
+	t.Parallel()
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	ecDER, _ := x509.MarshalPKIXPublicKey(ecKey.Public())
+	data := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PUBLIC KEY",
+		Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey),
+	})
+	data = append(data, pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: ecDER,
+	})...)
+
+	keys, err := ParsePublicKeys(data)
+	if assert.NoError(t, err) && assert.Len(t, keys, 2) {
+		assert.Equal(t, &rsaKey.PublicKey, keys[0])
+		assert.Equal(t, ecKey.Public(), keys[1])
+	}
+
+	_, err = ParsePublicKeys([]byte("not a key"))
+	assert.EqualError(t, err, ErrNoKeys.Error())
+
+	_, err = ParsePublicKeys(pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: []byte("garbage"),
+	}))
+	assert.Error(t, err)
+}
+
+func TestJWKSVerifier(t *testing.T) {
+	t.Parallel()
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	edPub := edKey.Public().(ed25519.PublicKey)
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "rsa",
+			"use": "sig",
+			"n":   b64(rsaKey.N.Bytes()),
+			"e":   b64([]byte{1, 0, 1}),
+		}, {
+			"kty": "EC",
+			"kid": "ec",
+			"crv": "P-256",
+			"x":   b64(ecKey.X.Bytes()),
+			"y":   b64(ecKey.Y.Bytes()),
+		}, {
+			"kty": "OKP",
+			"kid": "ed",
+			"crv": "Ed25519",
+			"x":   b64(edPub),
+		}, {
+			// unsupported keys are skipped
+			"kty": "oct",
+			"kid": "hmac",
+			"k":   b64([]byte("secret")),
+		}},
+	}
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			_ = json.NewEncoder(w).Encode(jwks)
+		},
+	))
+	defer srv.Close()
+
+	claims := map[string]interface{}{"sub": "user"}
+	verifier := NewJWKSVerifier(srv.URL, srv.Client())
+
+	assert.NoError(t, verifier.Verify(sign(t, "RS256", "rsa", claims, rsaKey)))
+	assert.NoError(t, verifier.Verify(sign(t, "ES256", "ec", claims, ecKey)))
+	assert.NoError(t, verifier.Verify(sign(t, "EdDSA", "ed", claims, edKey)))
+	assert.NoError(t, verifier.Verify(sign(t, "EdDSA", "", claims, edKey)))
+	assert.EqualError(t,
+		verifier.Verify(sign(t, "RS256", "ec", claims, rsaKey)),
+		ErrTokenSignature.Error(),
+	)
+	// Unknown key ID triggers a refresh at most once per interval
+	assert.EqualError(t,
+		verifier.Verify(sign(t, "RS256", "unknown", claims, rsaKey)),
+		ErrTokenSignature.Error(),
+	)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	srv404 := httptest.NewServer(http.NotFoundHandler())
+	defer srv404.Close()
+	unavailable := NewJWKSVerifier(srv404.URL, nil)
+	assert.Error(t, unavailable.Verify(sign(t, "RS256", "rsa", claims, rsaKey)))
+}
+
+func TestJWKSVerifierRefresh(t *testing.T) {
+	t.Parallel()
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk := func(kid string, key *ecdsa.PrivateKey) map[string]string {
+		return map[string]string{
+			"kty": "EC",
+			"kid": kid,
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+		}
+	}
+	var (
+		jwks    atomic.Value
+		blocked = make(chan struct{})
+		release = make(chan struct{})
+	)
+	jwks.Store([]map[string]string{jwk("old", oldKey)})
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			keys := jwks.Load().([]map[string]string)
+			if len(keys) > 1 {
+				// the key rotation is published slowly
+				blocked <- struct{}{}
+				<-release
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+		},
+	))
+	defer srv.Close()
+
+	now := time.Now()
+	verifier := NewJWKSVerifier(srv.URL, srv.Client()).(*jwksVerifier)
+	verifier.now = func() time.Time { return now }
+	claims := map[string]interface{}{"sub": "user"}
+	assert.NoError(t, verifier.Verify(sign(t, "ES256", "old", claims, oldKey)))
+
+	// the refresh for the unknown key does not block the known keys
+	jwks.Store([]map[string]string{jwk("old", oldKey), jwk("new", newKey)})
+	now = now.Add(jwksRefreshInterval)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- verifier.Verify(sign(t, "ES256", "new", claims, newKey))
+	}()
+	<-blocked
+	assert.NoError(t, verifier.Verify(sign(t, "ES256", "old", claims, oldKey)))
+	close(release)
+	assert.NoError(t, <-errs)
+
+	// the removed keys are no longer trusted once the key set is stale
+	jwks.Store([]map[string]string{jwk("new", newKey)})
+	now = now.Add(jwksMaxAge)
+	_ = verifier.Verify(sign(t, "ES256", "new", claims, newKey))
+	verifier.mu.Lock()
+	done := verifier.refreshing
+	verifier.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+	assert.EqualError(t,
+		verifier.Verify(sign(t, "ES256", "old", claims, oldKey)),
+		ErrTokenSignature.Error(),
+	)
+}
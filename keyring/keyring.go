@@ -0,0 +1,175 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package keyring encrypts the secrets stored in the database with
+// versioned AES-256-GCM keys.
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// prefix marks the encrypted values: "enc2:<key id>:<base64 payload>",
+	// where the payload is the nonce followed by the ciphertext. The values
+	// are bound to their tenant: the tenant ID is the additional data.
+	prefix = "enc2:"
+	// legacyPrefix marks the values encrypted before they were bound to
+	// their tenant, see Legacy
+	legacyPrefix = "enc:"
+	keyLength    = 32
+)
+
+var (
+	ErrInvalidKey = errors.New("keyring: invalid key, " +
+		"expected <id>:<base64 encoded 32 bytes key>")
+	ErrUnknownKey = errors.New("keyring: unknown key")
+	ErrMalformed  = errors.New("keyring: malformed encrypted value")
+	ErrDecrypt    = errors.New("keyring: failed to decrypt value")
+)
+
+// Keyring encrypts the values with the current key and decrypts the values
+// encrypted with any of its keys. A nil Keyring, or a Keyring without a
+// current key, leaves the values in plaintext.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// Parse returns the keyring of the keys in the "<id>:<base64 key>" form,
+// encrypting with the key identified by current; if current is empty the
+// values are stored in plaintext.
+func Parse(current string, keys []string) (*Keyring, error) {
+	k := &Keyring{
+		current: current,
+		keys:    make(map[string]cipher.AEAD, len(keys)),
+	}
+	for _, key := range keys {
+		i := strings.Index(key, ":")
+		if i <= 0 {
+			return nil, ErrInvalidKey
+		}
+		b, err := base64.StdEncoding.DecodeString(key[i+1:])
+		if err != nil || len(b) != keyLength {
+			return nil, errors.Wrapf(ErrInvalidKey, "key %q", key[:i])
+		}
+		block, err := aes.NewCipher(b)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[key[:i]] = gcm
+	}
+	if _, ok := k.keys[current]; current != "" && !ok {
+		return nil, errors.Wrapf(ErrUnknownKey, "%q", current)
+	}
+	return k, nil
+}
+
+// Current returns the ID of the key encrypting the values, or an empty
+// string if the values are stored in plaintext
+func (k *Keyring) Current() string {
+	if k == nil {
+		return ""
+	}
+	return k.current
+}
+
+// splitValue returns the prefix of the encrypted value, or an empty
+// string if the value is in plaintext, and the rest of the value
+func splitValue(value string) (string, string) {
+	for _, p := range []string{prefix, legacyPrefix} {
+		if strings.HasPrefix(value, p) {
+			return p, value[len(p):]
+		}
+	}
+	return "", value
+}
+
+// KeyID returns the ID of the key which encrypted the value, or an empty
+// string if the value is in plaintext
+func KeyID(value string) string {
+	p, value := splitValue(value)
+	if p == "" {
+		return ""
+	}
+	if i := strings.Index(value, ":"); i > 0 {
+		return value[:i]
+	}
+	return ""
+}
+
+// Legacy returns whether the value was encrypted without being bound to
+// its tenant. Such values are still decrypted, until re-encrypted.
+func Legacy(value string) bool {
+	p, _ := splitValue(value)
+	return p == legacyPrefix
+}
+
+// Encrypt encrypts the value of the tenant with the current key; empty
+// values are not encrypted
+func (k *Keyring) Encrypt(value, tenantID string) (string, error) {
+	if k.Current() == "" || value == "" {
+		return value, nil
+	}
+	gcm := k.keys[k.current]
+	payload := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(value)+gcm.Overhead())
+	if _, err := io.ReadFull(rand.Reader, payload); err != nil {
+		return "", err
+	}
+	payload = gcm.Seal(payload, payload, []byte(value), []byte(tenantID))
+	return prefix + k.current + ":" + base64.StdEncoding.EncodeToString(payload), nil
+}
+
+// Decrypt returns the plaintext of the value of the tenant encrypted with
+// any of the keys; it fails if the value belongs to another tenant. Values
+// in plaintext are returned as they are.
+func (k *Keyring) Decrypt(value, tenantID string) (string, error) {
+	id := KeyID(value)
+	if id == "" {
+		return value, nil
+	}
+	var gcm cipher.AEAD
+	if k != nil {
+		gcm = k.keys[id]
+	}
+	if gcm == nil {
+		return "", errors.Wrapf(ErrUnknownKey, "%q", id)
+	}
+	p, rest := splitValue(value)
+	payload, err := base64.StdEncoding.DecodeString(rest[len(id)+1:])
+	if err != nil || len(payload) < gcm.NonceSize() {
+		return "", ErrMalformed
+	}
+	var additionalData []byte
+	if p == prefix {
+		additionalData = []byte(tenantID)
+	}
+	nonce, ciphertext := payload[:gcm.NonceSize()], payload[gcm.NonceSize():]
+	b, err := gcm.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(b), nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	key1 = "v1:" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("1", 32)))
+	key2 = "v2:" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("2", 32)))
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		Name string
+
+		Current string
+		Keys    []string
+
+		Error error
+	}{{
+		Name: "ok",
+
+		Current: "v2",
+		Keys:    []string{key1, key2},
+	}, {
+		Name: "ok, plaintext",
+
+		Keys: []string{key1},
+	}, {
+		Name: "error, unknown current key",
+
+		Current: "v3",
+		Keys:    []string{key1, key2},
+
+		Error: ErrUnknownKey,
+	}, {
+		Name: "error, missing ID",
+
+		Keys: []string{":" + strings.Split(key1, ":")[1]},
+
+		Error: ErrInvalidKey,
+	}, {
+		Name: "error, short key",
+
+		Keys: []string{"v1:" + base64.StdEncoding.EncodeToString([]byte("short"))},
+
+		Error: ErrInvalidKey,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			k, err := Parse(tc.Current, tc.Keys)
+			if tc.Error != nil {
+				assert.ErrorIs(t, err, tc.Error)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, tc.Current, k.Current())
+			}
+		})
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	const value = "HostName=hub.azure-devices.net;" +
+		"SharedAccessKeyName=registryReadWrite;SharedAccessKey=c2VjcmV0"
+
+	old, err := Parse("v1", []string{key1})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	encrypted, err := old.Encrypt(value, "tenant1")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encrypted, "enc2:v1:"))
+	assert.Equal(t, "v1", KeyID(encrypted))
+	again, _ := old.Encrypt(value, "tenant1")
+	assert.NotEqual(t, encrypted, again, "the nonce is not random")
+
+	// the rotated keyring decrypts the values of the previous key
+	k, err := Parse("v2", []string{key1, key2})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	decrypted, err := k.Decrypt(encrypted, "tenant1")
+	assert.NoError(t, err)
+	assert.Equal(t, value, decrypted)
+	reencrypted, err := k.Encrypt(decrypted, "tenant1")
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", KeyID(reencrypted))
+
+	// plaintext and empty values pass through
+	plain, err := k.Decrypt(value, "tenant1")
+	assert.NoError(t, err)
+	assert.Equal(t, value, plain)
+	assert.Equal(t, "", KeyID(value))
+	empty, err := k.Encrypt("", "tenant1")
+	assert.NoError(t, err)
+	assert.Equal(t, "", empty)
+	var nilKeyring *Keyring
+	plain, err = nilKeyring.Encrypt(value, "tenant1")
+	assert.NoError(t, err)
+	assert.Equal(t, value, plain)
+
+	_, err = old.Decrypt(reencrypted, "tenant1")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	_, err = nilKeyring.Decrypt(reencrypted, "tenant1")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	_, err = k.Decrypt("enc2:v1:!!!", "tenant1")
+	assert.ErrorIs(t, err, ErrMalformed)
+	tampered := encrypted[:len(encrypted)-4] + "AAAA"
+	_, err = k.Decrypt(tampered, "tenant1")
+	assert.ErrorIs(t, err, ErrDecrypt)
+
+	// the values are bound to their tenant
+	_, err = k.Decrypt(encrypted, "tenant2")
+	assert.ErrorIs(t, err, ErrDecrypt)
+	assert.False(t, Legacy(encrypted))
+}
+
+func TestDecryptLegacy(t *testing.T) {
+	const value = "HostName=hub.azure-devices.net;" +
+		"SharedAccessKeyName=registryReadWrite;SharedAccessKey=c2VjcmV0"
+
+	// a value encrypted without the tenant as additional data
+	b, _ := base64.StdEncoding.DecodeString(strings.SplitN(key1, ":", 2)[1])
+	block, _ := aes.NewCipher(b)
+	gcm, _ := cipher.NewGCM(block)
+	nonce := make([]byte, gcm.NonceSize())
+	legacy := "enc:v1:" + base64.StdEncoding.EncodeToString(
+		gcm.Seal(nonce, nonce, []byte(value), nil),
+	)
+	assert.True(t, Legacy(legacy))
+	assert.Equal(t, "v1", KeyID(legacy))
+
+	k, err := Parse("v1", []string{key1})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	decrypted, err := k.Decrypt(legacy, "tenant1")
+	assert.NoError(t, err)
+	assert.Equal(t, value, decrypted)
+}
@@ -0,0 +1,261 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package logging extends the global logger with log levels set per
+// component of the service. The component of a log entry is derived from
+// the package logging it and recorded in the "component" field; entries
+// less severe than the level of their component are discarded. The
+// secrets (shared access keys, SAS tokens) are scrubbed from all entries.
+package logging
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+)
+
+const (
+	ComponentAPI             = "api"
+	ComponentApp             = "app"
+	ComponentStore           = "store"
+	ComponentAzureClient     = "azure_client"
+	ComponentAuditLogsClient = "auditlogs_client"
+	ComponentSecrets         = "secrets"
+	ComponentServer          = "server"
+
+	// FormatText is the logfmt-like format of the log entries
+	FormatText = "text"
+	// FormatJSON formats the log entries as JSON objects, one per line
+	FormatJSON = "json"
+
+	// KeyComponent is the log field holding the component
+	KeyComponent = "component"
+
+	modulePath = "github.com/mendersoftware/azure-iot-manager"
+)
+
+var (
+	// Components are the components with a configurable log level
+	Components = []string{
+		ComponentAPI,
+		ComponentApp,
+		ComponentStore,
+		ComponentAzureClient,
+		ComponentAuditLogsClient,
+		ComponentSecrets,
+		ComponentServer,
+	}
+
+	packageComponents = []struct {
+		pkg       string
+		component string
+	}{
+		{modulePath + "/api", ComponentAPI},
+		{"github.com/mendersoftware/go-lib-micro/accesslog", ComponentAPI},
+		{modulePath + "/app", ComponentApp},
+		{modulePath + "/store", ComponentStore},
+		{modulePath + "/client/iothub", ComponentAzureClient},
+		{modulePath + "/client/auditlogs", ComponentAuditLogsClient},
+		{modulePath + "/secrets", ComponentSecrets},
+		{modulePath + "/server", ComponentServer},
+	}
+
+	ErrUnknownComponent = errors.New("unknown component")
+	ErrUnknownFormat    = errors.New("unknown log format")
+
+	mu              sync.RWMutex
+	defaultLevel    = logrus.InfoLevel
+	componentLevels = map[string]logrus.Level{}
+	setupOnce       sync.Once
+)
+
+// Levels are the log levels, by name (e.g. "debug"): Default applies to
+// the components without a level of their own.
+type Levels struct {
+	Default    string            `json:"default"`
+	Components map[string]string `json:"components"`
+}
+
+// Setup installs the per-component log levels and the redaction of the
+// secrets on the global logger
+func Setup() {
+	setupOnce.Do(func() {
+		log.Log.AddHook(redactHook{})
+		log.Log.AddHook(componentHook{})
+		log.Log.SetFormatter(&levelFilter{Formatter: log.Log.Formatter})
+	})
+}
+
+// SetFormat sets the format of the log entries, FormatText or FormatJSON
+func SetFormat(format string) error {
+	var formatter logrus.Formatter
+	switch format {
+	case FormatText:
+		formatter = &logrus.TextFormatter{FullTimestamp: true}
+	case FormatJSON:
+		formatter = &logrus.JSONFormatter{}
+	default:
+		return errors.Wrap(ErrUnknownFormat, format)
+	}
+	if _, ok := log.Log.Formatter.(*levelFilter); ok {
+		formatter = &levelFilter{Formatter: formatter}
+	}
+	log.Log.SetFormatter(formatter)
+	return nil
+}
+
+// SetLevels replaces the log levels; the levels are left unchanged if any
+// of them is invalid.
+func SetLevels(levels Levels) error {
+	def, err := logrus.ParseLevel(levels.Default)
+	if err != nil {
+		return errors.Wrap(err, "default")
+	}
+	components := make(map[string]logrus.Level, len(levels.Components))
+	for component, name := range levels.Components {
+		if !knownComponent(component) {
+			return errors.Wrap(ErrUnknownComponent, component)
+		}
+		level, err := logrus.ParseLevel(name)
+		if err != nil {
+			return errors.Wrap(err, component)
+		}
+		components[component] = level
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	defaultLevel = def
+	componentLevels = components
+	// the global level lets through the entries of the most verbose
+	// component, the others are filtered by levelFilter
+	max := def
+	for _, level := range components {
+		if level > max {
+			max = level
+		}
+	}
+	log.Log.SetLevel(max)
+	return nil
+}
+
+// GetLevels returns the current log levels
+func GetLevels() Levels {
+	mu.RLock()
+	defer mu.RUnlock()
+	levels := Levels{
+		Default:    defaultLevel.String(),
+		Components: make(map[string]string, len(componentLevels)),
+	}
+	for component, level := range componentLevels {
+		levels.Components[component] = level.String()
+	}
+	return levels
+}
+
+func knownComponent(component string) bool {
+	for _, c := range Components {
+		if c == component {
+			return true
+		}
+	}
+	return false
+}
+
+func enabled(component string, level logrus.Level) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	max, ok := componentLevels[component]
+	if !ok {
+		max = defaultLevel
+	}
+	return level <= max
+}
+
+// componentHook records the component of the caller in the log entries
+type componentHook struct{}
+
+func (componentHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (componentHook) Fire(entry *logrus.Entry) error {
+	if _, ok := entry.Data[KeyComponent]; ok {
+		return nil
+	}
+	if component := callerComponent(); component != "" {
+		entry.Data[KeyComponent] = component
+	}
+	return nil
+}
+
+// callerComponent returns the component of the first caller outside of the
+// logging libraries
+func callerComponent() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		pkg := packageName(frame.Function)
+		switch {
+		case strings.HasPrefix(pkg, "github.com/sirupsen/logrus"),
+			pkg == "github.com/mendersoftware/go-lib-micro/log",
+			pkg == modulePath+"/logging":
+		default:
+			return component(pkg)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// packageName returns the import path of the package of the function,
+// e.g. "example.com/pkg" for "example.com/pkg.(*T).Method.func1"
+func packageName(function string) string {
+	slash := strings.LastIndex(function, "/")
+	if dot := strings.Index(function[slash+1:], "."); dot >= 0 {
+		return function[:slash+1+dot]
+	}
+	return function
+}
+
+func component(pkg string) string {
+	for _, pc := range packageComponents {
+		if pkg == pc.pkg || strings.HasPrefix(pkg, pc.pkg+"/") {
+			return pc.component
+		}
+	}
+	return ""
+}
+
+// levelFilter discards the entries less severe than the level of their
+// component
+type levelFilter struct {
+	logrus.Formatter
+}
+
+func (f *levelFilter) Format(entry *logrus.Entry) ([]byte, error) {
+	component, _ := entry.Data[KeyComponent].(string)
+	if !enabled(component, entry.Level) {
+		return nil, nil
+	}
+	return f.Formatter.Format(entry)
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package logging
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+)
+
+func TestComponent(t *testing.T) {
+	testCases := map[string]string{
+		modulePath + "/api/http.AuditMiddleware.func1":                ComponentAPI,
+		modulePath + "/store/mongo.(*DataStoreMongo).Ping":            ComponentStore,
+		modulePath + "/client/iothub.(*client).do":                    ComponentAzureClient,
+		modulePath + "/app.(*app).ForwardAuditLogs":                   ComponentApp,
+		"github.com/mendersoftware/go-lib-micro/accesslog.Middleware": ComponentAPI,
+		modulePath + "/apix.Foo":                                      "",
+		"main.main":                                                   "",
+	}
+	for function, expected := range testCases {
+		assert.Equal(t, expected, component(packageName(function)), function)
+	}
+}
+
+func TestSetLevels(t *testing.T) {
+	defer func(levels Levels) {
+		_ = SetLevels(levels)
+	}(GetLevels())
+
+	err := SetLevels(Levels{Default: "info", Components: map[string]string{
+		ComponentStore: "debug",
+		ComponentAPI:   "error",
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, Levels{Default: "info", Components: map[string]string{
+		ComponentStore: "debug",
+		ComponentAPI:   "error",
+	}}, GetLevels())
+	assert.Equal(t, logrus.DebugLevel, log.Log.GetLevel())
+
+	err = SetLevels(Levels{Default: "verbose"})
+	assert.EqualError(t, err, `default: not a valid logrus Level: "verbose"`)
+	err = SetLevels(Levels{Default: "info", Components: map[string]string{
+		"workers": "debug",
+	}})
+	assert.EqualError(t, err, "workers: unknown component")
+	assert.Equal(t, "debug", GetLevels().Components[ComponentStore])
+
+	err = SetLevels(Levels{Default: "warning"})
+	assert.NoError(t, err)
+	assert.Equal(t, Levels{Default: "warning", Components: map[string]string{}},
+		GetLevels())
+	assert.Equal(t, logrus.WarnLevel, log.Log.GetLevel())
+}
+
+func TestLevelFilter(t *testing.T) {
+	defer func(levels Levels) {
+		_ = SetLevels(levels)
+	}(GetLevels())
+	out := log.Log.Out
+	defer func() { log.Log.SetOutput(out) }()
+	var buf bytes.Buffer
+	log.Log.SetOutput(&buf)
+	Setup()
+	Setup()
+
+	err := SetLevels(Levels{Default: "info", Components: map[string]string{
+		ComponentStore: "debug",
+		ComponentAPI:   "error",
+	}})
+	assert.NoError(t, err)
+
+	logger := func(component string) *log.Logger {
+		return log.NewEmpty().F(log.Ctx{KeyComponent: component})
+	}
+	logger(ComponentStore).Debug("store debug")
+	logger(ComponentAPI).Warn("api warning")
+	logger(ComponentAPI).Error("api error")
+	logger(ComponentApp).Debug("app debug")
+	logger(ComponentApp).Info("app info")
+
+	output := buf.String()
+	assert.Contains(t, output, "store debug")
+	assert.NotContains(t, output, "api warning")
+	assert.Contains(t, output, "api error")
+	assert.NotContains(t, output, "app debug")
+	assert.Contains(t, output, "app info")
+	assert.Contains(t, output, "component=store")
+}
+
+func TestSetFormat(t *testing.T) {
+	formatter := log.Log.Formatter
+	defer log.Log.SetFormatter(formatter)
+	out := log.Log.Out
+	defer func() { log.Log.SetOutput(out) }()
+	var buf bytes.Buffer
+	log.Log.SetOutput(&buf)
+	Setup()
+
+	err := SetFormat("xml")
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+
+	err = SetFormat(FormatJSON)
+	assert.NoError(t, err)
+	assert.IsType(t, &levelFilter{}, log.Log.Formatter)
+	log.NewEmpty().F(log.Ctx{"tenant_id": "tenant1"}).Warn("hello")
+	assert.Contains(t, buf.String(), `"tenant_id":"tenant1"`)
+	assert.Contains(t, buf.String(), `"msg":"hello"`)
+}
@@ -15,17 +15,34 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
 
 	"github.com/mendersoftware/go-lib-micro/config"
+	"github.com/mendersoftware/go-lib-micro/identity"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 
+	"github.com/mendersoftware/azure-iot-manager/app"
+	"github.com/mendersoftware/azure-iot-manager/bundle"
+	"github.com/mendersoftware/azure-iot-manager/client/iothub"
+	"github.com/mendersoftware/azure-iot-manager/clock"
 	dconfig "github.com/mendersoftware/azure-iot-manager/config"
+	"github.com/mendersoftware/azure-iot-manager/doctor"
+	"github.com/mendersoftware/azure-iot-manager/model"
+	"github.com/mendersoftware/azure-iot-manager/secrets"
+	"github.com/mendersoftware/azure-iot-manager/seed"
 	"github.com/mendersoftware/azure-iot-manager/server"
 	store "github.com/mendersoftware/azure-iot-manager/store/mongo"
+	"github.com/mendersoftware/azure-iot-manager/version"
 )
 
 func main() {
@@ -51,6 +68,25 @@ func doMain(args []string) {
 				Name:   "server",
 				Usage:  "Run the HTTP API server",
 				Action: cmdServer,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "automigrate",
+						Usage: "Run database migrations before starting.",
+					},
+					&cli.BoolFlag{
+						Name: "azure-emulator",
+						Usage: "Use an in-memory IoT Hub emulator " +
+							"instead of Azure (for testing and demos).",
+					},
+				},
+			},
+			{
+				Name:  "worker",
+				Usage: "Run the background workers without the HTTP API",
+				Description: "Runs the forwarding and the purge of the " +
+					"audit logs, for the deployments running the " +
+					"server with workers.embedded disabled.",
+				Action: cmdWorker,
 				Flags: []cli.Flag{
 					&cli.BoolFlag{
 						Name:  "automigrate",
@@ -62,13 +98,165 @@ func doMain(args []string) {
 				Name:   "migrate",
 				Usage:  "Run the migrations",
 				Action: cmdMigrate,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name: "dry-run",
+						Usage: "Print the migrations and the index " +
+							"builds which would run, without " +
+							"applying them.",
+					},
+				},
+			},
+			{
+				Name:  "check-integration",
+				Usage: "Check the integration with the Azure IoT Hub",
+				Description: "Loads the settings of the tenant and " +
+					"verifies the connectivity to the IoT Hub and the " +
+					"permissions of the configured shared access policy.",
+				Action: cmdCheckIntegration,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "tenant",
+						Usage: "ID of the tenant to check.",
+					},
+				},
+			},
+			{
+				Name:  "doctor",
+				Usage: "Diagnose the deployment of the service",
+				Description: "Checks the configuration, the connectivity " +
+					"to the database, the migrations, the indexes, the " +
+					"clock skew and the integrations of a sample of " +
+					"the tenants, suggesting how to fix the failures.",
+				Action: cmdDoctor,
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "sample",
+						Usage: "Number of integrations to check.",
+						Value: 10,
+					},
+				},
+			},
+			{
+				Name:  "config",
+				Usage: "Manage the configuration",
+				Subcommands: []cli.Command{
+					{
+						Name: "validate",
+						Usage: "Validate the configuration file " +
+							"and the environment overrides",
+						Action: cmdConfigValidate,
+					},
+				},
+			},
+			{
+				Name:   "list-tenants",
+				Usage:  "List the tenants with a configured integration",
+				Action: cmdListTenants,
+			},
+			{
+				Name:   "seed",
+				Usage:  "Populate the database with fake data for development",
+				Action: cmdSeed,
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "tenants",
+						Usage: "Number of tenants to create.",
+						Value: 10,
+					},
+					&cli.IntFlag{
+						Name:  "audit-logs",
+						Usage: "Number of audit logs per tenant.",
+						Value: 10,
+					},
+					&cli.Int64Flag{
+						Name: "seed",
+						Usage: "Seed of the pseudo-random generator, " +
+							"the same seed produces the same data.",
+						Value: 1,
+					},
+				},
+			},
+			{
+				Name:  "export-data",
+				Usage: "Export the data of a tenant as a JSON bundle",
+				Description: "Exports the integration and the feature " +
+					"flags of the tenant. The connection string is " +
+					"encrypted with the passphrase read from the " +
+					"passphrase file.",
+				Action: cmdExportData,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "tenant",
+						Usage: "ID of the tenant to export.",
+					},
+					&cli.StringFlag{
+						Name: "output",
+						Usage: "Path of the bundle, defaults to the " +
+							"standard output.",
+					},
+					&cli.StringFlag{
+						Name: "passphrase-file",
+						Usage: "Path of the file containing the " +
+							"passphrase.",
+					},
+				},
+			},
+			{
+				Name:  "import-data",
+				Usage: "Import the data of a tenant from a JSON bundle",
+				Description: "Replaces the integration and the feature " +
+					"flags of the tenant with the ones of the bundle.",
+				Action: cmdImportData,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name: "tenant",
+						Usage: "ID of the tenant to import into, " +
+							"defaults to the tenant of the bundle.",
+					},
+					&cli.StringFlag{
+						Name: "input",
+						Usage: "Path of the bundle, defaults to the " +
+							"standard input.",
+					},
+					&cli.StringFlag{
+						Name: "passphrase-file",
+						Usage: "Path of the file containing the " +
+							"passphrase.",
+					},
+				},
+			},
+			{
+				Name:  "reencrypt-secrets",
+				Usage: "Re-encrypt the stored connection strings",
+				Description: "Encrypts the connection strings stored in " +
+					"plaintext, with a previous key or not bound to " +
+					"their tenant with the current key, or decrypts " +
+					"them if no current key is " +
+					"configured. Safe to run while the service is " +
+					"online once all the instances use the current " +
+					"key; run it again to resume an interrupted run.",
+				Action: cmdReencryptSecrets,
+			},
+			{
+				Name:   "version",
+				Usage:  "Show the version and build information",
+				Action: cmdVersion,
 			},
 		},
 	}
 	app.Usage = "Azure IoT Manager"
+	app.Version = version.Version
+	cli.VersionPrinter = func(*cli.Context) {
+		fmt.Println(version.Get())
+	}
 	app.Action = cmdServer
 
 	app.Before = func(args *cli.Context) error {
+		// The version command does not depend on the configuration
+		if args.Args().First() == "version" {
+			return nil
+		}
 		err := config.FromConfigFile(configPath, dconfig.Defaults)
 		if err != nil {
 			return cli.NewExitError(
@@ -77,10 +265,21 @@ func doMain(args []string) {
 		}
 
 		// Enable setting config values by environment variables
-		config.Config.SetEnvPrefix("AZURE_IOT_MANAGER")
+		config.Config.SetEnvPrefix(dconfig.EnvPrefix)
 		config.Config.AutomaticEnv()
 		config.Config.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
 
+		// The configuration is validated as written
+		if args.Args().First() == "config" {
+			return nil
+		}
+		err = secrets.Setup(context.Background(), config.Config)
+		if err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("error resolving secrets: %s", err),
+				1)
+		}
+
 		return nil
 	}
 
@@ -97,9 +296,303 @@ func cmdServer(args *cli.Context) error {
 		return err
 	}
 	defer dataStore.Close()
-	return server.InitAndRun(config.Config, dataStore)
+	opts := server.NewOptions().
+		SetAzureEmulator(args.Bool("azure-emulator"))
+	return server.InitAndRun(config.Config, dataStore, opts)
+}
+
+func cmdWorker(args *cli.Context) error {
+	mgoConfig := store.NewConfig().SetAutomigrate(args.Bool("automigrate"))
+	dataStore, err := store.SetupDataStore(mgoConfig)
+	if err != nil {
+		return err
+	}
+	defer dataStore.Close()
+	opts := server.NewOptions().
+		SetWorkersOnly(true)
+	return server.InitAndRun(config.Config, dataStore, opts)
 }
 
 func cmdMigrate(args *cli.Context) error {
+	if args.Bool("dry-run") {
+		return migrateDryRun()
+	}
+	mgoConfig := store.NewConfig().SetAutomigrate(true)
+	dataStore, err := store.SetupDataStore(mgoConfig)
+	if err != nil {
+		return err
+	}
+	return dataStore.Close()
+}
+
+func migrateDryRun() error {
+	ctx := context.Background()
+	client, err := store.NewClient(ctx, config.Config)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Disconnect(ctx) }()
+
+	plan, err := store.PlanMigrations(ctx, store.DbName, store.DbVersion, client)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("database %s: version %s -> %s\n", plan.Database, plan.From, plan.To)
+	if len(plan.Migrations) == 0 {
+		fmt.Println("  up to date, no migrations to apply")
+		return nil
+	}
+	for _, migration := range plan.Migrations {
+		fmt.Printf("  migration %s\n", migration.Version)
+		for _, idx := range migration.Indexes {
+			if idx.Exists {
+				fmt.Printf("    index %q on %s {%s}: exists, skipped\n",
+					idx.Name, idx.Collection, idx.Keys)
+				continue
+			}
+			fmt.Printf("    build index %q on %s {%s}: ~%d documents\n",
+				idx.Name, idx.Collection, idx.Keys, idx.Documents)
+		}
+	}
+	return nil
+}
+
+func cmdCheckIntegration(args *cli.Context) error {
+	httpClient, err := server.HTTPClient(config.Config)
+	if err != nil {
+		return err
+	}
+	dataStore, err := store.SetupDataStore(store.NewConfig())
+	if err != nil {
+		return err
+	}
+	defer dataStore.Close()
+
+	ctx := identity.WithContext(app.WithInternal(context.Background()), &identity.Identity{
+		Tenant: args.String("tenant"),
+	})
+	report := app.New(app.Config{
+		IoTHub: iothub.NewClient(httpClient, server.IoTHubOptions(config.Config)),
+	}, dataStore).CheckIntegration(ctx)
+	for _, check := range report.Checks {
+		fmt.Printf("[%-7s] %-17s %s\n", check.Status, check.Name, check.Description)
+		if check.Error != "" {
+			fmt.Printf("          %s\n", check.Error)
+		}
+	}
+	if !report.OK() {
+		return cli.NewExitError("integration check failed", 1)
+	}
+	return nil
+}
+
+func cmdDoctor(args *cli.Context) error {
+	ctx := context.Background()
+	opts := doctor.Options{
+		Config:  config.Config,
+		Environ: os.Environ(),
+		Sample:  args.Int("sample"),
+	}
+	client, err := store.NewClient(ctx, config.Config)
+	if err != nil {
+		opts.StoreError = err
+	} else {
+		// the database checks do not need the encryption keys
+		keyring, keyringErr := dconfig.Keyring(config.Config)
+		dataStore := store.NewDataStoreWithClient(client,
+			store.NewConfig().SetKeyring(keyring))
+		defer dataStore.Close()
+		opts.Store = dataStore
+		httpClient, err := server.HTTPClient(config.Config)
+		if keyringErr != nil {
+			opts.AppError = keyringErr
+		} else if err != nil {
+			opts.AppError = err
+		} else {
+			iotHub := iothub.NewClient(httpClient, server.IoTHubOptions(config.Config))
+			opts.App = app.New(app.Config{IoTHub: iotHub}, dataStore)
+		}
+	}
+
+	report := doctor.Run(ctx, opts)
+	for _, check := range report.Checks {
+		fmt.Printf("[%-7s] %-11s %s\n", check.Status, check.Name, check.Description)
+		if check.Error != "" {
+			fmt.Printf("          %s\n", check.Error)
+		}
+		if check.Hint != "" {
+			fmt.Printf("          hint: %s\n", check.Hint)
+		}
+	}
+	if !report.OK() {
+		return cli.NewExitError("diagnostic checks failed", 1)
+	}
+	return nil
+}
+
+func cmdConfigValidate(args *cli.Context) error {
+	errs := dconfig.Validate(config.Config, os.Environ())
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		return cli.NewExitError(
+			fmt.Sprintf("configuration is invalid: %d error(s)", len(errs)),
+			1)
+	}
+	fmt.Println("configuration is valid")
+	return nil
+}
+
+func cmdListTenants(args *cli.Context) error {
+	dataStore, err := store.SetupDataStore(store.NewConfig())
+	if err != nil {
+		return err
+	}
+	defer dataStore.Close()
+
+	settings, err := dataStore.ListSettings(context.Background())
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TENANT\tIOT HUB")
+	for _, s := range settings {
+		tenantID := s.TenantID
+		if tenantID == "" {
+			tenantID = "(default)"
+		}
+		hub := "(invalid connection string)"
+		if cs, err := model.ParseConnectionString(s.ConnectionString); err == nil {
+			hub = cs.MaskedHostName()
+		}
+		fmt.Fprintf(w, "%s\t%s\n", tenantID, hub)
+	}
+	return w.Flush()
+}
+
+func cmdSeed(args *cli.Context) error {
+	for _, flag := range []string{"tenants", "audit-logs"} {
+		if args.Int(flag) < 0 {
+			return cli.NewExitError(
+				fmt.Sprintf("invalid --%s: must not be negative", flag), 2)
+		}
+	}
+	dataStore, err := store.SetupDataStore(store.NewConfig())
+	if err != nil {
+		return err
+	}
+	defer dataStore.Close()
+
+	tenants, err := seed.Seed(context.Background(), dataStore, seed.Options{
+		Tenants:   args.Int("tenants"),
+		AuditLogs: args.Int("audit-logs"),
+		Seed:      args.Int64("seed"),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created %d tenants\n", len(tenants))
+	return nil
+}
+
+// readPassphrase returns the passphrase stored in the file at path,
+// without the trailing newline
+func readPassphrase(path string) ([]byte, error) {
+	if path == "" {
+		return nil, errors.New("missing passphrase file")
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the passphrase")
+	}
+	return bytes.TrimRight(b, "\r\n"), nil
+}
+
+func cmdExportData(args *cli.Context) error {
+	passphrase, err := readPassphrase(args.String("passphrase-file"))
+	if err != nil {
+		return err
+	}
+	dataStore, err := store.SetupDataStore(store.NewConfig())
+	if err != nil {
+		return err
+	}
+	defer dataStore.Close()
+
+	b, err := bundle.Export(context.Background(), dataStore, clock.New(),
+		args.String("tenant"), passphrase)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path := args.String("output"); path != "" {
+		return ioutil.WriteFile(path, data, 0600)
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+func cmdImportData(args *cli.Context) error {
+	passphrase, err := readPassphrase(args.String("passphrase-file"))
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if path := args.String("input"); path != "" {
+		data, err = ioutil.ReadFile(path)
+	} else {
+		data, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to read the bundle")
+	}
+	var b bundle.Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return errors.Wrap(err, "malformed bundle")
+	}
+
+	dataStore, err := store.SetupDataStore(store.NewConfig())
+	if err != nil {
+		return err
+	}
+	defer dataStore.Close()
+
+	err = bundle.Import(context.Background(), dataStore, &b,
+		args.String("tenant"), passphrase)
+	if err != nil {
+		return err
+	}
+	fmt.Println("bundle imported")
+	return nil
+}
+
+func cmdReencryptSecrets(args *cli.Context) error {
+	dataStore, err := store.SetupDataStore(store.NewConfig())
+	if err != nil {
+		return err
+	}
+	defer dataStore.Close()
+
+	result, err := dataStore.ReencryptSettings(context.Background())
+	fmt.Printf("checked %d settings, re-encrypted %d\n",
+		result.Checked, result.Reencrypted)
+	if err != nil {
+		return err
+	}
+	if result.Modified > 0 {
+		return cli.NewExitError(fmt.Sprintf(
+			"%d settings were modified concurrently, run the command again",
+			result.Modified), 1)
+	}
+	return nil
+}
+
+func cmdVersion(args *cli.Context) error {
+	cli.VersionPrinter(args)
 	return nil
 }
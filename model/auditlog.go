@@ -0,0 +1,70 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	AuditActorTypeUser = "user"
+
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
+
+// AuditLog is the record of a mutation performed through the management API
+type AuditLog struct {
+	ID       uuid.UUID   `json:"id" bson:"_id"`
+	TenantID string      `json:"-" bson:"tenant_id"`
+	Actor    AuditActor  `json:"actor" bson:"actor"`
+	Action   string      `json:"action" bson:"action"`
+	Object   AuditObject `json:"object" bson:"object"`
+	Outcome  string      `json:"outcome" bson:"outcome"`
+	Time     time.Time   `json:"time" bson:"time"`
+
+	// Forwarded is true once the record is submitted to the auditlogs
+	// service.
+	Forwarded bool `json:"-" bson:"forwarded"`
+}
+
+// AuditActor identifies who performed the action
+type AuditActor struct {
+	ID   string `json:"id" bson:"id"`
+	Type string `json:"type" bson:"type"`
+}
+
+// AuditObject identifies the object of the action
+type AuditObject struct {
+	ID   string `json:"id,omitempty" bson:"id,omitempty"`
+	Type string `json:"type" bson:"type"`
+}
+
+// AuditLogFilter selects the audit logs to export
+type AuditLogFilter struct {
+	// TenantID restricts the logs to the tenant; nil selects the logs of
+	// all the tenants.
+	TenantID *string
+	// From and To restrict the logs to the time range [From, To); zero
+	// values leave the range open.
+	From time.Time
+	To   time.Time
+}
@@ -0,0 +1,134 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	csKeyHostName            = "HostName"
+	csKeyDeviceID            = "DeviceId"
+	csKeySharedAccessKeyName = "SharedAccessKeyName"
+	csKeySharedAccessKey     = "SharedAccessKey"
+)
+
+var (
+	ErrConnectionStringMalformed = errors.New("connection string is malformed")
+)
+
+// ConnectionString is the parsed representation of an Azure IoT Hub
+// connection string, e.g.:
+// HostName=myhub.azure-devices.net;SharedAccessKeyName=iothubowner;SharedAccessKey=...
+type ConnectionString struct {
+	HostName string
+	DeviceID string
+	Name     string
+	Key      []byte
+}
+
+// ParseConnectionString parses and validates an IoT Hub connection string
+func ParseConnectionString(connection string) (*ConnectionString, error) {
+	var cs ConnectionString
+	for _, attr := range strings.Split(connection, ";") {
+		if attr == "" {
+			continue
+		}
+		i := strings.Index(attr, "=")
+		if i < 0 {
+			return nil, errors.Wrapf(ErrConnectionStringMalformed,
+				"invalid attribute %q", attr)
+		}
+		key, value := attr[:i], attr[i+1:]
+		switch key {
+		case csKeyHostName:
+			cs.HostName = value
+		case csKeyDeviceID:
+			cs.DeviceID = value
+		case csKeySharedAccessKeyName:
+			cs.Name = value
+		case csKeySharedAccessKey:
+			b, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, errors.Wrap(ErrConnectionStringMalformed,
+					"shared access key is not valid base64")
+			}
+			cs.Key = b
+		default:
+			return nil, errors.Wrapf(ErrConnectionStringMalformed,
+				"unknown attribute %q", key)
+		}
+	}
+	if err := cs.Validate(); err != nil {
+		return nil, err
+	}
+	return &cs, nil
+}
+
+// Validate checks that the connection string contains the attributes
+// required for authenticating with the IoT Hub.
+func (cs ConnectionString) Validate() error {
+	switch {
+	case cs.HostName == "":
+		return errors.Wrap(ErrConnectionStringMalformed, "missing HostName")
+	case len(cs.Key) == 0:
+		return errors.Wrap(ErrConnectionStringMalformed, "missing SharedAccessKey")
+	case cs.Name == "" && cs.DeviceID == "":
+		return errors.Wrap(ErrConnectionStringMalformed,
+			"missing SharedAccessKeyName or DeviceId")
+	}
+	return nil
+}
+
+// MaskedHostName returns the hub host name with the hub name partially
+// masked, e.g. "my*****.azure-devices.net", suitable for logs and reports.
+func (cs ConnectionString) MaskedHostName() string {
+	name, domain := cs.HostName, ""
+	if i := strings.Index(name, "."); i >= 0 {
+		name, domain = name[:i], name[i:]
+	}
+	visible := len(name) / 4
+	return name[:visible] + strings.Repeat("*", len(name)-visible) + domain
+}
+
+// Authorization returns a shared access signature token valid until
+// expireAt, for use in the Authorization header of IoT Hub requests.
+func (cs ConnectionString) Authorization(expireAt time.Time) string {
+	resource := url.QueryEscape(strings.ToLower(cs.HostName))
+	if cs.DeviceID != "" {
+		resource = url.QueryEscape(
+			strings.ToLower(cs.HostName) + "/devices/" + cs.DeviceID,
+		)
+	}
+	expiry := strconv.FormatInt(expireAt.Unix(), 10)
+	mac := hmac.New(sha256.New, cs.Key)
+	_, _ = mac.Write([]byte(resource + "\n" + expiry))
+	sig := url.QueryEscape(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	token := fmt.Sprintf("SharedAccessSignature sr=%s&sig=%s&se=%s",
+		resource, sig, expiry)
+	if cs.Name != "" {
+		token += "&skn=" + url.QueryEscape(cs.Name)
+	}
+	return token
+}
@@ -0,0 +1,157 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testKey = "c2VjcmV0IGtleSBmb3IgdGVzdGluZw=="
+
+func TestParseConnectionString(t *testing.T) {
+	testCases := []struct {
+		Name string
+
+		ConnectionString string
+
+		Result *ConnectionString
+		Error  string
+	}{
+		{
+			Name: "ok, shared access policy",
+
+			ConnectionString: "HostName=myhub.azure-devices.net;" +
+				"SharedAccessKeyName=iothubowner;SharedAccessKey=" + testKey,
+
+			Result: &ConnectionString{
+				HostName: "myhub.azure-devices.net",
+				Name:     "iothubowner",
+				Key:      []byte("secret key for testing"),
+			},
+		},
+		{
+			Name: "ok, device",
+
+			ConnectionString: "HostName=myhub.azure-devices.net;" +
+				"DeviceId=dev1;SharedAccessKey=" + testKey + ";",
+
+			Result: &ConnectionString{
+				HostName: "myhub.azure-devices.net",
+				DeviceID: "dev1",
+				Key:      []byte("secret key for testing"),
+			},
+		},
+		{
+			Name: "error, missing host name",
+
+			ConnectionString: "SharedAccessKeyName=iothubowner;" +
+				"SharedAccessKey=" + testKey,
+
+			Error: "missing HostName: connection string is malformed",
+		},
+		{
+			Name: "error, missing key",
+
+			ConnectionString: "HostName=myhub.azure-devices.net;" +
+				"SharedAccessKeyName=iothubowner",
+
+			Error: "missing SharedAccessKey: connection string is malformed",
+		},
+		{
+			Name: "error, missing key name",
+
+			ConnectionString: "HostName=myhub.azure-devices.net;" +
+				"SharedAccessKey=" + testKey,
+
+			Error: "missing SharedAccessKeyName or DeviceId: " +
+				"connection string is malformed",
+		},
+		{
+			Name: "error, invalid key",
+
+			ConnectionString: "HostName=myhub.azure-devices.net;" +
+				"SharedAccessKeyName=iothubowner;SharedAccessKey=!!!",
+
+			Error: "shared access key is not valid base64: " +
+				"connection string is malformed",
+		},
+		{
+			Name: "error, unknown attribute",
+
+			ConnectionString: "HostName=myhub.azure-devices.net;Foo=bar",
+
+			Error: `unknown attribute "Foo": connection string is malformed`,
+		},
+		{
+			Name: "error, invalid attribute",
+
+			ConnectionString: "my://connection.string",
+
+			Error: `invalid attribute "my://connection.string": ` +
+				"connection string is malformed",
+		},
+	}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			cs, err := ParseConnectionString(tc.ConnectionString)
+			if tc.Error != "" {
+				assert.EqualError(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.Result, cs)
+			}
+		})
+	}
+}
+
+func TestConnectionStringMaskedHostName(t *testing.T) {
+	cs := ConnectionString{HostName: "myhub1234.azure-devices.net"}
+	assert.Equal(t, "my*******.azure-devices.net", cs.MaskedHostName())
+	cs = ConnectionString{HostName: "localhost"}
+	assert.Equal(t, "lo*******", cs.MaskedHostName())
+}
+
+func TestConnectionStringAuthorization(t *testing.T) {
+	cs := ConnectionString{
+		HostName: "MyHub.azure-devices.net",
+		Name:     "iothubowner",
+		Key:      []byte("secret key for testing"),
+	}
+	expireAt := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t,
+		"SharedAccessSignature sr=myhub.azure-devices.net"+
+			"&sig=XYxAANNsGJFQm%2BUtRZLF9ZX7nyEZvod7FNnalOnevfo%3D"+
+			"&se=1633089600&skn=iothubowner",
+		cs.Authorization(expireAt),
+	)
+}
+
+func TestConnectionStringPermissions(t *testing.T) {
+	cs := ConnectionString{Name: "registryReadWrite"}
+	permissions, ok := cs.Permissions()
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		PermissionRegistryRead,
+		PermissionRegistryWrite,
+	}, permissions)
+
+	cs = ConnectionString{Name: "mender"}
+	_, ok = cs.Permissions()
+	assert.False(t, ok)
+}
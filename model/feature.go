@@ -0,0 +1,46 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+const (
+	FeatureSourceDefault = "default"
+	FeatureSourceConfig  = "config"
+	FeatureSourceTenant  = "tenant"
+)
+
+// FeatureFlag is the state of a feature flag for a tenant
+type FeatureFlag struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+	// Source is where the state of the flag comes from: the built-in
+	// default, the service configuration or the tenant override.
+	Source string `json:"source"`
+}
+
+// FeatureFlagOverride is the request body for overriding a feature flag
+type FeatureFlagOverride struct {
+	Enabled *bool `json:"enabled"`
+}
+
+func (o FeatureFlagOverride) Validate() error {
+	return validation.ValidateStruct(&o,
+		validation.Field(&o.Enabled, validation.NotNil),
+	)
+}
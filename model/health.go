@@ -0,0 +1,42 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+const (
+	HealthStatusOK    = "ok"
+	HealthStatusError = "error"
+)
+
+// HealthReport is the detailed result of a health check
+type HealthReport struct {
+	Status       string             `json:"status"`
+	Dependencies []DependencyHealth `json:"dependencies"`
+}
+
+// DependencyHealth is the health status of a single service dependency
+type DependencyHealth struct {
+	Name    string  `json:"name"`
+	Status  string  `json:"status"`
+	Latency float64 `json:"latency_ms"`
+	// Lag is the age, in seconds, of the oldest item waiting to be
+	// processed by a background worker
+	Lag   float64 `json:"lag_s,omitempty"`
+	Error string  `json:"error,omitempty"`
+}
+
+// Healthy returns true if all the dependencies are healthy
+func (r HealthReport) Healthy() bool {
+	return r.Status == HealthStatusOK
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"time"
+)
+
+const (
+	IntegrationCheckOK      = "ok"
+	IntegrationCheckFailed  = "failed"
+	IntegrationCheckSkipped = "skipped"
+)
+
+// IntegrationReport is the result of the diagnostic checks of the
+// integration with the Azure IoT Hub
+type IntegrationReport struct {
+	Checks []IntegrationCheck `json:"checks"`
+}
+
+// IntegrationCheck is the result of a single diagnostic check
+type IntegrationCheck struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+}
+
+// OK returns true if none of the checks failed
+func (r IntegrationReport) OK() bool {
+	for _, check := range r.Checks {
+		if check.Status == IntegrationCheckFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// FleetHealthReport is the result of the integration checks of a sample of
+// the tenants
+type FleetHealthReport struct {
+	// Time is when the checks were performed
+	Time time.Time `json:"time"`
+	// Sampled is the number of integrations checked
+	Sampled int `json:"sampled"`
+	// Failed is the number of integrations with failed checks
+	Failed   int                  `json:"failed"`
+	Failures []IntegrationFailure `json:"failures"`
+	// Hubs are the number of integrations checked and failed for each
+	// IoT Hub, sorted by host name
+	Hubs []HubHealth `json:"hubs"`
+}
+
+// HubHealth is the number of integrations with an IoT Hub checked by the
+// fleet health checks, and of the failed ones, with the mean latency of
+// the checks
+type HubHealth struct {
+	HostName string `json:"host_name"`
+	Sampled  int    `json:"sampled"`
+	Failed   int    `json:"failed"`
+	// Latency is the mean duration of the checks of an integration
+	Latency float64 `json:"latency_ms"`
+}
+
+// IntegrationFailure is the first failed check of the integration of a
+// tenant
+type IntegrationFailure struct {
+	TenantID string `json:"tenant_id"`
+	HostName string `json:"host_name,omitempty"`
+	Check    string `json:"check"`
+	Error    string `json:"error"`
+}
@@ -0,0 +1,68 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"time"
+)
+
+// MigrationStatus reports the schema migrations applied to the database
+type MigrationStatus struct {
+	// Database is the name of the database
+	Database string `json:"database"`
+	// Version is the schema version required by the service
+	Version string `json:"version"`
+	// Pending is true if the database is behind Version
+	Pending bool `json:"pending"`
+	// Applied are the applied migrations, the most recent first
+	Applied []AppliedMigration `json:"applied"`
+}
+
+// AppliedMigration is a migration applied to the database
+type AppliedMigration struct {
+	Version string    `json:"version"`
+	Time    time.Time `json:"time"`
+}
+
+// MigrationPlan is the list of the migrations which would be applied to
+// the database to reach the schema version required by the service
+type MigrationPlan struct {
+	// Database is the name of the database
+	Database string `json:"database"`
+	// From is the current schema version of the database
+	From string `json:"from"`
+	// To is the schema version required by the service
+	To string `json:"to"`
+	// Migrations are the pending migrations, in the order of application
+	Migrations []PlannedMigration `json:"migrations"`
+}
+
+// PlannedMigration is a pending migration
+type PlannedMigration struct {
+	Version string         `json:"version"`
+	Indexes []PlannedIndex `json:"indexes"`
+}
+
+// PlannedIndex is an index built by a pending migration
+type PlannedIndex struct {
+	Collection string `json:"collection"`
+	Name       string `json:"name"`
+	Keys       string `json:"keys"`
+	// Exists is true if the index already exists and won't be built
+	Exists bool `json:"exists"`
+	// Documents is the estimated number of documents of the collection,
+	// scanned by the index build
+	Documents int64 `json:"documents"`
+}
@@ -0,0 +1,46 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+// Permissions of the IoT Hub shared access policies
+const (
+	PermissionRegistryRead   = "RegistryRead"
+	PermissionRegistryWrite  = "RegistryWrite"
+	PermissionServiceConnect = "ServiceConnect"
+	PermissionDeviceConnect  = "DeviceConnect"
+)
+
+// builtinPolicies are the permissions of the shared access policies
+// created with every IoT Hub
+var builtinPolicies = map[string][]string{
+	"iothubowner": {
+		PermissionRegistryRead,
+		PermissionRegistryWrite,
+		PermissionServiceConnect,
+		PermissionDeviceConnect,
+	},
+	"service":           {PermissionServiceConnect},
+	"device":            {PermissionDeviceConnect},
+	"registryRead":      {PermissionRegistryRead},
+	"registryReadWrite": {PermissionRegistryRead, PermissionRegistryWrite},
+}
+
+// Permissions returns the permissions granted by the shared access policy
+// of the connection string. The permissions are only known for the
+// built-in policies of the IoT Hub; ok is false for any other policy.
+func (cs ConnectionString) Permissions() (permissions []string, ok bool) {
+	permissions, ok = builtinPolicies[cs.Name]
+	return permissions, ok
+}
@@ -15,6 +15,11 @@
 package model
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 )
 
@@ -24,6 +29,27 @@ var (
 
 type Settings struct {
 	ConnectionString string `json:"connection_string,omitempty" bson:"connection_string,omitempty"`
+
+	// PreviousConnectionString is the connection string replaced by the
+	// last rotation, used as a fallback until PreviousExpiresAt.
+	PreviousConnectionString string     `json:"-" bson:"previous_connection_string,omitempty"`
+	PreviousExpiresAt        *time.Time `json:"-" bson:"previous_expires_at,omitempty"`
+}
+
+// FallbackConnectionString returns the connection string replaced by the
+// last rotation if its grace period has not expired at now
+func (s Settings) FallbackConnectionString(now time.Time) (string, bool) {
+	if s.PreviousConnectionString == "" || s.PreviousExpiresAt == nil ||
+		!now.Before(*s.PreviousExpiresAt) {
+		return "", false
+	}
+	return s.PreviousConnectionString, true
+}
+
+// TenantSettings are the settings of a tenant
+type TenantSettings struct {
+	TenantID string `json:"tenant_id" bson:"tenant_id"`
+	Settings `bson:",inline"`
 }
 
 func (s Settings) Validate() error {
@@ -31,3 +57,22 @@ func (s Settings) Validate() error {
 		validation.Field(&s.ConnectionString, ruleLenLte2048),
 	)
 }
+
+// ETag returns a strong entity tag identifying the settings content
+func (s Settings) ETag() string {
+	b, _ := json.Marshal(s)
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// ReencryptionResult is the outcome of the re-encryption of the stored
+// connection strings with the current key
+type ReencryptionResult struct {
+	// Checked is the number of settings checked
+	Checked int `json:"checked"`
+	// Reencrypted is the number of settings re-encrypted
+	Reencrypted int `json:"reencrypted"`
+	// Modified is the number of settings skipped because they were
+	// modified concurrently
+	Modified int `json:"modified"`
+}
@@ -0,0 +1,53 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"time"
+)
+
+const (
+	// UsageAPICalls counts the requests to the management API
+	UsageAPICalls = "api_calls"
+	// UsageAzureOperations counts the requests to the Azure IoT Hub made
+	// on behalf of the tenant, i.e. verifying the rotated connection
+	// strings; the checks run by the service itself are not metered
+	UsageAzureOperations = "azure_operations"
+
+	usagePeriodFormat = "2006-01"
+)
+
+// Usage is the metered usage of a tenant during a billing period
+type Usage struct {
+	TenantID        string `json:"tenant_id" bson:"tenant_id"`
+	Period          string `json:"period" bson:"period"`
+	APICalls        int64  `json:"api_calls" bson:"api_calls"`
+	AzureOperations int64  `json:"azure_operations" bson:"azure_operations"`
+}
+
+// UsagePeriod returns the billing period, i.e. the calendar month in UTC,
+// containing t, e.g. "2021-10"
+func UsagePeriod(t time.Time) string {
+	return t.UTC().Format(usagePeriodFormat)
+}
+
+// ParseUsagePeriod validates and normalizes a billing period
+func ParseUsagePeriod(period string) (string, error) {
+	t, err := time.Parse(usagePeriodFormat, period)
+	if err != nil {
+		return "", err
+	}
+	return UsagePeriod(t), nil
+}
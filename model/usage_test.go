@@ -0,0 +1,63 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsagePeriod(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	assert.Equal(t, "2021-10",
+		UsagePeriod(time.Date(2021, 10, 31, 23, 59, 0, 0, time.UTC)))
+	assert.Equal(t, "2021-09",
+		UsagePeriod(time.Date(2021, 10, 1, 1, 0, 0, 0, loc)))
+}
+
+func TestParseUsagePeriod(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Period string
+
+		Result string
+		Error  bool
+	}{{
+		Name:   "ok",
+		Period: "2021-10",
+		Result: "2021-10",
+	}, {
+		Name:   "error, day",
+		Period: "2021-10-01",
+		Error:  true,
+	}, {
+		Name:   "error, month",
+		Period: "2021-13",
+		Error:  true,
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			period, err := ParseUsagePeriod(tc.Period)
+			if tc.Error {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.Result, period)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package rbac
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeToken(claims string) string {
+	return "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".c2ln"
+}
+
+func TestExtractScopes(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		Name string
+
+		Token string
+
+		Scopes Scopes
+		Error  bool
+		Read   bool
+		Write  bool
+	}{{
+		Name:  "no scope claim",
+		Token: makeToken(`{"sub":"user"}`),
+		Read:  true,
+		Write: true,
+	}, {
+		Name:   "all scopes",
+		Token:  makeToken(`{"sub":"user","scp":"mender.*"}`),
+		Scopes: Scopes{ScopeAll},
+		Read:   true,
+		Write:  true,
+	}, {
+		Name:   "read-only",
+		Token:  makeToken(`{"scp":"openid mender.azure-iot-manager:read"}`),
+		Scopes: Scopes{"openid", ScopeRead},
+		Read:   true,
+	}, {
+		Name:   "write, array claim",
+		Token:  makeToken(`{"scp":["mender.azure-iot-manager:write"]}`),
+		Scopes: Scopes{ScopeWrite},
+		Read:   true,
+		Write:  true,
+	}, {
+		Name:   "no access",
+		Token:  makeToken(`{"scp":""}`),
+		Scopes: Scopes{},
+	}, {
+		Name:  "error, invalid claim",
+		Token: makeToken(`{"scp":1}`),
+		Error: true,
+	}, {
+		Name:  "error, malformed token",
+		Token: "e30.e30",
+		Error: true,
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			scopes, err := ExtractScopes(tc.Token)
+			if tc.Error {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Scopes, scopes)
+			assert.Equal(t, tc.Read, scopes.CanRead())
+			assert.Equal(t, tc.Write, scopes.CanWrite())
+
+			ctx := WithContext(context.Background(), scopes)
+			assert.Equal(t, scopes, FromContext(ctx))
+		})
+	}
+	assert.Nil(t, FromContext(context.Background()))
+}
@@ -0,0 +1,154 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package secrets resolves sensitive configuration values stored in an
+// external secret store.
+package secrets
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/config"
+
+	dconfig "github.com/mendersoftware/azure-iot-manager/config"
+)
+
+const (
+	// BackendVault is the HashiCorp Vault secret backend
+	BackendVault = "vault"
+
+	// RefPrefix is the prefix of the setting values referencing a secret,
+	// e.g. "secret:database/creds/azure-iot-manager#password"
+	RefPrefix = "secret:"
+)
+
+var (
+	ErrUnknownBackend = errors.New("unknown secrets backend")
+	ErrNoBackend      = errors.New(
+		"setting references a secret but no secrets backend is configured",
+	)
+	ErrInvalidRef     = errors.New("invalid secret reference, expected <path>#<field>")
+	ErrSecretNotFound = errors.New("secret not found")
+)
+
+// Backend fetches secrets from an external secret store
+//nolint:lll
+//go:generate ../utils/mockgen.sh
+type Backend interface {
+	// Get returns the value of the secret referenced by ref, in the
+	// <path>#<field> form.
+	Get(ctx context.Context, ref string) (string, error)
+}
+
+// SensitiveSettings are the settings which can reference a secret
+var SensitiveSettings = []string{
+	dconfig.SettingMongo,
+	dconfig.SettingDbUsername,
+	dconfig.SettingDbPassword,
+	dconfig.SettingJWKSURL,
+	dconfig.SettingAuditLogsAddr,
+	dconfig.SettingErrorReportingDSN,
+	dconfig.SettingEncryptionKeys,
+}
+
+// NewBackend returns the secrets backend configured in conf, or nil if
+// none is configured.
+func NewBackend(conf config.Reader) (Backend, error) {
+	switch backend := conf.GetString(dconfig.SettingSecretsBackend); backend {
+	case "":
+		return nil, nil
+	case BackendVault:
+		token := conf.GetString(dconfig.SettingVaultToken)
+		if path := conf.GetString(dconfig.SettingVaultTokenFile); path != "" {
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read vault token")
+			}
+			token = strings.TrimSpace(string(b))
+		}
+		tlsConfig, err := dconfig.TLSConfig(conf)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		return NewVault(VaultOptions{
+			Address:   conf.GetString(dconfig.SettingVaultAddress),
+			Token:     token,
+			Namespace: conf.GetString(dconfig.SettingVaultNamespace),
+			Client: &http.Client{
+				Timeout:   vaultTimeout,
+				Transport: transport,
+			},
+		})
+	default:
+		return nil, errors.Wrapf(ErrUnknownBackend, "%q", backend)
+	}
+}
+
+// Setup resolves the sensitive settings referencing a secret using the
+// configured backend, and keeps renewing the secret leases in the
+// background until the context is canceled.
+func Setup(ctx context.Context, conf config.Handler) error {
+	backend, err := NewBackend(conf)
+	if err != nil {
+		return err
+	}
+	err = ResolveSettings(ctx, backend, conf)
+	if err != nil {
+		return err
+	}
+	if vault, ok := backend.(*Vault); ok {
+		go vault.Run(ctx)
+	}
+	return nil
+}
+
+// ResolveSettings replaces the values of the sensitive settings
+// referencing a secret with the value of the secret. A list setting
+// references a secret with a single element, e.g. the encryption keys,
+// and the secret holds the space separated elements.
+func ResolveSettings(ctx context.Context, backend Backend, conf config.Handler) error {
+	for _, key := range SensitiveSettings {
+		value := conf.GetString(key)
+		if list := conf.GetStringSlice(key); value == "" && len(list) == 1 {
+			value = list[0]
+		}
+		if !strings.HasPrefix(value, RefPrefix) {
+			continue
+		}
+		if backend == nil {
+			return errors.Wrap(ErrNoBackend, key)
+		}
+		secret, err := backend.Get(ctx, strings.TrimPrefix(value, RefPrefix))
+		if err != nil {
+			return errors.Wrapf(err, "failed to resolve %s", key)
+		}
+		conf.Set(key, secret)
+	}
+	return nil
+}
+
+func parseRef(ref string) (path, field string, err error) {
+	i := strings.LastIndex(ref, "#")
+	if i <= 0 || i == len(ref)-1 {
+		return "", "", errors.Wrapf(ErrInvalidRef, "%q", ref)
+	}
+	return strings.Trim(ref[:i], "/"), ref[i+1:], nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package secrets
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	dconfig "github.com/mendersoftware/azure-iot-manager/config"
+	"github.com/mendersoftware/azure-iot-manager/secrets/mocks"
+)
+
+func TestNewBackend(t *testing.T) {
+	f, err := ioutil.TempFile("", "token")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.Remove(f.Name())
+	_, _ = f.WriteString("s.token\n")
+	f.Close()
+
+	conf := viper.New()
+	backend, err := NewBackend(conf)
+	assert.NoError(t, err)
+	assert.Nil(t, backend)
+
+	conf.Set(dconfig.SettingSecretsBackend, "keyring")
+	_, err = NewBackend(conf)
+	assert.EqualError(t, err, `"keyring": `+ErrUnknownBackend.Error())
+
+	conf.Set(dconfig.SettingSecretsBackend, BackendVault)
+	conf.Set(dconfig.SettingVaultAddress, "http://vault:8200")
+	conf.Set(dconfig.SettingVaultTokenFile, f.Name())
+	backend, err = NewBackend(conf)
+	assert.NoError(t, err)
+	if assert.IsType(t, &Vault{}, backend) {
+		assert.Equal(t, "s.token", backend.(*Vault).Token)
+	}
+
+	conf.Set(dconfig.SettingVaultTokenFile, f.Name()+".missing")
+	_, err = NewBackend(conf)
+	assert.Error(t, err)
+}
+
+func TestResolveSettings(t *testing.T) {
+	conf := viper.New()
+	conf.Set(dconfig.SettingMongo, "mongodb://mongo:27017")
+	conf.Set(dconfig.SettingDbUsername, "secret:database/creds/aim#username")
+	conf.Set(dconfig.SettingDbPassword, "secret:database/creds/aim#password")
+	conf.Set(dconfig.SettingEncryptionKeys, []string{"secret:secret/data/aim#keys"})
+
+	backend := &mocks.Backend{}
+	defer backend.AssertExpectations(t)
+	backend.On("Get", mock.Anything, "secret/data/aim#keys").
+		Return("v1:a2V5MQ== v2:a2V5Mg==", nil)
+	backend.On("Get", mock.Anything, "database/creds/aim#username").
+		Return("v-user", nil)
+	backend.On("Get", mock.Anything, "database/creds/aim#password").
+		Return("v-password", nil)
+
+	err := ResolveSettings(context.Background(), backend, conf)
+	assert.NoError(t, err)
+	assert.Equal(t, "mongodb://mongo:27017", conf.GetString(dconfig.SettingMongo))
+	assert.Equal(t, "v-user", conf.GetString(dconfig.SettingDbUsername))
+	assert.Equal(t, "v-password", conf.GetString(dconfig.SettingDbPassword))
+	assert.Equal(t, []string{"v1:a2V5MQ==", "v2:a2V5Mg=="},
+		conf.GetStringSlice(dconfig.SettingEncryptionKeys))
+
+	conf.Set(dconfig.SettingDbPassword, "secret:database/creds/aim#password")
+	err = ResolveSettings(context.Background(), nil, conf)
+	assert.EqualError(t, err, "mongo_password: "+ErrNoBackend.Error())
+
+	backend = &mocks.Backend{}
+	defer backend.AssertExpectations(t)
+	backend.On("Get", mock.Anything, "database/creds/aim#password").
+		Return("", errors.New("vault: sealed"))
+	err = ResolveSettings(context.Background(), backend, conf)
+	assert.EqualError(t, err, "failed to resolve mongo_password: vault: sealed")
+}
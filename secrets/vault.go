@@ -0,0 +1,312 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/azure-iot-manager/clock"
+)
+
+const (
+	vaultHeaderToken     = "X-Vault-Token"
+	vaultHeaderNamespace = "X-Vault-Namespace"
+
+	vaultURIRenewSelf  = "/v1/auth/token/renew-self"
+	vaultURILookupSelf = "/v1/auth/token/lookup-self"
+	vaultURIRenewLease = "/v1/sys/leases/renew"
+
+	vaultTimeout          = 10 * time.Second
+	vaultMinRenewInterval = 5 * time.Second
+	vaultMaxRenewInterval = time.Hour
+)
+
+var (
+	ErrVaultAddress = errors.New("vault: missing address")
+	ErrVaultToken   = errors.New("vault: missing token")
+)
+
+// VaultOptions are the options of the HashiCorp Vault backend
+type VaultOptions struct {
+	// Address is the URL of the Vault server
+	Address string
+	// Token is the Vault token used for authentication
+	Token string
+	// Namespace is the Vault Enterprise namespace, if any
+	Namespace string
+	// Client is the HTTP client; if nil, a default client is used
+	Client *http.Client
+	// Clock schedules the renewals; if nil, the system clock is used
+	Clock clock.Clock
+}
+
+type vaultLease struct {
+	id   string
+	path string
+	// ttl is the duration of the lease when the secret was read, requested
+	// on each renewal
+	ttl time.Duration
+	// duration is the duration granted by the last renewal
+	duration time.Duration
+}
+
+// Vault is a HashiCorp Vault secrets backend. The secrets are read through
+// the HTTP API; both the key-value (v1 and v2) and the dynamic secret
+// engines are supported. Each secret path is read only once, so that the
+// fields of dynamic credentials (e.g. username and password) belong to the
+// same lease, until the lease reaches its maximum TTL.
+type Vault struct {
+	VaultOptions
+
+	mu       sync.Mutex
+	secrets  map[string]map[string]interface{}
+	leases   []vaultLease
+	tokenTTL time.Duration
+}
+
+// NewVault returns a new Vault backend
+func NewVault(opts VaultOptions) (*Vault, error) {
+	if opts.Address == "" {
+		return nil, ErrVaultAddress
+	} else if opts.Token == "" {
+		return nil, ErrVaultToken
+	}
+	opts.Address = strings.TrimRight(opts.Address, "/")
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: vaultTimeout}
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.New()
+	}
+	return &Vault{
+		VaultOptions: opts,
+		secrets:      make(map[string]map[string]interface{}),
+	}, nil
+}
+
+type vaultResponse struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int64                  `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *struct {
+		LeaseDuration int64 `json:"lease_duration"`
+		Renewable     bool  `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+func (v *Vault) do(
+	ctx context.Context,
+	method, path string,
+	body interface{},
+) (*vaultResponse, error) {
+	var r *bytes.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		r = bytes.NewReader(b)
+	} else {
+		r = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.Address+path, r)
+	if err != nil {
+		return nil, errors.Wrap(err, "vault: failed to prepare request")
+	}
+	req.Header.Set(vaultHeaderToken, v.Token)
+	if v.Namespace != "" {
+		req.Header.Set(vaultHeaderNamespace, v.Namespace)
+	}
+	rsp, err := v.Client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "vault: failed to execute request")
+	}
+	defer rsp.Body.Close()
+
+	var vrsp vaultResponse
+	if rsp.StatusCode == http.StatusNoContent {
+		return &vrsp, nil
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&vrsp)
+	if rsp.StatusCode == http.StatusNotFound {
+		return nil, ErrSecretNotFound
+	} else if rsp.StatusCode >= 300 {
+		return nil, errors.Errorf("vault: unexpected HTTP status %s: %s",
+			rsp.Status, strings.Join(vrsp.Errors, ", "))
+	} else if err != nil {
+		return nil, errors.Wrap(err, "vault: failed to decode response")
+	}
+	return &vrsp, nil
+}
+
+// Get returns the value of the field of the secret at path, with the
+// reference in the <path>#<field> form, e.g.:
+// "secret/data/azure-iot-manager#mongo_password" (key-value v2) or
+// "database/creds/azure-iot-manager#password" (dynamic credentials).
+func (v *Vault) Get(ctx context.Context, ref string) (string, error) {
+	path, field, err := parseRef(ref)
+	if err != nil {
+		return "", err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	data, ok := v.secrets[path]
+	if !ok {
+		rsp, err := v.do(ctx, http.MethodGet, "/v1/"+path, nil)
+		if err != nil {
+			return "", errors.Wrapf(err, "vault: failed to read %s", path)
+		}
+		data = rsp.Data
+		// key-value v2 secrets are nested in data.data
+		if inner, ok := data["data"].(map[string]interface{}); ok {
+			if _, ok := data["metadata"]; ok {
+				data = inner
+			}
+		}
+		v.secrets[path] = data
+		// the lease of a secret read again is replaced
+		leases := v.leases[:0]
+		for _, lease := range v.leases {
+			if lease.path != path {
+				leases = append(leases, lease)
+			}
+		}
+		v.leases = leases
+		if rsp.LeaseID != "" && rsp.Renewable {
+			ttl := time.Duration(rsp.LeaseDuration) * time.Second
+			v.leases = append(v.leases, vaultLease{
+				id:       rsp.LeaseID,
+				path:     path,
+				ttl:      ttl,
+				duration: ttl,
+			})
+		}
+	}
+	value, ok := data[field]
+	if !ok {
+		return "", errors.Wrapf(ErrSecretNotFound, "%s#%s", path, field)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// Renew renews the token and the leases of the secrets read so far, and
+// returns the time to wait before renewing them again. All the leases are
+// renewed even if some fail, and the errors are returned together. A lease
+// reaching its maximum TTL cannot be extended: the secret is read again by
+// the next Get, and an error is returned until the lease is replaced, as
+// the settings resolved from the secret expire with it.
+func (v *Vault) Renew(ctx context.Context) (time.Duration, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var errs []string
+	next := vaultMaxRenewInterval
+	retry := func(err error) {
+		errs = append(errs, err.Error())
+		next = vaultMinRenewInterval
+	}
+
+	if v.tokenTTL == 0 {
+		rsp, err := v.do(ctx, http.MethodGet, vaultURILookupSelf, nil)
+		if err != nil {
+			retry(errors.Wrap(err, "vault: failed to lookup token"))
+		} else {
+			ttl, _ := rsp.Data["ttl"].(float64)
+			renewable, _ := rsp.Data["renewable"].(bool)
+			if ttl == 0 || !renewable {
+				// the token does not expire or cannot be renewed
+				v.tokenTTL = -1
+			} else {
+				v.tokenTTL = time.Duration(ttl) * time.Second
+			}
+		}
+	}
+	if v.tokenTTL > 0 {
+		rsp, err := v.do(ctx, http.MethodPost, vaultURIRenewSelf, nil)
+		if err != nil {
+			retry(errors.Wrap(err, "vault: failed to renew token"))
+		} else if rsp.Auth != nil && rsp.Auth.LeaseDuration > 0 {
+			v.tokenTTL = time.Duration(rsp.Auth.LeaseDuration) * time.Second
+		}
+		if v.tokenTTL/2 < next {
+			next = v.tokenTTL / 2
+		}
+	}
+
+	for i := range v.leases {
+		lease := &v.leases[i]
+		rsp, err := v.do(ctx, http.MethodPut, vaultURIRenewLease,
+			map[string]interface{}{
+				"lease_id":  lease.id,
+				"increment": int64(lease.ttl / time.Second),
+			},
+		)
+		if err != nil {
+			retry(errors.Wrapf(err, "vault: failed to renew lease %s", lease.id))
+			continue
+		}
+		if rsp.LeaseDuration > 0 {
+			lease.duration = time.Duration(rsp.LeaseDuration) * time.Second
+		}
+		if lease.duration < lease.ttl {
+			// the increment is capped by the maximum TTL of the lease
+			delete(v.secrets, lease.path)
+			errs = append(errs, fmt.Sprintf(
+				"vault: lease %s of %s expires in %s and cannot be extended; "+
+					"restart the service to read the secret again",
+				lease.id, lease.path, lease.duration,
+			))
+		}
+		if lease.duration/2 < next {
+			next = lease.duration / 2
+		}
+	}
+	if next < vaultMinRenewInterval {
+		next = vaultMinRenewInterval
+	}
+	if len(errs) > 0 {
+		return next, errors.New(strings.Join(errs, "; "))
+	}
+	return next, nil
+}
+
+// Run renews the token and the leases of the secrets until the context is
+// canceled.
+func (v *Vault) Run(ctx context.Context) {
+	l := log.FromContext(ctx)
+	for {
+		next, err := v.Renew(ctx)
+		if err != nil {
+			l.Errorf("failed to renew the secrets leases: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-v.Clock.After(next):
+		}
+	}
+}
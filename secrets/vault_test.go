@@ -0,0 +1,308 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/azure-iot-manager/clock"
+)
+
+type fakeVault struct {
+	*httptest.Server
+	reads       int32
+	renewSelf   int32
+	renewLeases int32
+	// leaseTTL caps the duration of the renewed leases if not zero
+	leaseTTL int32
+}
+
+func newFakeVault(t *testing.T) *fakeVault {
+	fv := &fakeVault{}
+	respond := func(w http.ResponseWriter, status int, body interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(body)
+	}
+	fv.Server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(vaultHeaderToken) != "s.token" {
+				respond(w, http.StatusForbidden, map[string]interface{}{
+					"errors": []string{"permission denied"},
+				})
+				return
+			}
+			assert.Equal(t, "ns1", r.Header.Get(vaultHeaderNamespace))
+			switch r.URL.Path {
+			case "/v1/secret/data/azure-iot-manager":
+				atomic.AddInt32(&fv.reads, 1)
+				respond(w, http.StatusOK, map[string]interface{}{
+					"data": map[string]interface{}{
+						"data": map[string]interface{}{
+							"mongo_password": "kv-password",
+							"port":           27017,
+						},
+						"metadata": map[string]interface{}{"version": 1},
+					},
+				})
+			case "/v1/database/creds/azure-iot-manager":
+				atomic.AddInt32(&fv.reads, 1)
+				respond(w, http.StatusOK, map[string]interface{}{
+					"lease_id":       "database/creds/azure-iot-manager/abcd",
+					"lease_duration": 60,
+					"renewable":      true,
+					"data": map[string]interface{}{
+						"username": "v-user",
+						"password": "v-password",
+					},
+				})
+			case "/v1/database/creds/revoked":
+				respond(w, http.StatusOK, map[string]interface{}{
+					"lease_id":       "database/creds/revoked/efgh",
+					"lease_duration": 60,
+					"renewable":      true,
+					"data": map[string]interface{}{
+						"username": "v-revoked",
+					},
+				})
+			case vaultURILookupSelf:
+				respond(w, http.StatusOK, map[string]interface{}{
+					"data": map[string]interface{}{
+						"ttl":       600,
+						"renewable": true,
+					},
+				})
+			case vaultURIRenewSelf:
+				atomic.AddInt32(&fv.renewSelf, 1)
+				respond(w, http.StatusOK, map[string]interface{}{
+					"auth": map[string]interface{}{
+						"lease_duration": 600,
+						"renewable":      true,
+					},
+				})
+			case vaultURIRenewLease:
+				var body map[string]interface{}
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				if body["lease_id"] != "database/creds/azure-iot-manager/abcd" {
+					respond(w, http.StatusBadRequest, map[string]interface{}{
+						"errors": []string{"lease not found"},
+					})
+					return
+				}
+				atomic.AddInt32(&fv.renewLeases, 1)
+				ttl, _ := body["increment"].(float64)
+				if max := atomic.LoadInt32(&fv.leaseTTL); max > 0 {
+					ttl = float64(max)
+				}
+				respond(w, http.StatusOK, map[string]interface{}{
+					"lease_id":       body["lease_id"],
+					"lease_duration": ttl,
+					"renewable":      true,
+				})
+			default:
+				respond(w, http.StatusNotFound, map[string]interface{}{
+					"errors": []string{},
+				})
+			}
+		},
+	))
+	return fv
+}
+
+func TestNewVault(t *testing.T) {
+	_, err := NewVault(VaultOptions{Token: "s.token"})
+	assert.EqualError(t, err, ErrVaultAddress.Error())
+	_, err = NewVault(VaultOptions{Address: "http://vault:8200"})
+	assert.EqualError(t, err, ErrVaultToken.Error())
+}
+
+func TestVaultGet(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	v, err := NewVault(VaultOptions{
+		Address:   fv.URL + "/",
+		Token:     "s.token",
+		Namespace: "ns1",
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	value, err := v.Get(ctx, "secret/data/azure-iot-manager#mongo_password")
+	assert.NoError(t, err)
+	assert.Equal(t, "kv-password", value)
+
+	value, err = v.Get(ctx, "/secret/data/azure-iot-manager#port")
+	assert.NoError(t, err)
+	assert.Equal(t, "27017", value)
+
+	value, err = v.Get(ctx, "database/creds/azure-iot-manager#username")
+	assert.NoError(t, err)
+	assert.Equal(t, "v-user", value)
+	value, err = v.Get(ctx, "database/creds/azure-iot-manager#password")
+	assert.NoError(t, err)
+	assert.Equal(t, "v-password", value)
+
+	// each path is read only once
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fv.reads))
+
+	_, err = v.Get(ctx, "database/creds/azure-iot-manager#token")
+	assert.EqualError(t, err,
+		"database/creds/azure-iot-manager#token: "+ErrSecretNotFound.Error())
+
+	_, err = v.Get(ctx, "secret/data/unknown#password")
+	assert.EqualError(t, err,
+		"vault: failed to read secret/data/unknown: "+ErrSecretNotFound.Error())
+
+	_, err = v.Get(ctx, "secret/data/azure-iot-manager")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), ErrInvalidRef.Error())
+
+	v.Token = "s.invalid"
+	_, err = v.Get(ctx, "secret/data/other#password")
+	assert.EqualError(t, err, "vault: failed to read secret/data/other: "+
+		"vault: unexpected HTTP status 403 Forbidden: permission denied")
+}
+
+func TestVaultRenew(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	v, err := NewVault(VaultOptions{
+		Address:   fv.URL,
+		Token:     "s.token",
+		Namespace: "ns1",
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	next, err := v.Renew(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, next)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fv.renewSelf))
+
+	_, err = v.Get(ctx, "database/creds/azure-iot-manager#username")
+	require.NoError(t, err)
+	next, err = v.Renew(ctx)
+	assert.NoError(t, err)
+	// the lease is renewed for 60s, half of it is the next renewal
+	assert.Equal(t, 30*time.Second, next)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fv.renewSelf))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fv.renewLeases))
+
+	v.Token = "s.invalid"
+	next, err = v.Renew(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, vaultMinRenewInterval, next)
+}
+
+func TestVaultRenewFailures(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	v, err := NewVault(VaultOptions{
+		Address:   fv.URL,
+		Token:     "s.token",
+		Namespace: "ns1",
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = v.Get(ctx, "database/creds/revoked#username")
+	require.NoError(t, err)
+	_, err = v.Get(ctx, "database/creds/azure-iot-manager#username")
+	require.NoError(t, err)
+
+	// the revoked lease does not prevent renewing the others
+	next, err := v.Renew(ctx)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(),
+			"vault: failed to renew lease database/creds/revoked/efgh")
+	}
+	assert.Equal(t, vaultMinRenewInterval, next)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fv.renewLeases))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fv.renewSelf))
+
+	// the lease reaching its maximum TTL is reported until the secret is
+	// read again
+	v, err = NewVault(VaultOptions{
+		Address:   fv.URL,
+		Token:     "s.token",
+		Namespace: "ns1",
+	})
+	require.NoError(t, err)
+	_, err = v.Get(ctx, "database/creds/azure-iot-manager#username")
+	require.NoError(t, err)
+	atomic.StoreInt32(&fv.leaseTTL, 20)
+	for i := 0; i < 2; i++ {
+		next, err = v.Renew(ctx)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(),
+				"lease database/creds/azure-iot-manager/abcd of "+
+					"database/creds/azure-iot-manager expires in 20s "+
+					"and cannot be extended")
+		}
+		assert.Equal(t, 10*time.Second, next)
+	}
+	_, err = v.Get(ctx, "database/creds/azure-iot-manager#username")
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&fv.reads))
+	assert.Len(t, v.leases, 1)
+
+	atomic.StoreInt32(&fv.leaseTTL, 0)
+	next, err = v.Renew(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, next)
+}
+
+func TestVaultRun(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	clk := clock.NewFake(time.Now())
+	v, err := NewVault(VaultOptions{
+		Address:   fv.URL,
+		Token:     "s.token",
+		Namespace: "ns1",
+		Clock:     clk,
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		v.Run(ctx)
+		close(done)
+	}()
+
+	waiting := func() bool { return clk.Waiters() == 1 }
+	require.Eventually(t, waiting, time.Second, time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fv.renewSelf))
+
+	// the token TTL is 10 minutes, it is renewed after 5 minutes
+	clk.Advance(4 * time.Minute)
+	assert.Equal(t, 1, clk.Waiters())
+	clk.Advance(time.Minute)
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&fv.renewSelf) == 2 && waiting()
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package seed populates the data store with fake data for local
+// development and load testing.
+package seed
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/azure-iot-manager/model"
+	"github.com/mendersoftware/azure-iot-manager/store"
+)
+
+const year = 365 * 24 * time.Hour
+
+var auditActions = []string{
+	model.AuditActionCreate,
+	model.AuditActionUpdate,
+	model.AuditActionDelete,
+}
+
+// Options are the seeding options
+type Options struct {
+	// Tenants is the number of tenants to create
+	Tenants int
+	// AuditLogs is the number of audit logs to create for each tenant
+	AuditLogs int
+	// Seed is the seed of the pseudo-random generator; the same seed
+	// always produces the same data.
+	Seed int64
+}
+
+// Tenant is the generated data of a tenant
+type Tenant struct {
+	ID        string
+	Settings  model.Settings
+	AuditLogs []model.AuditLog
+}
+
+// Generate generates the data of the tenants
+func Generate(opts Options) []Tenant {
+	rng := rand.New(rand.NewSource(opts.Seed))
+	epoch := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	tenants := make([]Tenant, opts.Tenants)
+	for i := range tenants {
+		tenant := &tenants[i]
+		b := make([]byte, 12)
+		_, _ = rng.Read(b)
+		tenant.ID = hex.EncodeToString(b)
+
+		key := make([]byte, 32)
+		_, _ = rng.Read(key)
+		tenant.Settings.ConnectionString = fmt.Sprintf(
+			"HostName=seed-hub-%04d.azure-devices.net;"+
+				"SharedAccessKeyName=iothubowner;SharedAccessKey=%s",
+			i, base64.StdEncoding.EncodeToString(key),
+		)
+
+		user, _ := uuid.NewRandomFromReader(rng)
+		// The audit logs are marked as forwarded, so that the fake
+		// records are never submitted to the auditlogs service.
+		tenant.AuditLogs = make([]model.AuditLog, opts.AuditLogs)
+		for j := range tenant.AuditLogs {
+			id, _ := uuid.NewRandomFromReader(rng)
+			outcome := model.AuditOutcomeSuccess
+			if rng.Intn(10) == 0 {
+				outcome = model.AuditOutcomeFailure
+			}
+			tenant.AuditLogs[j] = model.AuditLog{
+				ID:       id,
+				TenantID: tenant.ID,
+				Actor: model.AuditActor{
+					ID:   user.String(),
+					Type: model.AuditActorTypeUser,
+				},
+				Action:    auditActions[rng.Intn(len(auditActions))],
+				Object:    model.AuditObject{Type: "settings"},
+				Outcome:   outcome,
+				Time:      epoch.Add(time.Duration(rng.Int63n(int64(year)))),
+				Forwarded: true,
+			}
+		}
+	}
+	return tenants
+}
+
+// Seed generates the data of the tenants and stores it in the data store
+func Seed(ctx context.Context, ds store.DataStore, opts Options) ([]Tenant, error) {
+	tenants := Generate(opts)
+	for _, tenant := range tenants {
+		ctx := identity.WithContext(ctx, &identity.Identity{
+			Tenant: tenant.ID,
+		})
+		err := ds.SetSettings(ctx, tenant.Settings)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tenant %s", tenant.ID)
+		}
+		for _, log := range tenant.AuditLogs {
+			err = ds.InsertAuditLog(ctx, log)
+			if err != nil {
+				return nil, errors.Wrapf(err, "tenant %s", tenant.ID)
+			}
+		}
+	}
+	return tenants, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package seed
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+
+	"github.com/mendersoftware/azure-iot-manager/model"
+	storeMocks "github.com/mendersoftware/azure-iot-manager/store/mocks"
+)
+
+func TestGenerate(t *testing.T) {
+	opts := Options{Tenants: 3, AuditLogs: 5, Seed: 42}
+	tenants := Generate(opts)
+	assert.Equal(t, tenants, Generate(opts), "generation is not deterministic")
+	assert.NotEqual(t, tenants, Generate(Options{Tenants: 3, AuditLogs: 5, Seed: 43}))
+
+	if assert.Len(t, tenants, 3) {
+		for _, tenant := range tenants {
+			assert.Len(t, tenant.ID, 24)
+			_, err := model.ParseConnectionString(tenant.Settings.ConnectionString)
+			assert.NoError(t, err)
+			if assert.Len(t, tenant.AuditLogs, 5) {
+				for _, log := range tenant.AuditLogs {
+					assert.Equal(t, tenant.ID, log.TenantID)
+					assert.True(t, log.Forwarded)
+				}
+			}
+		}
+	}
+}
+
+func TestSeed(t *testing.T) {
+	opts := Options{Tenants: 2, AuditLogs: 1, Seed: 1}
+	tenantMatcher := func(tenantID string) interface{} {
+		return mock.MatchedBy(func(ctx context.Context) bool {
+			id := identity.FromContext(ctx)
+			return id != nil && id.Tenant == tenantID
+		})
+	}
+
+	ds := &storeMocks.DataStore{}
+	defer ds.AssertExpectations(t)
+	for _, tenant := range Generate(opts) {
+		ds.On("SetSettings", tenantMatcher(tenant.ID), tenant.Settings).
+			Return(nil).Once()
+		ds.On("InsertAuditLog", tenantMatcher(tenant.ID), tenant.AuditLogs[0]).
+			Return(nil).Once()
+	}
+	tenants, err := Seed(context.Background(), ds, opts)
+	assert.NoError(t, err)
+	assert.Len(t, tenants, 2)
+
+	ds = &storeMocks.DataStore{}
+	defer ds.AssertExpectations(t)
+	ds.On("SetSettings", mock.Anything, mock.Anything).
+		Return(errors.New("internal error"))
+	_, err = Seed(context.Background(), ds, opts)
+	assert.Regexp(t, "tenant [0-9a-f]{24}: internal error", err.Error())
+}
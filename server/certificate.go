@@ -0,0 +1,99 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/go-lib-micro/config"
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	dconfig "github.com/mendersoftware/azure-iot-manager/config"
+)
+
+// certificateLoader serves the TLS certificate of the HTTPS listener,
+// and replaces it when the configuration is reloaded, so that renewed
+// certificates are applied without restarting the service.
+type certificateLoader struct {
+	conf        config.Reader
+	certificate atomic.Value
+}
+
+// newCertificateLoader loads the certificate and key pair configured in
+// conf; the pair is loaded again on every reload.
+func newCertificateLoader(conf config.Reader, reloader *reloader) (*certificateLoader, error) {
+	loader := &certificateLoader{conf: conf}
+	if _, err := loader.load(); err != nil {
+		return nil, err
+	}
+	reload := func() { loader.reload(context.Background()) }
+	reloader.Handle(dconfig.SettingTLSCertFile, reload)
+	reloader.Handle(dconfig.SettingTLSKeyFile, reload)
+	// the files may be replaced in place
+	reloader.OnReload(reload)
+	return loader, nil
+}
+
+// load reads and validates the pair, and replaces the served certificate
+// with it; it returns whether the certificate changed.
+func (l *certificateLoader) load() (bool, error) {
+	certificate, err := tls.LoadX509KeyPair(
+		l.conf.GetString(dconfig.SettingTLSCertFile),
+		l.conf.GetString(dconfig.SettingTLSKeyFile),
+	)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to load the TLS certificate")
+	}
+	current, _ := l.certificate.Load().(*tls.Certificate)
+	if current != nil && sameCertificate(current, &certificate) {
+		return false, nil
+	}
+	l.certificate.Store(&certificate)
+	return true, nil
+}
+
+// reload loads the pair again, the served certificate is kept if the new
+// pair fails to load.
+func (l *certificateLoader) reload(ctx context.Context) {
+	changed, err := l.load()
+	if err != nil {
+		log.FromContext(ctx).
+			Errorf("%s, keeping the current certificate", err)
+	} else if changed {
+		log.FromContext(ctx).Info("TLS certificate reloaded")
+	}
+}
+
+// GetCertificate returns the served certificate, see tls.Config
+func (l *certificateLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return l.certificate.Load().(*tls.Certificate), nil
+}
+
+func sameCertificate(a, b *tls.Certificate) bool {
+	if len(a.Certificate) != len(b.Certificate) {
+		return false
+	}
+	for i := range a.Certificate {
+		if !bytes.Equal(a.Certificate[i], b.Certificate[i]) {
+			return false
+		}
+	}
+	return true
+}
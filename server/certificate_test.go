@@ -0,0 +1,112 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dconfig "github.com/mendersoftware/azure-iot-manager/config"
+)
+
+func generateCertificate(t *testing.T, commonName string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestCertificateLoader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certificate")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	writeFile := func(path string, content []byte) {
+		err := ioutil.WriteFile(path, content, 0600)
+		require.NoError(t, err)
+	}
+	writeCertificate := func(commonName string) {
+		cert, key := generateCertificate(t, commonName)
+		writeFile(certFile, cert)
+		writeFile(keyFile, key)
+	}
+	commonName := func(loader *certificateLoader) string {
+		certificate, err := loader.GetCertificate(nil)
+		require.NoError(t, err)
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		require.NoError(t, err)
+		return leaf.Subject.CommonName
+	}
+
+	writeFile(path, []byte("tls:\n  cert_file: "+certFile+"\n  key_file: "+keyFile+"\n"))
+	conf := viper.New()
+	conf.SetConfigFile(path)
+	require.NoError(t, conf.ReadInConfig())
+	r := newReloader(conf)
+
+	_, err = newCertificateLoader(conf, r)
+	assert.Error(t, err)
+
+	writeCertificate("first")
+	loader, err := newCertificateLoader(conf, r)
+	require.NoError(t, err)
+	assert.Equal(t, "first", commonName(loader))
+
+	// the pair replaced in place
+	writeCertificate("second")
+	require.NoError(t, r.Reload(context.Background()))
+	assert.Equal(t, "second", commonName(loader))
+
+	// the current pair is kept if the new one fails to load
+	writeFile(keyFile, []byte("invalid"))
+	require.NoError(t, r.Reload(context.Background()))
+	assert.Equal(t, "second", commonName(loader))
+
+	// the pair moved
+	certFile = filepath.Join(dir, "new.crt")
+	keyFile = filepath.Join(dir, "new.key")
+	writeCertificate("third")
+	writeFile(path, []byte("tls:\n  cert_file: "+certFile+"\n  key_file: "+keyFile+"\n"))
+	require.NoError(t, r.Reload(context.Background()))
+	assert.Equal(t, "third", commonName(loader))
+	assert.Equal(t, certFile, conf.GetString(dconfig.SettingTLSCertFile))
+}
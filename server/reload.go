@@ -0,0 +1,128 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package server
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/mendersoftware/go-lib-micro/config"
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	dconfig "github.com/mendersoftware/azure-iot-manager/config"
+	"github.com/mendersoftware/azure-iot-manager/logging"
+)
+
+var (
+	ErrReloadNotSupported = errors.New("configuration reload is not supported")
+)
+
+// reloader applies the changes of the configuration when reloaded. Only
+// the settings with a registered handler are applied, the others require
+// restarting the service.
+type reloader struct {
+	conf     config.Reader
+	settings map[string]interface{}
+	handlers map[string]func()
+	hooks    []func()
+}
+
+func newReloader(conf config.Reader) *reloader {
+	return &reloader{
+		conf:     conf,
+		settings: snapshotSettings(conf),
+		handlers: make(map[string]func()),
+	}
+}
+
+func snapshotSettings(conf config.Reader) map[string]interface{} {
+	settings := make(map[string]interface{})
+	for _, key := range dconfig.Keys() {
+		settings[key] = conf.Get(key)
+	}
+	return settings
+}
+
+// Handle registers the function applying the changes of the setting key
+func (r *reloader) Handle(key string, f func()) {
+	r.handlers[key] = f
+}
+
+// OnReload registers a function called on every reload, whether or not
+// the settings changed, e.g. for reading files replaced in place again
+func (r *reloader) OnReload(f func()) {
+	r.hooks = append(r.hooks, f)
+}
+
+// Reload reads the configuration file again and applies the changes
+func (r *reloader) Reload(ctx context.Context) error {
+	l := log.FromContext(ctx)
+	rc, ok := r.conf.(interface{ ReadInConfig() error })
+	if !ok {
+		return ErrReloadNotSupported
+	}
+	if err := rc.ReadInConfig(); err != nil {
+		return errors.Wrap(err, "failed to reload configuration")
+	}
+	settings := snapshotSettings(r.conf)
+	changed := false
+	for _, key := range dconfig.Keys() {
+		if reflect.DeepEqual(r.settings[key], settings[key]) {
+			continue
+		}
+		changed = true
+		if f, ok := r.handlers[key]; ok {
+			f()
+			l.Infof("configuration reloaded: %s changed", key)
+		} else {
+			l.Warnf("configuration reloaded: %s changed, "+
+				"the change requires a restart to take effect", key)
+		}
+	}
+	if !changed {
+		l.Info("configuration reloaded: no changes")
+	}
+	for _, f := range r.hooks {
+		f()
+	}
+	r.settings = settings
+	return nil
+}
+
+// setLogLevel sets the global log level, and the levels of the
+// components configured with a level of their own
+func setLogLevel(conf config.Reader) {
+	levels := logging.Levels{
+		Default:    logrus.InfoLevel.String(),
+		Components: map[string]string{},
+	}
+	if conf.GetBool(dconfig.SettingDebugLog) {
+		levels.Default = logrus.DebugLevel.String()
+	}
+	for _, component := range logging.Components {
+		if level := conf.GetString(dconfig.LogLevelKey(component)); level != "" {
+			levels.Components[component] = level
+		}
+	}
+	if err := logging.SetLevels(levels); err != nil {
+		log.NewEmpty().Warnf("invalid %s: %s, using the global log level",
+			dconfig.SettingLogLevels, err)
+		levels.Components = nil
+		_ = logging.SetLevels(levels)
+	}
+}
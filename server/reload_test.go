@@ -0,0 +1,137 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/azure-iot-manager/clock"
+	dconfig "github.com/mendersoftware/azure-iot-manager/config"
+)
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	writeConfig := func(content string) {
+		err := ioutil.WriteFile(path, []byte(content), 0600)
+		require.NoError(t, err)
+	}
+
+	writeConfig("debug_log: false\nlisten: :8080\n")
+	conf := viper.New()
+	conf.SetConfigFile(path)
+	require.NoError(t, conf.ReadInConfig())
+
+	defer log.Log.SetLevel(log.Log.GetLevel())
+	setLogLevel(conf)
+	assert.Equal(t, logrus.InfoLevel, log.Log.GetLevel())
+
+	r := newReloader(conf)
+	reloaded := 0
+	r.Handle(dconfig.SettingDebugLog, func() {
+		reloaded++
+		setLogLevel(conf)
+	})
+
+	writeConfig("debug_log: true\nlisten: :8081\n")
+	err = r.Reload(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reloaded)
+	assert.Equal(t, logrus.DebugLevel, log.Log.GetLevel())
+
+	// no changes
+	err = r.Reload(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reloaded)
+
+	writeConfig("debug_log: [")
+	err = r.Reload(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 1, reloaded)
+
+	err = newReloader(readOnlyConfig{conf}).Reload(context.Background())
+	assert.EqualError(t, err, ErrReloadNotSupported.Error())
+}
+
+func TestReloadRateLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	writeConfig := func(content string) {
+		err := ioutil.WriteFile(path, []byte(content), 0600)
+		require.NoError(t, err)
+	}
+
+	writeConfig("management_rate_limit: 1\n")
+	conf := viper.New()
+	conf.SetConfigFile(path)
+	require.NoError(t, conf.ReadInConfig())
+
+	r := newReloader(conf)
+	clk := clock.NewFake(time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC))
+	router := gin.New()
+	router.Use(newRateLimiter(conf, r, clk).Middleware())
+	router.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	request := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusNoContent, request().Code)
+	assert.Equal(t, http.StatusTooManyRequests, request().Code)
+
+	writeConfig("management_rate_limit: 3\n")
+	require.NoError(t, r.Reload(context.Background()))
+	w := request()
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "3", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+
+	writeConfig("management_rate_limit: 0\n")
+	require.NoError(t, r.Reload(context.Background()))
+	w = request()
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Header().Get("X-RateLimit-Limit"))
+}
+
+// readOnlyConfig shadows the ReadInConfig method of the viper configuration
+// with one not matching the signature required for reloading
+type readOnlyConfig struct {
+	*viper.Viper
+}
+
+func (readOnlyConfig) ReadInConfig() {}
@@ -16,12 +16,16 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"github.com/mendersoftware/azure-iot-manager/store"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
+	"github.com/pkg/errors"
 	"golang.org/x/sys/unix"
 
 	"github.com/mendersoftware/go-lib-micro/config"
@@ -29,22 +33,227 @@ import (
 
 	api "github.com/mendersoftware/azure-iot-manager/api/http"
 	"github.com/mendersoftware/azure-iot-manager/app"
+	"github.com/mendersoftware/azure-iot-manager/client/auditlogs"
+	"github.com/mendersoftware/azure-iot-manager/client/iothub"
+	"github.com/mendersoftware/azure-iot-manager/client/sentry"
+	"github.com/mendersoftware/azure-iot-manager/clock"
 	dconfig "github.com/mendersoftware/azure-iot-manager/config"
+	"github.com/mendersoftware/azure-iot-manager/fips"
+	"github.com/mendersoftware/azure-iot-manager/jwt"
+	"github.com/mendersoftware/azure-iot-manager/logging"
+	"github.com/mendersoftware/azure-iot-manager/version"
 )
 
+const (
+	// auditLogsPurgeInterval is the interval between two purges of the
+	// audit logs past their retention
+	auditLogsPurgeInterval = time.Hour
+)
+
+// Options are the options of the server which are not part of the
+// configuration
+type Options struct {
+	// AzureEmulator replaces the Azure IoT Hub with an in-memory emulator
+	AzureEmulator bool
+	// WorkersOnly runs the background workers without the API
+	WorkersOnly bool
+}
+
+// NewOptions returns a new Options
+func NewOptions() *Options {
+	return new(Options)
+}
+
+// SetAzureEmulator sets whether to use the IoT Hub emulator
+func (o *Options) SetAzureEmulator(emulator bool) *Options {
+	o.AzureEmulator = emulator
+	return o
+}
+
+// SetWorkersOnly sets whether to run the background workers without the API
+func (o *Options) SetWorkersOnly(workersOnly bool) *Options {
+	o.WorkersOnly = workersOnly
+	return o
+}
+
+func mergeOptions(opts []*Options) *Options {
+	opt := NewOptions()
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if o.AzureEmulator {
+			opt.AzureEmulator = o.AzureEmulator
+		}
+		if o.WorkersOnly {
+			opt.WorkersOnly = o.WorkersOnly
+		}
+	}
+	return opt
+}
+
 // InitAndRun initializes the server and runs it
-func InitAndRun(conf config.Reader, dataStore store.DataStore) error {
-	ctx := context.Background()
+func InitAndRun(
+	conf config.Reader,
+	dataStore store.DataStore,
+	opts ...*Options,
+) error {
+	// canceled on return, stopping the background workers
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opt := mergeOptions(opts)
 
-	log.Setup(conf.GetBool(dconfig.SettingDebugLog))
+	logging.Setup()
+	if err := logging.SetFormat(conf.GetString(dconfig.SettingLogFormat)); err != nil {
+		return errors.Wrapf(err, "invalid %s", dconfig.SettingLogFormat)
+	}
+	setLogLevel(conf)
 	l := log.FromContext(ctx)
+	if conf.GetBool(dconfig.SettingFIPSMode) {
+		if err := fips.Enable(); err != nil {
+			return err
+		}
+		l.Info("FIPS mode enabled")
+	}
+	reloader := newReloader(conf)
+	reloader.Handle(dconfig.SettingDebugLog, func() { setLogLevel(conf) })
+	for _, component := range logging.Components {
+		reloader.Handle(dconfig.LogLevelKey(component), func() { setLogLevel(conf) })
+	}
 
-	config := app.Config{}
-	azureIotManagerApp := app.New(config, dataStore)
+	tlsConfig, err := dconfig.TLSConfig(conf)
+	if err != nil {
+		return err
+	}
+	httpClient, err := HTTPClient(conf)
+	if err != nil {
+		return err
+	}
+
+	clk := clock.New()
+	config := app.Config{
+		Features: featureFlags(ctx, conf),
+		Clock:    clk,
+
+		AzureConcurrency:       conf.GetInt(dconfig.SettingAzureConcurrency),
+		AzureTenantConcurrency: conf.GetInt(dconfig.SettingAzureTenantConcurrency),
+		AzureHourlyQuota:       int64(conf.GetInt(dconfig.SettingAzureQuotaHourly)),
+		AzureDailyQuota:        int64(conf.GetInt(dconfig.SettingAzureQuotaDaily)),
+		HealthCacheTTL: time.Duration(
+			conf.GetInt(dconfig.SettingHealthCacheTTL),
+		) * time.Second,
+		RotationGracePeriod: time.Duration(
+			conf.GetInt(dconfig.SettingConnectionStringGracePeriod),
+		) * time.Second,
+		PolicyEnforcement: conf.GetString(dconfig.SettingSASPolicyEnforcement),
+	}
+	if err := app.ValidatePolicyEnforcement(config.PolicyEnforcement); err != nil {
+		return errors.Wrapf(err, "invalid %s", dconfig.SettingSASPolicyEnforcement)
+	}
+	if opt.AzureEmulator {
+		l.Warn("using the IoT Hub emulator, no requests will reach Azure")
+		config.IoTHub = iothub.NewEmulator()
+	} else {
+		config.IoTHub = iothub.NewClient(httpClient, IoTHubOptions(conf))
+	}
+	if addr := conf.GetString(dconfig.SettingAuditLogsAddr); addr != "" {
+		config.AuditLogs = auditlogs.NewClient(addr, httpClient)
+	}
+	azureIotManagerApp := app.NewWithRBAC(app.NewWithPlans(
+		app.New(config, dataStore),
+		planCapabilities(ctx, conf),
+	))
 
-	router, err := api.NewRouter(azureIotManagerApp)
+	var srv *http.Server
+	// serveErrs receives the error of the listener if it stops
+	serveErrs := make(chan error, 1)
+	if !opt.WorkersOnly {
+		srv, err = startAPI(ctx, conf, azureIotManagerApp, clk, reloader,
+			tlsConfig, httpClient, serveErrs)
+		if err != nil {
+			return err
+		}
+	}
+	if opt.WorkersOnly || conf.GetBool(dconfig.SettingWorkersEmbedded) {
+		l.Info("starting the background workers")
+		startWorkers(ctx, conf, azureIotManagerApp, clk, reloader,
+			config.AuditLogs != nil)
+	} else {
+		l.Info("background workers disabled, run them with the worker command")
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, unix.SIGINT, unix.SIGTERM, unix.SIGHUP)
+	defer signal.Stop(quit)
+	var serveErr error
+wait:
+	for {
+		select {
+		case sig := <-quit:
+			if sig != unix.SIGHUP {
+				break wait
+			}
+			l.Info("received SIGHUP, reloading configuration")
+			if err := reloader.Reload(ctx); err != nil {
+				l.Error(err)
+			}
+		case serveErr = <-serveErrs:
+			break wait
+		}
+	}
+
+	l.Info("server shutdown")
+
+	ctxWithTimeout, cancelShutdown := context.WithTimeout(ctx, 5*time.Second)
+	defer cancelShutdown()
+	if srv != nil && serveErr == nil {
+		if err := srv.Shutdown(ctxWithTimeout); err != nil {
+			return errors.Wrap(err, "error when shutting down the server")
+		}
+	}
+
+	l.Info("server exiting")
+	return serveErr
+}
+
+// startAPI starts serving the API in the background; the error stopping
+// the listener is sent to errs.
+func startAPI(
+	ctx context.Context,
+	conf config.Reader,
+	azureIotManagerApp app.App,
+	clk clock.Clock,
+	reloader *reloader,
+	tlsConfig *tls.Config,
+	httpClient *http.Client,
+	errs chan<- error,
+) (*http.Server, error) {
+	l := log.FromContext(ctx)
+	apiKeys, err := internalAPIKeys(conf)
+	if err != nil {
+		return nil, err
+	}
+	jwtVerifier, err := newJWTVerifier(conf, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	errorReporter, err := newErrorReporter(conf, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	routerOpts := api.NewRouterOptions().
+		SetJWTVerifier(jwtVerifier).
+		SetInternalAPIKeys(apiKeys...).
+		SetInternalAPIAllowedCIDRs(
+			conf.GetStringSlice(dconfig.SettingInternalAPIAllowedCIDRs)...,
+		).
+		SetRateLimiter(newRateLimiter(conf, reloader, clk)).
+		SetUsageMetering(true).
+		SetErrorReporter(errorReporter).
+		SetClock(clk)
+	router, err := api.NewRouter(azureIotManagerApp, routerOpts)
 	if err != nil {
-		l.Fatal(err)
+		return nil, err
 	}
 
 	var listen = conf.GetString(dconfig.SettingListen)
@@ -52,28 +261,313 @@ func InitAndRun(conf config.Reader, dataStore store.DataStore) error {
 		Addr:    listen,
 		Handler: router,
 	}
+	serveTLS := conf.GetString(dconfig.SettingTLSCertFile) != "" &&
+		conf.GetString(dconfig.SettingTLSKeyFile) != ""
+	if serveTLS {
+		certificates, err := newCertificateLoader(conf, reloader)
+		if err != nil {
+			return nil, err
+		}
+		srv.TLSConfig = tlsConfig.Clone()
+		srv.TLSConfig.GetCertificate = certificates.GetCertificate
+	}
 
 	l.Info("Azure IoT Manager service starting up")
 	l.Infof("listening on %s", listen)
 
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			l.Fatalf("listen: %s\n", err)
+		var err error
+		if serveTLS {
+			// the certificate is served by TLSConfig.GetCertificate
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			errs <- errors.Wrap(err, "listen")
 		}
 	}()
+	return srv, nil
+}
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, unix.SIGINT, unix.SIGTERM)
-	<-quit
+// newRateLimiter returns the limiter of the management API requests,
+// reloading the limit on SIGHUP
+func newRateLimiter(
+	conf config.Reader,
+	reloader *reloader,
+	clk clock.Clock,
+) *api.RateLimiter {
+	limiter := api.NewRateLimiter(
+		conf.GetInt(dconfig.SettingManagementRateLimit), time.Minute, clk,
+	)
+	reloader.Handle(dconfig.SettingManagementRateLimit, func() {
+		limiter.SetLimit(conf.GetInt(dconfig.SettingManagementRateLimit))
+	})
+	return limiter
+}
 
-	l.Info("server shutdown")
+// startWorkers starts the background workers: the forwarding of the audit
+// logs to the auditlogs service, if configured, and the purge of the audit
+// logs past their retention
+func startWorkers(
+	ctx context.Context,
+	conf config.Reader,
+	azureIotManagerApp app.App,
+	clk clock.Clock,
+	reloader *reloader,
+	forward bool,
+) {
+	l := log.FromContext(ctx)
+	if forward {
+		intervals := make(chan time.Duration, 1)
+		forwardInterval := func() {
+			interval := conf.GetInt(dconfig.SettingAuditLogsForwardInterval)
+			if interval <= 0 {
+				l.Warnf("invalid %s: %d, using the default",
+					dconfig.SettingAuditLogsForwardInterval, interval)
+				interval = dconfig.SettingAuditLogsForwardIntervalDefault
+			}
+			sendLatest(intervals, time.Duration(interval)*time.Second)
+		}
+		forwardInterval()
+		reloader.Handle(dconfig.SettingAuditLogsForwardInterval, forwardInterval)
+		go forwardAuditLogs(ctx, azureIotManagerApp, clk, intervals)
+	}
 
-	ctxWithTimeout, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-	if err := srv.Shutdown(ctxWithTimeout); err != nil {
-		l.Fatal("error when shutting down the server ", err)
+	retentions := make(chan time.Duration, 1)
+	auditLogsRetention := func() {
+		days := conf.GetInt(dconfig.SettingAuditLogsRetention)
+		if days < 0 {
+			l.Warnf("invalid %s: %d, keeping the audit logs forever",
+				dconfig.SettingAuditLogsRetention, days)
+			days = 0
+		}
+		sendLatest(retentions, time.Duration(days)*24*time.Hour)
 	}
+	auditLogsRetention()
+	reloader.Handle(dconfig.SettingAuditLogsRetention, auditLogsRetention)
+	go purgeAuditLogs(ctx, azureIotManagerApp, clk, retentions)
+}
 
-	l.Info("server exiting")
-	return nil
+// sendLatest sends d on the buffered channel ch without blocking, replacing
+// the value not received yet if any: the reloads must not block the signal
+// loop while a worker is busy, and only the latest value matters.
+func sendLatest(ch chan time.Duration, d time.Duration) {
+	for {
+		select {
+		case ch <- d:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// internalAPIKeys returns the internal API keys from the configuration
+// and the keys file if configured.
+func internalAPIKeys(conf config.Reader) ([]string, error) {
+	keys := conf.GetStringSlice(dconfig.SettingInternalAPIKeys)
+	if path := conf.GetString(dconfig.SettingInternalAPIKeysFile); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read internal API keys")
+		}
+		for _, key := range strings.Split(string(b), "\n") {
+			key = strings.TrimSpace(key)
+			if key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// featureFlags returns the feature flags set in the configuration, warning
+// about the ones which do not match any known feature.
+func featureFlags(ctx context.Context, conf config.Reader) map[string]bool {
+	flags := make(map[string]bool)
+	known := make(map[string]struct{}, len(app.Features))
+	for _, feature := range app.Features {
+		known[feature.Name] = struct{}{}
+		key := dconfig.SettingFeatures + "." + feature.Name
+		if conf.IsSet(key) {
+			flags[feature.Name] = conf.GetBool(key)
+		}
+	}
+	for name := range conf.GetStringMap(dconfig.SettingFeatures) {
+		if _, ok := known[name]; !ok {
+			log.FromContext(ctx).
+				Warnf("ignoring configuration of unknown feature %q", name)
+		}
+	}
+	return flags
+}
+
+// planCapabilities returns the capabilities included in each tenant plan;
+// unknown capabilities are ignored.
+func planCapabilities(ctx context.Context, conf config.Reader) map[string][]string {
+	plans := make(map[string][]string)
+	for name := range conf.GetStringMap(dconfig.SettingPlans) {
+		var capabilities []string
+		key := dconfig.SettingPlans + "." + name
+		for _, capability := range conf.GetStringSlice(key) {
+			if !knownCapability(capability) {
+				log.FromContext(ctx).Warnf(
+					"ignoring unknown capability %q of plan %q",
+					capability, name,
+				)
+				continue
+			}
+			capabilities = append(capabilities, capability)
+		}
+		plans[name] = capabilities
+	}
+	return plans
+}
+
+func knownCapability(name string) bool {
+	for _, capability := range app.Capabilities {
+		if capability == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HTTPClient returns the client of the outbound HTTP connections, enforcing
+// the TLS policy of the configuration.
+func HTTPClient(conf config.Reader) (*http.Client, error) {
+	tlsConfig, err := dconfig.TLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
+
+// newJWTVerifier returns the configured token verifier or nil if token
+// verification is disabled.
+func newJWTVerifier(conf config.Reader, httpClient *http.Client) (jwt.Verifier, error) {
+	if path := conf.GetString(dconfig.SettingJWTPublicKeyFile); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read JWT public key")
+		}
+		keys, err := jwt.ParsePublicKeys(b)
+		if err != nil {
+			return nil, err
+		}
+		if fips.Enabled() {
+			for _, key := range keys {
+				if err := fips.ValidatePublicKey(key); err != nil {
+					return nil, errors.Wrap(err, "invalid JWT public key")
+				}
+			}
+		}
+		return jwt.NewKeyVerifier(keys...), nil
+	} else if url := conf.GetString(dconfig.SettingJWKSURL); url != "" {
+		return jwt.NewJWKSVerifier(url, httpClient), nil
+	}
+	return nil, nil
+}
+
+// newErrorReporter returns the client of the error tracker configured in
+// conf, or nil if error reporting is disabled
+func newErrorReporter(conf config.Reader, httpClient *http.Client) (sentry.Client, error) {
+	dsn := conf.GetString(dconfig.SettingErrorReportingDSN)
+	if dsn == "" {
+		return nil, nil
+	}
+	hostname, _ := os.Hostname()
+	return sentry.NewClient(dsn, sentry.NewOptions().
+		SetRelease(version.Get().Version).
+		SetServerName(hostname).
+		SetHTTPClient(httpClient),
+	)
+}
+
+// forwardAuditLogs periodically forwards the locally stored audit logs to
+// the auditlogs service. The first value received from intervals sets the
+// forwarding interval, the following ones update it.
+func forwardAuditLogs(
+	ctx context.Context,
+	app app.App,
+	clk clock.Clock,
+	intervals <-chan time.Duration,
+) {
+	l := log.FromContext(ctx)
+	var interval time.Duration
+	select {
+	case <-ctx.Done():
+		return
+	case interval = <-intervals:
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case interval = <-intervals:
+			continue
+		case <-clk.After(interval):
+		}
+		n, err := app.ForwardAuditLogs(ctx)
+		if err != nil {
+			l.Warnf("failed to forward audit logs: %s", err)
+		}
+		if n > 0 {
+			l.Debugf("forwarded %d audit logs", n)
+		}
+	}
+}
+
+// purgeAuditLogs deletes the audit logs older than the retention received
+// from retentions, when the retention is received and then every
+// auditLogsPurgeInterval; a zero retention keeps the logs forever.
+func purgeAuditLogs(
+	ctx context.Context,
+	app app.App,
+	clk clock.Clock,
+	retentions <-chan time.Duration,
+) {
+	l := log.FromContext(ctx)
+	var retention time.Duration
+	select {
+	case <-ctx.Done():
+		return
+	case retention = <-retentions:
+	}
+	for {
+		if retention > 0 {
+			n, err := app.PurgeAuditLogs(ctx, clk.Now().Add(-retention))
+			if err != nil {
+				l.Warnf("failed to purge audit logs: %s", err)
+			}
+			if n > 0 {
+				l.Infof("purged %d audit logs older than %s", n, retention)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case retention = <-retentions:
+		case <-clk.After(auditLogsPurgeInterval):
+		}
+	}
+}
+
+// IoTHubOptions returns the options of the IoT Hub client from the
+// configuration
+func IoTHubOptions(conf config.Reader) *iothub.Options {
+	opts := iothub.NewOptions()
+	for operation, key := range map[string]string{
+		iothub.OperationRegistry: dconfig.SettingAzureTimeoutRegistry,
+		iothub.OperationService:  dconfig.SettingAzureTimeoutService,
+	} {
+		opts.SetTimeout(operation, time.Duration(conf.GetInt(key))*time.Second)
+	}
+	return opts
 }
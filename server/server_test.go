@@ -0,0 +1,239 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/azure-iot-manager/app"
+	app_mocks "github.com/mendersoftware/azure-iot-manager/app/mocks"
+	"github.com/mendersoftware/azure-iot-manager/client/iothub"
+	"github.com/mendersoftware/azure-iot-manager/client/sentry"
+	"github.com/mendersoftware/azure-iot-manager/clock"
+	dconfig "github.com/mendersoftware/azure-iot-manager/config"
+	store_mocks "github.com/mendersoftware/azure-iot-manager/store/mocks"
+)
+
+func TestForwardAuditLogs(t *testing.T) {
+	app := &app_mocks.App{}
+	defer app.AssertExpectations(t)
+	forwarded := make(chan struct{}, 1)
+	app.On("ForwardAuditLogs", mock.Anything).
+		Run(func(mock.Arguments) { forwarded <- struct{}{} }).
+		Return(1, nil)
+
+	clk := clock.NewFake(time.Now())
+	intervals := make(chan time.Duration, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		forwardAuditLogs(ctx, app, clk, intervals)
+		close(done)
+	}()
+	waiting := func(n int) func() bool {
+		return func() bool { return clk.Waiters() == n }
+	}
+
+	intervals <- 10 * time.Second
+	assert.Eventually(t, waiting(1), time.Second, time.Millisecond)
+	clk.Advance(10 * time.Second)
+	<-forwarded
+
+	// the new interval applies to the next wait
+	assert.Eventually(t, waiting(1), time.Second, time.Millisecond)
+	intervals <- time.Minute
+	assert.Eventually(t, waiting(2), time.Second, time.Millisecond)
+	clk.Advance(30 * time.Second)
+	select {
+	case <-forwarded:
+		t.Error("audit logs forwarded before the interval elapsed")
+	case <-time.After(10 * time.Millisecond):
+	}
+	clk.Advance(30 * time.Second)
+	<-forwarded
+
+	cancel()
+	<-done
+}
+
+func TestPurgeAuditLogs(t *testing.T) {
+	app := &app_mocks.App{}
+	defer app.AssertExpectations(t)
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	purged := make(chan time.Time, 1)
+	app.On("PurgeAuditLogs", mock.Anything, mock.AnythingOfType("time.Time")).
+		Run(func(args mock.Arguments) { purged <- args.Get(1).(time.Time) }).
+		Return(1, nil)
+
+	clk := clock.NewFake(now)
+	retentions := make(chan time.Duration, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		purgeAuditLogs(ctx, app, clk, retentions)
+		close(done)
+	}()
+	waiting := func(n int) func() bool {
+		return func() bool { return clk.Waiters() == n }
+	}
+
+	// retention disabled
+	retentions <- 0
+	assert.Eventually(t, waiting(1), time.Second, time.Millisecond)
+	clk.Advance(auditLogsPurgeInterval)
+	assert.Eventually(t, waiting(1), time.Second, time.Millisecond)
+	select {
+	case <-purged:
+		t.Error("audit logs purged with retention disabled")
+	default:
+	}
+
+	// purged as soon as the retention is set, then periodically
+	retentions <- 24 * time.Hour
+	assert.Equal(t, now.Add(auditLogsPurgeInterval-24*time.Hour), <-purged)
+	assert.Eventually(t, waiting(2), time.Second, time.Millisecond)
+	clk.Advance(auditLogsPurgeInterval)
+	assert.Equal(t, now.Add(2*auditLogsPurgeInterval-24*time.Hour), <-purged)
+
+	cancel()
+	<-done
+}
+
+func TestSendLatest(t *testing.T) {
+	ch := make(chan time.Duration, 1)
+	sendLatest(ch, time.Second)
+	// the pending value is replaced instead of blocking the sender
+	sendLatest(ch, time.Minute)
+	sendLatest(ch, time.Hour)
+	assert.Equal(t, time.Hour, <-ch)
+	select {
+	case d := <-ch:
+		t.Errorf("unexpected value: %s", d)
+	default:
+	}
+}
+
+func TestIoTHubOptions(t *testing.T) {
+	conf := viper.New()
+	conf.Set(dconfig.SettingAzureTimeoutRegistry, 5)
+
+	opts := IoTHubOptions(conf)
+	assert.Equal(t, map[string]time.Duration{
+		iothub.OperationRegistry: 5 * time.Second,
+		iothub.OperationService:  0,
+	}, opts.Timeouts)
+}
+
+func TestHTTPClient(t *testing.T) {
+	conf := viper.New()
+	conf.Set(dconfig.SettingTLSMinVersion, "1.3")
+	client, err := HTTPClient(conf)
+	if assert.NoError(t, err) {
+		transport := client.Transport.(*http.Transport)
+		assert.Equal(t, uint16(tls.VersionTLS13), transport.TLSClientConfig.MinVersion)
+	}
+
+	conf.Set(dconfig.SettingTLSMinVersion, "1.0")
+	_, err = HTTPClient(conf)
+	assert.Error(t, err)
+}
+
+func TestNewErrorReporter(t *testing.T) {
+	conf := viper.New()
+	reporter, err := newErrorReporter(conf, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, reporter)
+
+	conf.Set(dconfig.SettingErrorReportingDSN, "https://abc123@sentry.example.com/42")
+	reporter, err = newErrorReporter(conf, nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, reporter)
+
+	conf.Set(dconfig.SettingErrorReportingDSN, "https://sentry.example.com/42")
+	_, err = newErrorReporter(conf, nil)
+	assert.ErrorIs(t, err, sentry.ErrInvalidDSN)
+}
+
+func TestPlanCapabilities(t *testing.T) {
+	conf := viper.New()
+	conf.Set(dconfig.SettingPlans, map[string]interface{}{
+		"enterprise": []string{
+			app.CapabilitySettingsRotation,
+			app.CapabilityAuditLogsExport,
+		},
+		"professional": []string{app.CapabilityAuditLogsExport, "bulk_jobs"},
+	})
+
+	plans := planCapabilities(context.Background(), conf)
+	assert.Equal(t, map[string][]string{
+		"enterprise": {
+			app.CapabilitySettingsRotation,
+			app.CapabilityAuditLogsExport,
+		},
+		"professional": {app.CapabilityAuditLogsExport},
+	}, plans)
+}
+
+func TestMergeOptions(t *testing.T) {
+	opt := mergeOptions([]*Options{
+		NewOptions().SetAzureEmulator(true),
+		nil,
+		NewOptions().SetWorkersOnly(true),
+	})
+	assert.True(t, opt.AzureEmulator)
+	assert.True(t, opt.WorkersOnly)
+
+	opt = mergeOptions(nil)
+	assert.False(t, opt.AzureEmulator)
+	assert.False(t, opt.WorkersOnly)
+}
+
+func TestInitAndRunListenError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer l.Close()
+
+	conf := viper.New()
+	for _, d := range dconfig.Defaults {
+		conf.SetDefault(d.Key, d.Value)
+	}
+	conf.Set(dconfig.SettingListen, l.Addr().String())
+	conf.Set(dconfig.SettingWorkersEmbedded, false)
+	ds := &store_mocks.DataStore{}
+	defer ds.AssertExpectations(t)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- InitAndRun(conf, ds, NewOptions().SetAzureEmulator(true))
+	}()
+	select {
+	case err := <-done:
+		assert.Contains(t, err.Error(), "listen")
+		assert.Contains(t, err.Error(), "address already in use")
+	case <-time.After(5 * time.Second):
+		t.Error("timeout waiting for the listen error")
+	}
+}
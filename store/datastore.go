@@ -17,6 +17,9 @@ package store
 import (
 	"context"
 	"errors"
+	"time"
+
+	"github.com/google/uuid"
 
 	"github.com/mendersoftware/azure-iot-manager/model"
 )
@@ -27,12 +30,35 @@ import (
 type DataStore interface {
 	Ping(ctx context.Context) error
 	Close() error
+	GetMigrationStatus(ctx context.Context) (model.MigrationStatus, error)
+	GetMissingIndexes(ctx context.Context) ([]string, error)
+	GetServerTime(ctx context.Context) (time.Time, error)
 
 	SetSettings(ctx context.Context, settings model.Settings) error
+	SetSettingsIfMatch(ctx context.Context, settings model.Settings, match func(current model.Settings) bool) error
 	GetSettings(ctx context.Context) (model.Settings, error)
+	RotateSettings(ctx context.Context, current string, settings model.Settings) error
+	ListSettings(ctx context.Context) ([]model.TenantSettings, error)
+	ReencryptSettings(ctx context.Context) (model.ReencryptionResult, error)
+
+	GetFeatureFlags(ctx context.Context) (map[string]bool, error)
+	SetFeatureFlag(ctx context.Context, name string, enabled bool) error
+	DeleteFeatureFlag(ctx context.Context, name string) error
+
+	IncrementUsage(ctx context.Context, period string, counter string, n int64) error
+	ListUsage(ctx context.Context, period string) ([]model.Usage, error)
+	IncrementQuota(ctx context.Context, window string, expiresAt time.Time) (int64, error)
+
+	InsertAuditLog(ctx context.Context, log model.AuditLog) error
+	IterateAuditLogs(ctx context.Context, filter model.AuditLogFilter, fn func(model.AuditLog) error) error
+	ClaimAuditLogs(ctx context.Context, limit int, lease time.Duration) ([]model.AuditLog, error)
+	SetAuditLogForwarded(ctx context.Context, id uuid.UUID) error
+	GetOldestUnforwardedAuditLog(ctx context.Context) (*model.AuditLog, error)
+	DeleteAuditLogs(ctx context.Context, before time.Time, forwardedOnly bool) (int64, error)
 }
 
 var (
 	ErrSerialization  = errors.New("store: failed to serialize object")
 	ErrObjectNotFound = errors.New("store: object not found")
+	ErrObjectModified = errors.New("store: object modified concurrently")
 )
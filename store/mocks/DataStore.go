@@ -18,10 +18,11 @@ package mocks
 
 import (
 	context "context"
+	time "time"
 
-	mock "github.com/stretchr/testify/mock"
-
+	uuid "github.com/google/uuid"
 	model "github.com/mendersoftware/azure-iot-manager/model"
+	mock "github.com/stretchr/testify/mock"
 )
 
 // DataStore is an autogenerated mock type for the DataStore type
@@ -29,6 +30,29 @@ type DataStore struct {
 	mock.Mock
 }
 
+// ClaimAuditLogs provides a mock function with given fields: ctx, limit, lease
+func (_m *DataStore) ClaimAuditLogs(ctx context.Context, limit int, lease time.Duration) ([]model.AuditLog, error) {
+	ret := _m.Called(ctx, limit, lease)
+
+	var r0 []model.AuditLog
+	if rf, ok := ret.Get(0).(func(context.Context, int, time.Duration) []model.AuditLog); ok {
+		r0 = rf(ctx, limit, lease)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.AuditLog)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int, time.Duration) error); ok {
+		r1 = rf(ctx, limit, lease)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Close provides a mock function with given fields:
 func (_m *DataStore) Close() error {
 	ret := _m.Called()
@@ -43,6 +67,152 @@ func (_m *DataStore) Close() error {
 	return r0
 }
 
+// DeleteAuditLogs provides a mock function with given fields: ctx, before, forwardedOnly
+func (_m *DataStore) DeleteAuditLogs(ctx context.Context, before time.Time, forwardedOnly bool) (int64, error) {
+	ret := _m.Called(ctx, before, forwardedOnly)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, bool) int64); ok {
+		r0 = rf(ctx, before, forwardedOnly)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time, bool) error); ok {
+		r1 = rf(ctx, before, forwardedOnly)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteFeatureFlag provides a mock function with given fields: ctx, name
+func (_m *DataStore) DeleteFeatureFlag(ctx context.Context, name string) error {
+	ret := _m.Called(ctx, name)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, name)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetFeatureFlags provides a mock function with given fields: ctx
+func (_m *DataStore) GetFeatureFlags(ctx context.Context) (map[string]bool, error) {
+	ret := _m.Called(ctx)
+
+	var r0 map[string]bool
+	if rf, ok := ret.Get(0).(func(context.Context) map[string]bool); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[string]bool)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetMigrationStatus provides a mock function with given fields: ctx
+func (_m *DataStore) GetMigrationStatus(ctx context.Context) (model.MigrationStatus, error) {
+	ret := _m.Called(ctx)
+
+	var r0 model.MigrationStatus
+	if rf, ok := ret.Get(0).(func(context.Context) model.MigrationStatus); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(model.MigrationStatus)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetMissingIndexes provides a mock function with given fields: ctx
+func (_m *DataStore) GetMissingIndexes(ctx context.Context) ([]string, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(context.Context) []string); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetOldestUnforwardedAuditLog provides a mock function with given fields: ctx
+func (_m *DataStore) GetOldestUnforwardedAuditLog(ctx context.Context) (*model.AuditLog, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *model.AuditLog
+	if rf, ok := ret.Get(0).(func(context.Context) *model.AuditLog); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.AuditLog)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetServerTime provides a mock function with given fields: ctx
+func (_m *DataStore) GetServerTime(ctx context.Context) (time.Time, error) {
+	ret := _m.Called(ctx)
+
+	var r0 time.Time
+	if rf, ok := ret.Get(0).(func(context.Context) time.Time); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(time.Time)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetSettings provides a mock function with given fields: ctx
 func (_m *DataStore) GetSettings(ctx context.Context) (model.Settings, error) {
 	ret := _m.Called(ctx)
@@ -64,6 +234,115 @@ func (_m *DataStore) GetSettings(ctx context.Context) (model.Settings, error) {
 	return r0, r1
 }
 
+// IncrementQuota provides a mock function with given fields: ctx, window, expiresAt
+func (_m *DataStore) IncrementQuota(ctx context.Context, window string, expiresAt time.Time) (int64, error) {
+	ret := _m.Called(ctx, window, expiresAt)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) int64); ok {
+		r0 = rf(ctx, window, expiresAt)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time) error); ok {
+		r1 = rf(ctx, window, expiresAt)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// IncrementUsage provides a mock function with given fields: ctx, period, counter, n
+func (_m *DataStore) IncrementUsage(ctx context.Context, period string, counter string, n int64) error {
+	ret := _m.Called(ctx, period, counter, n)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, int64) error); ok {
+		r0 = rf(ctx, period, counter, n)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// InsertAuditLog provides a mock function with given fields: ctx, log
+func (_m *DataStore) InsertAuditLog(ctx context.Context, log model.AuditLog) error {
+	ret := _m.Called(ctx, log)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuditLog) error); ok {
+		r0 = rf(ctx, log)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// IterateAuditLogs provides a mock function with given fields: ctx, filter, fn
+func (_m *DataStore) IterateAuditLogs(ctx context.Context, filter model.AuditLogFilter, fn func(model.AuditLog) error) error {
+	ret := _m.Called(ctx, filter, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuditLogFilter, func(model.AuditLog) error) error); ok {
+		r0 = rf(ctx, filter, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ListSettings provides a mock function with given fields: ctx
+func (_m *DataStore) ListSettings(ctx context.Context) ([]model.TenantSettings, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.TenantSettings
+	if rf, ok := ret.Get(0).(func(context.Context) []model.TenantSettings); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.TenantSettings)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListUsage provides a mock function with given fields: ctx, period
+func (_m *DataStore) ListUsage(ctx context.Context, period string) ([]model.Usage, error) {
+	ret := _m.Called(ctx, period)
+
+	var r0 []model.Usage
+	if rf, ok := ret.Get(0).(func(context.Context, string) []model.Usage); ok {
+		r0 = rf(ctx, period)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Usage)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, period)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Ping provides a mock function with given fields: ctx
 func (_m *DataStore) Ping(ctx context.Context) error {
 	ret := _m.Called(ctx)
@@ -78,6 +357,69 @@ func (_m *DataStore) Ping(ctx context.Context) error {
 	return r0
 }
 
+// ReencryptSettings provides a mock function with given fields: ctx
+func (_m *DataStore) ReencryptSettings(ctx context.Context) (model.ReencryptionResult, error) {
+	ret := _m.Called(ctx)
+
+	var r0 model.ReencryptionResult
+	if rf, ok := ret.Get(0).(func(context.Context) model.ReencryptionResult); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(model.ReencryptionResult)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RotateSettings provides a mock function with given fields: ctx, current, settings
+func (_m *DataStore) RotateSettings(ctx context.Context, current string, settings model.Settings) error {
+	ret := _m.Called(ctx, current, settings)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.Settings) error); ok {
+		r0 = rf(ctx, current, settings)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetAuditLogForwarded provides a mock function with given fields: ctx, id
+func (_m *DataStore) SetAuditLogForwarded(ctx context.Context, id uuid.UUID) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetFeatureFlag provides a mock function with given fields: ctx, name, enabled
+func (_m *DataStore) SetFeatureFlag(ctx context.Context, name string, enabled bool) error {
+	ret := _m.Called(ctx, name, enabled)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, bool) error); ok {
+		r0 = rf(ctx, name, enabled)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // SetSettings provides a mock function with given fields: ctx, settings
 func (_m *DataStore) SetSettings(ctx context.Context, settings model.Settings) error {
 	ret := _m.Called(ctx, settings)
@@ -91,3 +433,17 @@ func (_m *DataStore) SetSettings(ctx context.Context, settings model.Settings) e
 
 	return r0
 }
+
+// SetSettingsIfMatch provides a mock function with given fields: ctx, settings, match
+func (_m *DataStore) SetSettingsIfMatch(ctx context.Context, settings model.Settings, match func(current model.Settings) bool) error {
+	ret := _m.Called(ctx, settings, match)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.Settings, func(current model.Settings) bool) error); ok {
+		r0 = rf(ctx, settings, match)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -16,11 +16,11 @@ package mongo
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -30,14 +30,32 @@ import (
 	"github.com/mendersoftware/go-lib-micro/identity"
 	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
 
+	"github.com/mendersoftware/azure-iot-manager/clock"
 	dconfig "github.com/mendersoftware/azure-iot-manager/config"
+	"github.com/mendersoftware/azure-iot-manager/keyring"
 	"github.com/mendersoftware/azure-iot-manager/model"
 	"github.com/mendersoftware/azure-iot-manager/store"
 )
 
 const (
-	CollNameSettings = "settings"
-	KeyTenantID      = "tenant_id"
+	CollNameSettings  = "settings"
+	CollNameAuditLogs = "audit_logs"
+	CollNameFeatures  = "feature_flags"
+	CollNameUsage     = "usage"
+	CollNameQuotas    = "quotas"
+
+	KeyID              = "_id"
+	KeyTenantID        = "tenant_id"
+	KeyConnStr         = "connection_string"
+	KeyPreviousConnStr = "previous_connection_string"
+	KeyTime            = "time"
+	KeyForwarded       = "forwarded"
+	KeyClaimedUntil    = "claimed_until"
+	KeyFlags           = "flags"
+	KeyPeriod          = "period"
+	KeyWindow          = "window"
+	KeyCount           = "count"
+	KeyExpiresAt       = "expires_at"
 
 	ConnectTimeoutSeconds = 10
 	defaultAutomigrate    = false
@@ -49,11 +67,18 @@ var (
 
 type Config struct {
 	Automigrate *bool
+	// Clock is the time source used for the audit log leases
+	Clock clock.Clock
+	// Keyring encrypts the stored connection strings; if nil, they are
+	// stored in plaintext
+	Keyring *keyring.Keyring
 }
 
 func NewConfig() *Config {
 	conf := new(Config)
-	return conf.SetAutomigrate(defaultAutomigrate)
+	return conf.
+		SetAutomigrate(defaultAutomigrate).
+		SetClock(clock.New())
 }
 
 func (c *Config) SetAutomigrate(migrate bool) *Config {
@@ -61,12 +86,28 @@ func (c *Config) SetAutomigrate(migrate bool) *Config {
 	return c
 }
 
+func (c *Config) SetClock(clock clock.Clock) *Config {
+	c.Clock = clock
+	return c
+}
+
+func (c *Config) SetKeyring(keyring *keyring.Keyring) *Config {
+	c.Keyring = keyring
+	return c
+}
+
 func mergeConfig(configs []*Config) *Config {
 	config := NewConfig()
 	for _, c := range configs {
 		if c.Automigrate != nil {
 			config.SetAutomigrate(*c.Automigrate)
 		}
+		if c.Clock != nil {
+			config.SetClock(c.Clock)
+		}
+		if c.Keyring != nil {
+			config.SetKeyring(c.Keyring)
+		}
 	}
 	return config
 }
@@ -75,6 +116,13 @@ func mergeConfig(configs []*Config) *Config {
 func SetupDataStore(conf *Config) (store.DataStore, error) {
 	conf = mergeConfig([]*Config{conf})
 	ctx := context.Background()
+	if conf.Keyring == nil {
+		keyring, err := dconfig.Keyring(config.Config)
+		if err != nil {
+			return nil, err
+		}
+		conf.SetKeyring(keyring)
+	}
 	dbClient, err := NewClient(ctx, config.Config)
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("failed to connect to db: %v", err))
@@ -83,7 +131,7 @@ func SetupDataStore(conf *Config) (store.DataStore, error) {
 	if err != nil {
 		return nil, err
 	}
-	dataStore := NewDataStoreWithClient(dbClient)
+	dataStore := NewDataStoreWithClient(dbClient, conf)
 	return dataStore, nil
 }
 
@@ -116,8 +164,18 @@ func NewClient(ctx context.Context, c config.Reader) (*mongo.Client, error) {
 		clientOptions.SetAuth(credentials)
 	}
 
+	threshold := c.GetInt(dconfig.SettingDbSlowQueryThreshold)
+	if threshold > 0 {
+		clientOptions.SetMonitor(
+			newSlowQueryMonitor(time.Duration(threshold) * time.Millisecond),
+		)
+	}
+
 	if c.GetBool(dconfig.SettingDbSSL) {
-		tlsConfig := &tls.Config{}
+		tlsConfig, err := dconfig.TLSConfig(c)
+		if err != nil {
+			return nil, err
+		}
 		tlsConfig.InsecureSkipVerify = c.GetBool(dconfig.SettingDbSSLSkipVerify)
 		clientOptions.SetTLSConfig(tlsConfig)
 	}
@@ -164,6 +222,20 @@ func (db *DataStoreMongo) Ping(ctx context.Context) error {
 	return res.Err()
 }
 
+// GetServerTime returns the current time of the database server
+func (db *DataStoreMongo) GetServerTime(ctx context.Context) (time.Time, error) {
+	var res struct {
+		LocalTime time.Time `bson:"localTime"`
+	}
+	err := db.client.Database(DbName).
+		RunCommand(ctx, bson.M{"isMaster": 1}).
+		Decode(&res)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "failed to get the server time")
+	}
+	return res.LocalTime, nil
+}
+
 func (db *DataStoreMongo) Close() error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
@@ -181,7 +253,15 @@ func (db *DataStoreMongo) SetSettings(ctx context.Context, settings model.Settin
 		tenantID = identity.Tenant
 	}
 
-	_, err := collSettings.ReplaceOne(ctx, bson.M{KeyTenantID: tenantID}, mstore.WithTenantID(ctx, settings), o)
+	settings, err := db.encryptSettings(settings, tenantID)
+	if err != nil {
+		return err
+	}
+	_, err = collSettings.ReplaceOne(ctx,
+		bson.M{KeyTenantID: tenantID},
+		mstore.WithTenantID(ctx, settings),
+		o,
+	)
 	if err != nil && err != mongo.ErrNoDocuments {
 		return errors.Wrapf(err, "failed to store settings %v", settings)
 	}
@@ -189,6 +269,108 @@ func (db *DataStoreMongo) SetSettings(ctx context.Context, settings model.Settin
 	return err
 }
 
+// SetSettingsIfMatch replaces the settings of the tenant only if match
+// accepts the stored settings and they are not modified before the write
+func (db *DataStoreMongo) SetSettingsIfMatch(
+	ctx context.Context,
+	settings model.Settings,
+	match func(current model.Settings) bool,
+) error {
+	collSettings := db.client.Database(DbName).Collection(CollNameSettings)
+	tenantID := tenantIDFromContext(ctx)
+	filter := bson.D{{Key: KeyTenantID, Value: tenantID}}
+
+	var stored model.Settings
+	err := collSettings.FindOne(ctx, filter).Decode(&stored)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return errors.Wrap(err, ErrFailedToGetSettings.Error())
+	}
+	found := err == nil
+	current := stored
+	if err := db.decryptSettings(&current, tenantID); err != nil {
+		return err
+	} else if !match(current) {
+		return store.ErrObjectModified
+	}
+
+	settings, err = db.encryptSettings(settings, tenantID)
+	if err != nil {
+		return err
+	}
+	doc := mstore.WithTenantID(ctx, settings)
+	if !found {
+		// the document is identified by the tenant, so that the
+		// concurrent inserts fail on the unique _id index
+		doc = append(bson.D{{Key: KeyID, Value: tenantID}}, doc...)
+		_, err = collSettings.InsertOne(ctx, doc)
+		if mongo.IsDuplicateKeyError(err) {
+			return store.ErrObjectModified
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to store settings %v", settings)
+		}
+		return nil
+	}
+	// the stored connection string is encrypted with a random nonce, so
+	// every write changes it
+	var version interface{} = stored.ConnectionString
+	if stored.ConnectionString == "" {
+		version = bson.D{{Key: "$exists", Value: false}}
+	}
+	filter = append(filter, bson.E{Key: KeyConnStr, Value: version})
+	res, err := collSettings.ReplaceOne(ctx, filter, doc)
+	if err != nil {
+		return errors.Wrapf(err, "failed to store settings %v", settings)
+	} else if res.MatchedCount == 0 {
+		return store.ErrObjectModified
+	}
+	return nil
+}
+
+// RotateSettings replaces the settings of the tenant only if the stored
+// connection string is still current
+func (db *DataStoreMongo) RotateSettings(
+	ctx context.Context,
+	current string,
+	settings model.Settings,
+) error {
+	collSettings := db.client.Database(DbName).Collection(CollNameSettings)
+	// the stored connection string may be encrypted with a random nonce:
+	// compare the plaintext, then swap on the stored value
+	var stored model.Settings
+	tenantID := tenantIDFromContext(ctx)
+	err := collSettings.FindOne(ctx,
+		bson.D{{Key: KeyTenantID, Value: tenantID}},
+	).Decode(&stored)
+	if err == mongo.ErrNoDocuments {
+		return store.ErrObjectModified
+	} else if err != nil {
+		return errors.Wrap(err, "failed to rotate settings")
+	}
+	plaintext, err := db.Keyring.Decrypt(stored.ConnectionString, tenantID)
+	if err != nil {
+		return errors.Wrap(err, "failed to rotate settings")
+	} else if plaintext != current {
+		return store.ErrObjectModified
+	}
+	settings, err = db.encryptSettings(settings, tenantID)
+	if err != nil {
+		return err
+	}
+	res, err := collSettings.ReplaceOne(ctx,
+		bson.D{
+			{Key: KeyTenantID, Value: tenantID},
+			{Key: KeyConnStr, Value: stored.ConnectionString},
+		},
+		mstore.WithTenantID(ctx, settings),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to rotate settings")
+	} else if res.MatchedCount == 0 {
+		return store.ErrObjectModified
+	}
+	return nil
+}
+
 func (db *DataStoreMongo) GetSettings(ctx context.Context) (model.Settings, error) {
 	var settings model.Settings
 
@@ -207,5 +389,422 @@ func (db *DataStoreMongo) GetSettings(ctx context.Context) (model.Settings, erro
 			return model.Settings{}, errors.Wrap(err, ErrFailedToGetSettings.Error())
 		}
 	}
+	if err := db.decryptSettings(&settings, tenantId); err != nil {
+		return model.Settings{}, err
+	}
 	return settings, nil
 }
+
+// ListSettings returns the settings of all the tenants with a connection
+// string configured, sorted by tenant ID
+func (db *DataStoreMongo) ListSettings(ctx context.Context) ([]model.TenantSettings, error) {
+	collSettings := db.client.Database(DbName).Collection(CollNameSettings)
+	cur, err := collSettings.Find(ctx,
+		bson.D{{Key: KeyConnStr, Value: bson.D{
+			{Key: "$nin", Value: bson.A{nil, ""}},
+		}}},
+		mopts.Find().SetSort(bson.D{{Key: KeyTenantID, Value: 1}}),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, ErrFailedToGetSettings.Error())
+	}
+	settings := []model.TenantSettings{}
+	if err := cur.All(ctx, &settings); err != nil {
+		return nil, errors.Wrap(err, ErrFailedToGetSettings.Error())
+	}
+	for i := range settings {
+		err := db.decryptSettings(&settings[i].Settings, settings[i].TenantID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tenant %s", settings[i].TenantID)
+		}
+	}
+	return settings, nil
+}
+
+// encryptSettings returns the settings of the tenant with the connection
+// strings encrypted with the current key
+func (db *DataStoreMongo) encryptSettings(
+	settings model.Settings,
+	tenantID string,
+) (model.Settings, error) {
+	var err error
+	settings.ConnectionString, err = db.Keyring.Encrypt(settings.ConnectionString, tenantID)
+	if err == nil {
+		settings.PreviousConnectionString, err = db.Keyring.Encrypt(
+			settings.PreviousConnectionString, tenantID,
+		)
+	}
+	if err != nil {
+		return model.Settings{}, errors.Wrap(err, "failed to encrypt settings")
+	}
+	return settings, nil
+}
+
+// decryptSettings decrypts the connection strings of the settings of the
+// tenant
+func (db *DataStoreMongo) decryptSettings(settings *model.Settings, tenantID string) error {
+	var err error
+	settings.ConnectionString, err = db.Keyring.Decrypt(settings.ConnectionString, tenantID)
+	if err == nil {
+		settings.PreviousConnectionString, err = db.Keyring.Decrypt(
+			settings.PreviousConnectionString, tenantID,
+		)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to decrypt settings")
+	}
+	return nil
+}
+
+// ReencryptSettings re-encrypts the stored connection strings which are not
+// encrypted with the current key of the keyring or not bound to their
+// tenant, or decrypts them if the keyring has no current key. Each document
+// is swapped only if unchanged, so it is safe to run while the service is
+// writing with the current key; an interrupted run is resumed by running it
+// again.
+func (db *DataStoreMongo) ReencryptSettings(ctx context.Context) (model.ReencryptionResult, error) {
+	var result model.ReencryptionResult
+	collSettings := db.client.Database(DbName).Collection(CollNameSettings)
+	cur, err := collSettings.Find(ctx, bson.D{},
+		mopts.Find().SetSort(bson.D{{Key: KeyTenantID, Value: 1}}),
+	)
+	if err != nil {
+		return result, errors.Wrap(err, ErrFailedToGetSettings.Error())
+	}
+	defer cur.Close(ctx)
+	current := db.Keyring.Current()
+	for cur.Next(ctx) {
+		var stored struct {
+			ID             interface{} `bson:"_id"`
+			TenantID       string      `bson:"tenant_id"`
+			model.Settings `bson:",inline"`
+		}
+		if err := cur.Decode(&stored); err != nil {
+			return result, errors.Wrap(err, ErrFailedToGetSettings.Error())
+		}
+		result.Checked++
+		filter := bson.D{{Key: KeyID, Value: stored.ID}}
+		update := bson.D{}
+		for _, field := range []struct {
+			key   string
+			value string
+		}{
+			{KeyConnStr, stored.ConnectionString},
+			{KeyPreviousConnStr, stored.PreviousConnectionString},
+		} {
+			upToDate := keyring.KeyID(field.value) == current &&
+				!keyring.Legacy(field.value)
+			if field.value == "" || upToDate {
+				continue
+			}
+			plaintext, err := db.Keyring.Decrypt(field.value, stored.TenantID)
+			if err == nil {
+				plaintext, err = db.Keyring.Encrypt(plaintext, stored.TenantID)
+			}
+			if err != nil {
+				return result, errors.Wrapf(err, "tenant %s", stored.TenantID)
+			}
+			filter = append(filter, bson.E{Key: field.key, Value: field.value})
+			update = append(update, bson.E{Key: field.key, Value: plaintext})
+		}
+		if len(update) == 0 {
+			continue
+		}
+		res, err := collSettings.UpdateOne(ctx, filter, bson.D{
+			{Key: "$set", Value: update},
+		})
+		if err != nil {
+			return result, errors.Wrapf(err, "tenant %s", stored.TenantID)
+		} else if res.MatchedCount == 0 {
+			result.Modified++
+		} else {
+			result.Reencrypted++
+		}
+	}
+	return result, errors.Wrap(cur.Err(), ErrFailedToGetSettings.Error())
+}
+
+func tenantIDFromContext(ctx context.Context) string {
+	if id := identity.FromContext(ctx); id != nil {
+		return id.Tenant
+	}
+	return ""
+}
+
+// GetFeatureFlags returns the feature flags overridden for the tenant
+func (db *DataStoreMongo) GetFeatureFlags(ctx context.Context) (map[string]bool, error) {
+	collFeatures := db.client.Database(DbName).Collection(CollNameFeatures)
+	var doc struct {
+		Flags map[string]bool `bson:"flags"`
+	}
+	err := collFeatures.FindOne(ctx,
+		bson.D{{Key: KeyTenantID, Value: tenantIDFromContext(ctx)}},
+	).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return map[string]bool{}, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to get feature flags")
+	}
+	if doc.Flags == nil {
+		doc.Flags = map[string]bool{}
+	}
+	return doc.Flags, nil
+}
+
+// SetFeatureFlag overrides the feature flag for the tenant
+func (db *DataStoreMongo) SetFeatureFlag(ctx context.Context, name string, enabled bool) error {
+	collFeatures := db.client.Database(DbName).Collection(CollNameFeatures)
+	_, err := collFeatures.UpdateOne(ctx,
+		bson.D{{Key: KeyTenantID, Value: tenantIDFromContext(ctx)}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: KeyFlags + "." + name, Value: enabled},
+		}}},
+		mopts.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to set feature flag")
+	}
+	return nil
+}
+
+// DeleteFeatureFlag removes the override of the feature flag for the tenant
+func (db *DataStoreMongo) DeleteFeatureFlag(ctx context.Context, name string) error {
+	collFeatures := db.client.Database(DbName).Collection(CollNameFeatures)
+	_, err := collFeatures.UpdateOne(ctx,
+		bson.D{{Key: KeyTenantID, Value: tenantIDFromContext(ctx)}},
+		bson.D{{Key: "$unset", Value: bson.D{
+			{Key: KeyFlags + "." + name, Value: ""},
+		}}},
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete feature flag")
+	}
+	return nil
+}
+
+// IncrementUsage adds n to the usage counter of the tenant for the period
+func (db *DataStoreMongo) IncrementUsage(
+	ctx context.Context,
+	period string,
+	counter string,
+	n int64,
+) error {
+	collUsage := db.client.Database(DbName).Collection(CollNameUsage)
+	_, err := collUsage.UpdateOne(ctx,
+		bson.D{
+			{Key: KeyTenantID, Value: tenantIDFromContext(ctx)},
+			{Key: KeyPeriod, Value: period},
+		},
+		bson.D{{Key: "$inc", Value: bson.D{
+			{Key: counter, Value: n},
+		}}},
+		mopts.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to increment usage")
+	}
+	return nil
+}
+
+// ListUsage returns the usage of all the tenants for the period, sorted by
+// tenant ID
+func (db *DataStoreMongo) ListUsage(ctx context.Context, period string) ([]model.Usage, error) {
+	collUsage := db.client.Database(DbName).Collection(CollNameUsage)
+	cur, err := collUsage.Find(ctx,
+		bson.D{{Key: KeyPeriod, Value: period}},
+		mopts.Find().SetSort(bson.D{{Key: KeyTenantID, Value: 1}}),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list usage")
+	}
+	usage := []model.Usage{}
+	if err := cur.All(ctx, &usage); err != nil {
+		return nil, errors.Wrap(err, "failed to list usage")
+	}
+	return usage, nil
+}
+
+// IncrementQuota increments the quota counter of the tenant for the
+// window and returns its new value; the counter is removed after
+// expiresAt.
+func (db *DataStoreMongo) IncrementQuota(
+	ctx context.Context,
+	window string,
+	expiresAt time.Time,
+) (int64, error) {
+	collQuotas := db.client.Database(DbName).Collection(CollNameQuotas)
+	var doc struct {
+		Count int64 `bson:"count"`
+	}
+	err := collQuotas.FindOneAndUpdate(ctx,
+		bson.D{
+			{Key: KeyTenantID, Value: tenantIDFromContext(ctx)},
+			{Key: KeyWindow, Value: window},
+		},
+		bson.D{
+			{Key: "$inc", Value: bson.D{{Key: KeyCount, Value: int64(1)}}},
+			{Key: "$setOnInsert", Value: bson.D{
+				{Key: KeyExpiresAt, Value: expiresAt},
+			}},
+		},
+		mopts.FindOneAndUpdate().
+			SetUpsert(true).
+			SetReturnDocument(mopts.After),
+	).Decode(&doc)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to increment quota")
+	}
+	return doc.Count, nil
+}
+
+// InsertAuditLog stores a new audit log
+func (db *DataStoreMongo) InsertAuditLog(ctx context.Context, log model.AuditLog) error {
+	collAuditLogs := db.client.Database(DbName).Collection(CollNameAuditLogs)
+	_, err := collAuditLogs.InsertOne(ctx, log)
+	if err != nil {
+		return errors.Wrap(err, "failed to store audit log")
+	}
+	return nil
+}
+
+// IterateAuditLogs calls fn with each audit log matching the filter, in
+// chronological order; it stops at the first error returned by fn.
+func (db *DataStoreMongo) IterateAuditLogs(
+	ctx context.Context,
+	filter model.AuditLogFilter,
+	fn func(model.AuditLog) error,
+) error {
+	collAuditLogs := db.client.Database(DbName).Collection(CollNameAuditLogs)
+	query := bson.D{}
+	if filter.TenantID != nil {
+		query = append(query, bson.E{Key: KeyTenantID, Value: *filter.TenantID})
+	}
+	timeRange := bson.D{}
+	if !filter.From.IsZero() {
+		timeRange = append(timeRange, bson.E{Key: "$gte", Value: filter.From})
+	}
+	if !filter.To.IsZero() {
+		timeRange = append(timeRange, bson.E{Key: "$lt", Value: filter.To})
+	}
+	if len(timeRange) > 0 {
+		query = append(query, bson.E{Key: KeyTime, Value: timeRange})
+	}
+	cur, err := collAuditLogs.Find(ctx, query,
+		mopts.Find().SetSort(bson.D{{Key: KeyTime, Value: 1}}),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to list audit logs")
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var log model.AuditLog
+		if err := cur.Decode(&log); err != nil {
+			return errors.Wrap(err, "failed to decode audit log")
+		}
+		if err := fn(log); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(cur.Err(), "failed to list audit logs")
+}
+
+// ClaimAuditLogs returns up to limit audit logs which have not been
+// forwarded yet, in chronological order. The returned logs are not returned
+// by subsequent calls for the duration of the lease, so that concurrent
+// instances of the service do not forward the same logs.
+func (db *DataStoreMongo) ClaimAuditLogs(
+	ctx context.Context,
+	limit int,
+	lease time.Duration,
+) ([]model.AuditLog, error) {
+	collAuditLogs := db.client.Database(DbName).Collection(CollNameAuditLogs)
+	opts := mopts.FindOneAndUpdate().
+		SetSort(bson.D{{Key: KeyTime, Value: 1}}).
+		SetReturnDocument(mopts.After)
+	logs := make([]model.AuditLog, 0, limit)
+	for len(logs) < limit {
+		now := db.Clock.Now()
+		filter := bson.D{
+			{Key: KeyForwarded, Value: false},
+			{Key: "$or", Value: bson.A{
+				bson.D{{Key: KeyClaimedUntil, Value: bson.D{
+					{Key: "$exists", Value: false},
+				}}},
+				bson.D{{Key: KeyClaimedUntil, Value: bson.D{
+					{Key: "$lt", Value: now},
+				}}},
+			}},
+		}
+		update := bson.D{{Key: "$set", Value: bson.D{
+			{Key: KeyClaimedUntil, Value: now.Add(lease)},
+		}}}
+		var log model.AuditLog
+		err := collAuditLogs.FindOneAndUpdate(ctx, filter, update, opts).
+			Decode(&log)
+		if err == mongo.ErrNoDocuments {
+			break
+		} else if err != nil {
+			return logs, errors.Wrap(err, "failed to claim audit logs")
+		}
+		logs = append(logs, log)
+	}
+	return logs, nil
+}
+
+// DeleteAuditLogs deletes the audit logs, of all the tenants, older than
+// before and returns the number of logs deleted; if forwardedOnly is true,
+// the logs not yet forwarded are kept.
+func (db *DataStoreMongo) DeleteAuditLogs(
+	ctx context.Context,
+	before time.Time,
+	forwardedOnly bool,
+) (int64, error) {
+	collAuditLogs := db.client.Database(DbName).Collection(CollNameAuditLogs)
+	filter := bson.D{{Key: KeyTime, Value: bson.D{{Key: "$lt", Value: before}}}}
+	if forwardedOnly {
+		filter = append(filter, bson.E{Key: KeyForwarded, Value: true})
+	}
+	res, err := collAuditLogs.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to delete audit logs")
+	}
+	return res.DeletedCount, nil
+}
+
+// GetOldestUnforwardedAuditLog returns the oldest audit log, of all the
+// tenants, which has not been forwarded yet, or nil if all the audit logs
+// have been forwarded
+func (db *DataStoreMongo) GetOldestUnforwardedAuditLog(
+	ctx context.Context,
+) (*model.AuditLog, error) {
+	collAuditLogs := db.client.Database(DbName).Collection(CollNameAuditLogs)
+	var log model.AuditLog
+	err := collAuditLogs.FindOne(ctx,
+		bson.D{{Key: KeyForwarded, Value: false}},
+		mopts.FindOne().SetSort(bson.D{{Key: KeyTime, Value: 1}}),
+	).Decode(&log)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to get audit logs")
+	}
+	return &log, nil
+}
+
+// SetAuditLogForwarded marks the audit log as forwarded
+func (db *DataStoreMongo) SetAuditLogForwarded(ctx context.Context, id uuid.UUID) error {
+	collAuditLogs := db.client.Database(DbName).Collection(CollNameAuditLogs)
+	res, err := collAuditLogs.UpdateOne(ctx,
+		bson.D{{Key: KeyID, Value: id}},
+		bson.D{
+			{Key: "$set", Value: bson.D{{Key: KeyForwarded, Value: true}}},
+			{Key: "$unset", Value: bson.D{{Key: KeyClaimedUntil, Value: ""}}},
+		},
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to update audit log")
+	} else if res.MatchedCount == 0 {
+		return store.ErrObjectNotFound
+	}
+	return nil
+}
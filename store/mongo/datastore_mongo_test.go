@@ -16,15 +16,25 @@ package mongo
 
 import (
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson"
 
 	"github.com/mendersoftware/go-lib-micro/identity"
 	mstore "github.com/mendersoftware/go-lib-micro/store/v2"
 
+	"github.com/mendersoftware/azure-iot-manager/clock"
+	"github.com/mendersoftware/azure-iot-manager/keyring"
 	"github.com/mendersoftware/azure-iot-manager/model"
+	"github.com/mendersoftware/azure-iot-manager/store"
 )
 
 func TestSetSettings(t *testing.T) {
@@ -180,3 +190,692 @@ func TestGetSettings(t *testing.T) {
 		})
 	}
 }
+
+func TestRotateSettings(t *testing.T) {
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "123456789012345678901234",
+	})
+	expiresAt := time.Date(2021, 10, 1, 13, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		Name string
+
+		Current  string
+		Settings model.Settings
+
+		Error    error
+		Expected model.Settings
+	}{{
+		Name: "ok",
+
+		Current: "my://connection",
+		Settings: model.Settings{
+			ConnectionString:         "my://new.connection",
+			PreviousConnectionString: "my://connection",
+			PreviousExpiresAt:        &expiresAt,
+		},
+		Expected: model.Settings{
+			ConnectionString:         "my://new.connection",
+			PreviousConnectionString: "my://connection",
+			PreviousExpiresAt:        &expiresAt,
+		},
+	}, {
+		Name: "error, connection string modified",
+
+		Current: "my://other.connection",
+		Settings: model.Settings{
+			ConnectionString: "my://new.connection",
+		},
+		Error: store.ErrObjectModified,
+		Expected: model.Settings{
+			ConnectionString: "my://connection",
+		},
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			db.Wipe()
+			ds := NewDataStoreWithClient(db.Client())
+			err := ds.SetSettings(ctx, model.Settings{
+				ConnectionString: "my://connection",
+			})
+			require.NoError(t, err)
+
+			err = ds.RotateSettings(ctx, tc.Current, tc.Settings)
+			if tc.Error != nil {
+				assert.Equal(t, tc.Error, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			settings, err := ds.GetSettings(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, tc.Expected.ConnectionString, settings.ConnectionString)
+			assert.Equal(t,
+				tc.Expected.PreviousConnectionString,
+				settings.PreviousConnectionString,
+			)
+			if tc.Expected.PreviousExpiresAt != nil &&
+				assert.NotNil(t, settings.PreviousExpiresAt) {
+				assert.True(t, tc.Expected.PreviousExpiresAt.Equal(
+					*settings.PreviousExpiresAt,
+				))
+			}
+		})
+	}
+}
+
+func TestSetSettingsIfMatch(t *testing.T) {
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "123456789012345678901234",
+	})
+	testCases := []struct {
+		Name string
+
+		Stored *model.Settings
+		// Match is called with the datastore to simulate concurrent writes
+		Match func(ds store.DataStore, current model.Settings) bool
+
+		Error    error
+		Expected string
+	}{{
+		Name: "ok",
+
+		Stored: &model.Settings{ConnectionString: "my://connection"},
+		Match: func(_ store.DataStore, current model.Settings) bool {
+			return current.ConnectionString == "my://connection"
+		},
+		Expected: "my://new.connection",
+	}, {
+		Name: "ok, no settings",
+
+		Match: func(_ store.DataStore, current model.Settings) bool {
+			return current == model.Settings{}
+		},
+		Expected: "my://new.connection",
+	}, {
+		Name: "ok, empty connection string",
+
+		Stored: &model.Settings{},
+		Match: func(_ store.DataStore, current model.Settings) bool {
+			return current == model.Settings{}
+		},
+		Expected: "my://new.connection",
+	}, {
+		Name: "error, precondition failed",
+
+		Stored: &model.Settings{ConnectionString: "my://connection"},
+		Match: func(_ store.DataStore, current model.Settings) bool {
+			return false
+		},
+		Error:    store.ErrObjectModified,
+		Expected: "my://connection",
+	}, {
+		Name: "error, modified concurrently",
+
+		Stored: &model.Settings{ConnectionString: "my://connection"},
+		Match: func(ds store.DataStore, current model.Settings) bool {
+			err := ds.SetSettings(ctx, model.Settings{
+				ConnectionString: "my://other.connection",
+			})
+			return err == nil
+		},
+		Error:    store.ErrObjectModified,
+		Expected: "my://other.connection",
+	}, {
+		Name: "error, inserted concurrently",
+
+		Match: func(ds store.DataStore, current model.Settings) bool {
+			err := ds.SetSettingsIfMatch(ctx,
+				model.Settings{ConnectionString: "my://other.connection"},
+				func(model.Settings) bool { return true },
+			)
+			return err == nil
+		},
+		Error:    store.ErrObjectModified,
+		Expected: "my://other.connection",
+	}}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.Name, func(t *testing.T) {
+			db.Wipe()
+			ds := NewDataStoreWithClient(db.Client())
+			if tc.Stored != nil {
+				err := ds.SetSettings(ctx, *tc.Stored)
+				require.NoError(t, err)
+			}
+
+			err := ds.SetSettingsIfMatch(ctx,
+				model.Settings{ConnectionString: "my://new.connection"},
+				func(current model.Settings) bool {
+					return tc.Match(ds, current)
+				},
+			)
+			if tc.Error != nil {
+				assert.Equal(t, tc.Error, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			settings, err := ds.GetSettings(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, tc.Expected, settings.ConnectionString)
+			// the tenant has a single settings document
+			n, err := db.Client().Database(DbName).
+				Collection(CollNameSettings).
+				CountDocuments(ctx, bson.D{{
+					Key: KeyTenantID, Value: "123456789012345678901234",
+				}})
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), n)
+		})
+	}
+}
+
+func TestAuditLogs(t *testing.T) {
+	db.Wipe()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	clk := clock.NewFake(now)
+	ds := NewDataStoreWithClient(db.Client(), NewConfig().SetClock(clk))
+	ctx := context.Background()
+
+	logs := make([]model.AuditLog, 3)
+	for i := range logs {
+		logs[i] = model.AuditLog{
+			ID:       uuid.New(),
+			TenantID: "123456789012345678901234",
+			Actor: model.AuditActor{
+				ID:   uuid.NewString(),
+				Type: model.AuditActorTypeUser,
+			},
+			Action:  model.AuditActionUpdate,
+			Object:  model.AuditObject{Type: "settings"},
+			Outcome: model.AuditOutcomeSuccess,
+			Time:    now.Add(time.Duration(i) * time.Second),
+		}
+	}
+	// insert in reverse order to check the logs are claimed in
+	// chronological order
+	for i := len(logs) - 1; i >= 0; i-- {
+		err := ds.InsertAuditLog(ctx, logs[i])
+		require.NoError(t, err)
+	}
+
+	claimed, err := ds.ClaimAuditLogs(ctx, 2, time.Minute)
+	require.NoError(t, err)
+	if assert.Len(t, claimed, 2) {
+		assert.Equal(t, logs[0].ID, claimed[0].ID)
+		assert.Equal(t, logs[1].ID, claimed[1].ID)
+		assert.Equal(t, logs[0].Time, claimed[0].Time.UTC())
+	}
+
+	claimed, err = ds.ClaimAuditLogs(ctx, 2, time.Minute)
+	require.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, logs[2].ID, claimed[0].ID)
+	}
+
+	claimed, err = ds.ClaimAuditLogs(ctx, 2, time.Minute)
+	require.NoError(t, err)
+	assert.Len(t, claimed, 0)
+
+	// the leases expired
+	clk.Advance(2 * time.Minute)
+	claimed, err = ds.ClaimAuditLogs(ctx, 2, time.Minute)
+	require.NoError(t, err)
+	if assert.Len(t, claimed, 2) {
+		assert.Equal(t, logs[0].ID, claimed[0].ID)
+	}
+
+	oldest, err := ds.GetOldestUnforwardedAuditLog(ctx)
+	require.NoError(t, err)
+	if assert.NotNil(t, oldest) {
+		assert.Equal(t, logs[0].ID, oldest.ID)
+	}
+
+	for _, log := range logs {
+		err = ds.SetAuditLogForwarded(ctx, log.ID)
+		assert.NoError(t, err)
+	}
+	err = ds.SetAuditLogForwarded(ctx, uuid.New())
+	assert.EqualError(t, err, store.ErrObjectNotFound.Error())
+
+	oldest, err = ds.GetOldestUnforwardedAuditLog(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, oldest)
+
+	clk.Advance(2 * time.Minute)
+	claimed, err = ds.ClaimAuditLogs(ctx, 2, time.Minute)
+	require.NoError(t, err)
+	assert.Len(t, claimed, 0)
+}
+
+func TestIterateAuditLogs(t *testing.T) {
+	db.Wipe()
+	ds := NewDataStoreWithClient(db.Client())
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	logs := []model.AuditLog{{
+		ID:       uuid.New(),
+		TenantID: "tenant1",
+		Time:     now.Add(-2 * time.Hour),
+	}, {
+		ID:       uuid.New(),
+		TenantID: "tenant2",
+		Time:     now.Add(-time.Hour),
+	}, {
+		ID:       uuid.New(),
+		TenantID: "tenant1",
+		Time:     now,
+	}}
+	for i := len(logs) - 1; i >= 0; i-- {
+		err := ds.InsertAuditLog(ctx, logs[i])
+		require.NoError(t, err)
+	}
+	tenantID := "tenant1"
+	testCases := []struct {
+		Name string
+
+		Filter model.AuditLogFilter
+		IDs    []uuid.UUID
+	}{{
+		Name: "all tenants",
+
+		IDs: []uuid.UUID{logs[0].ID, logs[1].ID, logs[2].ID},
+	}, {
+		Name: "tenant",
+
+		Filter: model.AuditLogFilter{TenantID: &tenantID},
+		IDs:    []uuid.UUID{logs[0].ID, logs[2].ID},
+	}, {
+		Name: "time range",
+
+		Filter: model.AuditLogFilter{
+			From: now.Add(-time.Hour),
+			To:   now,
+		},
+		IDs: []uuid.UUID{logs[1].ID},
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var ids []uuid.UUID
+			err := ds.IterateAuditLogs(ctx, tc.Filter,
+				func(log model.AuditLog) error {
+					ids = append(ids, log.ID)
+					return nil
+				})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.IDs, ids)
+		})
+	}
+
+	err := ds.IterateAuditLogs(ctx, model.AuditLogFilter{},
+		func(model.AuditLog) error {
+			return store.ErrObjectNotFound
+		})
+	assert.Equal(t, store.ErrObjectNotFound, err)
+}
+
+func TestDeleteAuditLogs(t *testing.T) {
+	db.Wipe()
+	ds := NewDataStoreWithClient(db.Client())
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	logs := []model.AuditLog{{
+		ID:        uuid.New(),
+		TenantID:  "tenant1",
+		Time:      now.Add(-3 * time.Hour),
+		Forwarded: true,
+	}, {
+		ID:       uuid.New(),
+		TenantID: "tenant2",
+		Time:     now.Add(-2 * time.Hour),
+	}, {
+		ID:        uuid.New(),
+		TenantID:  "tenant1",
+		Time:      now,
+		Forwarded: true,
+	}}
+	for _, log := range logs {
+		err := ds.InsertAuditLog(ctx, log)
+		require.NoError(t, err)
+	}
+	remaining := func() []uuid.UUID {
+		var ids []uuid.UUID
+		err := ds.IterateAuditLogs(ctx, model.AuditLogFilter{},
+			func(log model.AuditLog) error {
+				ids = append(ids, log.ID)
+				return nil
+			})
+		require.NoError(t, err)
+		return ids
+	}
+
+	n, err := ds.DeleteAuditLogs(ctx, now.Add(-time.Hour), true)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, []uuid.UUID{logs[1].ID, logs[2].ID}, remaining())
+
+	n, err = ds.DeleteAuditLogs(ctx, now.Add(-time.Hour), false)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, []uuid.UUID{logs[2].ID}, remaining())
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = ds.DeleteAuditLogs(cctx, now, false)
+	assert.Error(t, err)
+}
+
+func TestListSettings(t *testing.T) {
+	db.Wipe()
+	client := db.Client()
+	collSettings := client.Database(DbName).Collection(CollNameSettings)
+	ctx := context.Background()
+
+	_, err := collSettings.InsertMany(ctx, []interface{}{
+		model.TenantSettings{
+			TenantID: "tenant2",
+			Settings: model.Settings{ConnectionString: "my://connection.string/2"},
+		},
+		model.TenantSettings{
+			TenantID: "tenant1",
+			Settings: model.Settings{ConnectionString: "my://connection.string/1"},
+		},
+		model.TenantSettings{TenantID: "tenant3"},
+	})
+	require.NoError(t, err)
+
+	ds := NewDataStoreWithClient(client)
+	settings, err := ds.ListSettings(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []model.TenantSettings{{
+		TenantID: "tenant1",
+		Settings: model.Settings{ConnectionString: "my://connection.string/1"},
+	}, {
+		TenantID: "tenant2",
+		Settings: model.Settings{ConnectionString: "my://connection.string/2"},
+	}}, settings)
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = ds.ListSettings(cctx)
+	assert.Error(t, err)
+}
+
+func TestFeatureFlags(t *testing.T) {
+	db.Wipe()
+	ds := NewDataStoreWithClient(db.Client())
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "123456789012345678901234",
+	})
+	otherCtx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "111111111111111111111111",
+	})
+
+	flags, err := ds.GetFeatureFlags(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{}, flags)
+
+	err = ds.SetFeatureFlag(ctx, "foo", true)
+	assert.NoError(t, err)
+	err = ds.SetFeatureFlag(ctx, "bar", true)
+	assert.NoError(t, err)
+	err = ds.SetFeatureFlag(ctx, "bar", false)
+	assert.NoError(t, err)
+	err = ds.SetFeatureFlag(otherCtx, "baz", true)
+	assert.NoError(t, err)
+
+	flags, err = ds.GetFeatureFlags(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"foo": true, "bar": false}, flags)
+
+	err = ds.DeleteFeatureFlag(ctx, "foo")
+	assert.NoError(t, err)
+	err = ds.DeleteFeatureFlag(ctx, "unknown")
+	assert.NoError(t, err)
+
+	flags, err = ds.GetFeatureFlags(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"bar": false}, flags)
+
+	flags, err = ds.GetFeatureFlags(otherCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"baz": true}, flags)
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = ds.GetFeatureFlags(cctx)
+	assert.Error(t, err)
+	err = ds.SetFeatureFlag(cctx, "foo", true)
+	assert.Error(t, err)
+	err = ds.DeleteFeatureFlag(cctx, "foo")
+	assert.Error(t, err)
+}
+
+func TestUsage(t *testing.T) {
+	db.Wipe()
+	ds := NewDataStoreWithClient(db.Client())
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "123456789012345678901234",
+	})
+	otherCtx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "111111111111111111111111",
+	})
+
+	usage, err := ds.ListUsage(ctx, "2021-10")
+	assert.NoError(t, err)
+	assert.Equal(t, []model.Usage{}, usage)
+
+	err = ds.IncrementUsage(ctx, "2021-10", model.UsageAPICalls, 1)
+	assert.NoError(t, err)
+	err = ds.IncrementUsage(ctx, "2021-10", model.UsageAPICalls, 2)
+	assert.NoError(t, err)
+	err = ds.IncrementUsage(ctx, "2021-10", model.UsageAzureOperations, 1)
+	assert.NoError(t, err)
+	err = ds.IncrementUsage(otherCtx, "2021-10", model.UsageAPICalls, 1)
+	assert.NoError(t, err)
+	err = ds.IncrementUsage(ctx, "2021-11", model.UsageAPICalls, 1)
+	assert.NoError(t, err)
+
+	usage, err = ds.ListUsage(ctx, "2021-10")
+	assert.NoError(t, err)
+	assert.Equal(t, []model.Usage{{
+		TenantID: "111111111111111111111111",
+		Period:   "2021-10",
+		APICalls: 1,
+	}, {
+		TenantID:        "123456789012345678901234",
+		Period:          "2021-10",
+		APICalls:        3,
+		AzureOperations: 1,
+	}}, usage)
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	err = ds.IncrementUsage(cctx, "2021-10", model.UsageAPICalls, 1)
+	assert.Error(t, err)
+	_, err = ds.ListUsage(cctx, "2021-10")
+	assert.Error(t, err)
+}
+
+func TestIncrementQuota(t *testing.T) {
+	db.Wipe()
+	ds := NewDataStoreWithClient(db.Client())
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "123456789012345678901234",
+	})
+	otherCtx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "111111111111111111111111",
+	})
+	expiresAt := time.Date(2021, 10, 1, 13, 0, 0, 0, time.UTC)
+
+	count, err := ds.IncrementQuota(ctx, "2021-10-01T12", expiresAt)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	count, err = ds.IncrementQuota(ctx, "2021-10-01T12", expiresAt)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	count, err = ds.IncrementQuota(otherCtx, "2021-10-01T12", expiresAt)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	count, err = ds.IncrementQuota(ctx, "2021-10-01", expiresAt)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = ds.IncrementQuota(cctx, "2021-10-01T12", expiresAt)
+	assert.Error(t, err)
+}
+
+func TestGetServerTime(t *testing.T) {
+	db.Wipe()
+	ds := NewDataStoreWithClient(db.Client())
+
+	before := time.Now()
+	serverTime, err := ds.GetServerTime(context.Background())
+	require.NoError(t, err)
+	assert.WithinDuration(t, before, serverTime, time.Minute)
+}
+
+func newTestKeyring(t *testing.T, current string, ids ...string) *keyring.Keyring {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = id + ":" + base64.StdEncoding.EncodeToString(
+			[]byte(strings.Repeat(id[len(id)-1:], 32)),
+		)
+	}
+	k, err := keyring.Parse(current, keys)
+	require.NoError(t, err)
+	return k
+}
+
+func TestSettingsEncryption(t *testing.T) {
+	db.Wipe()
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "123456789012345678901234",
+	})
+	ds := NewDataStoreWithClient(db.Client(),
+		NewConfig().SetKeyring(newTestKeyring(t, "v1", "v1")))
+
+	err := ds.SetSettings(ctx, model.Settings{ConnectionString: "my://connection"})
+	require.NoError(t, err)
+	var raw model.Settings
+	err = db.Client().Database(DbName).Collection(CollNameSettings).
+		FindOne(ctx, bson.M{KeyTenantID: "123456789012345678901234"}).
+		Decode(&raw)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", keyring.KeyID(raw.ConnectionString))
+
+	settings, err := ds.GetSettings(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "my://connection", settings.ConnectionString)
+
+	err = ds.RotateSettings(ctx, "my://connection", model.Settings{
+		ConnectionString:         "my://new.connection",
+		PreviousConnectionString: "my://connection",
+	})
+	require.NoError(t, err)
+	list, err := ds.ListSettings(ctx)
+	require.NoError(t, err)
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, "my://new.connection", list[0].ConnectionString)
+		assert.Equal(t, "my://connection", list[0].PreviousConnectionString)
+	}
+
+	// the keys of the stored secrets are required to read them
+	_, err = NewDataStoreWithClient(db.Client()).GetSettings(ctx)
+	assert.ErrorIs(t, err, keyring.ErrUnknownKey)
+
+	// the stored secrets are bound to their tenant
+	_, err = db.Client().Database(DbName).Collection(CollNameSettings).
+		InsertOne(ctx, bson.M{
+			KeyTenantID: "tenant2",
+			KeyConnStr:  raw.ConnectionString,
+		})
+	require.NoError(t, err)
+	_, err = ds.GetSettings(identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "tenant2",
+	}))
+	assert.ErrorIs(t, err, keyring.ErrDecrypt)
+}
+
+func TestReencryptSettings(t *testing.T) {
+	db.Wipe()
+	ctx := context.Background()
+	plaintext := NewDataStoreWithClient(db.Client())
+	for _, tenantID := range []string{"tenant1", "tenant2"} {
+		err := plaintext.SetSettings(
+			identity.WithContext(ctx, &identity.Identity{Tenant: tenantID}),
+			model.Settings{ConnectionString: "my://" + tenantID},
+		)
+		require.NoError(t, err)
+	}
+
+	ds := NewDataStoreWithClient(db.Client(),
+		NewConfig().SetKeyring(newTestKeyring(t, "v1", "v1")))
+	result, err := ds.ReencryptSettings(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, model.ReencryptionResult{Checked: 2, Reencrypted: 2}, result)
+
+	// rotate the key
+	ds = NewDataStoreWithClient(db.Client(),
+		NewConfig().SetKeyring(newTestKeyring(t, "v2", "v1", "v2")))
+	result, err = ds.ReencryptSettings(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, model.ReencryptionResult{Checked: 2, Reencrypted: 2}, result)
+	result, err = ds.ReencryptSettings(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, model.ReencryptionResult{Checked: 2}, result)
+
+	list, err := NewDataStoreWithClient(db.Client(),
+		NewConfig().SetKeyring(newTestKeyring(t, "v2", "v2"))).
+		ListSettings(ctx)
+	require.NoError(t, err)
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, "my://tenant1", list[0].ConnectionString)
+		assert.Equal(t, "my://tenant2", list[1].ConnectionString)
+	}
+}
+
+func TestReencryptLegacySettings(t *testing.T) {
+	db.Wipe()
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "tenant1",
+	})
+	// a connection string encrypted before the values were bound to their
+	// tenant
+	block, err := aes.NewCipher([]byte(strings.Repeat("1", 32)))
+	require.NoError(t, err)
+	gcm, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	nonce := make([]byte, gcm.NonceSize())
+	legacy := "enc:v1:" + base64.StdEncoding.EncodeToString(
+		gcm.Seal(nonce, nonce, []byte("my://tenant1"), nil),
+	)
+	collSettings := db.Client().Database(DbName).Collection(CollNameSettings)
+	_, err = collSettings.InsertOne(ctx, bson.M{
+		KeyTenantID: "tenant1",
+		KeyConnStr:  legacy,
+	})
+	require.NoError(t, err)
+
+	ds := NewDataStoreWithClient(db.Client(),
+		NewConfig().SetKeyring(newTestKeyring(t, "v1", "v1")))
+	settings, err := ds.GetSettings(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "my://tenant1", settings.ConnectionString)
+
+	result, err := ds.ReencryptSettings(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, model.ReencryptionResult{Checked: 1, Reencrypted: 1}, result)
+	var raw model.Settings
+	err = collSettings.FindOne(ctx, bson.M{KeyTenantID: "tenant1"}).Decode(&raw)
+	require.NoError(t, err)
+	assert.False(t, keyring.Legacy(raw.ConnectionString))
+	assert.Equal(t, "v1", keyring.KeyID(raw.ConnectionString))
+
+	settings, err = ds.GetSettings(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "my://tenant1", settings.ConnectionString)
+}
@@ -33,11 +33,9 @@ type migration_1_0_0 struct {
 	db     string
 }
 
-// Up creates indexes for fetching by device ID and sorting by timestamp,
-// and a TTL index for evicting expired alerts.
-func (m *migration_1_0_0) Up(from migrate.Version) error {
-	ctx := context.Background()
-	indexModels := []mongo.IndexModel{{
+// indexes returns the collection and the indexes created by the migration
+func (m *migration_1_0_0) indexes() (string, []mongo.IndexModel) {
+	return CollNameSettings, []mongo.IndexModel{{
 		Keys: bson.D{
 			// $match
 			{Key: KeyTenantID, Value: 1},
@@ -45,14 +43,12 @@ func (m *migration_1_0_0) Up(from migrate.Version) error {
 		Options: mopts.Index().
 			SetName(IndexNameSettingsGet),
 	}}
-	collLogs := m.client.
-		Database(m.db).
-		Collection(CollNameSettings)
-
-	idxView := collLogs.Indexes()
+}
 
-	_, err := idxView.CreateMany(ctx, indexModels)
-	return err
+// Up creates indexes for fetching by device ID and sorting by timestamp,
+// and a TTL index for evicting expired alerts.
+func (m *migration_1_0_0) Up(from migrate.Version) error {
+	return createIndexes(context.Background(), m.client.Database(m.db), m)
 }
 
 func (m *migration_1_0_0) Version() migrate.Version {
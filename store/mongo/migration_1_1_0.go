@@ -0,0 +1,64 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+)
+
+const (
+	IndexNameAuditLogsForward = "audit_logs forward"
+	IndexNameAuditLogsTenant  = "audit_logs tenant"
+)
+
+type migration_1_1_0 struct {
+	client *mongo.Client
+	db     string
+}
+
+// indexes returns the collection and the indexes created by the migration
+func (m *migration_1_1_0) indexes() (string, []mongo.IndexModel) {
+	return CollNameAuditLogs, []mongo.IndexModel{{
+		Keys: bson.D{
+			{Key: KeyForwarded, Value: 1},
+			{Key: KeyTime, Value: 1},
+		},
+		Options: mopts.Index().
+			SetName(IndexNameAuditLogsForward),
+	}, {
+		Keys: bson.D{
+			{Key: KeyTenantID, Value: 1},
+			{Key: KeyTime, Value: 1},
+		},
+		Options: mopts.Index().
+			SetName(IndexNameAuditLogsTenant),
+	}}
+}
+
+// Up creates the indexes for fetching the audit logs pending forwarding
+// and listing the audit logs of a tenant by time.
+func (m *migration_1_1_0) Up(from migrate.Version) error {
+	return createIndexes(context.Background(), m.client.Database(m.db), m)
+}
+
+func (m *migration_1_1_0) Version() migrate.Version {
+	return migrate.MakeVersion(1, 1, 0)
+}
@@ -0,0 +1,55 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopts "go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+)
+
+const (
+	IndexNameFeaturesTenant = "feature_flags tenant"
+)
+
+type migration_1_2_0 struct {
+	client *mongo.Client
+	db     string
+}
+
+// indexes returns the collection and the indexes created by the migration
+func (m *migration_1_2_0) indexes() (string, []mongo.IndexModel) {
+	return CollNameFeatures, []mongo.IndexModel{{
+		Keys: bson.D{
+			{Key: KeyTenantID, Value: 1},
+		},
+		Options: mopts.Index().
+			SetName(IndexNameFeaturesTenant).
+			SetUnique(true),
+	}}
+}
+
+// Up creates the unique index of the tenant feature flags overrides
+func (m *migration_1_2_0) Up(from migrate.Version) error {
+	return createIndexes(context.Background(), m.client.Database(m.db), m)
+}
+
+func (m *migration_1_2_0) Version() migrate.Version {
+	return migrate.MakeVersion(1, 2, 0)
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+)
+
+func TestMigration_1_2_0(t *testing.T) {
+	db.Wipe()
+	client := db.Client()
+	m := &migration_1_2_0{
+		client: client,
+		db:     DbName,
+	}
+	from := migrate.MakeVersion(1, 1, 0)
+
+	err := m.Up(from)
+	require.NoError(t, err)
+
+	iv := client.Database(DbName).
+		Collection(CollNameFeatures).
+		Indexes()
+	ctx := context.Background()
+	cur, err := iv.List(ctx)
+	require.NoError(t, err)
+
+	var idxes []orderedIndex
+	err = cur.All(ctx, &idxes)
+	require.NoError(t, err)
+	require.Len(t, idxes, 2)
+	for _, idx := range idxes {
+		switch idx.Name {
+		case "_id_":
+			// Skip default index
+			continue
+		case IndexNameFeaturesTenant:
+			assert.Equal(t, bson.D{
+				{Key: KeyTenantID, Value: int32(1)},
+			}, idx.Keys)
+		default:
+			assert.Failf(t, "Index name \"%s\" not recognized", idx.Name)
+		}
+	}
+	assert.Equal(t, "1.2.0", m.Version().String())
+}
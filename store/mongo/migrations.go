@@ -16,22 +16,126 @@ package mongo
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/mendersoftware/go-lib-micro/log"
 	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+
+	"github.com/mendersoftware/azure-iot-manager/model"
 )
 
 const (
 	// DbVersion is the current schema version
-	DbVersion = "1.0.0"
+	DbVersion = "1.5.0"
 
 	// DbName is the database name
 	DbName = "azure_iot_manager"
+
+	errCodeNamespaceNotFound = 26
 )
 
+// indexMigration is a migration building indexes, which can be listed
+// without applying the migration
+type indexMigration interface {
+	migrate.Migration
+	indexes() (string, []mongo.IndexModel)
+}
+
+// migrations returns the migrations of the database schema, in the order
+// of application
+func migrations(client *mongo.Client, db string) []indexMigration {
+	return []indexMigration{
+		&migration_1_0_0{
+			client: client,
+			db:     db,
+		},
+		&migration_1_1_0{
+			client: client,
+			db:     db,
+		},
+		&migration_1_2_0{
+			client: client,
+			db:     db,
+		},
+		&migration_1_3_0{
+			client: client,
+			db:     db,
+		},
+		&migration_1_4_0{
+			client: client,
+			db:     db,
+		},
+		&migration_1_5_0{
+			client: client,
+			db:     db,
+		},
+	}
+}
+
+// createIndexes builds the indexes of the migration
+func createIndexes(ctx context.Context, db *mongo.Database, m indexMigration) error {
+	collection, indexModels := m.indexes()
+	_, err := db.Collection(collection).Indexes().CreateMany(ctx, indexModels)
+	return err
+}
+
+// indexName returns the name of the index, or the name generated by the
+// server from its keys if the index is not named
+func indexName(index mongo.IndexModel) string {
+	if index.Options != nil && index.Options.Name != nil {
+		return *index.Options.Name
+	}
+	keys := index.Keys.(bson.D)
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = fmt.Sprintf("%s_%v", key.Key, key.Value)
+	}
+	return strings.Join(parts, "_")
+}
+
+// indexKeys returns the keys of the index as "key: order, ..."
+func indexKeys(index mongo.IndexModel) string {
+	keys := index.Keys.(bson.D)
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = fmt.Sprintf("%s: %v", key.Key, key.Value)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// listIndexes returns the names of the indexes of the collection; the
+// collection may not exist
+func listIndexes(
+	ctx context.Context,
+	db *mongo.Database,
+	collection string,
+) (map[string]struct{}, error) {
+	var list []struct {
+		Name string `bson:"name"`
+	}
+	cur, err := db.Collection(collection).Indexes().List(ctx)
+	if err == nil {
+		err = cur.All(ctx, &list)
+	}
+	if cmdErr, ok := err.(mongo.CommandError); ok &&
+		cmdErr.Code == errCodeNamespaceNotFound {
+		err = nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list the indexes of %s", collection)
+	}
+	names := make(map[string]struct{}, len(list))
+	for _, idx := range list {
+		names[idx.Name] = struct{}{}
+	}
+	return names, nil
+}
+
 // Migrate applies migrations to the database
 func Migrate(ctx context.Context,
 	db string,
@@ -47,18 +151,136 @@ func Migrate(ctx context.Context,
 		return errors.Wrap(err, "failed to parse service version")
 	}
 
-	m := migrate.DummyMigrator{
+	m := migrate.SimpleMigrator{
 		Client:      client,
 		Db:          db,
 		Automigrate: automigrate,
 	}
 
-	var migrations []migrate.Migration
+	var schema []migrate.Migration
+	for _, migration := range migrations(client, db) {
+		schema = append(schema, migration)
+	}
 
-	err = m.Apply(ctx, *ver, migrations)
+	err = m.Apply(ctx, *ver, schema)
 	if err != nil {
 		return errors.Wrap(err, "failed to apply migrations")
 	}
 
 	return nil
 }
+
+// GetMigrationStatus returns the migrations applied to the database and
+// whether the database is behind DbVersion
+func (db *DataStoreMongo) GetMigrationStatus(
+	ctx context.Context,
+) (model.MigrationStatus, error) {
+	target, err := migrate.NewVersion(DbVersion)
+	if err != nil {
+		return model.MigrationStatus{}, errors.Wrap(err,
+			"failed to parse service version")
+	}
+	entries, err := migrate.GetMigrationInfo(ctx, db.client, DbName)
+	if err != nil {
+		return model.MigrationStatus{}, err
+	}
+	// the entries are sorted by version in descending order
+	pending := len(entries) == 0 ||
+		migrate.VersionIsLess(entries[0].Version, *target)
+	status := model.MigrationStatus{
+		Database: DbName,
+		Version:  DbVersion,
+		Pending:  pending,
+		Applied:  make([]model.AppliedMigration, len(entries)),
+	}
+	for i, entry := range entries {
+		status.Applied[i] = model.AppliedMigration{
+			Version: entry.Version.String(),
+			Time:    entry.Timestamp,
+		}
+	}
+	return status, nil
+}
+
+// GetMissingIndexes returns the indexes created by the migrations which are
+// missing in the database, as "<collection>: <index name>"
+func (db *DataStoreMongo) GetMissingIndexes(ctx context.Context) ([]string, error) {
+	var missing []string
+	database := db.client.Database(DbName)
+	for _, migration := range migrations(db.client, DbName) {
+		collection, indexModels := migration.indexes()
+		existing, err := listIndexes(ctx, database, collection)
+		if err != nil {
+			return nil, err
+		}
+		for _, indexModel := range indexModels {
+			name := indexName(indexModel)
+			if _, ok := existing[name]; !ok {
+				missing = append(missing, collection+": "+name)
+			}
+		}
+	}
+	return missing, nil
+}
+
+// PlanMigrations returns the migrations which Migrate would apply to reach
+// version, without applying them, with the index builds and the estimated
+// number of documents of the indexed collections.
+func PlanMigrations(
+	ctx context.Context,
+	db string,
+	version string,
+	client *mongo.Client,
+) (model.MigrationPlan, error) {
+	target, err := migrate.NewVersion(version)
+	if err != nil {
+		return model.MigrationPlan{}, errors.Wrap(err,
+			"failed to parse service version")
+	}
+	entries, err := migrate.GetMigrationInfo(ctx, client, db)
+	if err != nil {
+		return model.MigrationPlan{}, err
+	}
+	// the entries are sorted by version in descending order
+	last := migrate.Version{}
+	if len(entries) > 0 {
+		last = entries[0].Version
+	}
+	plan := model.MigrationPlan{
+		Database:   db,
+		From:       last.String(),
+		To:         target.String(),
+		Migrations: []model.PlannedMigration{},
+	}
+	database := client.Database(db)
+	for _, migration := range migrations(client, db) {
+		v := migration.Version()
+		if !migrate.VersionIsLess(last, v) || migrate.VersionIsLess(*target, v) {
+			continue
+		}
+		collection, indexModels := migration.indexes()
+		existing, err := listIndexes(ctx, database, collection)
+		if err != nil {
+			return model.MigrationPlan{}, err
+		}
+		count, err := database.Collection(collection).EstimatedDocumentCount(ctx)
+		if err != nil {
+			return model.MigrationPlan{}, errors.Wrapf(err,
+				"failed to count the documents of %s", collection)
+		}
+		planned := model.PlannedMigration{Version: v.String()}
+		for _, indexModel := range indexModels {
+			name := indexName(indexModel)
+			_, exists := existing[name]
+			planned.Indexes = append(planned.Indexes, model.PlannedIndex{
+				Collection: collection,
+				Name:       name,
+				Keys:       indexKeys(indexModel),
+				Exists:     exists,
+				Documents:  count,
+			})
+		}
+		plan.Migrations = append(plan.Migrations, planned)
+	}
+	return plan, nil
+}
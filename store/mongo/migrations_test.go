@@ -0,0 +1,116 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/mendersoftware/azure-iot-manager/model"
+)
+
+func TestGetMigrationStatus(t *testing.T) {
+	db.Wipe()
+	client := db.Client()
+	ds := NewDataStoreWithClient(client)
+	ctx := context.Background()
+
+	status, err := ds.GetMigrationStatus(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, DbName, status.Database)
+	assert.Equal(t, DbVersion, status.Version)
+	assert.True(t, status.Pending)
+	assert.Empty(t, status.Applied)
+
+	err = Migrate(ctx, DbName, "1.1.0", client, true)
+	require.NoError(t, err)
+	status, err = ds.GetMigrationStatus(ctx)
+	require.NoError(t, err)
+	assert.True(t, status.Pending)
+	if assert.NotEmpty(t, status.Applied) {
+		assert.Equal(t, "1.1.0", status.Applied[0].Version)
+	}
+
+	err = Migrate(ctx, DbName, DbVersion, client, true)
+	require.NoError(t, err)
+	status, err = ds.GetMigrationStatus(ctx)
+	require.NoError(t, err)
+	assert.False(t, status.Pending)
+	if assert.NotEmpty(t, status.Applied) {
+		assert.Equal(t, DbVersion, status.Applied[0].Version)
+		assert.False(t, status.Applied[0].Time.IsZero())
+	}
+}
+
+func TestGetMissingIndexes(t *testing.T) {
+	db.Wipe()
+	client := db.Client()
+	ds := NewDataStoreWithClient(client)
+	ctx := context.Background()
+
+	missing, err := ds.GetMissingIndexes(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, missing, CollNameSettings+": "+IndexNameSettingsGet)
+	assert.Contains(t, missing, CollNameQuotas+": "+IndexNameQuotasExpiresAt)
+
+	err = Migrate(ctx, DbName, DbVersion, client, true)
+	require.NoError(t, err)
+	missing, err = ds.GetMissingIndexes(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+}
+
+func TestPlanMigrations(t *testing.T) {
+	db.Wipe()
+	client := db.Client()
+	ctx := context.Background()
+
+	err := Migrate(ctx, DbName, "1.2.0", client, true)
+	require.NoError(t, err)
+	_, err = client.Database(DbName).Collection(CollNameUsage).
+		InsertOne(ctx, bson.M{KeyTenantID: "tenant", KeyPeriod: "2021-10"})
+	require.NoError(t, err)
+
+	plan, err := PlanMigrations(ctx, DbName, DbVersion, client)
+	require.NoError(t, err)
+	assert.Equal(t, DbName, plan.Database)
+	assert.Equal(t, "1.2.0", plan.From)
+	assert.Equal(t, DbVersion, plan.To)
+	if assert.Len(t, plan.Migrations, 3) {
+		assert.Equal(t, model.PlannedMigration{
+			Version: "1.3.0",
+			Indexes: []model.PlannedIndex{{
+				Collection: CollNameUsage,
+				Name:       IndexNameUsageTenantPeriod,
+				Keys:       "tenant_id: 1, period: 1",
+				Documents:  1,
+			}},
+		}, plan.Migrations[0])
+		assert.Equal(t, "1.4.0", plan.Migrations[1].Version)
+		assert.Len(t, plan.Migrations[1].Indexes, 2)
+		assert.Equal(t, "1.5.0", plan.Migrations[2].Version)
+		assert.Len(t, plan.Migrations[2].Indexes, 1)
+	}
+
+	err = Migrate(ctx, DbName, DbVersion, client, true)
+	require.NoError(t, err)
+	plan, err = PlanMigrations(ctx, DbName, DbVersion, client)
+	require.NoError(t, err)
+	assert.Empty(t, plan.Migrations)
+}
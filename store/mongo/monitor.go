@@ -0,0 +1,163 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/event"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+)
+
+// slowQueryMonitor logs the commands on a collection taking longer than
+// the threshold, with the shape of their filter: the filter with the
+// values replaced by "?", so that the log does not leak tenant data.
+type slowQueryMonitor struct {
+	threshold time.Duration
+	// commands are the started commands by request ID
+	commands sync.Map
+}
+
+type startedCommand struct {
+	collection string
+	filter     bson.RawValue
+}
+
+// newSlowQueryMonitor returns the command monitor logging the commands
+// taking longer than threshold
+func newSlowQueryMonitor(threshold time.Duration) *event.CommandMonitor {
+	m := &slowQueryMonitor{threshold: threshold}
+	return &event.CommandMonitor{
+		Started: m.started,
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			m.finished(ctx, &evt.CommandFinishedEvent)
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			m.finished(ctx, &evt.CommandFinishedEvent)
+		},
+	}
+}
+
+func (m *slowQueryMonitor) started(_ context.Context, evt *event.CommandStartedEvent) {
+	collection, _ := evt.Command.Lookup(evt.CommandName).StringValueOK()
+	if evt.CommandName == "getMore" {
+		collection, _ = evt.Command.Lookup("collection").StringValueOK()
+	}
+	if collection == "" {
+		return
+	}
+	cmd := startedCommand{collection: collection}
+	if filter, ok := commandFilter(evt.Command); ok {
+		// the command is only valid for the duration of the callback
+		cmd.filter = bson.RawValue{
+			Type:  filter.Type,
+			Value: append([]byte(nil), filter.Value...),
+		}
+	}
+	m.commands.Store(evt.RequestID, cmd)
+}
+
+func (m *slowQueryMonitor) finished(ctx context.Context, evt *event.CommandFinishedEvent) {
+	v, ok := m.commands.LoadAndDelete(evt.RequestID)
+	if !ok {
+		return
+	}
+	duration := time.Duration(evt.DurationNanos)
+	if duration < m.threshold {
+		return
+	}
+	cmd := v.(startedCommand)
+	fields := log.Ctx{
+		"collection":  cmd.collection,
+		"operation":   evt.CommandName,
+		"duration_ms": duration.Milliseconds(),
+	}
+	if cmd.filter.Type != 0 {
+		fields["filter"] = valueShape(cmd.filter)
+	}
+	log.FromContext(ctx).F(fields).Warnf("slow query: %s on %s took %s",
+		evt.CommandName, cmd.collection, duration.Round(time.Millisecond))
+}
+
+// commandFilter returns the filter of the command, or the pipeline of an
+// aggregation
+func commandFilter(cmd bson.Raw) (bson.RawValue, bool) {
+	for _, key := range []string{"filter", "query", "pipeline"} {
+		if v, err := cmd.LookupErr(key); err == nil {
+			return v, true
+		}
+	}
+	// update and delete commands: filter of the first statement
+	for _, key := range []string{"updates", "deletes"} {
+		if v, err := cmd.LookupErr(key, "0", "q"); err == nil {
+			return v, true
+		}
+	}
+	return bson.RawValue{}, false
+}
+
+// valueShape returns the value with all the scalars replaced by "?",
+// e.g. {"tenant_id": ?, "time": {"$lt": ?}}
+func valueShape(v bson.RawValue) string {
+	var b strings.Builder
+	writeShape(&b, v)
+	return b.String()
+}
+
+func writeShape(b *strings.Builder, v bson.RawValue) {
+	switch v.Type {
+	case bsontype.EmbeddedDocument:
+		elems, _ := v.Document().Elements()
+		b.WriteString("{")
+		for i, elem := range elems {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(strconv.Quote(elem.Key()))
+			b.WriteString(": ")
+			writeShape(b, elem.Value())
+		}
+		b.WriteString("}")
+	case bsontype.Array:
+		// the scalars of an array are collapsed into a single "?"
+		values, _ := v.Array().Values()
+		b.WriteString("[")
+		scalar := false
+		n := 0
+		for _, value := range values {
+			isScalar := value.Type != bsontype.EmbeddedDocument &&
+				value.Type != bsontype.Array
+			if isScalar && scalar {
+				continue
+			}
+			scalar = scalar || isScalar
+			if n > 0 {
+				b.WriteString(", ")
+			}
+			writeShape(b, value)
+			n++
+		}
+		b.WriteString("]")
+	default:
+		b.WriteString("?")
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package mongo
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+)
+
+func TestCommandFilterShape(t *testing.T) {
+	testCases := []struct {
+		Name    string
+		Command bson.D
+
+		Shape string
+	}{{
+		Name: "find",
+		Command: bson.D{
+			{Key: "find", Value: CollNameAuditLogs},
+			{Key: "filter", Value: bson.D{
+				{Key: KeyTenantID, Value: "tenant1"},
+				{Key: KeyTime, Value: bson.D{{Key: "$lt", Value: time.Now()}}},
+			}},
+		},
+
+		Shape: `{"tenant_id": ?, "time": {"$lt": ?}}`,
+	}, {
+		Name: "update",
+		Command: bson.D{
+			{Key: "update", Value: CollNameSettings},
+			{Key: "updates", Value: bson.A{bson.D{
+				{Key: "q", Value: bson.D{{Key: KeyTenantID, Value: "tenant1"}}},
+				{Key: "u", Value: bson.D{{Key: KeyConnStr, Value: "secret"}}},
+			}}},
+		},
+
+		Shape: `{"tenant_id": ?}`,
+	}, {
+		Name: "delete with $in and $or",
+		Command: bson.D{
+			{Key: "delete", Value: CollNameUsage},
+			{Key: "deletes", Value: bson.A{bson.D{
+				{Key: "q", Value: bson.D{{Key: "$or", Value: bson.A{
+					bson.D{{Key: KeyTenantID, Value: bson.D{
+						{Key: "$in", Value: bson.A{"t1", "t2", "t3"}},
+					}}},
+					bson.D{{Key: KeyPeriod, Value: "2021-10"}},
+				}}}},
+			}}},
+		},
+
+		Shape: `{"$or": [{"tenant_id": {"$in": [?]}}, {"period": ?}]}`,
+	}, {
+		Name: "aggregate",
+		Command: bson.D{
+			{Key: "aggregate", Value: CollNameSettings},
+			{Key: "pipeline", Value: bson.A{
+				bson.D{{Key: "$sample", Value: bson.D{{Key: "size", Value: 10}}}},
+			}},
+		},
+
+		Shape: `[{"$sample": {"size": ?}}]`,
+	}, {
+		Name: "no filter",
+		Command: bson.D{
+			{Key: "insert", Value: CollNameAuditLogs},
+		},
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			cmd, _ := bson.Marshal(tc.Command)
+			filter, ok := commandFilter(cmd)
+			if tc.Shape == "" {
+				assert.False(t, ok)
+				return
+			}
+			if assert.True(t, ok) {
+				assert.Equal(t, tc.Shape, valueShape(filter))
+			}
+		})
+	}
+}
+
+func TestSlowQueryMonitor(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buf)
+	ctx := log.WithContext(context.Background(), log.NewFromLogger(logger, log.Ctx{}))
+
+	monitor := newSlowQueryMonitor(100 * time.Millisecond)
+	command := func(requestID int64, duration time.Duration) {
+		cmd, _ := bson.Marshal(bson.D{
+			{Key: "find", Value: CollNameAuditLogs},
+			{Key: "filter", Value: bson.D{{Key: KeyTenantID, Value: "tenant1"}}},
+		})
+		monitor.Started(ctx, &event.CommandStartedEvent{
+			Command:     cmd,
+			CommandName: "find",
+			RequestID:   requestID,
+		})
+		// the driver reuses the buffer of the command
+		copy(cmd, make([]byte, len(cmd)))
+		monitor.Succeeded(ctx, &event.CommandSucceededEvent{
+			CommandFinishedEvent: event.CommandFinishedEvent{
+				DurationNanos: int64(duration),
+				CommandName:   "find",
+				RequestID:     requestID,
+			},
+		})
+	}
+
+	command(1, 10*time.Millisecond)
+	assert.Empty(t, buf.String())
+
+	command(2, 250*time.Millisecond)
+	output := buf.String()
+	assert.Contains(t, output, "slow query: find on audit_logs took 250ms")
+	assert.Contains(t, output, "collection=audit_logs")
+	assert.Contains(t, output, "operation=find")
+	assert.Contains(t, output, "duration_ms=250")
+	assert.Contains(t, output, `filter="{\"tenant_id\": ?}"`)
+	assert.NotContains(t, output, "tenant1")
+
+	// commands which are not on a collection are not monitored
+	buf.Reset()
+	cmd, _ := bson.Marshal(bson.D{{Key: "ping", Value: 1}})
+	monitor.Started(ctx, &event.CommandStartedEvent{
+		Command:     cmd,
+		CommandName: "ping",
+		RequestID:   3,
+	})
+	monitor.Failed(ctx, &event.CommandFailedEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{
+			DurationNanos: int64(time.Second),
+			CommandName:   "ping",
+			RequestID:     3,
+		},
+	})
+	assert.Empty(t, buf.String())
+}
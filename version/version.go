@@ -0,0 +1,55 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package version holds the build information of the service, injected at
+// build time using -ldflags (see the Makefile).
+package version
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// The following variables are set at build time using -ldflags -X
+var (
+	// Version is the version of the service
+	Version = "unknown"
+	// Commit is the git commit the service was built from
+	Commit = "unknown"
+	// BuildDate is the date the service was built
+	BuildDate = "unknown"
+)
+
+// Info describes the build of the running service
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build information
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}
+
+func (i Info) String() string {
+	return fmt.Sprintf("azure-iot-manager %s (commit: %s, built: %s, %s)",
+		i.Version, i.Commit, i.BuildDate, i.GoVersion)
+}
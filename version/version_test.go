@@ -0,0 +1,41 @@
+// Copyright 2021 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package version
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGet(t *testing.T) {
+	Version, Commit, BuildDate = "1.2.3", "f00ba4", "2021-10-01T12:00:00Z"
+	defer func() {
+		Version, Commit, BuildDate = "unknown", "unknown", "unknown"
+	}()
+	info := Get()
+	assert.Equal(t, Info{
+		Version:   "1.2.3",
+		Commit:    "f00ba4",
+		BuildDate: "2021-10-01T12:00:00Z",
+		GoVersion: runtime.Version(),
+	}, info)
+	assert.Equal(t,
+		"azure-iot-manager 1.2.3 (commit: f00ba4, built: 2021-10-01T12:00:00Z, "+
+			runtime.Version()+")",
+		info.String(),
+	)
+}